@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -74,6 +76,15 @@ type RoomServerConfig struct {
 	HashTagEventService HashTagEventServiceConfig `yaml:"hash_tag_event_service"`
 	RedisCluster        RedisClusterConfig        `yaml:"redis_cluster"`
 	DB                  DBClusterConfig           `yaml:"db_cluster"`
+	// empty means all supported commands are allowed
+	AllowedCommands []string `yaml:"allowed_commands"`
+	// empty means keys are not rewritten
+	KeyNamespace string `yaml:"key_namespace"`
+	// 0 means exec of transaction is not timed out
+	ExecTimeoutMS int `yaml:"exec_timeout_ms"`
+	// commands and watches of transactions are rejected when their queued commands and watched keys
+	// take more than transaction_memory_limit_bytes, 0 means no limit.
+	TransactionMemoryLimitBytes int64 `yaml:"transaction_memory_limit_bytes"`
 }
 
 func (config RoomServerConfig) Check() error {
@@ -96,6 +107,15 @@ func (config RoomServerConfig) check() error {
 	if err := config.RedisCluster.check(); err != nil {
 		return fmt.Errorf("redis_cluster.%w", err)
 	}
+	if strings.ContainsAny(config.KeyNamespace, "{}") {
+		return fmt.Errorf("key_namespace is %s, it should not contain { or }", config.KeyNamespace)
+	}
+	if config.ExecTimeoutMS < 0 {
+		return fmt.Errorf("exec_timeout_ms is %d, it should be equal to or greater than 0", config.ExecTimeoutMS)
+	}
+	if config.TransactionMemoryLimitBytes < 0 {
+		return fmt.Errorf("transaction_memory_limit_bytes is %d, it should be equal to or greater than 0", config.TransactionMemoryLimitBytes)
+	}
 	if err := config.DB.check(); err != nil {
 		return fmt.Errorf("db_cluster.%w", err)
 	}
@@ -248,14 +268,75 @@ type RoomCollectEventConfig struct {
 	SaveFile CollectEventServiceSaveFileConfig `yaml:"save_file"`
 
 	BufferLimit int `yaml:"buffer_limit"`
+	// bounded_drop: events added when buffer is full are dropped,
+	// bounded_block: adding an event waits at most buffer_block_timeout for room before that,
+	// overflow: events added when buffer is full are kept in overflow_buffer,
+	// priority: events of high_priority_access_modes have their own buffer and are aggregated first.
+	// Empty means bounded_drop.
+	BufferStrategy        string        `yaml:"buffer_strategy"`
+	RawBufferBlockTimeout string        `yaml:"buffer_block_timeout"`
+	BufferBlockTimeout    time.Duration `yaml:"-"`
+	// warn when events in buffer are more than buffer_warning_ratio * buffer_limit
+	// for buffer_warning_ticks monitor intervals in a row, 0 ratio means no warning.
+	BufferWarningRatio float64 `yaml:"buffer_warning_ratio"`
+	BufferWarningTicks int     `yaml:"buffer_warning_ticks"`
+
+	OverflowBuffer CollectEventServiceOverflowBufferConfig `yaml:"overflow_buffer"`
+
+	Overload CollectEventServiceOverloadConfig `yaml:"overload"`
+
+	ClientRateLimit CollectEventServiceClientRateLimitConfig `yaml:"client_rate_limit"`
+
+	ConcurrencyLimit CollectEventServiceConcurrencyLimitConfig `yaml:"concurrency_limit"`
+
+	// dc is stamped on every collected event, empty means events have no dc.
+	DC string `yaml:"dc"`
+
+	// reject events with keys not belonging to their hash tags, it costs for every key.
+	StrictKeyCheck bool `yaml:"strict_key_check"`
+
+	// events with these access modes are aggregated before other events, it is used by priority buffer strategy
+	HighPriorityAccessModes []HashTagAccessMode `yaml:"high_priority_access_modes"`
+
+	// how events of the same hash tag are merged in aggregation, empty means latest_wins.
+	EventMergeMode EventMergeMode `yaml:"event_merge_mode"`
 
 	RawAggInterval string `yaml:"agg_interval"`
 	AggInterval    time.Duration
 
+	HotTag CollectEventServiceHotTagConfig `yaml:"hot_tag"`
+
+	OverloadSampling CollectEventServiceOverloadSamplingConfig `yaml:"overload_sampling"`
+
 	ServerShutdownTimeoutSeconds int `yaml:"server_shutdown_timeout_seconds"`
 
 	RawMonitorInterval string `yaml:"monitor_interval"`
 	MonitorInterval    time.Duration
+	// save workers not progressing in worker_stale_threshold are reported as stuck by monitor,
+	// empty means workers are not checked.
+	RawWorkerStaleThreshold string        `yaml:"worker_stale_threshold"`
+	WorkerStaleThreshold    time.Duration `yaml:"-"`
+	// latencies from added to buffer to saved in db are sampled for percentiles in every monitor interval,
+	// 0 means latency is not sampled.
+	LatencyReservoirSize int `yaml:"latency_reservoir_size"`
+	// copies of latest accepted events are kept for /debug/recent_events, 0 means events are not kept.
+	RecentEventCount int `yaml:"recent_event_count"`
+
+	SelfTest CollectEventServiceSelfTestConfig `yaml:"self_test"`
+
+	RecordCache CollectEventServiceRecordCacheConfig `yaml:"record_cache"`
+
+	DeletedTagFilter CollectEventServiceDeletedTagFilterConfig `yaml:"deleted_tag_filter"`
+
+	Ack CollectEventServiceAckConfig `yaml:"ack"`
+
+	ErrorWindow CollectEventServiceErrorWindowConfig `yaml:"error_window"`
+
+	ServiceLog CollectEventServiceLogConfig `yaml:"service_log"`
+
+	KeyRedaction CollectEventServiceKeyRedactionConfig `yaml:"key_redaction"`
+
+	Audit CollectEventServiceAuditConfig `yaml:"audit"`
 
 	DB DBClusterConfig `yaml:"db_cluster"`
 }
@@ -279,15 +360,107 @@ func (config RoomCollectEventConfig) check() error {
 	if config.BufferLimit <= 0 {
 		return fmt.Errorf("buffer_limit is %d, it should be greater than 0", config.BufferLimit)
 	}
+	switch config.BufferStrategy {
+	case "", EventBufferStrategyBoundedDrop:
+	case EventBufferStrategyBoundedBlock:
+		if config.RawBufferBlockTimeout == "" {
+			return errors.New("buffer_block_timeout should not be empty")
+		}
+	case EventBufferStrategyOverflow:
+		if config.OverflowBuffer.Limit == 0 {
+			return fmt.Errorf("overflow_buffer.limit should be greater than 0 if buffer_strategy is %s", config.BufferStrategy)
+		}
+	case EventBufferStrategyPriority:
+		if len(config.HighPriorityAccessModes) == 0 {
+			return fmt.Errorf("high_priority_access_modes should not be empty if buffer_strategy is %s", config.BufferStrategy)
+		}
+	default:
+		return fmt.Errorf(
+			"buffer_strategy is %s, it should be %s, %s, %s or %s", config.BufferStrategy,
+			EventBufferStrategyBoundedDrop, EventBufferStrategyBoundedBlock, EventBufferStrategyOverflow, EventBufferStrategyPriority)
+	}
+	if config.OverflowBuffer.Limit > 0 && config.BufferStrategy != EventBufferStrategyOverflow {
+		return fmt.Errorf("overflow_buffer.limit is %d, it should be 0 if buffer_strategy is not %s", config.OverflowBuffer.Limit, EventBufferStrategyOverflow)
+	}
+	if len(config.HighPriorityAccessModes) > 0 && config.BufferStrategy != EventBufferStrategyPriority {
+		return fmt.Errorf("high_priority_access_modes should be empty if buffer_strategy is not %s", EventBufferStrategyPriority)
+	}
+	if config.BufferWarningRatio < 0 || config.BufferWarningRatio > 1 {
+		return fmt.Errorf("buffer_warning_ratio is %v, it should be in [0, 1]", config.BufferWarningRatio)
+	}
+	if config.BufferWarningRatio > 0 && config.BufferWarningTicks <= 0 {
+		return fmt.Errorf("buffer_warning_ticks is %d, it should be greater than 0", config.BufferWarningTicks)
+	}
+	if err := config.OverflowBuffer.check(); err != nil {
+		return fmt.Errorf("overflow_buffer.%w", err)
+	}
+	if err := config.Overload.check(); err != nil {
+		return fmt.Errorf("overload.%w", err)
+	}
+	if err := config.ClientRateLimit.check(); err != nil {
+		return fmt.Errorf("client_rate_limit.%w", err)
+	}
+	if err := config.ConcurrencyLimit.check(); err != nil {
+		return fmt.Errorf("concurrency_limit.%w", err)
+	}
+	for _, mode := range config.HighPriorityAccessModes {
+		if !mode.IsValid() {
+			return fmt.Errorf("high_priority_access_modes has invalid mode %s", mode)
+		}
+	}
+	switch config.EventMergeMode {
+	case "", EventMergeModeLatestWins, EventMergeModeUnion:
+	default:
+		return fmt.Errorf("event_merge_mode is %s, it should be %s or %s", config.EventMergeMode, EventMergeModeLatestWins, EventMergeModeUnion)
+	}
 	if config.RawAggInterval == "" {
 		return errors.New("agg_interval should not be empty")
 	}
+	if err := config.HotTag.check(); err != nil {
+		return fmt.Errorf("hot_tag.%w", err)
+	}
+	if err := config.OverloadSampling.check(); err != nil {
+		return fmt.Errorf("overload_sampling.%w", err)
+	}
+	if config.OverloadSampling.BufferRatio > 0 && config.HotTag.MergeThreshold <= 0 {
+		return errors.New("overload_sampling needs hot tags, hot_tag.merge_threshold should be greater than 0")
+	}
 	if config.ServerShutdownTimeoutSeconds <= 0 {
 		return fmt.Errorf("server_shutdown_timeout_seconds is %d, it should be greater than 0", config.ServerShutdownTimeoutSeconds)
 	}
 	if config.RawMonitorInterval == "" {
 		return errors.New("monitor_interval should not be empty")
 	}
+	if config.LatencyReservoirSize < 0 {
+		return fmt.Errorf("latency_reservoir_size is %d, it should be equal to or greater than 0", config.LatencyReservoirSize)
+	}
+	if config.RecentEventCount < 0 {
+		return fmt.Errorf("recent_event_count is %d, it should be equal to or greater than 0", config.RecentEventCount)
+	}
+	if err := config.SelfTest.check(); err != nil {
+		return fmt.Errorf("self_test.%w", err)
+	}
+	if err := config.RecordCache.check(); err != nil {
+		return fmt.Errorf("record_cache.%w", err)
+	}
+	if err := config.DeletedTagFilter.check(); err != nil {
+		return fmt.Errorf("deleted_tag_filter.%w", err)
+	}
+	if err := config.Ack.check(); err != nil {
+		return fmt.Errorf("ack.%w", err)
+	}
+	if err := config.ErrorWindow.check(); err != nil {
+		return fmt.Errorf("error_window.%w", err)
+	}
+	if err := config.ServiceLog.check(); err != nil {
+		return fmt.Errorf("service_log.%w", err)
+	}
+	if err := config.KeyRedaction.check(); err != nil {
+		return fmt.Errorf("key_redaction.%w", err)
+	}
+	if err := config.Audit.check(); err != nil {
+		return fmt.Errorf("audit.%w", err)
+	}
 	if err := config.DB.check(); err != nil {
 		return fmt.Errorf("db_cluster.%w", err)
 	}
@@ -322,6 +495,522 @@ func (config *RoomCollectEventConfig) init() error {
 		return fmt.Errorf("monitor_interval is inavlid %w", err)
 	}
 	config.MonitorInterval = duration
+
+	if config.RawWorkerStaleThreshold != "" {
+		duration, err = time.ParseDuration(config.RawWorkerStaleThreshold)
+		if err != nil {
+			return fmt.Errorf("worker_stale_threshold.%w", err)
+		}
+		config.WorkerStaleThreshold = duration
+	}
+
+	if config.Server.IdempotencyCacheSize > 0 {
+		duration, err = time.ParseDuration(config.Server.RawIdempotencyKeyTTL)
+		if err != nil {
+			return fmt.Errorf("server.idempotency_key_ttl.%w", err)
+		}
+		config.Server.IdempotencyKeyTTL = duration
+	}
+
+	if config.BufferStrategy == EventBufferStrategyBoundedBlock {
+		duration, err = time.ParseDuration(config.RawBufferBlockTimeout)
+		if err != nil {
+			return fmt.Errorf("buffer_block_timeout.%w", err)
+		}
+		config.BufferBlockTimeout = duration
+	}
+
+	if config.ConcurrencyLimit.MaxRequests > 0 {
+		duration, err = time.ParseDuration(config.ConcurrencyLimit.RawQueueTimeout)
+		if err != nil {
+			return fmt.Errorf("concurrency_limit.queue_timeout.%w", err)
+		}
+		config.ConcurrencyLimit.QueueTimeout = duration
+	}
+
+	if config.HotTag.MergeThreshold > 0 {
+		duration, err = time.ParseDuration(config.HotTag.RawInterval)
+		if err != nil {
+			return fmt.Errorf("hot_tag.interval.%w", err)
+		}
+		config.HotTag.Interval = duration
+	}
+
+	if config.OverloadSampling.BufferRatio > 0 {
+		duration, err = time.ParseDuration(config.OverloadSampling.RawWindow)
+		if err != nil {
+			return fmt.Errorf("overload_sampling.window.%w", err)
+		}
+		config.OverloadSampling.Window = duration
+	}
+
+	if config.RecordCache.Size > 0 {
+		duration, err = time.ParseDuration(config.RecordCache.RawTTL)
+		if err != nil {
+			return fmt.Errorf("record_cache.ttl.%w", err)
+		}
+		config.RecordCache.TTL = duration
+	}
+
+	if config.DeletedTagFilter.Size > 0 {
+		duration, err = time.ParseDuration(config.DeletedTagFilter.RawTTL)
+		if err != nil {
+			return fmt.Errorf("deleted_tag_filter.ttl.%w", err)
+		}
+		config.DeletedTagFilter.TTL = duration
+	}
+
+	if config.Ack.MaxPending > 0 {
+		duration, err = time.ParseDuration(config.Ack.RawTimeout)
+		if err != nil {
+			return fmt.Errorf("ack.timeout.%w", err)
+		}
+		config.Ack.Timeout = duration
+	}
+
+	if config.SelfTest.Enabled {
+		duration, err = time.ParseDuration(config.SelfTest.RawInterval)
+		if err != nil {
+			return fmt.Errorf("self_test.interval.%w", err)
+		}
+		config.SelfTest.Interval = duration
+
+		if config.SelfTest.RawLeaseDuration != "" {
+			duration, err = time.ParseDuration(config.SelfTest.RawLeaseDuration)
+			if err != nil {
+				return fmt.Errorf("self_test.lease_duration.%w", err)
+			}
+			if duration <= config.SelfTest.Interval {
+				return fmt.Errorf("self_test.lease_duration is %s, it should be longer than interval", duration)
+			}
+			config.SelfTest.LeaseDuration = duration
+		}
+	}
+
+	if config.ErrorWindow.RawWindow != "" {
+		duration, err = time.ParseDuration(config.ErrorWindow.RawWindow)
+		if err != nil {
+			return fmt.Errorf("error_window.window.%w", err)
+		}
+		if duration < time.Duration(config.ErrorWindow.BucketCount)*time.Millisecond {
+			return fmt.Errorf("error_window.window is %s, it should be at least bucket_count milliseconds", duration)
+		}
+		config.ErrorWindow.Window = duration
+	}
+
+	if config.ServiceLog.Sampling.Enabled {
+		duration, err = time.ParseDuration(config.ServiceLog.Sampling.RawInterval)
+		if err != nil {
+			return fmt.Errorf("service_log.sampling.interval.%w", err)
+		}
+		config.ServiceLog.Sampling.Interval = duration
+	}
+	return nil
+}
+
+// CollectEventServiceSelfTestConfig configures a canary which periodically adds an event with
+// reserved hash tag and checks whether it is saved to db.
+// Interval should be longer than the time an event takes to reach db,
+// which is about agg_interval + save_file.max_file_age + save_db.file_age.
+type CollectEventServiceSelfTestConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	HashTag     string        `yaml:"hash_tag"`
+	RawInterval string        `yaml:"interval"`
+	Interval    time.Duration `yaml:"-"`
+	// self test runs in only one of instances holding lease in db, lease_duration should be longer than interval.
+	// Empty means self test runs in every instance.
+	RawLeaseDuration string        `yaml:"lease_duration"`
+	LeaseDuration    time.Duration `yaml:"-"`
+}
+
+func (config CollectEventServiceSelfTestConfig) check() error {
+	if !config.Enabled {
+		return nil
+	}
+	if config.HashTag == "" {
+		return errors.New("hash_tag should not be empty")
+	}
+	if config.RawInterval == "" {
+		return errors.New("interval should not be empty")
+	}
+	return nil
+}
+
+const (
+	OverflowBufferBackingMemory = "memory"
+	OverflowBufferBackingDisk   = "disk"
+)
+
+const (
+	EventBufferStrategyBoundedDrop  = "bounded_drop"
+	EventBufferStrategyBoundedBlock = "bounded_block"
+	EventBufferStrategyOverflow     = "overflow"
+	EventBufferStrategyPriority     = "priority"
+)
+
+// CollectEventServiceOverflowBufferConfig configures buffer of events added when event buffer is full,
+// it is used by overflow buffer strategy. Events in overflow buffer are aggregated after events in event buffer.
+type CollectEventServiceOverflowBufferConfig struct {
+	// 0 means no overflow buffer
+	Limit int `yaml:"limit"`
+	// events are kept in a file of save_file.file_directory if backing is disk
+	Backing string `yaml:"backing"`
+}
+
+func (config CollectEventServiceOverflowBufferConfig) check() error {
+	if config.Limit < 0 {
+		return fmt.Errorf("limit is %d, it should be equal to or greater than 0", config.Limit)
+	}
+	if config.Limit > 0 && config.Backing != OverflowBufferBackingMemory && config.Backing != OverflowBufferBackingDisk {
+		return fmt.Errorf("backing is %s, it should be %s or %s", config.Backing, OverflowBufferBackingMemory, OverflowBufferBackingDisk)
+	}
+	return nil
+}
+
+// CollectEventServiceHotTagConfig configures coalescing of hot tags.
+// A tag is hot if its events are merged at least merge_threshold times since it is collected last time,
+// hot tags are collected at most once per interval, other tags are collected every agg_interval.
+type CollectEventServiceHotTagConfig struct {
+	// 0 means no tag is hot
+	MergeThreshold int           `yaml:"merge_threshold"`
+	RawInterval    string        `yaml:"interval"`
+	Interval       time.Duration `yaml:"-"`
+}
+
+func (config CollectEventServiceHotTagConfig) check() error {
+	if config.MergeThreshold < 0 {
+		return fmt.Errorf("merge_threshold is %d, it should be equal to or greater than 0", config.MergeThreshold)
+	}
+	if config.MergeThreshold > 0 && config.RawInterval == "" {
+		return errors.New("interval should not be empty")
+	}
+	return nil
+}
+
+// CollectEventServiceOverloadConfig rejects requests with 503 when service is overloaded,
+// that is events in buffer are more than buffer_ratio * buffer_limit and collected events waiting to be saved
+// are more than collected_buffer_ratio * buffer_limit. Service exits overload when events in buffer are
+// less than exit_buffer_ratio * buffer_limit.
+type CollectEventServiceOverloadConfig struct {
+	// 0 means requests are not rejected for overload
+	BufferRatio          float64 `yaml:"buffer_ratio"`
+	CollectedBufferRatio float64 `yaml:"collected_buffer_ratio"`
+	ExitBufferRatio      float64 `yaml:"exit_buffer_ratio"`
+	// value of Retry-After header of rejected requests
+	RetryAfterSeconds int `yaml:"retry_after_seconds"`
+}
+
+func (config CollectEventServiceOverloadConfig) check() error {
+	if config.BufferRatio < 0 || config.BufferRatio > 1 {
+		return fmt.Errorf("buffer_ratio is %v, it should be in [0, 1]", config.BufferRatio)
+	}
+	if config.BufferRatio == 0 {
+		return nil
+	}
+	if config.CollectedBufferRatio < 0 || config.CollectedBufferRatio > 1 {
+		return fmt.Errorf("collected_buffer_ratio is %v, it should be in [0, 1]", config.CollectedBufferRatio)
+	}
+	if config.ExitBufferRatio < 0 || config.ExitBufferRatio > config.BufferRatio {
+		return fmt.Errorf("exit_buffer_ratio is %v, it should be in [0, buffer_ratio]", config.ExitBufferRatio)
+	}
+	if config.RetryAfterSeconds <= 0 {
+		return fmt.Errorf("retry_after_seconds is %d, it should be greater than 0", config.RetryAfterSeconds)
+	}
+	return nil
+}
+
+// CollectEventServiceClientRateLimitConfig limits requests to /events of every client address with a token bucket,
+// requests exceeding the limit are rejected with 429. Buckets of at most max_clients recently seen clients are kept.
+type CollectEventServiceClientRateLimitConfig struct {
+	// 0 means requests are not limited
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// requests allowed in a burst, it should not be less than 1
+	Burst      int `yaml:"burst"`
+	MaxClients int `yaml:"max_clients"`
+}
+
+func (config CollectEventServiceClientRateLimitConfig) check() error {
+	if config.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests_per_second is %v, it should be equal to or greater than 0", config.RequestsPerSecond)
+	}
+	if config.RequestsPerSecond == 0 {
+		return nil
+	}
+	if config.Burst < 1 {
+		return fmt.Errorf("burst is %d, it should be greater than 0", config.Burst)
+	}
+	if config.MaxClients <= 0 {
+		return fmt.Errorf("max_clients is %d, it should be greater than 0", config.MaxClients)
+	}
+	return nil
+}
+
+// CollectEventServiceConcurrencyLimitConfig limits requests to /events handled at the same time,
+// at most queue_size requests wait for queue_timeout, other requests are rejected with 429.
+type CollectEventServiceConcurrencyLimitConfig struct {
+	// 0 means concurrent requests are not limited
+	MaxRequests     int           `yaml:"max_requests"`
+	QueueSize       int           `yaml:"queue_size"`
+	RawQueueTimeout string        `yaml:"queue_timeout"`
+	QueueTimeout    time.Duration `yaml:"-"`
+}
+
+func (config CollectEventServiceConcurrencyLimitConfig) check() error {
+	if config.MaxRequests < 0 {
+		return fmt.Errorf("max_requests is %d, it should be equal to or greater than 0", config.MaxRequests)
+	}
+	if config.MaxRequests == 0 {
+		return nil
+	}
+	if config.QueueSize < 0 {
+		return fmt.Errorf("queue_size is %d, it should be equal to or greater than 0", config.QueueSize)
+	}
+	if config.RawQueueTimeout == "" {
+		return errors.New("queue_timeout should not be empty")
+	}
+	return nil
+}
+
+// CollectEventServiceOverloadSamplingConfig samples read events of hot tags when buffer is overloaded,
+// at least min_events_per_tag events of every tag are kept in every window, so rare tags are not dropped.
+// Write and delete events are never sampled.
+type CollectEventServiceOverloadSamplingConfig struct {
+	// events are sampled when events in buffer are more than buffer_ratio * buffer_limit,
+	// 0 means events are not sampled.
+	BufferRatio     float64       `yaml:"buffer_ratio"`
+	RawWindow       string        `yaml:"window"`
+	Window          time.Duration `yaml:"-"`
+	MinEventsPerTag int           `yaml:"min_events_per_tag"`
+	// ratio of read events of hot tags kept after min_events_per_tag
+	HotTagKeepRatio float64 `yaml:"hot_tag_keep_ratio"`
+}
+
+func (config CollectEventServiceOverloadSamplingConfig) check() error {
+	if config.BufferRatio < 0 || config.BufferRatio > 1 {
+		return fmt.Errorf("buffer_ratio is %v, it should be in [0, 1]", config.BufferRatio)
+	}
+	if config.BufferRatio == 0 {
+		return nil
+	}
+	if config.RawWindow == "" {
+		return errors.New("window should not be empty")
+	}
+	if config.MinEventsPerTag <= 0 {
+		return fmt.Errorf("min_events_per_tag is %d, it should be greater than 0", config.MinEventsPerTag)
+	}
+	if config.HotTagKeepRatio < 0 || config.HotTagKeepRatio > 1 {
+		return fmt.Errorf("hot_tag_keep_ratio is %v, it should be in [0, 1]", config.HotTagKeepRatio)
+	}
+	return nil
+}
+
+// CollectEventServiceErrorWindowConfig configures error counts by reason in a sliding window,
+// at most bucket_count * max_reason_count counts are kept.
+type CollectEventServiceErrorWindowConfig struct {
+	// empty means error counts are not kept
+	RawWindow      string        `yaml:"window"`
+	Window         time.Duration `yaml:"-"`
+	BucketCount    int           `yaml:"bucket_count"`
+	MaxReasonCount int           `yaml:"max_reason_count"`
+}
+
+func (config CollectEventServiceErrorWindowConfig) check() error {
+	if config.RawWindow == "" {
+		return nil
+	}
+	if config.BucketCount <= 0 {
+		return fmt.Errorf("bucket_count is %d, it should be greater than 0", config.BucketCount)
+	}
+	if config.MaxReasonCount <= 0 {
+		return fmt.Errorf("max_reason_count is %d, it should be greater than 0", config.MaxReasonCount)
+	}
+	return nil
+}
+
+// CollectEventServiceRecordCacheConfig configures cache of records saved or loaded recently,
+// records in cache may be stale for at most TTL.
+type CollectEventServiceRecordCacheConfig struct {
+	// 0 means records are not cached
+	Size   int           `yaml:"size"`
+	RawTTL string        `yaml:"ttl"`
+	TTL    time.Duration `yaml:"-"`
+}
+
+func (config CollectEventServiceRecordCacheConfig) check() error {
+	if config.Size < 0 {
+		return fmt.Errorf("size is %d, it should be equal to or greater than 0", config.Size)
+	}
+	if config.Size > 0 && config.RawTTL == "" {
+		return errors.New("ttl should not be empty")
+	}
+	return nil
+}
+
+// CollectEventServiceDeletedTagFilterConfig keeps hash tags deleted by saved delete events,
+// events accessing them before deleted are dropped instead of saving records of deleted tags again.
+type CollectEventServiceDeletedTagFilterConfig struct {
+	// 0 means events are not filtered
+	Size   int           `yaml:"size"`
+	RawTTL string        `yaml:"ttl"`
+	TTL    time.Duration `yaml:"-"`
+}
+
+func (config CollectEventServiceDeletedTagFilterConfig) check() error {
+	if config.Size < 0 {
+		return fmt.Errorf("size is %d, it should be equal to or greater than 0", config.Size)
+	}
+	if config.Size > 0 && config.RawTTL == "" {
+		return errors.New("ttl should not be empty")
+	}
+	return nil
+}
+
+// CollectEventServiceAckConfig configures acks of requests with X-Room-Ack-URL header,
+// results are posted to ack url after events of request are saved to db or timeout.
+type CollectEventServiceAckConfig struct {
+	// 0 means ack url is ignored
+	MaxPending int           `yaml:"max_pending"`
+	RawTimeout string        `yaml:"timeout"`
+	Timeout    time.Duration `yaml:"-"`
+
+	RateLimitPerSecond int `yaml:"rate_limit_per_second"`
+	RequestTimeoutMS   int `yaml:"request_timeout_ms"`
+	RetryTimes         int `yaml:"retry_times"`
+	RetryIntervalMS    int `yaml:"retry_interval_ms"`
+	// retries of all acks are limited by budget, 0 means retries are not limited
+	RetryBudgetPerSecond int `yaml:"retry_budget_per_second"`
+	// hosts of ack url, e.g. "ack.example.com", "*.example.com" matches subdomains of example.com
+	AllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+func (config CollectEventServiceAckConfig) check() error {
+	if config.MaxPending < 0 {
+		return fmt.Errorf("max_pending is %d, it should be equal to or greater than 0", config.MaxPending)
+	}
+	if config.MaxPending == 0 {
+		return nil
+	}
+	if config.RawTimeout == "" {
+		return errors.New("timeout should not be empty")
+	}
+	if config.RateLimitPerSecond <= 0 {
+		return fmt.Errorf("rate_limit_per_second is %d, it should be greater than 0", config.RateLimitPerSecond)
+	}
+	if config.RequestTimeoutMS <= 0 {
+		return fmt.Errorf("request_timeout_ms is %d, it should be greater than 0", config.RequestTimeoutMS)
+	}
+	if config.RetryTimes <= 0 {
+		return fmt.Errorf("retry_times is %d, it should be greater than 0", config.RetryTimes)
+	}
+	if config.RetryIntervalMS < 0 {
+		return fmt.Errorf("retry_interval_ms is %d, it should be equal to or greater than 0", config.RetryIntervalMS)
+	}
+	if config.RetryBudgetPerSecond < 0 {
+		return fmt.Errorf("retry_budget_per_second is %d, it should be equal to or greater than 0", config.RetryBudgetPerSecond)
+	}
+	if len(config.AllowedHosts) == 0 {
+		return errors.New("allowed_hosts should not be empty")
+	}
+	for _, host := range config.AllowedHosts {
+		if strings.TrimPrefix(host, "*.") == "" {
+			return fmt.Errorf("allowed_hosts has %q, it should be a host name", host)
+		}
+	}
+	return nil
+}
+
+// CollectEventServiceLogConfig filters logs of collect event service,
+// outputs are still configured by log.
+type CollectEventServiceLogConfig struct {
+	// empty level means all logs are passed to outputs
+	Level    string                               `yaml:"level"`
+	Sampling CollectEventServiceLogSamplingConfig `yaml:"sampling"`
+	// events in logs keep at most event_key_limit keys and count of all keys,
+	// 0 means events are logged with all keys. Events in event files are never truncated.
+	EventKeyLimit int `yaml:"event_key_limit"`
+}
+
+func (config CollectEventServiceLogConfig) check() error {
+	switch config.Level {
+	case "", "debug", "info", "warn", "error", "fatal":
+	default:
+		return fmt.Errorf("level is %s, it should be one of debug, info, warn, error, fatal", config.Level)
+	}
+	if err := config.Sampling.check(); err != nil {
+		return fmt.Errorf("sampling.%w", err)
+	}
+	if config.EventKeyLimit < 0 {
+		return fmt.Errorf("event_key_limit is %d, it should be equal to or greater than 0", config.EventKeyLimit)
+	}
+	return nil
+}
+
+// CollectEventServiceKeyRedactionConfig replaces sensitive segments of keys with placeholder before saved in db,
+// hash tag of keys is never redacted so keys are still routed to the same record.
+type CollectEventServiceKeyRedactionConfig struct {
+	// segments of keys matching any pattern are replaced
+	Patterns []string `yaml:"patterns"`
+	// the rest of key after any prefix is replaced, prefixes are matched with the part of key after hash tag
+	Prefixes    []string `yaml:"prefixes"`
+	Placeholder string   `yaml:"placeholder"`
+}
+
+func (config CollectEventServiceKeyRedactionConfig) check() error {
+	for _, pattern := range config.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("patterns has %s, it is invalid %w", pattern, err)
+		}
+	}
+	for _, prefix := range config.Prefixes {
+		if prefix == "" {
+			return errors.New("prefixes should not have empty prefix")
+		}
+	}
+	if (len(config.Patterns) > 0 || len(config.Prefixes) > 0) && config.Placeholder == "" {
+		return errors.New("placeholder should not be empty")
+	}
+	return nil
+}
+
+// CollectEventServiceAuditConfig writes a record of every accepted request to /events,
+// records are written to outputs in the same format as log e.g. file and tcp, apart from service logs.
+type CollectEventServiceAuditConfig struct {
+	// empty log means requests are not audited
+	Log map[string]interface{} `yaml:"log"`
+	// records are dropped when there are buffer_size records not written yet, so requests are never blocked
+	BufferSize int `yaml:"buffer_size"`
+}
+
+func (config CollectEventServiceAuditConfig) check() error {
+	if len(config.Log) > 0 && config.BufferSize <= 0 {
+		return fmt.Errorf("buffer_size is %d, it should be greater than 0", config.BufferSize)
+	}
+	return nil
+}
+
+// CollectEventServiceLogSamplingConfig samples error logs with the same reason,
+// the first First logs in every Interval are kept, then one of every Thereafter logs is kept.
+type CollectEventServiceLogSamplingConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	RawInterval string        `yaml:"interval"`
+	Interval    time.Duration `yaml:"-"`
+	First       int           `yaml:"first"`
+	Thereafter  int           `yaml:"thereafter"`
+}
+
+func (config CollectEventServiceLogSamplingConfig) check() error {
+	if !config.Enabled {
+		return nil
+	}
+	if config.RawInterval == "" {
+		return errors.New("interval should not be empty")
+	}
+	if config.First <= 0 {
+		return fmt.Errorf("first is %d, it should be greater than 0", config.First)
+	}
+	if config.Thereafter < 0 {
+		return fmt.Errorf("thereafter is %d, it should not be less than 0", config.Thereafter)
+	}
 	return nil
 }
 
@@ -330,6 +1019,36 @@ type CollectEventServiceServerConfig struct {
 	ReadTimeoutMS  int    `yaml:"read_timeout_ms"`
 	WriteTimeoutMS int    `yaml:"write_timeout_ms"`
 	IdleTimeoutMS  int    `yaml:"idle_timeout_ms"`
+	MaxBodyBytes   int64  `yaml:"max_body_bytes"`
+	// 0 means read_timeout_ms is used for reading headers
+	ReadHeaderTimeoutMS int `yaml:"read_header_timeout_ms"`
+	// request is aborted when body is read slower than min_body_bytes_per_second
+	// after min_body_rate_grace_ms, 0 means rate is not checked.
+	MinBodyBytesPerSecond int64 `yaml:"min_body_bytes_per_second"`
+	MinBodyRateGraceMS    int   `yaml:"min_body_rate_grace_ms"`
+	// ids are assigned to accepted events and returned in response if assign_event_id is true
+	AssignEventID bool `yaml:"assign_event_id"`
+	// responses not shorter than gzip_min_bytes are compressed if client accepts gzip,
+	// 0 means responses are not compressed.
+	GzipMinBytes int `yaml:"gzip_min_bytes"`
+	// address of client is taken from X-Forwarded-For header if trust_forwarded_for is true,
+	// it should be true only behind a proxy setting the header.
+	TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+	// requests to /events without json or form content type are rejected with 415 if strict_content_type is true,
+	// it should be false if some clients omit Content-Type header.
+	StrictContentType bool `yaml:"strict_content_type"`
+	// admin endpoints e.g. /debug/buffer require "Authorization: Bearer <admin_token>" header,
+	// empty admin_token means admin endpoints are disabled.
+	AdminToken string `yaml:"admin_token"`
+	// optional endpoints e.g. "/stats/reset", "/events/status" and "/debug/*" are not registered if disabled,
+	// "/events" can not be disabled.
+	DisabledEndpoints []string `yaml:"disabled_endpoints"`
+
+	// responses of requests with Idempotency-Key header are cached,
+	// 0 cache size means idempotency key is ignored.
+	IdempotencyCacheSize int           `yaml:"idempotency_cache_size"`
+	RawIdempotencyKeyTTL string        `yaml:"idempotency_key_ttl"`
+	IdempotencyKeyTTL    time.Duration `yaml:"-"`
 }
 
 func (config CollectEventServiceServerConfig) check() error {
@@ -345,6 +1064,35 @@ func (config CollectEventServiceServerConfig) check() error {
 	if config.IdleTimeoutMS <= 0 {
 		return fmt.Errorf("idle_timeout_ms is %d, it should be greater than 0", config.IdleTimeoutMS)
 	}
+	if config.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes is %d, it should be equal to or greater than 0", config.MaxBodyBytes)
+	}
+	if config.GzipMinBytes < 0 {
+		return fmt.Errorf("gzip_min_bytes is %d, it should be equal to or greater than 0", config.GzipMinBytes)
+	}
+	for _, endpoint := range config.DisabledEndpoints {
+		if !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("disabled_endpoints has %s, it should start with /", endpoint)
+		}
+		if endpoint == "/events" || endpoint == "/*" {
+			return fmt.Errorf("disabled_endpoints has %s, /events can not be disabled", endpoint)
+		}
+	}
+	if config.ReadHeaderTimeoutMS < 0 {
+		return fmt.Errorf("read_header_timeout_ms is %d, it should be equal to or greater than 0", config.ReadHeaderTimeoutMS)
+	}
+	if config.MinBodyBytesPerSecond < 0 {
+		return fmt.Errorf("min_body_bytes_per_second is %d, it should be equal to or greater than 0", config.MinBodyBytesPerSecond)
+	}
+	if config.MinBodyRateGraceMS < 0 {
+		return fmt.Errorf("min_body_rate_grace_ms is %d, it should be equal to or greater than 0", config.MinBodyRateGraceMS)
+	}
+	if config.IdempotencyCacheSize < 0 {
+		return fmt.Errorf("idempotency_cache_size is %d, it should be equal to or greater than 0", config.IdempotencyCacheSize)
+	}
+	if config.IdempotencyCacheSize > 0 && config.RawIdempotencyKeyTTL == "" {
+		return errors.New("idempotency_key_ttl should not be empty")
+	}
 	return nil
 }
 
@@ -352,11 +1100,34 @@ type CollectEventServiceSaveDBConfig struct {
 	RetryTimes      int `yaml:"retry_times"`
 	RetryIntervalMS int `yaml:"retry_interval_ms"`
 	TimeoutMS       int `yaml:"timeout_ms"`
+	// every attempt times out after attempt_timeout_ms within timeout_ms and is retried,
+	// 0 means attempts share timeout_ms and an attempt timed out is not retried.
+	AttemptTimeoutMS int `yaml:"attempt_timeout_ms"`
 
 	RawFileAge string `yaml:"file_age"`
 	FileAge    time.Duration
 
+	// events older than max_event_age_ms are not saved, their files are backed up. 0 means no limit.
+	MaxEventAgeMS int `yaml:"max_event_age_ms"`
+
+	// rate limit is halved when saving an event takes longer than adaptive_target_latency_ms,
+	// and increased by 1 until rate_limit_per_second otherwise. 0 means rate limit is fixed.
+	AdaptiveTargetLatencyMS       int `yaml:"adaptive_target_latency_ms"`
+	AdaptiveMinRateLimitPerSecond int `yaml:"adaptive_min_rate_limit_per_second"`
+
+	// retries of all events are limited by retry_budget_per_second,
+	// events fail without retry when budget is exhausted. 0 means no limit.
+	RetryBudgetPerSecond int `yaml:"retry_budget_per_second"`
+
 	RateLimitPerSecond int `yaml:"rate_limit_per_second"`
+
+	// events read from files are upserted in batches of batch_size in one transaction per db shard,
+	// a batch is saved when it is full or batch_interval_ms after its first event. 0 or 1 means one by one.
+	BatchSize       int `yaml:"batch_size"`
+	BatchIntervalMS int `yaml:"batch_interval_ms"`
+
+	// events read from files are reused after they are saved if pool_events is true
+	PoolEvents bool `yaml:"pool_events"`
 }
 
 func (config CollectEventServiceSaveDBConfig) check() error {
@@ -369,12 +1140,37 @@ func (config CollectEventServiceSaveDBConfig) check() error {
 	if config.TimeoutMS <= 0 {
 		return fmt.Errorf("timeout_ms is %d, it should be greater than 0", config.TimeoutMS)
 	}
+	if config.AttemptTimeoutMS < 0 || config.AttemptTimeoutMS > config.TimeoutMS {
+		return fmt.Errorf("attempt_timeout_ms is %d, it should be between 0 and timeout_ms", config.AttemptTimeoutMS)
+	}
 	if config.RawFileAge == "" {
 		return errors.New("file_age should not be empty")
 	}
 	if config.RateLimitPerSecond <= 0 {
 		return fmt.Errorf("rate_limit_per_second is %d, it should be greater than 0", config.RateLimitPerSecond)
 	}
+	if config.MaxEventAgeMS < 0 {
+		return fmt.Errorf("max_event_age_ms is %d, it should be equal to or greater than 0", config.MaxEventAgeMS)
+	}
+	if config.RetryBudgetPerSecond < 0 {
+		return fmt.Errorf("retry_budget_per_second is %d, it should be equal to or greater than 0", config.RetryBudgetPerSecond)
+	}
+	if config.AdaptiveTargetLatencyMS < 0 {
+		return fmt.Errorf("adaptive_target_latency_ms is %d, it should be equal to or greater than 0", config.AdaptiveTargetLatencyMS)
+	}
+	if config.AdaptiveTargetLatencyMS > 0 {
+		if config.AdaptiveMinRateLimitPerSecond <= 0 || config.AdaptiveMinRateLimitPerSecond > config.RateLimitPerSecond {
+			return fmt.Errorf(
+				"adaptive_min_rate_limit_per_second is %d, it should be greater than 0 and not greater than rate_limit_per_second",
+				config.AdaptiveMinRateLimitPerSecond)
+		}
+	}
+	if config.BatchSize < 0 {
+		return fmt.Errorf("batch_size is %d, it should be equal to or greater than 0", config.BatchSize)
+	}
+	if config.BatchIntervalMS < 0 {
+		return fmt.Errorf("batch_interval_ms is %d, it should be equal to or greater than 0", config.BatchIntervalMS)
+	}
 	return nil
 }
 
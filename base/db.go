@@ -206,6 +206,44 @@ func (dbCluster *DBCluster) GetTableNameAndDBClientByModel(model Model) (string,
 	return tableName, client, nil
 }
 
+// DBPoolStats is connection pool stats of a db client, counts are accumulated since client is created.
+type DBPoolStats struct {
+	// "<start_index>_<end_index>" of sharding indexes served by db client
+	Name     string `json:"name"`
+	PoolSize int    `json:"pool_size"`
+
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	InUseConns uint32 `json:"in_use_conns"`
+
+	// misses are times free connection was not found in pool and a connection was waited for or dialed,
+	// timeouts are times waiting for a connection timed out.
+	Hits     uint32 `json:"hits"`
+	Misses   uint32 `json:"misses"`
+	Timeouts uint32 `json:"timeouts"`
+}
+
+func (dbCluster *DBCluster) PoolStats() []DBPoolStats {
+	stats := make([]DBPoolStats, 0, len(dbCluster.clients))
+	for _, client := range dbCluster.clients {
+		poolStats := client.client.PoolStats()
+		s := DBPoolStats{
+			Name:       fmt.Sprintf("%d_%d", client.startIndex, client.endIndex),
+			PoolSize:   client.client.Options().PoolSize,
+			TotalConns: poolStats.TotalConns,
+			IdleConns:  poolStats.IdleConns,
+			Hits:       poolStats.Hits,
+			Misses:     poolStats.Misses,
+			Timeouts:   poolStats.Timeouts,
+		}
+		if s.TotalConns > s.IdleConns {
+			s.InUseConns = s.TotalConns - s.IdleConns
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
 func (dbCluser *DBCluster) GetShardingCount() int {
 	return dbCluser.shardingCount
 }
@@ -5,8 +5,11 @@ import (
 	"bytepower_room/utility"
 	"bytes"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
@@ -17,12 +20,28 @@ import (
 )
 
 var (
+	ErrEventEmpty            = errors.New("event is empty")
 	ErrEventHashKeyEmpty     = errors.New("event hash_tag is empty")
 	ErrEventAccessModeEmpty  = errors.New("event access_mode is empty")
+	ErrEventAccessModeWrong  = errors.New("event access_mode is unknown")
+	ErrEventAccessModeTimes  = errors.New("event access_mode does not match event times")
 	ErrEventAccessTimeEmpty  = errors.New("event access_time is empty")
 	ErrWriteEventWithoutKeys = errors.New("write event does not have keys")
+	ErrDeleteEventWithKeys   = errors.New("delete event should not have keys")
+	ErrDeleteEventWithWrite  = errors.New("delete event should not have write_time")
+	ErrDeleteEventWithExpire = errors.New("delete event should not have expire_time")
+	ErrExpireEventWithoutTTL = errors.New("expire event does not have expire_time")
+	ErrEventKeyHashTagWrong  = errors.New("event key does not belong to hash_tag")
+	ErrEventTimeAndAgeBoth   = errors.New("event time and age should not be both set")
+	ErrEventAgeNegative      = errors.New("event age should not be negative")
+	ErrEventNumberInvalid    = errors.New("event number is invalid")
+
+	ErrEventPriorDeleteTimeWrong = errors.New("event prior_delete_time should be before access_time and not set on delete event")
 )
 
+// ages larger than maxEventAgeMS overflow time.Duration
+const maxEventAgeMS = math.MaxInt64 / int64(time.Millisecond)
+
 const HTTPContentTypeJSON = "application/json"
 
 const HashTagEventServiceName = "hash_tag_event_service"
@@ -42,15 +61,110 @@ var (
 type HashTagAccessMode string
 
 const (
-	HashTagAccessModeRead  HashTagAccessMode = "read"
-	HashTagAccessModeWrite HashTagAccessMode = "write"
+	HashTagAccessModeRead   HashTagAccessMode = "read"
+	HashTagAccessModeWrite  HashTagAccessMode = "write"
+	HashTagAccessModeDelete HashTagAccessMode = "delete"
+	// expire sets ttl of keys, expire_time is when keys expire.
+	HashTagAccessModeExpire HashTagAccessMode = "expire"
 )
 
+func (mode HashTagAccessMode) IsValid() bool {
+	switch mode {
+	case HashTagAccessModeRead, HashTagAccessModeWrite, HashTagAccessModeDelete, HashTagAccessModeExpire:
+		return true
+	}
+	return false
+}
+
 type HashTagEvent struct {
 	HashTag    string             `json:"hash_tag"`
 	Keys       *utility.StringSet `json:"keys"`
 	AccessTime time.Time          `json:"access_time"`
 	WriteTime  time.Time          `json:"write_time"`
+	DeleteTime time.Time          `json:"delete_time"`
+	ExpireTime time.Time          `json:"expire_time"`
+	// PriorDeleteTime is set on an access event merged with an earlier delete event,
+	// record accessed before it is removed before the access event is saved.
+	PriorDeleteTime time.Time `json:"prior_delete_time"`
+	// Mode is declared by client and checked against times, empty means mode is decided by times.
+	Mode HashTagAccessMode `json:"access_mode,omitempty"`
+	// DC is the data center collecting event, it is assigned by server.
+	DC string `json:"dc,omitempty"`
+	// ID is assigned by server when event is accepted, merged event has ID of the latest event.
+	ID string `json:"id,omitempty"`
+	// EnqueueTime is assigned by server when event is added to buffer, merged event has the earliest one.
+	EnqueueTime time.Time `json:"enqueue_time"`
+	// ages are milliseconds before event is received, they are set instead of times by clients
+	// without reliable clocks, and resolved to times by ResolveAges.
+	AccessAgeMS *int64 `json:"access_age_ms,omitempty"`
+	WriteAgeMS  *int64 `json:"write_age_ms,omitempty"`
+	DeleteAgeMS *int64 `json:"delete_age_ms,omitempty"`
+
+	// numberErr is set by UnmarshalJSON if a number field is not an integer in range,
+	// it is returned by Check and ResolveAges instead of failing decoding of other events.
+	numberErr error
+}
+
+// eventNumber keeps a raw JSON number, so it is checked to be an integer instead of truncated.
+type eventNumber []byte
+
+func (number *eventNumber) UnmarshalJSON(data []byte) error {
+	*number = append((*number)[:0], data...)
+	return nil
+}
+
+// UnmarshalJSON decodes number fields as integers, fractional or out of range numbers are kept in numberErr.
+func (event *HashTagEvent) UnmarshalJSON(data []byte) error {
+	type hashTagEventAlias HashTagEvent
+	numbers := struct {
+		*hashTagEventAlias
+		AccessAgeMS eventNumber `json:"access_age_ms,omitempty"`
+		WriteAgeMS  eventNumber `json:"write_age_ms,omitempty"`
+		DeleteAgeMS eventNumber `json:"delete_age_ms,omitempty"`
+	}{hashTagEventAlias: (*hashTagEventAlias)(event)}
+	if err := json.Unmarshal(data, &numbers); err != nil {
+		return err
+	}
+	event.numberErr = nil
+	fields := []struct {
+		name   string
+		number eventNumber
+		value  **int64
+	}{
+		{name: "access_age_ms", number: numbers.AccessAgeMS, value: &event.AccessAgeMS},
+		{name: "write_age_ms", number: numbers.WriteAgeMS, value: &event.WriteAgeMS},
+		{name: "delete_age_ms", number: numbers.DeleteAgeMS, value: &event.DeleteAgeMS},
+	}
+	for _, field := range fields {
+		*field.value = nil
+		if len(field.number) == 0 || string(field.number) == "null" {
+			continue
+		}
+		value, err := strconv.ParseInt(string(field.number), 10, 64)
+		if err != nil {
+			if event.numberErr == nil {
+				event.numberErr = fmt.Errorf(
+					"%w, %s is %s, it should be an integer in [0, %d]",
+					ErrEventNumberInvalid, field.name, field.number, maxEventAgeMS)
+			}
+			continue
+		}
+		*field.value = &value
+	}
+	return nil
+}
+
+func checkEventAgeMS(name string, ageMS *int64) error {
+	if ageMS == nil {
+		return nil
+	}
+	if *ageMS < 0 {
+		return fmt.Errorf("%w, %s is %d", ErrEventAgeNegative, name, *ageMS)
+	}
+	if *ageMS > maxEventAgeMS {
+		return fmt.Errorf("%w, %s is %d, it should be an integer in [0, %d]", ErrEventNumberInvalid, name, *ageMS, maxEventAgeMS)
+	}
+	return nil
 }
 
 func NewHashTagEvent(hashTag string, keys []string, accessMode HashTagAccessMode, accessTime time.Time) (HashTagEvent, error) {
@@ -59,8 +173,15 @@ func NewHashTagEvent(hashTag string, keys []string, accessMode HashTagAccessMode
 		Keys:       utility.NewStringSet(keys...),
 		AccessTime: accessTime,
 	}
-	if accessMode == HashTagAccessModeWrite {
+	switch accessMode {
+	case HashTagAccessModeWrite:
 		event.WriteTime = accessTime
+	case HashTagAccessModeDelete:
+		event.DeleteTime = accessTime
+	case HashTagAccessModeRead:
+	default:
+		// expire event needs expire_time, it and unknown modes are rejected by Check
+		event.Mode = accessMode
 	}
 	if err := event.Check(); err != nil {
 		return HashTagEvent{}, err
@@ -68,42 +189,231 @@ func NewHashTagEvent(hashTag string, keys []string, accessMode HashTagAccessMode
 	return event, nil
 }
 
+// ResolveAges converts ages to times before receiveTime and clears ages,
+// time and age of the same kind should not be both set.
+func (event *HashTagEvent) ResolveAges(receiveTime time.Time) error {
+	timeAges := []struct {
+		name  string
+		time  *time.Time
+		ageMS **int64
+	}{
+		{name: "access", time: &event.AccessTime, ageMS: &event.AccessAgeMS},
+		{name: "write", time: &event.WriteTime, ageMS: &event.WriteAgeMS},
+		{name: "delete", time: &event.DeleteTime, ageMS: &event.DeleteAgeMS},
+	}
+	if event.numberErr != nil {
+		return event.numberErr
+	}
+	for _, timeAge := range timeAges {
+		ageMS := *timeAge.ageMS
+		if ageMS == nil {
+			continue
+		}
+		if !timeAge.time.IsZero() {
+			return fmt.Errorf("%w, %s_time and %s_age_ms", ErrEventTimeAndAgeBoth, timeAge.name, timeAge.name)
+		}
+		if err := checkEventAgeMS(timeAge.name+"_age_ms", ageMS); err != nil {
+			return err
+		}
+		*timeAge.time = receiveTime.Add(-time.Duration(*ageMS) * time.Millisecond)
+		*timeAge.ageMS = nil
+	}
+	return nil
+}
+
+// IsEmpty returns true if event has no fields, e.g. null or {} in json.
+func (event HashTagEvent) IsEmpty() bool {
+	return event.HashTag == "" && event.Keys == nil && event.AccessTime.IsZero() && event.WriteTime.IsZero() &&
+		event.DeleteTime.IsZero() && event.ExpireTime.IsZero() && event.PriorDeleteTime.IsZero() && event.EnqueueTime.IsZero() && event.Mode == "" && event.AccessAgeMS == nil && event.WriteAgeMS == nil && event.DeleteAgeMS == nil &&
+		event.numberErr == nil
+}
+
 func (event HashTagEvent) Check() error {
 	if event.HashTag == "" {
+		if event.IsEmpty() {
+			return ErrEventEmpty
+		}
 		return ErrEventHashKeyEmpty
 	}
+	if event.numberErr != nil {
+		return event.numberErr
+	}
+	if err := checkEventAgeMS("access_age_ms", event.AccessAgeMS); err != nil {
+		return err
+	}
+	if err := checkEventAgeMS("write_age_ms", event.WriteAgeMS); err != nil {
+		return err
+	}
+	if err := checkEventAgeMS("delete_age_ms", event.DeleteAgeMS); err != nil {
+		return err
+	}
 	if event.AccessTime.IsZero() {
 		return ErrEventAccessTimeEmpty
 	}
-	if !event.WriteTime.IsZero() && event.Keys.Len() == 0 {
+	if event.Mode != "" {
+		if !event.Mode.IsValid() {
+			return fmt.Errorf("%w, access_mode is %s", ErrEventAccessModeWrong, event.Mode)
+		}
+		if event.Mode == HashTagAccessModeExpire && event.ExpireTime.IsZero() {
+			return ErrExpireEventWithoutTTL
+		}
+		if mode := event.AccessMode(); mode != event.Mode {
+			return fmt.Errorf("%w, access_mode is %s, times are of %s", ErrEventAccessModeTimes, event.Mode, mode)
+		}
+	}
+	if !event.PriorDeleteTime.IsZero() && (event.IsDelete() || event.PriorDeleteTime.After(event.AccessTime)) {
+		return ErrEventPriorDeleteTimeWrong
+	}
+	if event.IsDelete() {
+		if event.Keys != nil && event.Keys.Len() != 0 {
+			return ErrDeleteEventWithKeys
+		}
+		if !event.WriteTime.IsZero() {
+			return ErrDeleteEventWithWrite
+		}
+		if !event.ExpireTime.IsZero() {
+			return ErrDeleteEventWithExpire
+		}
+		return nil
+	}
+	if !event.WriteTime.IsZero() && (event.Keys == nil || event.Keys.Len() == 0) {
 		return ErrWriteEventWithoutKeys
 	}
 	return nil
 }
 
+// CheckKeysHashTag checks every key belongs to hash tag of event,
+// it is not a part of Check since it costs for every key.
+func (event HashTagEvent) CheckKeysHashTag() error {
+	if event.Keys == nil {
+		return nil
+	}
+	for _, key := range event.Keys.ToSlice() {
+		if ExtractHashTagFromKey(key) != event.HashTag {
+			return fmt.Errorf("%w, key %s, hash_tag %s", ErrEventKeyHashTagWrong, key, event.HashTag)
+		}
+	}
+	return nil
+}
+
+// ExtractHashTagFromKey returns content in the first {...} of key, empty if key has no hash tag.
+func ExtractHashTagFromKey(key string) string {
+	leftBraceIndex := strings.Index(key, "{")
+	if leftBraceIndex == -1 {
+		return ""
+	}
+	rightBraceIndex := strings.Index(key[leftBraceIndex:], "}")
+	if rightBraceIndex > 1 {
+		return key[leftBraceIndex+1 : leftBraceIndex+rightBraceIndex]
+	}
+	return ""
+}
+
+// IsDelete reports whether event is a tombstone, which means the hash tag is deleted.
+func (event HashTagEvent) IsDelete() bool {
+	return !event.DeleteTime.IsZero()
+}
+
+// AccessMode is decided by times, a write setting ttl is a write event.
+func (event HashTagEvent) AccessMode() HashTagAccessMode {
+	if event.IsDelete() {
+		return HashTagAccessModeDelete
+	}
+	if !event.WriteTime.IsZero() {
+		return HashTagAccessModeWrite
+	}
+	if !event.ExpireTime.IsZero() {
+		return HashTagAccessModeExpire
+	}
+	return HashTagAccessModeRead
+}
+
 func (event HashTagEvent) String() string {
 	var result string
 	bs, err := json.Marshal(event)
 	if err != nil {
 		result = fmt.Sprintf(
-			"Event[hash_tag=%s, access_time=%v, write_time=%v, keys=%s]",
-			event.HashTag, event.AccessTime, event.WriteTime, strings.Join(event.Keys.ToSlice(), " "))
+			"Event[hash_tag=%s, access_time=%v, write_time=%v, delete_time=%v, keys=%s]",
+			event.HashTag, event.AccessTime, event.WriteTime, event.DeleteTime, strings.Join(event.Keys.ToSlice(), " "))
 	} else {
 		result = string(bs)
 	}
 	return result
 }
 
+// TruncatedString is like String but keeps at most keyLimit keys in sorted order and adds key_count of all keys,
+// it keeps logs of events with many keys short. keyLimit <= 0 means all keys are kept.
+func (event HashTagEvent) TruncatedString(keyLimit int) string {
+	if keyLimit <= 0 || event.Keys == nil || event.Keys.Len() <= keyLimit {
+		return event.String()
+	}
+	keys := event.Keys.ToSlice()
+	sort.Strings(keys)
+	truncated := event
+	truncated.Keys = utility.NewStringSet(keys[:keyLimit]...)
+	bs, err := json.Marshal(struct {
+		HashTagEvent
+		KeyCount int `json:"key_count"`
+	}{HashTagEvent: truncated, KeyCount: len(keys)})
+	if err != nil {
+		return fmt.Sprintf("%s, key_count=%d", truncated.String(), len(keys))
+	}
+	return string(bs)
+}
+
 func (event HashTagEvent) Copy() HashTagEvent {
 	return HashTagEvent{
 		HashTag:    event.HashTag,
 		Keys:       event.Keys.Copy(),
 		AccessTime: event.AccessTime,
 		WriteTime:  event.WriteTime,
+		DeleteTime: event.DeleteTime,
+		ExpireTime: event.ExpireTime,
+		Mode:       event.Mode,
+		DC:         event.DC,
+		ID:         event.ID,
+
+		PriorDeleteTime: event.PriorDeleteTime,
+		EnqueueTime:     event.EnqueueTime,
+
+		AccessAgeMS: copyInt64Pointer(event.AccessAgeMS),
+		WriteAgeMS:  copyInt64Pointer(event.WriteAgeMS),
+		DeleteAgeMS: copyInt64Pointer(event.DeleteAgeMS),
+
+		numberErr: event.numberErr,
+	}
+}
+
+func copyInt64Pointer(p *int64) *int64 {
+	if p == nil {
+		return nil
 	}
+	v := *p
+	return &v
 }
 
+// EventMergeMode defines how attributes of events of the same hash tag are merged,
+// keys are always unioned and access and write times are always the latest ones.
+type EventMergeMode string
+
+const (
+	// dc and id are of the latest event by access time
+	EventMergeModeLatestWins EventMergeMode = "latest_wins"
+	// dc and id are kept only if all merged events have the same ones
+	EventMergeModeUnion EventMergeMode = "union"
+)
+
 func MergeEvents(event HashTagEvent, events ...HashTagEvent) (HashTagEvent, error) {
+	return MergeEventsWithMode(EventMergeModeLatestWins, event, events...)
+}
+
+// MergeEventsWithMode merges events in the same result regardless of their order.
+// A delete event can not be merged with access events in both modes, the latest one wins,
+// an access event winning a delete event keeps its delete time as PriorDeleteTime, so the deletion is not lost.
+// Keys of access events merged before an earlier delete event are kept, events are expected to be merged nearly in order.
+// Events with the same access time are ordered by delete first, then id and dc, so ties are deterministic.
+// In latest_wins mode, empty dc or id of the latest event does not replace a non-empty one.
+func MergeEventsWithMode(mode EventMergeMode, event HashTagEvent, events ...HashTagEvent) (HashTagEvent, error) {
 	if err := event.Check(); err != nil {
 		return HashTagEvent{}, err
 	}
@@ -115,6 +425,39 @@ func MergeEvents(event HashTagEvent, events ...HashTagEvent) (HashTagEvent, erro
 		if newEvent.HashTag != event.HashTag {
 			return HashTagEvent{}, errors.New("events should have the same hash_tag")
 		}
+		enqueueTime := utility.GetEarliestTime(newEvent.EnqueueTime, event.EnqueueTime)
+		if newEvent.IsDelete() || event.IsDelete() {
+			deleteTime := utility.GetLatestTime(newEvent.DeleteTime, event.DeleteTime)
+			if event.isLaterThan(newEvent) {
+				newEvent = event.Copy()
+			}
+			if !newEvent.IsDelete() {
+				newEvent.PriorDeleteTime = utility.GetLatestTime(newEvent.PriorDeleteTime, deleteTime)
+			}
+			newEvent.EnqueueTime = enqueueTime
+			continue
+		}
+		newEvent.EnqueueTime = enqueueTime
+		newEvent.PriorDeleteTime = utility.GetLatestTime(newEvent.PriorDeleteTime, event.PriorDeleteTime)
+		// expire time is of the latest event setting ttl
+		if !event.ExpireTime.IsZero() && (newEvent.ExpireTime.IsZero() || event.isLaterThan(newEvent)) {
+			newEvent.ExpireTime = event.ExpireTime
+		}
+		// merged event of different modes has the mode decided by times
+		newEvent.Mode = mergeUnionAttributeMode(newEvent.Mode, event.Mode)
+		switch mode {
+		case EventMergeModeUnion:
+			newEvent.DC = mergeUnionAttribute(newEvent.DC, event.DC)
+			newEvent.ID = mergeUnionAttribute(newEvent.ID, event.ID)
+		default:
+			isLater := event.isLaterThan(newEvent)
+			if event.DC != "" && (isLater || newEvent.DC == "") {
+				newEvent.DC = event.DC
+			}
+			if event.ID != "" && (isLater || newEvent.ID == "") {
+				newEvent.ID = event.ID
+			}
+		}
 		newEvent.WriteTime = utility.GetLatestTime(newEvent.WriteTime, event.WriteTime)
 		newEvent.AccessTime = utility.GetLatestTime(newEvent.AccessTime, event.AccessTime)
 		newEvent.Keys.Merge(event.Keys)
@@ -122,6 +465,30 @@ func MergeEvents(event HashTagEvent, events ...HashTagEvent) (HashTagEvent, erro
 	return newEvent, nil
 }
 
+func (event HashTagEvent) isLaterThan(other HashTagEvent) bool {
+	if !event.AccessTime.Equal(other.AccessTime) {
+		return event.AccessTime.After(other.AccessTime)
+	}
+	if event.IsDelete() != other.IsDelete() {
+		return event.IsDelete()
+	}
+	if event.ID != other.ID {
+		return event.ID > other.ID
+	}
+	return event.DC > other.DC
+}
+
+func mergeUnionAttribute(a, b string) string {
+	if a == b {
+		return a
+	}
+	return ""
+}
+
+func mergeUnionAttributeMode(a, b HashTagAccessMode) HashTagAccessMode {
+	return HashTagAccessMode(mergeUnionAttribute(string(a), string(b)))
+}
+
 type HashTagEventServiceConfig struct {
 	EventReport HashTagEventServiceEventReportConfig `yaml:"event_report"`
 
@@ -153,6 +520,9 @@ func (config HashTagEventServiceConfig) check() error {
 
 type HashTagEventServiceEventReportConfig struct {
 	URL string `yaml:"url"`
+	// events are reported to one of urls by consistent hashing of hash tag, url is ignored if urls is not empty.
+	URLs                []string `yaml:"urls"`
+	URLVirtualNodeCount int      `yaml:"url_virtual_node_count"`
 
 	RawRequestTimeout string `yaml:"request_timeout"`
 	RequestTimeout    time.Duration
@@ -174,8 +544,11 @@ type HashTagEventServiceEventReportConfig struct {
 }
 
 func (config HashTagEventServiceEventReportConfig) check() error {
-	if config.URL == "" {
-		return errors.New("url should not be empty")
+	if config.URL == "" && len(config.URLs) == 0 {
+		return errors.New("url and urls should not be both empty")
+	}
+	if len(config.URLs) > 0 && config.URLVirtualNodeCount <= 0 {
+		return fmt.Errorf("url_virtual_node_count=%d, it should be greater than 0", config.URLVirtualNodeCount)
 	}
 	if config.RawRequestTimeout == "" {
 		return errors.New("request_timeout should not be empty")
@@ -216,6 +589,9 @@ type HashTagEventService struct {
 	stopCh                           chan bool
 	stop                             int32
 	client                           *http.Client
+	// nil if events are reported to config.EventReport.URL
+	urlRing  *utility.ConsistentHash
+	urlMutex sync.RWMutex
 }
 
 func NewHashTagEventService(config *HashTagEventServiceConfig, logger *log.Logger, metric *MetricClient) (*HashTagEventService, error) {
@@ -252,6 +628,9 @@ func NewHashTagEventService(config *HashTagEventServiceConfig, logger *log.Logge
 		stop:                             0,
 		client:                           client,
 	}
+	if len(config.EventReport.URLs) > 0 {
+		server.urlRing = utility.NewConsistentHash(config.EventReport.URLVirtualNodeCount, config.EventReport.URLs...)
+	}
 	logger.Info(
 		"new hash_tag_event service",
 		log.String("config", fmt.Sprintf("%+v", config)))
@@ -390,19 +769,56 @@ func (service *HashTagEventService) reportEvents() {
 				break loop
 			}
 		}
-		err := service._reportEvents(events)
-		if err != nil {
-			service.recordReportEventsError(events, err)
-		} else {
-			service.metric.MetricCount(metricReportEventsSuccess, len(events))
-		}
+		service.reportEventsByURL(events)
 		if stop {
 			break
 		}
 	}
 }
 
-func (service *HashTagEventService) _reportEvents(events []HashTagEvent) error {
+// SetEventReportURLs changes urls events are reported to,
+// only events of hash tags on added or removed urls are reported to other urls.
+func (service *HashTagEventService) SetEventReportURLs(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("urls should not be empty")
+	}
+	virtualNodeCount := service.config.EventReport.URLVirtualNodeCount
+	if virtualNodeCount <= 0 {
+		return fmt.Errorf("url_virtual_node_count=%d, it should be greater than 0", virtualNodeCount)
+	}
+	urlRing := utility.NewConsistentHash(virtualNodeCount, urls...)
+	service.urlMutex.Lock()
+	defer service.urlMutex.Unlock()
+	service.urlRing = urlRing
+	service.logger.Info(fmt.Sprintf("%s: set event report urls", service.name), log.String("urls", strings.Join(urls, " ")))
+	return nil
+}
+
+func (service *HashTagEventService) groupEventsByURL(events []HashTagEvent) map[string][]HashTagEvent {
+	service.urlMutex.RLock()
+	defer service.urlMutex.RUnlock()
+	if service.urlRing == nil {
+		return map[string][]HashTagEvent{service.config.EventReport.URL: events}
+	}
+	urlEvents := make(map[string][]HashTagEvent)
+	for _, event := range events {
+		url := service.urlRing.Get(event.HashTag)
+		urlEvents[url] = append(urlEvents[url], event)
+	}
+	return urlEvents
+}
+
+func (service *HashTagEventService) reportEventsByURL(events []HashTagEvent) {
+	for url, urlEvents := range service.groupEventsByURL(events) {
+		if err := service._reportEvents(url, urlEvents); err != nil {
+			service.recordReportEventsError(urlEvents, err)
+		} else {
+			service.metric.MetricCount(metricReportEventsSuccess, len(urlEvents))
+		}
+	}
+}
+
+func (service *HashTagEventService) _reportEvents(url string, events []HashTagEvent) error {
 	if len(events) == 0 {
 		return nil
 	}
@@ -412,7 +828,7 @@ func (service *HashTagEventService) _reportEvents(events []HashTagEvent) error {
 		return err
 	}
 	requestBody := bytes.NewReader(bs)
-	resp, err := service.client.Post(service.config.EventReport.URL, HTTPContentTypeJSON, requestBody)
+	resp, err := service.client.Post(url, HTTPContentTypeJSON, requestBody)
 	if err != nil {
 		return err
 	}
@@ -463,6 +879,7 @@ func (service *HashTagEventService) send(event HashTagEvent) error {
 				log.String("info", fmt.Sprintf("%+v", r)),
 			)
 			service.metric.MetricIncrease(metricSendEventPanic)
+			service.metric.Flush()
 		}
 	}()
 	select {
@@ -495,19 +912,11 @@ func (service *HashTagEventService) drainEvents() {
 	for _, event := range allEvents {
 		events = append(events, event)
 		if len(events) == requestMaxEvent {
-			if err := service._reportEvents(events); err != nil {
-				service.recordReportEventsError(events, err)
-			} else {
-				service.metric.MetricCount(metricReportEventsSuccess, len(events))
-			}
+			service.reportEventsByURL(events)
 			events = make([]HashTagEvent, 0, requestMaxEvent)
 		}
 	}
-	if err := service._reportEvents(events); err != nil {
-		service.recordReportEventsError(events, err)
-	} else {
-		service.metric.MetricCount(metricReportEventsSuccess, len(events))
-	}
+	service.reportEventsByURL(events)
 }
 
 func (service *HashTagEventService) closeAndEmptifyChannel(ch chan HashTagEvent, counter *int64) {
@@ -2,6 +2,8 @@ package base
 
 import (
 	"bytepower_room/utility"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -31,6 +33,7 @@ func TestNewHashTagEvent(t *testing.T) {
 	assert.True(t, event.AccessTime.Equal(accessTime))
 	assert.True(t, event.WriteTime.IsZero())
 	assert.ElementsMatch(t, event.Keys.ToSlice(), keys)
+	assert.Equal(t, HashTagAccessModeRead, event.AccessMode())
 
 	// write event
 	event, err = NewHashTagEvent(hashTag, keys, HashTagAccessModeWrite, accessTime)
@@ -39,6 +42,7 @@ func TestNewHashTagEvent(t *testing.T) {
 	assert.True(t, event.AccessTime.Equal(accessTime))
 	assert.True(t, event.WriteTime.Equal(accessTime))
 	assert.ElementsMatch(t, event.Keys.ToSlice(), keys)
+	assert.Equal(t, HashTagAccessModeWrite, event.AccessMode())
 
 	// read with empty keys
 	event, err = NewHashTagEvent(hashTag, []string{}, HashTagAccessModeRead, accessTime)
@@ -51,6 +55,197 @@ func TestNewHashTagEvent(t *testing.T) {
 	// write with empty keys
 	event, err = NewHashTagEvent(hashTag, []string{}, HashTagAccessModeWrite, accessTime)
 	assert.NotNil(t, err)
+
+	// delete event
+	event, err = NewHashTagEvent(hashTag, []string{}, HashTagAccessModeDelete, accessTime)
+	assert.Nil(t, err)
+	assert.True(t, event.IsDelete())
+	assert.True(t, event.DeleteTime.Equal(accessTime))
+	assert.True(t, event.WriteTime.IsZero())
+	assert.Equal(t, HashTagAccessModeDelete, event.AccessMode())
+
+	// delete with keys
+	_, err = NewHashTagEvent(hashTag, keys, HashTagAccessModeDelete, accessTime)
+	assert.Equal(t, ErrDeleteEventWithKeys, err)
+
+	// delete with write time
+	event = HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: accessTime, WriteTime: accessTime, DeleteTime: accessTime}
+	assert.Equal(t, ErrDeleteEventWithWrite, event.Check())
+}
+
+func TestHashTagEventAccessMode(t *testing.T) {
+	accessTime := time.Now()
+	expireTime := accessTime.Add(time.Hour)
+
+	// expire event
+	event := HashTagEvent{HashTag: "xyz", AccessTime: accessTime, ExpireTime: expireTime, Mode: HashTagAccessModeExpire}
+	assert.Nil(t, event.Check())
+	assert.Equal(t, HashTagAccessModeExpire, event.AccessMode())
+	event.Mode = ""
+	assert.Nil(t, event.Check())
+	assert.Equal(t, HashTagAccessModeExpire, event.AccessMode())
+
+	// write setting ttl is a write event
+	event = HashTagEvent{HashTag: "xyz", Keys: utility.NewStringSet("{xyz}a"), AccessTime: accessTime, WriteTime: accessTime, ExpireTime: expireTime}
+	assert.Equal(t, HashTagAccessModeWrite, event.AccessMode())
+	event.Mode = HashTagAccessModeWrite
+	assert.Nil(t, event.Check())
+	event.Mode = HashTagAccessModeExpire
+	assert.True(t, errors.Is(event.Check(), ErrEventAccessModeTimes))
+
+	_, err := NewHashTagEvent("xyz", nil, HashTagAccessModeExpire, accessTime)
+	assert.Equal(t, ErrExpireEventWithoutTTL, err)
+	_, err = NewHashTagEvent("xyz", nil, HashTagAccessMode("touch"), accessTime)
+	assert.True(t, errors.Is(err, ErrEventAccessModeWrong))
+
+	event = HashTagEvent{HashTag: "xyz", AccessTime: accessTime, Mode: HashTagAccessModeWrite}
+	assert.True(t, errors.Is(event.Check(), ErrEventAccessModeTimes))
+	event = HashTagEvent{HashTag: "xyz", AccessTime: accessTime, DeleteTime: accessTime, Mode: HashTagAccessModeDelete}
+	assert.Nil(t, event.Check())
+	event.ExpireTime = expireTime
+	assert.Equal(t, ErrDeleteEventWithExpire, event.Check())
+
+	var events []HashTagEvent
+	body := `[{"hash_tag": "xyz", "access_time": "2021-06-25T11:30:25Z", "expire_time": "2021-06-25T12:30:25Z", "access_mode": "expire"},
+		{"hash_tag": "xyz", "access_time": "2021-06-25T11:30:25Z", "access_mode": "touch"}]`
+	assert.Nil(t, json.Unmarshal([]byte(body), &events))
+	assert.Nil(t, events[0].Check())
+	assert.Equal(t, HashTagAccessModeExpire, events[0].AccessMode())
+	assert.True(t, errors.Is(events[1].Check(), ErrEventAccessModeWrong))
+}
+
+func TestHashTagEventMergeExpire(t *testing.T) {
+	accessTime := time.Now()
+	expire := HashTagEvent{HashTag: "xyz", Keys: utility.NewStringSet(), AccessTime: accessTime, ExpireTime: accessTime.Add(time.Hour), Mode: HashTagAccessModeExpire}
+	laterExpire := HashTagEvent{HashTag: "xyz", Keys: utility.NewStringSet(), AccessTime: accessTime.Add(time.Second), ExpireTime: accessTime.Add(time.Minute), Mode: HashTagAccessModeExpire}
+	read, _ := NewHashTagEvent("xyz", nil, HashTagAccessModeRead, accessTime.Add(2*time.Second))
+
+	event, err := MergeEvents(laterExpire, expire)
+	assert.Nil(t, err)
+	assert.True(t, event.ExpireTime.Equal(laterExpire.ExpireTime))
+	assert.Equal(t, HashTagAccessModeExpire, event.Mode)
+
+	// expire time is kept by later read event, mode is decided by times
+	event, err = MergeEvents(expire, read)
+	assert.Nil(t, err)
+	assert.True(t, event.ExpireTime.Equal(expire.ExpireTime))
+	assert.Equal(t, HashTagAccessMode(""), event.Mode)
+	assert.Equal(t, HashTagAccessModeExpire, event.AccessMode())
+	assert.Nil(t, event.Check())
+}
+
+func TestHashTagEventTruncatedString(t *testing.T) {
+	accessTime := time.Date(2021, 6, 25, 11, 30, 25, 0, time.UTC)
+	event, _ := NewHashTagEvent("xyz", []string{"{xyz}c", "{xyz}a", "{xyz}b"}, HashTagAccessModeRead, accessTime)
+	var full map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(event.TruncatedString(0)), &full))
+	assert.Equal(t, 3, len(full["keys"].([]interface{})))
+	assert.Nil(t, full["key_count"])
+	full = nil
+	assert.Nil(t, json.Unmarshal([]byte(event.TruncatedString(3)), &full))
+	assert.Equal(t, 3, len(full["keys"].([]interface{})))
+
+	var truncated map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(event.TruncatedString(2)), &truncated))
+	assert.Equal(t, []interface{}{"{xyz}a", "{xyz}b"}, truncated["keys"])
+	assert.Equal(t, float64(3), truncated["key_count"])
+	assert.Equal(t, "xyz", truncated["hash_tag"])
+	assert.Equal(t, "2021-06-25T11:30:25Z", truncated["access_time"])
+	// event is not changed
+	assert.Equal(t, 3, event.Keys.Len())
+}
+
+func TestHashTagEventResolveAges(t *testing.T) {
+	receiveTime := time.Now()
+	accessAgeMS, writeAgeMS := int64(2000), int64(1000)
+	event := HashTagEvent{HashTag: "xyz", Keys: utility.NewStringSet("{xyz}a"), AccessAgeMS: &accessAgeMS, WriteAgeMS: &writeAgeMS}
+	assert.Equal(t, ErrEventAccessTimeEmpty, event.Check())
+	assert.Nil(t, event.ResolveAges(receiveTime))
+	assert.True(t, event.AccessTime.Equal(receiveTime.Add(-2*time.Second)))
+	assert.True(t, event.WriteTime.Equal(receiveTime.Add(-time.Second)))
+	assert.Nil(t, event.AccessAgeMS)
+	assert.Nil(t, event.WriteAgeMS)
+	assert.Nil(t, event.Check())
+
+	// times are kept without ages
+	assert.Nil(t, event.ResolveAges(time.Now()))
+	assert.True(t, event.AccessTime.Equal(receiveTime.Add(-2*time.Second)))
+
+	event = HashTagEvent{HashTag: "xyz", AccessTime: receiveTime, AccessAgeMS: &accessAgeMS}
+	assert.True(t, errors.Is(event.ResolveAges(receiveTime), ErrEventTimeAndAgeBoth))
+
+	negativeAgeMS := int64(-1)
+	event = HashTagEvent{HashTag: "xyz", DeleteAgeMS: &negativeAgeMS}
+	assert.True(t, errors.Is(event.ResolveAges(receiveTime), ErrEventAgeNegative))
+}
+
+func TestHashTagEventEmpty(t *testing.T) {
+	var events []HashTagEvent
+	assert.Nil(t, json.Unmarshal([]byte(`[null, {}, {"keys": []}, {"hash_tag": "abc"}]`), &events))
+	assert.Equal(t, 4, len(events))
+	assert.Equal(t, ErrEventEmpty, events[0].Check())
+	assert.Equal(t, ErrEventEmpty, events[1].Check())
+	assert.Equal(t, ErrEventHashKeyEmpty, events[2].Check())
+	assert.Equal(t, ErrEventAccessTimeEmpty, events[3].Check())
+}
+
+func TestHashTagEventUnmarshalNumbers(t *testing.T) {
+	var event HashTagEvent
+	assert.Nil(t, json.Unmarshal([]byte(`{"hash_tag": "abc", "keys": ["{abc}a"], "access_age_ms": 1000, "write_age_ms": null}`), &event))
+	assert.Equal(t, int64(1000), *event.AccessAgeMS)
+	assert.Nil(t, event.WriteAgeMS)
+	assert.Equal(t, []string{"{abc}a"}, event.Keys.ToSlice())
+	assert.Nil(t, event.ResolveAges(time.Now()))
+	assert.Nil(t, event.Check())
+
+	for _, number := range []string{"1.5", "1e3", "9223372036854775808", "-9223372036854775809"} {
+		event = HashTagEvent{}
+		assert.Nil(t, json.Unmarshal([]byte(`{"hash_tag": "abc", "keys": [], "access_age_ms": `+number+`}`), &event))
+		assert.Nil(t, event.AccessAgeMS)
+		err := event.Check()
+		assert.True(t, errors.Is(err, ErrEventNumberInvalid), number)
+		assert.Contains(t, err.Error(), "access_age_ms is "+number)
+		assert.True(t, errors.Is(event.ResolveAges(time.Now()), ErrEventNumberInvalid))
+		assert.True(t, errors.Is(event.Copy().Check(), ErrEventNumberInvalid))
+	}
+
+	// in int64 range but overflows time.Duration
+	event = HashTagEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"hash_tag": "abc", "keys": [], "delete_age_ms": 9223372036854775807}`), &event))
+	assert.True(t, errors.Is(event.Check(), ErrEventNumberInvalid))
+	assert.True(t, errors.Is(event.ResolveAges(time.Now()), ErrEventNumberInvalid))
+
+	// valid event after an invalid one
+	assert.Nil(t, json.Unmarshal([]byte(`{"hash_tag": "abc", "keys": [], "access_age_ms": 1}`), &event))
+	assert.Nil(t, event.ResolveAges(time.Now()))
+	assert.Nil(t, event.Check())
+
+	var events []HashTagEvent
+	assert.Nil(t, json.Unmarshal([]byte(`[{"hash_tag": "a", "keys": [], "access_age_ms": 1.5}, {"hash_tag": "b", "keys": [], "access_age_ms": 1}]`), &events))
+	assert.True(t, errors.Is(events[0].ResolveAges(time.Now()), ErrEventNumberInvalid))
+	assert.Nil(t, events[1].ResolveAges(time.Now()))
+	assert.Nil(t, events[1].Check())
+
+	// not a number
+	assert.Nil(t, json.Unmarshal([]byte(`{"hash_tag": "abc", "keys": [], "access_age_ms": "1"}`), &event))
+	assert.True(t, errors.Is(event.Check(), ErrEventNumberInvalid))
+}
+
+func TestHashTagEventCheckKeysHashTag(t *testing.T) {
+	accessTime := time.Now()
+	event, err := NewHashTagEvent("xyz", []string{"{xyz}a", "b{xyz}", "{xyz}{abc}"}, HashTagAccessModeWrite, accessTime)
+	assert.Nil(t, err)
+	assert.Nil(t, event.CheckKeysHashTag())
+
+	event = HashTagEvent{HashTag: "xyz", AccessTime: accessTime}
+	assert.Nil(t, event.CheckKeysHashTag())
+
+	for _, key := range []string{"{abc}a", "xyz", "{}xyz", "{abc}{xyz}", "{xy}z"} {
+		event, err = NewHashTagEvent("xyz", []string{"{xyz}a", key}, HashTagAccessModeWrite, accessTime)
+		assert.Nil(t, err)
+		err = event.CheckKeysHashTag()
+		assert.True(t, errors.Is(err, ErrEventKeyHashTagWrong), key)
+	}
 }
 
 func TestHashTagEventAggregateEvent(t *testing.T) {
@@ -142,27 +337,61 @@ func TestHashTagEventMerge(t *testing.T) {
 		{
 			"merge event with different hash tags",
 			[]HashTagEvent{
-				{"abc", utility.NewStringSet("{abc}a"), times[0], times[0]},
-				{"bcd", utility.NewStringSet("{bcd}a"), times[0], times[0]},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[0], WriteTime: times[0]},
+				{HashTag: "bcd", Keys: utility.NewStringSet("{bcd}a"), AccessTime: times[0], WriteTime: times[0]},
 			},
 			false,
 			HashTagEvent{},
 		}, {
 			"merge read and write events",
 			[]HashTagEvent{
-				{"abc", utility.NewStringSet("{abc}a", "{abc}c"), times[1], times[1]},
-				{"abc", utility.NewStringSet("{abc}b"), times[2], times[0]},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a", "{abc}c"), AccessTime: times[1], WriteTime: times[1]},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}b"), AccessTime: times[2], WriteTime: times[0]},
 			},
 			true,
-			HashTagEvent{"abc", utility.NewStringSet("{abc}a", "{abc}b", "{abc}c"), times[2], times[1]},
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet("{abc}a", "{abc}b", "{abc}c"), AccessTime: times[2], WriteTime: times[1]},
 		}, {
 			"merge read only events",
 			[]HashTagEvent{
-				{"abc", utility.NewStringSet("{abc}a", "{abc}b"), times[2], time.Time{}},
-				{"abc", utility.NewStringSet("{abc}m", "{abc}n"), times[3], time.Time{}},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a", "{abc}b"), AccessTime: times[2]},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}m", "{abc}n"), AccessTime: times[3]},
 			},
 			true,
-			HashTagEvent{"abc", utility.NewStringSet("{abc}a", "{abc}b", "{abc}m", "{abc}n"), times[3], time.Time{}},
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet("{abc}a", "{abc}b", "{abc}m", "{abc}n"), AccessTime: times[3]},
+		}, {
+			"merge write event and later delete event",
+			[]HashTagEvent{
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[4], WriteTime: times[4]},
+				{HashTag: "abc", Keys: utility.NewStringSet(), AccessTime: times[5], DeleteTime: times[5]},
+			},
+			true,
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet(), AccessTime: times[5], DeleteTime: times[5]},
+		}, {
+			"merge delete event and later write event",
+			[]HashTagEvent{
+				{HashTag: "abc", Keys: utility.NewStringSet(), AccessTime: times[5], DeleteTime: times[5]},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[6], WriteTime: times[6]},
+			},
+			true,
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[6], WriteTime: times[6], PriorDeleteTime: times[5]},
+		}, {
+			"merge events from different dcs",
+			[]HashTagEvent{
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[8], DC: "dc1"},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[7], DC: "dc2"},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[9]},
+			},
+			true,
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[9], DC: "dc1"},
+		}, {
+			"merge events with ids",
+			[]HashTagEvent{
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[8], ID: "id1"},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[9], ID: "id2"},
+				{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[7], ID: "id3"},
+			},
+			true,
+			HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: times[9], ID: "id2"},
 		},
 	}
 	for _, testCase := range testCases {
@@ -174,7 +403,140 @@ func TestHashTagEventMerge(t *testing.T) {
 			assert.Equal(t, testCase.result.HashTag, event.HashTag)
 			assert.Equal(t, testCase.result.AccessTime, event.AccessTime)
 			assert.Equal(t, testCase.result.WriteTime, event.WriteTime)
+			assert.Equal(t, testCase.result.DeleteTime, event.DeleteTime)
+			assert.Equal(t, testCase.result.PriorDeleteTime, event.PriorDeleteTime)
+			assert.Equal(t, testCase.result.DC, event.DC)
+			assert.Equal(t, testCase.result.ID, event.ID)
 			assert.ElementsMatch(t, testCase.result.Keys.ToSlice(), event.Keys.ToSlice())
 		}
 	}
 }
+
+// delete followed by access in one window is not lost, keys accessed before the delete are not kept.
+func TestHashTagEventMergeDeleteAndAccess(t *testing.T) {
+	currentTime := time.Now()
+	write, _ := NewHashTagEvent("abc", []string{"{abc}a"}, HashTagAccessModeWrite, currentTime)
+	deleteEvent, _ := NewHashTagEvent("abc", []string{}, HashTagAccessModeDelete, currentTime.Add(time.Second))
+	read, _ := NewHashTagEvent("abc", []string{"{abc}b"}, HashTagAccessModeRead, currentTime.Add(2*time.Second))
+	laterDelete, _ := NewHashTagEvent("abc", []string{}, HashTagAccessModeDelete, currentTime.Add(3*time.Second))
+
+	event, err := MergeEvents(write, deleteEvent, read)
+	assert.Nil(t, err)
+	assert.False(t, event.IsDelete())
+	assert.Equal(t, HashTagAccessModeRead, event.AccessMode())
+	assert.Equal(t, deleteEvent.DeleteTime, event.PriorDeleteTime)
+	assert.Equal(t, []string{"{abc}b"}, event.Keys.ToSlice())
+	assert.True(t, event.WriteTime.IsZero())
+	assert.Nil(t, event.Check())
+
+	// prior delete time is kept when merged with more access events
+	event, err = MergeEvents(event, write)
+	assert.Nil(t, err)
+	assert.Equal(t, deleteEvent.DeleteTime, event.PriorDeleteTime)
+	assert.Equal(t, read.AccessTime, event.AccessTime)
+
+	// the later delete wins
+	event, err = MergeEvents(event, laterDelete)
+	assert.Nil(t, err)
+	assert.True(t, event.IsDelete())
+	assert.True(t, event.PriorDeleteTime.IsZero())
+
+	event = read.Copy()
+	event.PriorDeleteTime = read.AccessTime.Add(time.Second)
+	assert.Equal(t, ErrEventPriorDeleteTimeWrong, event.Check())
+	event = deleteEvent.Copy()
+	event.PriorDeleteTime = deleteEvent.AccessTime
+	assert.Equal(t, ErrEventPriorDeleteTimeWrong, event.Check())
+}
+
+func TestHashTagEventMergeWithMode(t *testing.T) {
+	currentTime := time.Now()
+	events := []HashTagEvent{
+		{HashTag: "abc", Keys: utility.NewStringSet("{abc}a"), AccessTime: currentTime, WriteTime: currentTime, DC: "dc1", ID: "id1"},
+		{HashTag: "abc", Keys: utility.NewStringSet("{abc}b"), AccessTime: currentTime.Add(time.Second), DC: "dc2", ID: "id2"},
+		// the same access time as the latest one
+		{HashTag: "abc", Keys: utility.NewStringSet("{abc}c"), AccessTime: currentTime.Add(time.Second), DC: "dc1", ID: "id3"},
+	}
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+
+	testCases := []struct {
+		mode EventMergeMode
+		dc   string
+		id   string
+	}{
+		{EventMergeModeLatestWins, "dc1", "id3"},
+		{EventMergeModeUnion, "", ""},
+	}
+	for _, testCase := range testCases {
+		for _, order := range orders {
+			event, err := MergeEventsWithMode(testCase.mode, events[order[0]], events[order[1]], events[order[2]])
+			assert.Nil(t, err)
+			assert.Equal(t, currentTime.Add(time.Second), event.AccessTime)
+			assert.Equal(t, currentTime, event.WriteTime)
+			assert.ElementsMatch(t, []string{"{abc}a", "{abc}b", "{abc}c"}, event.Keys.ToSlice())
+			assert.Equal(t, testCase.dc, event.DC, testCase.mode)
+			assert.Equal(t, testCase.id, event.ID, testCase.mode)
+		}
+	}
+
+	// union keeps dc shared by all events
+	event, err := MergeEventsWithMode(EventMergeModeUnion, events[0], events[2])
+	assert.Nil(t, err)
+	assert.Equal(t, "dc1", event.DC)
+	assert.Equal(t, "", event.ID)
+
+	// delete event wins access event with the same access time in both modes
+	deleteEvent := HashTagEvent{HashTag: "abc", Keys: utility.NewStringSet(), AccessTime: currentTime.Add(time.Second), DeleteTime: currentTime.Add(time.Second)}
+	for _, mode := range []EventMergeMode{EventMergeModeLatestWins, EventMergeModeUnion} {
+		event, err = MergeEventsWithMode(mode, events[1], deleteEvent)
+		assert.Nil(t, err)
+		assert.True(t, event.IsDelete())
+		event, err = MergeEventsWithMode(mode, deleteEvent, events[1])
+		assert.Nil(t, err)
+		assert.True(t, event.IsDelete())
+	}
+}
+
+func TestHashTagEventGroupEventsByURL(t *testing.T) {
+	service := testInitHashTagEventService()
+	currentTime := time.Now()
+	events := make([]HashTagEvent, 0)
+	for i := 0; i < 100; i++ {
+		events = append(events, HashTagEvent{HashTag: fmt.Sprintf("tag%d", i), Keys: utility.NewStringSet(), AccessTime: currentTime})
+	}
+	assert.Equal(t, map[string][]HashTagEvent{"localhost": events}, service.groupEventsByURL(events))
+
+	assert.NotNil(t, service.SetEventReportURLs([]string{"url1", "url2"}))
+	service.config.EventReport.URLVirtualNodeCount = 10
+	assert.Nil(t, service.SetEventReportURLs([]string{"url1", "url2"}))
+	urlEvents := service.groupEventsByURL(events)
+	assert.Equal(t, 2, len(urlEvents))
+	assert.Equal(t, 100, len(urlEvents["url1"])+len(urlEvents["url2"]))
+
+	// events of the same hash tag are always reported to the same url
+	for url, groupedEvents := range urlEvents {
+		for _, event := range groupedEvents {
+			assert.Equal(t, map[string][]HashTagEvent{url: {event}}, service.groupEventsByURL([]HashTagEvent{event}))
+		}
+	}
+}
+
+func TestHashTagEventServiceEventReportConfigWorkerCount(t *testing.T) {
+	config := HashTagEventServiceEventReportConfig{
+		URL:                             "localhost",
+		RawRequestTimeout:               "1s",
+		RequestMaxEvent:                 10,
+		RawRequestMaxWaitDuration:       "1s",
+		RequestWorkerCount:              1,
+		RawRequestConnKeepAliveInterval: "1s",
+		RawRequestIdleConnTimeout:       "1s",
+		RequestMaxConn:                  1,
+	}
+	assert.Nil(t, config.check())
+
+	// no event is reported without workers
+	for _, count := range []int{0, -1} {
+		config.RequestWorkerCount = count
+		assert.NotNil(t, config.check())
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"strings"
 )
 
+// NewLoggerFromConfig creates logger from config in the same format as log config of services.
+func NewLoggerFromConfig(name string, cfg utility.StrMap) (*log.Logger, error) {
+	return parseLogger(name, cfg)
+}
+
 func parseLogger(name string, cfg utility.StrMap) (*log.Logger, error) {
 	var outputs []log.Output
 	for k, v := range cfg {
@@ -34,6 +34,27 @@ func MakeTCPOutput(name string, fmt LocalFormat, level Level, config utility.TCP
 	return newZapLogger(name, fmt, level, writer)
 }
 
+// WithLevel returns a logger sharing outputs with l, messages below level are dropped.
+func (l *Logger) WithLevel(level Level) *Logger {
+	outpers := make([]Output, 0, len(l.outpers))
+	for _, it := range l.outpers {
+		outpers = append(outpers, levelOutput{Output: it, level: level})
+	}
+	return &Logger{outpers: outpers}
+}
+
+type levelOutput struct {
+	Output
+	level Level
+}
+
+func (o levelOutput) Level() Level {
+	if level := o.Output.Level(); level > o.level {
+		return level
+	}
+	return o.level
+}
+
 func (l *Logger) Debugm(subject string, values map[string]interface{}) {
 	l.logPairs(LevelDebug, subject, convertStrMapToLogPairs(values))
 }
@@ -0,0 +1,46 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler limits log messages with the same key in every interval,
+// the first messages are allowed, and then one of every thereafter messages is allowed.
+type Sampler struct {
+	interval   time.Duration
+	first      int
+	thereafter int
+
+	mutex   sync.Mutex
+	resetAt time.Time
+	counts  map[string]int
+}
+
+func NewSampler(interval time.Duration, first, thereafter int) *Sampler {
+	return &Sampler{
+		interval:   interval,
+		first:      first,
+		thereafter: thereafter,
+		counts:     make(map[string]int),
+	}
+}
+
+func (s *Sampler) Allow(key string) bool {
+	return s.allow(key, time.Now())
+}
+
+func (s *Sampler) allow(key string, t time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !t.Before(s.resetAt) {
+		s.counts = make(map[string]int)
+		s.resetAt = t.Add(s.interval)
+	}
+	s.counts[key]++
+	count := s.counts[key]
+	if count <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (count-s.first)%s.thereafter == 0
+}
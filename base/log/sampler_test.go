@@ -0,0 +1,40 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	sampler := NewSampler(time.Second, 2, 3)
+	now := time.Now()
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if sampler.allow("a", now) {
+			allowed++
+		}
+	}
+	// 2 first messages, then the 5th and 8th messages
+	assert.Equal(t, 4, allowed)
+	assert.True(t, sampler.allow("b", now))
+
+	assert.True(t, sampler.allow("a", now.Add(time.Second)))
+
+	sampler = NewSampler(time.Second, 1, 0)
+	assert.True(t, sampler.allow("a", now))
+	assert.False(t, sampler.allow("a", now))
+}
+
+func TestLoggerWithLevel(t *testing.T) {
+	logger := NewLogger(MakeConsoleOutput("test", MakeLocalFormat(MessageFormatText), LevelDebug, ConsoleStreamStdout))
+	assert.Equal(t, LevelDebug, logger.outpers[0].Level())
+
+	errorLogger := logger.WithLevel(LevelError)
+	assert.Equal(t, LevelError, errorLogger.outpers[0].Level())
+	assert.Equal(t, LevelDebug, logger.outpers[0].Level())
+
+	assert.Equal(t, LevelError, errorLogger.WithLevel(LevelInfo).outpers[0].Level())
+}
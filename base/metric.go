@@ -2,6 +2,7 @@ package base
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"gopkg.in/alexcesaro/statsd.v2"
@@ -22,6 +23,9 @@ type MetricConfig struct {
 	FlushPeriodSeconds int64    `yaml:"flush_period_seconds"`
 	SampleRate         float32  `yaml:"sample_rate"`
 	Tags               []string `yaml:"tags"`
+	// counts of the same key are summed in period and sent once at the end of period,
+	// 0 means counts are sent when they are recorded.
+	CounterAggPeriodMS int `yaml:"counter_agg_period_ms"`
 }
 
 func (config MetricConfig) check() error {
@@ -31,11 +35,17 @@ func (config MetricConfig) check() error {
 	if len(config.Tags)%2 != 0 {
 		return errors.New("tags count should be even")
 	}
+	if config.CounterAggPeriodMS < 0 {
+		return errors.New("counter_agg_period_ms should not be negative")
+	}
 	return nil
 }
 
 type MetricClient struct {
 	*statsd.Client
+
+	// nil if counts are not aggregated
+	counterAggregator *metricCounterAggregator
 }
 
 // 初始化Metric (statsd)
@@ -70,21 +80,143 @@ func InitMetric(config MetricConfig) (*MetricClient, error) {
 	c := &MetricClient{}
 	client, err := statsd.New(opts...)
 	c.Client = client
+	if err == nil && config.CounterAggPeriodMS > 0 {
+		// sums are sent at rate 1, since sampling a sum drops counts of the whole period
+		sumClient := client.Clone(statsd.SampleRate(1))
+		c.counterAggregator = newMetricCounterAggregator(
+			time.Duration(config.CounterAggPeriodMS)*time.Millisecond,
+			func(key string, count int64) { sumClient.Count(key, count) })
+	}
 	return c, err
 }
 
+// Close sends aggregated counts and closes statsd client.
+func (mc *MetricClient) Close() {
+	if mc.counterAggregator != nil {
+		mc.counterAggregator.stop()
+	}
+	mc.Client.Close()
+}
+
+// Flush sends aggregated counts and buffered metrics now,
+// it is called in recover so metrics before a panic are not lost.
+func (mc *MetricClient) Flush() {
+	if mc.counterAggregator != nil {
+		mc.counterAggregator.flushCounts()
+	}
+	mc.Client.Flush()
+}
+
 // MetricCount would change count on <num> for key.
 func (mc *MetricClient) MetricCount(key string, num interface{}) *MetricClient {
+	if mc.counterAggregator != nil {
+		if count, ok := metricCountToInt64(num); ok && mc.counterAggregator.add(counterMetricPrefix+key, count) {
+			return mc
+		}
+	}
 	mc.Count(counterMetricPrefix+key, num)
 	return mc
 }
 
 // MetricIncrease would increase count on 1 for key with statsd count.
 func (mc *MetricClient) MetricIncrease(key string) *MetricClient {
+	if mc.counterAggregator != nil && mc.counterAggregator.add(counterMetricPrefix+key, 1) {
+		return mc
+	}
 	mc.Count(counterMetricPrefix+key, 1)
 	return mc
 }
 
+func metricCountToInt64(num interface{}) (int64, bool) {
+	switch n := num.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// metricCounterAggregator sums counts of every key in period and flushes the sums,
+// a count is added to exactly one period, so totals are the same as counts sent one by one.
+type metricCounterAggregator struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+	flush  func(key string, count int64)
+	// counts are not added after aggregator is stopped, since they would never be flushed
+	stopped bool
+
+	wg     sync.WaitGroup
+	stopCh chan bool
+	once   sync.Once
+}
+
+func newMetricCounterAggregator(period time.Duration, flush func(key string, count int64)) *metricCounterAggregator {
+	aggregator := &metricCounterAggregator{
+		counts: make(map[string]int64),
+		flush:  flush,
+		stopCh: make(chan bool),
+	}
+	aggregator.wg.Add(1)
+	go aggregator.run(period)
+	return aggregator
+}
+
+// add returns false if aggregator is stopped, count should be sent directly then.
+func (aggregator *metricCounterAggregator) add(key string, count int64) bool {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+	if aggregator.stopped {
+		return false
+	}
+	aggregator.counts[key] += count
+	return true
+}
+
+func (aggregator *metricCounterAggregator) run(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer func() {
+		ticker.Stop()
+		aggregator.flushCounts()
+		aggregator.wg.Done()
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			aggregator.flushCounts()
+		case <-aggregator.stopCh:
+			return
+		}
+	}
+}
+
+// flushCounts takes counts of current period and sends non-zero ones.
+func (aggregator *metricCounterAggregator) flushCounts() {
+	aggregator.mutex.Lock()
+	counts := aggregator.counts
+	aggregator.counts = make(map[string]int64, len(counts))
+	aggregator.mutex.Unlock()
+	for key, count := range counts {
+		if count != 0 {
+			aggregator.flush(key, count)
+		}
+	}
+}
+
+func (aggregator *metricCounterAggregator) stop() {
+	aggregator.once.Do(func() {
+		aggregator.mutex.Lock()
+		aggregator.stopped = true
+		aggregator.mutex.Unlock()
+		close(aggregator.stopCh)
+		aggregator.wg.Wait()
+	})
+}
+
 // MetricTimeDuration would record time duration for key with statsd timing.
 //
 // - Parameters:
@@ -0,0 +1,117 @@
+package base
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+func TestMetricCounterAggregator(t *testing.T) {
+	var mutex sync.Mutex
+	flushed := make(map[string]int64)
+	flushCount := 0
+	aggregator := newMetricCounterAggregator(time.Millisecond, func(key string, count int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		flushed[key] += count
+		flushCount++
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				aggregator.add(fmt.Sprintf("key%d", i%2), 1)
+				aggregator.add("zero", 0)
+			}
+		}(i)
+	}
+	wg.Wait()
+	aggregator.stop()
+	aggregator.stop()
+
+	assert.Equal(t, map[string]int64{"key0": 5000, "key1": 5000}, flushed)
+	assert.True(t, flushCount < 20000)
+	// counts added after stop would never be flushed
+	assert.False(t, aggregator.add("key0", 1))
+}
+
+func TestMetricClientFlush(t *testing.T) {
+	client, err := statsd.New(statsd.Mute(true))
+	assert.Nil(t, err)
+	flushed := make(map[string]int64)
+	mc := &MetricClient{Client: client}
+	mc.counterAggregator = newMetricCounterAggregator(time.Hour, func(key string, count int64) {
+		flushed[key] += count
+	})
+	defer mc.Close()
+
+	mc.MetricIncrease("a")
+	mc.MetricCount("a", 2)
+	assert.Equal(t, 0, len(flushed))
+	mc.Flush()
+	assert.Equal(t, map[string]int64{counterMetricPrefix + "a": 3}, flushed)
+}
+
+func TestMetricClientAggregatedCounts(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	config := MetricConfig{Host: conn.LocalAddr().String(), Network: "udp", CounterAggPeriodMS: 60000}
+
+	// sums are not sampled
+	config.SampleRate = 0.0001
+	mc, err := InitMetric(config)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		mc.MetricIncrease("a")
+	}
+	mc.Flush()
+	assert.Equal(t, "counter.a:10|c", testReadMetricPacket(t, conn))
+	mc.Close()
+
+	// counts are sent directly after aggregator is stopped
+	config.SampleRate = 0
+	mc, err = InitMetric(config)
+	assert.Nil(t, err)
+	defer mc.Close()
+	mc.counterAggregator.stop()
+	mc.MetricIncrease("b")
+	mc.MetricCount("b", 2)
+	mc.Flush()
+	assert.Equal(t, "counter.b:1|c\ncounter.b:2|c", testReadMetricPacket(t, conn))
+}
+
+// testReadMetricPacket returns the first packet with metrics.
+func testReadMetricPacket(t *testing.T, conn net.PacketConn) string {
+	buffer := make([]byte, 1500)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			assert.Nil(t, err)
+			return ""
+		}
+		if n > 0 {
+			return string(buffer[:n])
+		}
+	}
+}
+
+func TestMetricCountToInt64(t *testing.T) {
+	count, ok := metricCountToInt64(3)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), count)
+	count, ok = metricCountToInt64(int64(-2))
+	assert.True(t, ok)
+	assert.Equal(t, int64(-2), count)
+	_, ok = metricCountToInt64(1.5)
+	assert.False(t, ok)
+}
@@ -54,5 +54,6 @@ func main() {
 		log.String("signal", sig.String()))
 
 	collectEventService.Stop()
+	dep.Metric.Close()
 	dep.Logger.Info(fmt.Sprintf("close %s success", serviceName))
 }
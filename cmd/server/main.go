@@ -57,5 +57,6 @@ func main() {
 	roomService.Stop()
 	logger.Info("room server is stopped, try to stop other related services...")
 	base.StopServices()
+	dep.Metric.Close()
 	logger.Info("room server and related service are all closed")
 }
@@ -29,9 +29,12 @@ SQL = {
                 accessed_at timestamp with time zone NOT NULL,
                 written_at timestamp with time zone DEFAULT NULL,
                 synced_at timestamp with time zone DEFAULT NULL,
+                expires_at timestamp with time zone DEFAULT NULL,
                 created_at timestamp with time zone NOT NULL DEFAULT now(),
                 updated_at timestamp with time zone NOT NULL DEFAULT now(),
                 status character varying NOT NULL,
+                dc character varying DEFAULT NULL,
+                event_id character varying DEFAULT NULL,
                 version bigint NOT NULL DEFAULT 0
             );
 
@@ -46,6 +49,24 @@ SQL = {
         "truncate": "truncate table room_hash_tag_keys_{db_index};",
         "sum": "select sum(count), 'room_hash_tag_keys' as table_name from ({sql}) as t;",
     },
+    "lease": {
+        "create": textwrap.dedent('''
+            CREATE TABLE public.room_lease_{db_index} (
+                name character varying NOT NULL,
+                holder character varying NOT NULL,
+                expires_at timestamp with time zone NOT NULL,
+                created_at timestamp with time zone NOT NULL DEFAULT now(),
+                updated_at timestamp with time zone NOT NULL DEFAULT now(),
+                version bigint NOT NULL DEFAULT 0
+            );
+
+            ALTER TABLE ONLY public.room_lease_{db_index}
+                ADD CONSTRAINT room_lease_{db_index}_pkey PRIMARY KEY (name);
+        '''),
+        "count": "select 'room_lease_{db_index}' as table_name, count(*) as count from room_lease_{db_index}",
+        "truncate": "truncate table room_lease_{db_index};",
+        "sum": "select sum(count), 'room_lease' as table_name from ({sql}) as t;",
+    },
 }
 
 
@@ -84,7 +105,7 @@ if __name__ == "__main__":
     parser.add_argument("-d", "--database", required=True)
     parser.add_argument(
         "-t", "--table",
-        choices=["data", "keys"],
+        choices=["data", "keys", "lease"],
         required=True)
     parser.add_argument("-s", "--start_index", type=int, required=True)
     parser.add_argument("-e", "--end_index", type=int, required=True)
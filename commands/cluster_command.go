@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// clusterEndpoint is the address presented to clients as the only node of the cluster,
+// so that smart clients route all commands through the proxy.
+var clusterEndpoint = struct {
+	host   string
+	port   int
+	nodeID string
+}{host: "127.0.0.1", port: 6379, nodeID: newClusterNodeID("127.0.0.1", 6379)}
+
+// SetClusterEndpoint sets the address returned by CLUSTER SLOTS and CLUSTER NODES.
+// It should be called before serving commands.
+func SetClusterEndpoint(host string, port int) {
+	clusterEndpoint.host = host
+	clusterEndpoint.port = port
+	clusterEndpoint.nodeID = newClusterNodeID(host, port)
+}
+
+// node id of redis cluster is 40 hex characters, it is derived from address to keep it stable between restarts.
+func newClusterNodeID(host string, port int) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d", host, port)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClusterCommand is processed by the session, it is never sent to redis cluster.
+type ClusterCommand struct {
+	subcommand string
+	commonCommand
+}
+
+func NewClusterCommand(args []string) (Commander, error) {
+	command := &ClusterCommand{}
+	command.init(args)
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	command.subcommand = strings.ToLower(args[1])
+	switch command.subcommand {
+	case "slots", "nodes", "myid":
+		if len(args) == 2 {
+			return command, nil
+		}
+	}
+	return nil, newUnknownSubcommandError(command.name, args[1])
+}
+
+func (command *ClusterCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
+
+func (command *ClusterCommand) topology() RESPData {
+	switch command.subcommand {
+	case "slots":
+		return RESPData{
+			DataType: ArrayRespType,
+			Value: []RESPData{
+				{
+					DataType: ArrayRespType,
+					Value: []RESPData{
+						{DataType: IntegerRespType, Value: int64(0)},
+						{DataType: IntegerRespType, Value: int64(clusterSlotCount - 1)},
+						{
+							DataType: ArrayRespType,
+							Value: []RESPData{
+								{DataType: BulkStringRespType, Value: clusterEndpoint.host},
+								{DataType: IntegerRespType, Value: int64(clusterEndpoint.port)},
+								{DataType: BulkStringRespType, Value: clusterEndpoint.nodeID},
+							},
+						},
+					},
+				},
+			},
+		}
+	case "nodes":
+		address := clusterEndpoint.host + ":" + strconv.Itoa(clusterEndpoint.port)
+		line := fmt.Sprintf(
+			"%s %s@%d myself,master - 0 0 1 connected 0-%d\n",
+			clusterEndpoint.nodeID, address, clusterEndpoint.port+10000, clusterSlotCount-1)
+		return RESPData{DataType: VerbatimStringRespType, Value: line}
+	case "myid":
+		return RESPData{DataType: BulkStringRespType, Value: clusterEndpoint.nodeID}
+	}
+	return ConvertErrorToRESPData(newUnknownSubcommandError(command.name, command.subcommand))
+}
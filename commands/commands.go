@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/go-redis/redis/v8"
@@ -28,6 +29,7 @@ var supportedCommands = map[string]NewCommandFunc{
 	"pttl":      NewPTTLCommand,
 	"rename":    NewRenameCommand,
 	"renamenx":  NewRenameNXCommand,
+	"scan":      NewScanCommand,
 	"ttl":       NewTTLCommand,
 	"type":      NewTypeCommand,
 
@@ -132,12 +134,24 @@ var supportedCommands = map[string]NewCommandFunc{
 	"echo":    NewEchoCommand,
 	"ping":    NewPingCommand,
 
+	// connection commands
+	"hello":   NewHelloCommand,
+	"cluster": NewClusterCommand,
+
 	// transaction commands
 	"watch":   NewWatchCommand,
 	"multi":   NewMultiCommand,
 	"exec":    NewExecCommand,
 	"discard": NewDiscardCommand,
 	"unwatch": NewUnwatchCommand,
+	"reset":   NewResetCommand,
+
+	// pubsub commands
+	"subscribe":    NewSubscribeCommand,
+	"psubscribe":   NewPSubscribeCommand,
+	"unsubscribe":  NewUnsubscribeCommand,
+	"punsubscribe": NewPUnsubscribeCommand,
+	"publish":      NewPublishCommand,
 }
 
 type RESPType string
@@ -150,6 +164,17 @@ const (
 	ArrayRespType        RESPType = "array"
 	NilRespType          RESPType = "nil"
 	NilArrayRespType     RESPType = "nil_array"
+	// MapRespType's value is a slice of RESPData with keys and values in turn,
+	// it is encoded as an array in RESP2.
+	MapRespType RESPType = "map"
+	// values of double, big number and verbatim string are strings,
+	// they are encoded as bulk strings in RESP2.
+	DoubleRespType         RESPType = "double"
+	BigNumberRespType      RESPType = "big_number"
+	VerbatimStringRespType RESPType = "verbatim_string"
+	// PushRespType's value is a slice of RESPData sent out of band like pub/sub messages,
+	// it is encoded as an array in RESP2.
+	PushRespType RESPType = "push"
 )
 
 type RESPData struct {
@@ -177,8 +202,28 @@ func (data RESPData) String() string {
 			result = result + item.String() + " "
 		}
 		result = result + " }"
+	case PushRespType:
+		array := data.Value.([]RESPData)
+		result = fmt.Sprintf("p:%d{ ", len(array))
+		for _, item := range array {
+			result = result + item.String() + " "
+		}
+		result = result + " }"
 	case NilArrayRespType:
 		result = "na:na"
+	case MapRespType:
+		array := data.Value.([]RESPData)
+		result = fmt.Sprintf("m:%d{ ", len(array)/2)
+		for _, item := range array {
+			result = result + item.String() + " "
+		}
+		result = result + " }"
+	case DoubleRespType:
+		result = fmt.Sprintf("d:%s", data.Value)
+	case BigNumberRespType:
+		result = fmt.Sprintf("bn:%s", data.Value)
+	case VerbatimStringRespType:
+		result = fmt.Sprintf("vs:%s", data.Value)
 	}
 	return result
 }
@@ -187,8 +232,9 @@ func ConvertErrorToRESPData(err error) RESPData {
 	if errors.Is(err, redis.Nil) {
 		return RESPData{DataType: NilRespType, Value: nil}
 	}
+	// error is kept for callers to check the reason
 	if errors.Is(err, redis.TxFailedErr) {
-		return RESPData{DataType: NilArrayRespType, Value: nil}
+		return RESPData{DataType: NilArrayRespType, Value: err}
 	}
 	return RESPData{DataType: ErrorRespType, Value: err}
 }
@@ -275,9 +321,66 @@ func ParseCommand(args []string) (Commander, error) {
 	return fn(args)
 }
 
-func ExecuteCommand(redisCluster *redis.ClusterClient, command Commander) RESPData {
+// transaction, connection and cluster commands are not sent to redis cluster directly, they are always allowed.
+var alwaysAllowedCommands = map[string]bool{
+	"watch":   true,
+	"unwatch": true,
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"reset":   true,
+	"hello":   true,
+	"cluster": true,
+}
+
+// nil means all supported commands are allowed.
+var allowedCommands map[string]bool
+
+// SetAllowedCommands limits commands which can be executed, empty names means all supported commands are allowed.
+// It should be called before serving commands.
+func SetAllowedCommands(names []string) error {
+	if len(names) == 0 {
+		allowedCommands = nil
+		return nil
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := supportedCommands[name]; !ok {
+			return fmt.Errorf("command %s is not supported", name)
+		}
+		allowed[name] = true
+	}
+	allowedCommands = allowed
+	return nil
+}
+
+func checkCommandAllowed(command Commander) error {
+	if allowedCommands == nil || alwaysAllowedCommands[command.Name()] || allowedCommands[command.Name()] {
+		return nil
+	}
+	return newCommandNotAllowedError(command.Name())
+}
+
+func ExecuteCommand(ctx context.Context, redisCluster *redis.ClusterClient, command Commander) RESPData {
+	if len(commandMiddlewares) == 0 {
+		return executeCommand(ctx, redisCluster, command)
+	}
+	handler := withCommandMiddlewares(func(ctx context.Context, command Commander) RESPData {
+		return executeCommand(ctx, redisCluster, command)
+	})
+	return handler(ctx, command)
+}
+
+func executeCommand(ctx context.Context, redisCluster *redis.ClusterClient, command Commander) RESPData {
+	if err := checkCommandAllowed(command); err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	if scanCommand, ok := command.(*ScanCommand); ok {
+		return scanCommand.scan(ctx, redisCluster)
+	}
 	cmd := command.Cmd()
-	if err := redisCluster.Process(contextTODO, cmd); err != nil {
+	if err := redisCluster.Process(ctx, cmd); err != nil {
 		return ConvertErrorToRESPData(err)
 	}
 
@@ -306,8 +409,29 @@ func (c CommandBatch) AddCommand(index int, cmd Commander) {
 }
 
 func (c CommandBatch) Execute(ctx context.Context, redisCluster *redis.ClusterClient) map[int]RESPData {
-	indexes := c.getSortedIndexes()
+	indexes := make([]int, 0, len(c.cmds))
 	result := make(map[int]RESPData, len(c.cmds))
+	if len(commandMiddlewares) > 0 {
+		for _, index := range c.getSortedIndexes() {
+			result[index] = ExecuteCommand(ctx, redisCluster, c.cmds[index])
+		}
+		return result
+	}
+	for _, index := range c.getSortedIndexes() {
+		if err := checkCommandAllowed(c.cmds[index]); err != nil {
+			result[index] = ConvertErrorToRESPData(err)
+			continue
+		}
+		// scan is executed on nodes in turn, it can not be pipelined.
+		if scanCommand, ok := c.cmds[index].(*ScanCommand); ok {
+			result[index] = scanCommand.scan(ctx, redisCluster)
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return result
+	}
 	pipeline := redisCluster.Pipeline()
 	for _, index := range indexes {
 		pipeline.Process(ctx, c.cmds[index].Cmd())
@@ -320,15 +444,7 @@ func (c CommandBatch) Execute(ctx context.Context, redisCluster *redis.ClusterCl
 }
 
 func ExtractHashTagFromKey(key string) string {
-	leftBraceIndex := strings.Index(key, "{")
-	if leftBraceIndex == -1 {
-		return ""
-	}
-	rightBraceIndex := strings.Index(key[leftBraceIndex:], "}")
-	if rightBraceIndex > 1 {
-		return key[leftBraceIndex+1 : leftBraceIndex+rightBraceIndex]
-	}
-	return ""
+	return base.ExtractHashTagFromKey(key)
 }
 
 func convertCmdResultToRESPData(cmd redis.Cmder) RESPData {
@@ -354,6 +470,9 @@ func convertCmdResultToRESPData(cmd redis.Cmder) RESPData {
 			result = ConvertErrorToRESPData(err)
 		} else {
 			result = RESPData{DataType: BulkStringRespType, Value: r}
+			if doubleReplyCommands[command.Name()] {
+				result = convertBulkStringToDouble(result)
+			}
 		}
 	case *redis.IntSliceCmd:
 		r, err := command.Result()
@@ -385,6 +504,9 @@ func convertCmdResultToRESPData(cmd redis.Cmder) RESPData {
 			result = ConvertErrorToRESPData(err)
 		} else {
 			result = convertSliceToRESPData(r)
+			if doubleReplyCommands[command.Name()] {
+				result = convertBulkStringToDouble(result)
+			}
 		}
 	case *redis.CommandsInfoCmd:
 		r, err := command.Result()
@@ -406,6 +528,36 @@ func convertCmdResultToRESPData(cmd redis.Cmder) RESPData {
 	return result
 }
 
+// redis replies scores and float increments of these commands as doubles in RESP3,
+// they are bulk strings in replies of redis cluster.
+var doubleReplyCommands = map[string]bool{
+	"zscore":       true,
+	"zmscore":      true,
+	"zincrby":      true,
+	"incrbyfloat":  true,
+	"hincrbyfloat": true,
+}
+
+// convertBulkStringToDouble converts bulk strings of floats in data to doubles, other data is kept.
+func convertBulkStringToDouble(data RESPData) RESPData {
+	switch data.DataType {
+	case BulkStringRespType:
+		if s, ok := data.Value.(string); ok {
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return RESPData{DataType: DoubleRespType, Value: s}
+			}
+		}
+	case ArrayRespType:
+		array := data.Value.([]RESPData)
+		value := make([]RESPData, len(array))
+		for index, item := range array {
+			value[index] = convertBulkStringToDouble(item)
+		}
+		return RESPData{DataType: ArrayRespType, Value: value}
+	}
+	return data
+}
+
 func convertSliceToRESPData(slice []interface{}) RESPData {
 	data := RESPData{DataType: ArrayRespType}
 	value := make([]RESPData, 0)
@@ -50,7 +50,7 @@ func TestExecuteCommands(t *testing.T) {
 		command, err := newFn(testCase.args)
 		assert.Nil(t, err)
 		log.Printf("test case: %s, command execution: %s\n", testCase.description, command.String())
-		result := ExecuteCommand(dep.Redis, command)
+		result := ExecuteCommand(context.TODO(), dep.Redis, command)
 		assert.True(t, testCase.compareFn(testCase.respData, result))
 		testEmptyKeysInRedis(testCase.emptyKeys...)
 	}
@@ -1422,6 +1422,22 @@ var testCommandCases = []testCommandCase{
 		accessMode: base.HashTagAccessModeRead,
 		valid:      true,
 		cmdType:    &redis.Cmd{},
+	}, {
+		name:       "exec",
+		args:       []string{"exec", "dryrun"},
+		writeKeys:  []string{},
+		readKeys:   []string{},
+		accessMode: base.HashTagAccessModeRead,
+		valid:      true,
+		cmdType:    &redis.SliceCmd{},
+	}, {
+		name:  "exec",
+		args:  []string{"exec", "a"},
+		valid: false,
+	}, {
+		name:  "exec",
+		args:  []string{"exec", "dryrun", "a"},
+		valid: false,
 	}, {
 		name:       "multi",
 		args:       []string{"multi"},
@@ -1430,6 +1446,34 @@ var testCommandCases = []testCommandCase{
 		accessMode: base.HashTagAccessModeRead,
 		valid:      true,
 		cmdType:    &redis.StatusCmd{},
+	}, {
+		name:       "hello",
+		args:       []string{"hello"},
+		writeKeys:  []string{},
+		readKeys:   []string{},
+		accessMode: base.HashTagAccessModeRead,
+		valid:      true,
+		cmdType:    &redis.SliceCmd{},
+	}, {
+		name:       "hello",
+		args:       []string{"hello", "3", "setname", "client"},
+		writeKeys:  []string{},
+		readKeys:   []string{},
+		accessMode: base.HashTagAccessModeRead,
+		valid:      true,
+		cmdType:    &redis.SliceCmd{},
+	}, {
+		name:  "hello",
+		args:  []string{"hello", "a"},
+		valid: false,
+	}, {
+		name:  "hello",
+		args:  []string{"hello", "4"},
+		valid: false,
+	}, {
+		name:  "hello",
+		args:  []string{"hello", "3", "setname"},
+		valid: false,
 	},
 }
 
@@ -3323,6 +3367,11 @@ var testCommandHashTagCases = []testCommandHashTagCase{
 		args:    []string{"exec"},
 		valid:   true,
 		hashTag: "",
+	}, {
+		name:    "type",
+		args:    []string{"type", "x{abc}"},
+		valid:   true,
+		hashTag: "abc",
 	},
 }
 
@@ -3354,3 +3403,70 @@ func TestCommandBatch(t *testing.T) {
 		assert.Equal(t, expectedResults[index].Value, result.Value)
 	}
 }
+
+func TestAllowedCommands(t *testing.T) {
+	defer SetAllowedCommands(nil)
+	dep := base.GetServerDependency()
+
+	assert.NotNil(t, SetAllowedCommands([]string{"get", "unknown"}))
+	assert.Nil(t, SetAllowedCommands([]string{"GET"}))
+
+	command, _ := NewGetCommand([]string{"get", "{a}1"})
+	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, ExecuteCommand(context.TODO(), dep.Redis, command))
+
+	command, _ = NewSetCommand([]string{"set", "{a}1", "1"})
+	result := ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: newCommandNotAllowedError("set")}, result)
+
+	batch := NewCommandBatch()
+	batch.AddCommand(0, command)
+	results := batch.Execute(context.TODO(), dep.Redis)
+	assert.Equal(t, ErrorRespType, results[0].DataType)
+
+	transaction := NewTransaction(dep)
+	multiCommand, _ := NewMultiCommand([]string{"multi"})
+	assert.Equal(t, SimpleStringRespType, transaction.Process(context.TODO(), multiCommand).DataType)
+	assert.Equal(t, ErrorRespType, transaction.Process(context.TODO(), command).DataType)
+	assert.Equal(t, 0, len(transaction.commands))
+	transaction.Close("")
+}
+
+func TestConvertBulkStringToDouble(t *testing.T) {
+	assert.Equal(t, RESPData{DataType: DoubleRespType, Value: "1.5"}, convertBulkStringToDouble(RESPData{DataType: BulkStringRespType, Value: "1.5"}))
+	assert.Equal(t, RESPData{DataType: DoubleRespType, Value: "-inf"}, convertBulkStringToDouble(RESPData{DataType: BulkStringRespType, Value: "-inf"}))
+	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "a"}, convertBulkStringToDouble(RESPData{DataType: BulkStringRespType, Value: "a"}))
+	assert.Equal(t, RESPData{DataType: NilRespType}, convertBulkStringToDouble(RESPData{DataType: NilRespType}))
+
+	data := convertBulkStringToDouble(convertSliceToRESPData([]interface{}{"2", nil}))
+	assert.Equal(t, ArrayRespType, data.DataType)
+	assert.Equal(t, []RESPData{{DataType: DoubleRespType, Value: "2"}, {DataType: NilRespType}}, data.Value)
+
+	// replies of commands without doubles are kept
+	result := convertCmdResultToRESPData(redis.NewStringResult("1.5", nil))
+	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "1.5"}, result)
+}
+
+func TestExecuteCommandDoubleReply(t *testing.T) {
+	dep := base.GetServerDependency()
+	key := "{a}zset"
+	defer dep.Redis.Del(context.TODO(), key)
+	dep.Redis.ZAdd(context.TODO(), key, &redis.Z{Score: 1.5, Member: "m"})
+
+	command, _ := NewZScoreCommand([]string{"zscore", key, "m"})
+	assert.Equal(t, RESPData{DataType: DoubleRespType, Value: "1.5"}, ExecuteCommand(context.TODO(), dep.Redis, command))
+
+	transaction := NewTransaction(dep)
+	defer transaction.Close("")
+	multiCommand, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), multiCommand)
+	transaction.Process(context.TODO(), command)
+	command, _ = NewZMScoreCommand([]string{"zmscore", key, "m", "n"})
+	transaction.Process(context.TODO(), command)
+	execCommand, _ := NewExecCommand([]string{"exec"})
+	result := transaction.Process(context.TODO(), execCommand)
+	assert.Equal(t, ArrayRespType, result.DataType)
+	assert.Equal(t, []RESPData{
+		{DataType: DoubleRespType, Value: "1.5"},
+		{DataType: ArrayRespType, Value: []RESPData{{DataType: DoubleRespType, Value: "1.5"}, {DataType: NilRespType}}},
+	}, result.Value)
+}
@@ -10,6 +10,17 @@ func newWrongNumberOfArgumentsError(command string) error {
 	return fmt.Errorf("ERR wrong number of arguments for '%s' command", command)
 }
 
+func newCommandNotAllowedError(command string) error {
+	return fmt.Errorf("ERR command '%s' is not allowed", command)
+}
+
+func newUnknownSubcommandError(command string, subcommand string) error {
+	return fmt.Errorf(
+		"ERR Unknown subcommand or wrong number of arguments for '%s'. Try %s HELP.",
+		subcommand, strings.ToUpper(command),
+	)
+}
+
 func newUnknownCommand(command string, args []string) error {
 	argSlice := []string{}
 	for _, arg := range args {
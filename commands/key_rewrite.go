@@ -0,0 +1,269 @@
+package commands
+
+import (
+	"bytepower_room/base"
+	"strconv"
+	"strings"
+)
+
+// commandKeySpec describes positions of keys in command args like redis COMMAND does,
+// last is -1 means keys continue to the last arg.
+type commandKeySpec struct {
+	first int
+	last  int
+	step  int
+	// numKeysIndex > 0 means count of keys is in args[numKeysIndex] and keys follow it.
+	numKeysIndex int
+}
+
+var (
+	singleKeySpec  = commandKeySpec{first: 1, last: 1, step: 1}
+	doubleKeysSpec = commandKeySpec{first: 1, last: 2, step: 1}
+	allKeysSpec    = commandKeySpec{first: 1, last: -1, step: 1}
+	keyValuesSpec  = commandKeySpec{first: 1, last: -1, step: 2}
+)
+
+// commands not in commandKeySpecs have no keys.
+var commandKeySpecs = map[string]commandKeySpec{
+	"del":       allKeysSpec,
+	"exists":    allKeysSpec,
+	"expire":    singleKeySpec,
+	"expireat":  singleKeySpec,
+	"persist":   singleKeySpec,
+	"pexpire":   singleKeySpec,
+	"pexpireat": singleKeySpec,
+	"pttl":      singleKeySpec,
+	"rename":    doubleKeysSpec,
+	"renamenx":  doubleKeysSpec,
+	"ttl":       singleKeySpec,
+	"type":      singleKeySpec,
+
+	"set":         singleKeySpec,
+	"get":         singleKeySpec,
+	"append":      singleKeySpec,
+	"decr":        singleKeySpec,
+	"decrby":      singleKeySpec,
+	"getrange":    singleKeySpec,
+	"getset":      singleKeySpec,
+	"incr":        singleKeySpec,
+	"incrby":      singleKeySpec,
+	"incrbyfloat": singleKeySpec,
+	"mget":        allKeysSpec,
+	"mset":        keyValuesSpec,
+	"msetnx":      keyValuesSpec,
+	"psetex":      singleKeySpec,
+	"setex":       singleKeySpec,
+	"setnx":       singleKeySpec,
+	"setrange":    singleKeySpec,
+	"strlen":      singleKeySpec,
+
+	"lindex":    singleKeySpec,
+	"linsert":   singleKeySpec,
+	"llen":      singleKeySpec,
+	"lpop":      singleKeySpec,
+	"lpos":      singleKeySpec,
+	"lpush":     singleKeySpec,
+	"lpushx":    singleKeySpec,
+	"lrange":    singleKeySpec,
+	"lrem":      singleKeySpec,
+	"lset":      singleKeySpec,
+	"ltrim":     singleKeySpec,
+	"rpop":      singleKeySpec,
+	"rpoplpush": doubleKeysSpec,
+	"lmove":     doubleKeysSpec,
+	"rpush":     singleKeySpec,
+	"rpushx":    singleKeySpec,
+
+	"sadd":        singleKeySpec,
+	"scard":       singleKeySpec,
+	"sdiff":       allKeysSpec,
+	"sdiffstore":  allKeysSpec,
+	"sinter":      allKeysSpec,
+	"sinterstore": allKeysSpec,
+	"sismember":   singleKeySpec,
+	"smismember":  singleKeySpec,
+	"smembers":    singleKeySpec,
+	"smove":       doubleKeysSpec,
+	"spop":        singleKeySpec,
+	"srandmember": singleKeySpec,
+	"srem":        singleKeySpec,
+	"sunion":      allKeysSpec,
+	"sunionstore": allKeysSpec,
+
+	"hdel":         singleKeySpec,
+	"hexists":      singleKeySpec,
+	"hget":         singleKeySpec,
+	"hgetall":      singleKeySpec,
+	"hincrby":      singleKeySpec,
+	"hincrbyfloat": singleKeySpec,
+	"hkeys":        singleKeySpec,
+	"hlen":         singleKeySpec,
+	"hmget":        singleKeySpec,
+	"hmset":        singleKeySpec,
+	"hset":         singleKeySpec,
+	"hsetnx":       singleKeySpec,
+	"hstrlen":      singleKeySpec,
+	"hvals":        singleKeySpec,
+
+	"zadd":             singleKeySpec,
+	"zcard":            singleKeySpec,
+	"zcount":           singleKeySpec,
+	"zdiff":            {numKeysIndex: 1},
+	"zdiffstore":       {first: 1, last: 1, step: 1, numKeysIndex: 2},
+	"zincrby":          singleKeySpec,
+	"zlexcount":        singleKeySpec,
+	"zpopmax":          singleKeySpec,
+	"zpopmin":          singleKeySpec,
+	"zrange":           singleKeySpec,
+	"zrangebylex":      singleKeySpec,
+	"zrevrangebylex":   singleKeySpec,
+	"zrangebyscore":    singleKeySpec,
+	"zrank":            singleKeySpec,
+	"zrem":             singleKeySpec,
+	"zremrangebylex":   singleKeySpec,
+	"zremrangebyrank":  singleKeySpec,
+	"zremrangebyscore": singleKeySpec,
+	"zrevrange":        singleKeySpec,
+	"zrevrangebyscore": singleKeySpec,
+	"zrevrank":         singleKeySpec,
+	"zscore":           singleKeySpec,
+	"zmscore":          singleKeySpec,
+
+	"watch": allKeysSpec,
+}
+
+// channels and patterns of pub/sub commands are rewritten into namespace like keys,
+// so clients in different namespaces do not receive messages of each other.
+var (
+	commandChannelSpecs = map[string]commandKeySpec{
+		"publish":     singleKeySpec,
+		"subscribe":   allKeysSpec,
+		"unsubscribe": allKeysSpec,
+	}
+	commandPatternSpecs = map[string]commandKeySpec{
+		"psubscribe":   allKeysSpec,
+		"punsubscribe": allKeysSpec,
+	}
+)
+
+// keyIndexes returns indexes of keys in args, invalid args returns keys which can be found,
+// parsing command will report the error.
+func (spec commandKeySpec) keyIndexes(args []string) []int {
+	indexes := make([]int, 0)
+	if spec.step > 0 {
+		last := spec.last
+		if last < 0 || last >= len(args) {
+			last = len(args) - 1
+		}
+		for i := spec.first; i <= last; i += spec.step {
+			indexes = append(indexes, i)
+		}
+	}
+	if spec.numKeysIndex > 0 && spec.numKeysIndex < len(args) {
+		numKeys, err := strconv.Atoi(args[spec.numKeysIndex])
+		if err != nil || numKeys <= 0 {
+			return indexes
+		}
+		for i := spec.numKeysIndex + 1; i <= spec.numKeysIndex+numKeys && i < len(args); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// empty means keys are not rewritten.
+var keyNamespace string
+
+// SetKeyNamespace rewrites keys of commands into namespace, empty namespace means keys are not rewritten.
+// It should be called before serving commands.
+func SetKeyNamespace(namespace string) {
+	keyNamespace = namespace
+}
+
+// RewriteKey adds namespace to key, key without hash tag is used as hash tag,
+// so "foo" becomes "namespace:{foo}" and "{foo}bar" becomes "namespace:{foo}bar".
+// Keys with same hash tag stay in same slot after rewritten, note that hash tag of key with hash tag
+// is not changed, so such keys in different namespaces share same hash tag record in database.
+func RewriteKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	if base.ExtractHashTagFromKey(key) == "" {
+		return namespace + ":{" + key + "}"
+	}
+	return namespace + ":" + key
+}
+
+// RewriteChannel adds namespace to channel. Unlike key, channel without hash tag is not wrapped,
+// since messages are broadcast to all nodes and slot of channel does not matter.
+func RewriteChannel(namespace, channel string) string {
+	if namespace == "" {
+		return channel
+	}
+	return namespace + ":" + channel
+}
+
+// RewritePattern adds namespace to pattern, so it only matches channels in namespace.
+func RewritePattern(namespace, pattern string) string {
+	if namespace == "" {
+		return pattern
+	}
+	return escapeGlobPattern(namespace) + ":" + pattern
+}
+
+// trimChannelNamespace returns channel seen by client, it is the reverse of RewriteChannel.
+func trimChannelNamespace(channel string) string {
+	if keyNamespace == "" {
+		return channel
+	}
+	return strings.TrimPrefix(channel, keyNamespace+":")
+}
+
+// trimPatternNamespace returns pattern seen by client, it is the reverse of RewritePattern.
+func trimPatternNamespace(pattern string) string {
+	if keyNamespace == "" {
+		return pattern
+	}
+	return strings.TrimPrefix(pattern, escapeGlobPattern(keyNamespace)+":")
+}
+
+// escapeGlobPattern escapes glob special characters in s, so s is matched literally in pattern.
+func escapeGlobPattern(s string) string {
+	var builder strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// RewriteCommandKeys returns args with keys rewritten into namespace set by SetKeyNamespace,
+// args is not modified. Keys are rewritten before parsing command,
+// so read keys, write keys and cmd of parsed command are all rewritten keys.
+// Channels and patterns of pub/sub commands are rewritten the same way.
+func RewriteCommandKeys(args []string) []string {
+	if keyNamespace == "" || len(args) == 0 {
+		return args
+	}
+	name := strings.ToLower(args[0])
+	rewrite := RewriteKey
+	spec, ok := commandKeySpecs[name]
+	if !ok {
+		if spec, ok = commandChannelSpecs[name]; ok {
+			rewrite = RewriteChannel
+		} else if spec, ok = commandPatternSpecs[name]; ok {
+			rewrite = RewritePattern
+		} else {
+			return args
+		}
+	}
+	rewritten := make([]string, len(args))
+	copy(rewritten, args)
+	for _, index := range spec.keyIndexes(args) {
+		rewritten[index] = rewrite(keyNamespace, args[index])
+	}
+	return rewritten
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteKey(t *testing.T) {
+	assert.Equal(t, "foo", RewriteKey("", "foo"))
+	assert.Equal(t, "tenant_a:{foo}", RewriteKey("tenant_a", "foo"))
+	assert.Equal(t, "tenant_a:{foo}bar", RewriteKey("tenant_a", "{foo}bar"))
+}
+
+func TestRewriteCommandKeys(t *testing.T) {
+	defer SetKeyNamespace("")
+
+	args := []string{"mset", "a", "1", "b", "2"}
+	assert.Equal(t, args, RewriteCommandKeys(args))
+
+	SetKeyNamespace("ns")
+	testCases := []struct {
+		args      []string
+		readKeys  []string
+		writeKeys []string
+	}{
+		{
+			args:      []string{"GET", "foo"},
+			readKeys:  []string{"ns:{foo}"},
+			writeKeys: []string{},
+		},
+		{
+			args:      []string{"set", "{a}1", "1"},
+			readKeys:  []string{},
+			writeKeys: []string{"ns:{a}1"},
+		},
+		{
+			args:      []string{"mset", "{a}1", "1"},
+			readKeys:  []string{},
+			writeKeys: []string{"ns:{a}1"},
+		},
+		{
+			args:      []string{"rpoplpush", "{a}1", "{a}2"},
+			readKeys:  []string{},
+			writeKeys: []string{"ns:{a}1", "ns:{a}2"},
+		},
+		{
+			args:      []string{"zdiffstore", "{a}3", "2", "{a}1", "{a}2"},
+			readKeys:  []string{"ns:{a}1", "ns:{a}2"},
+			writeKeys: []string{"ns:{a}3"},
+		},
+		{
+			args:      []string{"zdiff", "2", "{a}1", "{a}2", "withscores"},
+			readKeys:  []string{"ns:{a}1", "ns:{a}2"},
+			writeKeys: []string{},
+		},
+	}
+	for _, testCase := range testCases {
+		rewritten := RewriteCommandKeys(testCase.args)
+		command, err := ParseCommand(rewritten)
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, testCase.readKeys, command.ReadKeys())
+		assert.ElementsMatch(t, testCase.writeKeys, command.WriteKeys())
+		assert.Equal(t, rewritten, command.Args())
+
+		hashTag, err := CheckAndGetCommandKeysHashTag(command)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", hashTag)
+	}
+
+	assert.Equal(
+		t, []string{"mset", "ns:{a}1", "1", "ns:{a}2", "2"},
+		RewriteCommandKeys([]string{"mset", "{a}1", "1", "{a}2", "2"}),
+	)
+
+	// args is not modified
+	args = []string{"get", "foo"}
+	RewriteCommandKeys(args)
+	assert.Equal(t, []string{"get", "foo"}, args)
+
+	args = []string{"ping"}
+	assert.Equal(t, args, RewriteCommandKeys(args))
+}
+
+func TestCommandKeySpecsCoverSupportedCommands(t *testing.T) {
+	keylessCommands := map[string]bool{
+		"scan": true, "command": true, "echo": true, "ping": true, "hello": true, "cluster": true,
+		"multi": true, "exec": true, "discard": true, "unwatch": true, "reset": true,
+	}
+	for name := range supportedCommands {
+		_, isKeyCommand := commandKeySpecs[name]
+		_, isChannelCommand := commandChannelSpecs[name]
+		_, isPatternCommand := commandPatternSpecs[name]
+		assert.True(t, isKeyCommand || isChannelCommand || isPatternCommand || keylessCommands[name], name)
+	}
+}
+
+func TestRewritePubSubCommands(t *testing.T) {
+	defer SetKeyNamespace("")
+	SetKeyNamespace("ns*")
+
+	assert.Equal(t, []string{"publish", "ns*:news", "hello"}, RewriteCommandKeys([]string{"publish", "news", "hello"}))
+	assert.Equal(t, []string{"SUBSCRIBE", "ns*:news", "ns*:{a}1"}, RewriteCommandKeys([]string{"SUBSCRIBE", "news", "{a}1"}))
+	assert.Equal(t, []string{"unsubscribe", "ns*:news"}, RewriteCommandKeys([]string{"unsubscribe", "news"}))
+	assert.Equal(t, []string{"psubscribe", `ns\*:{a}*`}, RewriteCommandKeys([]string{"psubscribe", "{a}*"}))
+	assert.Equal(t, []string{"punsubscribe", `ns\*:{a}*`}, RewriteCommandKeys([]string{"punsubscribe", "{a}*"}))
+
+	assert.Equal(t, "news", trimChannelNamespace("ns*:news"))
+	assert.Equal(t, "{a}*", trimPatternNamespace(`ns\*:{a}*`))
+	// patterns across slots are still rejected after rewritten
+	_, err := ParseCommand(RewriteCommandKeys([]string{"psubscribe", "{a}*", "{b}*"}))
+	assert.Equal(t, errPatternsNotInSameSlot, err)
+}
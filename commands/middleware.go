@@ -0,0 +1,28 @@
+package commands
+
+import "context"
+
+// CommandHandler executes a command and returns its result.
+type CommandHandler func(ctx context.Context, command Commander) RESPData
+
+// CommandMiddleware wraps handler of commands for cross-cutting concerns e.g. logging and rate limiting,
+// it may change command, or return a result without calling next.
+type CommandMiddleware func(next CommandHandler) CommandHandler
+
+// empty means commands are executed directly.
+var commandMiddlewares []CommandMiddleware
+
+// SetCommandMiddlewares sets middlewares of commands, the first one is the outermost.
+// Commands executed alone pass middlewares when they are executed, commands in MULTI pass middlewares
+// when they are queued and their result is QUEUED. Commands of a batch are executed one by one
+// instead of in a pipeline if there are middlewares. It should be called before serving commands.
+func SetCommandMiddlewares(middlewares ...CommandMiddleware) {
+	commandMiddlewares = middlewares
+}
+
+func withCommandMiddlewares(handler CommandHandler) CommandHandler {
+	for i := len(commandMiddlewares) - 1; i >= 0; i-- {
+		handler = commandMiddlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"bytepower_room/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandMiddlewares(t *testing.T) {
+	defer SetCommandMiddlewares()
+
+	names := make([]string, 0)
+	logging := func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, command Commander) RESPData {
+			names = append(names, "logging:"+command.Name())
+			return next(ctx, command)
+		}
+	}
+	errDenied := errors.New("ERR denied")
+	denyDel := func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, command Commander) RESPData {
+			names = append(names, "deny:"+command.Name())
+			if command.Name() == "del" {
+				return ConvertErrorToRESPData(errDenied)
+			}
+			return next(ctx, command)
+		}
+	}
+	SetCommandMiddlewares(logging, denyDel)
+
+	// standalone command
+	command, _ := NewDelCommand([]string{"del", "{a}1"})
+	result := ExecuteCommand(context.TODO(), base.GetServerDependency().Redis, command)
+	assert.Equal(t, ConvertErrorToRESPData(errDenied), result)
+	assert.Equal(t, []string{"logging:del", "deny:del"}, names)
+
+	batch := NewCommandBatch()
+	batch.AddCommand(3, command)
+	assert.Equal(t, map[int]RESPData{3: ConvertErrorToRESPData(errDenied)}, batch.Execute(context.TODO(), base.GetServerDependency().Redis))
+
+	// commands in multi pass middlewares when they are queued
+	names = names[:0]
+	transaction := NewTransaction(base.GetServerDependency())
+	multi, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), multi)
+	set, _ := NewSetCommand([]string{"set", "{a}1", "1"})
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, transaction.Process(context.TODO(), set))
+	assert.Equal(t, ConvertErrorToRESPData(errDenied), transaction.Process(context.TODO(), command))
+	assert.Equal(t, []string{"logging:set", "deny:set", "logging:del", "deny:del"}, names)
+	assert.Equal(t, 1, len(transaction.commands))
+	discard, _ := NewDiscardCommand([]string{"discard"})
+	transaction.Process(context.TODO(), discard)
+}
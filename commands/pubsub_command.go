@@ -0,0 +1,349 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Pub/sub of room is backed by pub/sub of redis cluster.
+//
+// PUBLISH is sent to any node like other keyless commands, redis cluster broadcasts
+// published messages to all nodes, so subscribers on any node receive them.
+//
+// Subscriptions of a client connection share one redis connection, it is opened to the master of
+// the slot of the first channel or pattern subscribed, channels and patterns are hashed like keys.
+// The redis connection is reopened after network errors to the slot of any subscribed channel or pattern,
+// so patterns of a connection should be in the same slot to be routed the same way, pattern subscribes
+// across slots are rejected. Channels are not limited since messages are broadcast anyway.
+//
+// Channels and patterns are rewritten into key namespace before parsing like keys,
+// replies and messages have them without namespace.
+
+var (
+	errPatternsNotInSameSlot         = errors.New("CROSSSLOT Patterns in request don't hash to the same slot")
+	errUnsubscribeNotInSubscribeMode = errors.New("ERR UNSUBSCRIBE and PUNSUBSCRIBE are only allowed in subscribe mode")
+)
+
+func newNotAllowedInSubscribeModeError(command string) error {
+	return fmt.Errorf(
+		"ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+		command,
+	)
+}
+
+// IsSubscribeCommand returns true if command switches connection into subscribe mode,
+// commands not allowed are executed as normal commands, so they are rejected the same way.
+func IsSubscribeCommand(command Commander) bool {
+	switch command.Name() {
+	case "subscribe", "psubscribe":
+		return checkCommandAllowed(command) == nil
+	}
+	return false
+}
+
+type SubscribeCommand struct {
+	channels []string
+	commonCommand
+}
+
+func NewSubscribeCommand(args []string) (Commander, error) {
+	command := &SubscribeCommand{}
+	command.init(args)
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	command.channels = args[1:]
+	return command, nil
+}
+
+// Cmd is never sent to redis cluster, subscribe commands are processed by Subscription.
+func (command *SubscribeCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
+
+type PSubscribeCommand struct {
+	patterns []string
+	commonCommand
+}
+
+func NewPSubscribeCommand(args []string) (Commander, error) {
+	command := &PSubscribeCommand{}
+	command.init(args)
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	command.patterns = args[1:]
+	if !newKeysSlot(command.patterns...).inSameSlot() {
+		return nil, errPatternsNotInSameSlot
+	}
+	return command, nil
+}
+
+func (command *PSubscribeCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
+
+// UnsubscribeCommand unsubscribes all channels if no channel is given, the same for PUnsubscribeCommand.
+type UnsubscribeCommand struct {
+	channels []string
+	commonCommand
+}
+
+func NewUnsubscribeCommand(args []string) (Commander, error) {
+	command := &UnsubscribeCommand{}
+	command.init(args)
+	command.channels = args[1:]
+	return command, nil
+}
+
+func (command *UnsubscribeCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
+
+type PUnsubscribeCommand struct {
+	patterns []string
+	commonCommand
+}
+
+func NewPUnsubscribeCommand(args []string) (Commander, error) {
+	command := &PUnsubscribeCommand{}
+	command.init(args)
+	command.patterns = args[1:]
+	return command, nil
+}
+
+func (command *PUnsubscribeCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
+
+type PublishCommand struct {
+	channel string
+	message string
+	commonCommand
+}
+
+func NewPublishCommand(args []string) (Commander, error) {
+	command := &PublishCommand{}
+	command.init(args)
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	command.channel = args[1]
+	command.message = args[2]
+	return command, nil
+}
+
+func (command *PublishCommand) Cmd() redis.Cmder {
+	return redis.NewIntCmd(contextTODO, command.name, command.channel, command.message)
+}
+
+// Subscription holds channels and patterns subscribed by a connection in subscribe mode,
+// it is not safe for concurrent use.
+type Subscription struct {
+	redisCluster *redis.ClusterClient
+	// nil until the first channel or pattern is subscribed, so it is routed by slot of them.
+	pubsub      *redis.PubSub
+	messages    <-chan *redis.Message
+	channels    map[string]bool
+	patterns    map[string]bool
+	patternSlot keysSlot
+}
+
+func NewSubscription(redisCluster *redis.ClusterClient) *Subscription {
+	return &Subscription{
+		redisCluster: redisCluster,
+		channels:     make(map[string]bool),
+		patterns:     make(map[string]bool),
+	}
+}
+
+// Count returns the number of channels and patterns subscribed.
+func (subscription *Subscription) Count() int {
+	return len(subscription.channels) + len(subscription.patterns)
+}
+
+// Messages returns channel of messages received, it is nil before anything is subscribed.
+func (subscription *Subscription) Messages() <-chan *redis.Message {
+	return subscription.messages
+}
+
+// Process returns replies of command, subscribe commands reply once for every channel or pattern.
+func (subscription *Subscription) Process(ctx context.Context, command Commander) []RESPData {
+	switch c := command.(type) {
+	case *SubscribeCommand:
+		return subscription.subscribe(ctx, c)
+	case *PSubscribeCommand:
+		return subscription.psubscribe(ctx, c)
+	case *UnsubscribeCommand:
+		return subscription.unsubscribe(ctx, c)
+	case *PUnsubscribeCommand:
+		return subscription.punsubscribe(ctx, c)
+	case *PingCommand:
+		message := ""
+		if c.message != nil {
+			message = *c.message
+		}
+		return []RESPData{{
+			DataType: PushRespType,
+			Value: []RESPData{
+				{DataType: BulkStringRespType, Value: "pong"},
+				{DataType: BulkStringRespType, Value: message},
+			},
+		}}
+	}
+	return []RESPData{ConvertErrorToRESPData(newNotAllowedInSubscribeModeError(command.Name()))}
+}
+
+func (subscription *Subscription) getPubSub() *redis.PubSub {
+	if subscription.pubsub == nil {
+		subscription.pubsub = subscription.redisCluster.Subscribe(contextTODO)
+	}
+	return subscription.pubsub
+}
+
+// startReceiving starts receiving messages after the redis connection is opened by subscribe,
+// receiving before that opens the connection to a random node.
+func (subscription *Subscription) startReceiving() {
+	if subscription.messages == nil {
+		subscription.messages = subscription.pubsub.Channel()
+	}
+}
+
+func (subscription *Subscription) subscribe(ctx context.Context, command *SubscribeCommand) []RESPData {
+	if err := checkCommandAllowed(command); err != nil {
+		return []RESPData{ConvertErrorToRESPData(err)}
+	}
+	if err := subscription.getPubSub().Subscribe(ctx, command.channels...); err != nil {
+		return []RESPData{ConvertErrorToRESPData(err)}
+	}
+	subscription.startReceiving()
+	results := make([]RESPData, 0, len(command.channels))
+	for _, channel := range command.channels {
+		subscription.channels[channel] = true
+		results = append(results, subscription.reply("subscribe", trimChannelNamespace(channel)))
+	}
+	return results
+}
+
+func (subscription *Subscription) psubscribe(ctx context.Context, command *PSubscribeCommand) []RESPData {
+	if err := checkCommandAllowed(command); err != nil {
+		return []RESPData{ConvertErrorToRESPData(err)}
+	}
+	slot := newKeysSlot(command.patterns...)
+	if len(subscription.patterns) != 0 && !subscription.patternSlot.inSameSlotWith(slot) {
+		return []RESPData{ConvertErrorToRESPData(errPatternsNotInSameSlot)}
+	}
+	if err := subscription.getPubSub().PSubscribe(ctx, command.patterns...); err != nil {
+		return []RESPData{ConvertErrorToRESPData(err)}
+	}
+	subscription.startReceiving()
+	if len(subscription.patterns) == 0 {
+		subscription.patternSlot = slot
+	}
+	results := make([]RESPData, 0, len(command.patterns))
+	for _, pattern := range command.patterns {
+		subscription.patterns[pattern] = true
+		results = append(results, subscription.reply("psubscribe", trimPatternNamespace(pattern)))
+	}
+	return results
+}
+
+func (subscription *Subscription) unsubscribe(ctx context.Context, command *UnsubscribeCommand) []RESPData {
+	channels := command.channels
+	if len(channels) == 0 {
+		channels = mapKeys(subscription.channels)
+	}
+	if len(channels) == 0 {
+		return []RESPData{subscription.reply("unsubscribe", nil)}
+	}
+	if subscription.pubsub != nil {
+		if err := subscription.pubsub.Unsubscribe(ctx, channels...); err != nil {
+			return []RESPData{ConvertErrorToRESPData(err)}
+		}
+	}
+	results := make([]RESPData, 0, len(channels))
+	for _, channel := range channels {
+		delete(subscription.channels, channel)
+		results = append(results, subscription.reply("unsubscribe", trimChannelNamespace(channel)))
+	}
+	return results
+}
+
+func (subscription *Subscription) punsubscribe(ctx context.Context, command *PUnsubscribeCommand) []RESPData {
+	patterns := command.patterns
+	if len(patterns) == 0 {
+		patterns = mapKeys(subscription.patterns)
+	}
+	if len(patterns) == 0 {
+		return []RESPData{subscription.reply("punsubscribe", nil)}
+	}
+	if subscription.pubsub != nil {
+		if err := subscription.pubsub.PUnsubscribe(ctx, patterns...); err != nil {
+			return []RESPData{ConvertErrorToRESPData(err)}
+		}
+	}
+	results := make([]RESPData, 0, len(patterns))
+	for _, pattern := range patterns {
+		delete(subscription.patterns, pattern)
+		results = append(results, subscription.reply("punsubscribe", trimPatternNamespace(pattern)))
+	}
+	if len(subscription.patterns) == 0 {
+		subscription.patternSlot = keysSlot{}
+	}
+	return results
+}
+
+// reply is like [kind, name, count], name is nil if nothing is unsubscribed.
+func (subscription *Subscription) reply(kind string, name interface{}) RESPData {
+	nameData := RESPData{DataType: NilRespType}
+	if name != nil {
+		nameData = RESPData{DataType: BulkStringRespType, Value: name}
+	}
+	return RESPData{
+		DataType: PushRespType,
+		Value: []RESPData{
+			{DataType: BulkStringRespType, Value: kind},
+			nameData,
+			{DataType: IntegerRespType, Value: int64(subscription.Count())},
+		},
+	}
+}
+
+func (subscription *Subscription) Close() error {
+	if subscription.pubsub == nil {
+		return nil
+	}
+	return subscription.pubsub.Close()
+}
+
+// ConvertMessageToRESPData converts message to [message, channel, payload],
+// or [pmessage, pattern, channel, payload] if it is received by pattern.
+func ConvertMessageToRESPData(message *redis.Message) RESPData {
+	value := make([]RESPData, 0, 4)
+	if message.Pattern != "" {
+		value = append(
+			value,
+			RESPData{DataType: BulkStringRespType, Value: "pmessage"},
+			RESPData{DataType: BulkStringRespType, Value: trimPatternNamespace(message.Pattern)},
+		)
+	} else {
+		value = append(value, RESPData{DataType: BulkStringRespType, Value: "message"})
+	}
+	value = append(
+		value,
+		RESPData{DataType: BulkStringRespType, Value: trimChannelNamespace(message.Channel)},
+		RESPData{DataType: BulkStringRespType, Value: message.Payload},
+	)
+	return RESPData{DataType: PushRespType, Value: value}
+}
+
+func mapKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"bytepower_room/base"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPubSubCommands(t *testing.T) {
+	_, err := NewSubscribeCommand([]string{"subscribe"})
+	assert.NotNil(t, err)
+	_, err = NewPublishCommand([]string{"publish", "channel"})
+	assert.NotNil(t, err)
+
+	_, err = NewPSubscribeCommand([]string{"psubscribe", "{a}*", "{a}news.*"})
+	assert.Nil(t, err)
+	_, err = NewPSubscribeCommand([]string{"psubscribe", "{a}*", "{b}*"})
+	assert.Equal(t, errPatternsNotInSameSlot, err)
+
+	command, _ := NewSubscribeCommand([]string{"subscribe", "a"})
+	assert.True(t, IsSubscribeCommand(command))
+	command, _ = NewUnsubscribeCommand([]string{"unsubscribe"})
+	assert.False(t, IsSubscribeCommand(command))
+	assert.Equal(t, ConvertErrorToRESPData(errUnsubscribeNotInSubscribeMode), NewSession().Process(command))
+}
+
+// clients in different namespaces do not receive messages of each other.
+func TestSubscriptionInNamespaces(t *testing.T) {
+	dep := base.GetServerDependency()
+	defer SetKeyNamespace("")
+	SetKeyNamespace("ns1")
+	subscription := NewSubscription(dep.Redis)
+	defer subscription.Close()
+	command, _ := ParseCommand(RewriteCommandKeys([]string{"subscribe", "{a}news"}))
+	results := subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("subscribe", "{a}news", 1)}, results)
+	command, _ = ParseCommand(RewriteCommandKeys([]string{"psubscribe", "{a}*"}))
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("psubscribe", "{a}*", 2)}, results)
+
+	SetKeyNamespace("ns2")
+	command, _ = ParseCommand(RewriteCommandKeys([]string{"publish", "{a}news", "hello"}))
+	result := ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: IntegerRespType, Value: int64(0)}, result)
+
+	SetKeyNamespace("ns1")
+	command, _ = ParseCommand(RewriteCommandKeys([]string{"publish", "{a}news", "hello"}))
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: IntegerRespType, Value: int64(2)}, result)
+	messages := []RESPData{testReceiveMessage(t, subscription), testReceiveMessage(t, subscription)}
+	assert.ElementsMatch(t, []RESPData{
+		{DataType: PushRespType, Value: []RESPData{
+			{DataType: BulkStringRespType, Value: "message"},
+			{DataType: BulkStringRespType, Value: "{a}news"},
+			{DataType: BulkStringRespType, Value: "hello"},
+		}},
+		{DataType: PushRespType, Value: []RESPData{
+			{DataType: BulkStringRespType, Value: "pmessage"},
+			{DataType: BulkStringRespType, Value: "{a}*"},
+			{DataType: BulkStringRespType, Value: "{a}news"},
+			{DataType: BulkStringRespType, Value: "hello"},
+		}},
+	}, messages)
+}
+
+func testReceiveMessage(t *testing.T, subscription *Subscription) RESPData {
+	select {
+	case message := <-subscription.Messages():
+		return ConvertMessageToRESPData(message)
+	case <-time.After(time.Second):
+		t.Fatal("no message is received")
+	}
+	return RESPData{}
+}
+
+func testPubSubReply(kind string, name string, count int64) RESPData {
+	return RESPData{
+		DataType: PushRespType,
+		Value: []RESPData{
+			{DataType: BulkStringRespType, Value: kind},
+			{DataType: BulkStringRespType, Value: name},
+			{DataType: IntegerRespType, Value: count},
+		},
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	dep := base.GetServerDependency()
+	subscription := NewSubscription(dep.Redis)
+	defer subscription.Close()
+	assert.Nil(t, subscription.Messages())
+
+	command, _ := NewSubscribeCommand([]string{"subscribe", "{a}news", "{a}sports"})
+	results := subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("subscribe", "{a}news", 1), testPubSubReply("subscribe", "{a}sports", 2)}, results)
+
+	command, _ = NewPSubscribeCommand([]string{"psubscribe", "{a}*"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("psubscribe", "{a}*", 3)}, results)
+
+	// patterns in another slot are rejected
+	command, _ = NewPSubscribeCommand([]string{"psubscribe", "{b}*"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{ConvertErrorToRESPData(errPatternsNotInSameSlot)}, results)
+	assert.Equal(t, 3, subscription.Count())
+
+	command, _ = NewPublishCommand([]string{"publish", "{a}news", "hello"})
+	result := ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: IntegerRespType, Value: int64(2)}, result)
+	messages := []RESPData{testReceiveMessage(t, subscription), testReceiveMessage(t, subscription)}
+	assert.ElementsMatch(t, []RESPData{
+		{DataType: PushRespType, Value: []RESPData{
+			{DataType: BulkStringRespType, Value: "message"},
+			{DataType: BulkStringRespType, Value: "{a}news"},
+			{DataType: BulkStringRespType, Value: "hello"},
+		}},
+		{DataType: PushRespType, Value: []RESPData{
+			{DataType: BulkStringRespType, Value: "pmessage"},
+			{DataType: BulkStringRespType, Value: "{a}*"},
+			{DataType: BulkStringRespType, Value: "{a}news"},
+			{DataType: BulkStringRespType, Value: "hello"},
+		}},
+	}, messages)
+
+	// only pub/sub commands and ping are allowed in subscribe mode
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, ErrorRespType, results[0].DataType)
+	command, _ = NewPingCommand([]string{"ping"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, PushRespType, results[0].DataType)
+
+	command, _ = NewUnsubscribeCommand([]string{"unsubscribe", "{a}news"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("unsubscribe", "{a}news", 2)}, results)
+	command, _ = NewPUnsubscribeCommand([]string{"punsubscribe"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("punsubscribe", "{a}*", 1)}, results)
+
+	// patterns in another slot can be subscribed after all patterns are unsubscribed
+	command, _ = NewPSubscribeCommand([]string{"psubscribe", "{b}*"})
+	results = subscription.Process(context.TODO(), command)
+	assert.Equal(t, []RESPData{testPubSubReply("psubscribe", "{b}*", 2)}, results)
+}
@@ -0,0 +1,205 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// SCAN iterates masters of redis cluster one by one, masters are ordered by the lowest slot they serve.
+//
+// Cursor returned to client encodes the master and the cursor of SCAN on it,
+// the lowest 14 bits are the lowest slot of the master, the other bits are the cursor of the master.
+// Cursor 0 is the start of the master serving slot 0, which is always the first master,
+// so cursor 0 is only returned after the last master is exhausted.
+// Keys migrated between masters during iteration may be missed or returned twice,
+// cursor is invalid if masters serving slots are changed.
+
+const (
+	scanCursorSlotBits = 14
+	scanCursorSlotMask = 1<<scanCursorSlotBits - 1
+)
+
+var (
+	errInvalidCursor      = errors.New("ERR invalid cursor")
+	errNodeCursorTooLarge = errors.New("ERR cursor of redis node is too large to be encoded")
+	errScanInMulti        = errors.New("ERR SCAN inside MULTI is not allowed")
+)
+
+// encodeScanCursor encodes slot of master and cursor of SCAN on it into cursor returned to client.
+func encodeScanCursor(slot int, nodeCursor uint64) (uint64, error) {
+	if nodeCursor > (1<<(64-scanCursorSlotBits))-1 {
+		return 0, errNodeCursorTooLarge
+	}
+	return nodeCursor<<scanCursorSlotBits | uint64(slot), nil
+}
+
+func decodeScanCursor(cursor uint64) (slot int, nodeCursor uint64) {
+	return int(cursor & scanCursorSlotMask), cursor >> scanCursorSlotBits
+}
+
+var slotKeysOnce sync.Once
+
+// slotKeys has a key in every slot, so a node can be found by slot with key.
+var slotKeys [clusterSlotCount]string
+
+// slotKey returns a key in slot, keys are found by trying numbers in turn.
+func slotKey(slot int) string {
+	slotKeysOnce.Do(func() {
+		found := 0
+		for i := 0; found < clusterSlotCount; i++ {
+			key := strconv.Itoa(i)
+			if s := keySlot(key); slotKeys[s] == "" {
+				slotKeys[s] = key
+				found++
+			}
+		}
+	})
+	return slotKeys[slot]
+}
+
+// masterFirstSlots returns the lowest slot of every master in ascending order.
+func masterFirstSlots(ctx context.Context, redisCluster *redis.ClusterClient) ([]int, error) {
+	slots, err := redisCluster.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	firstSlots := make(map[string]int)
+	for _, slot := range slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		master := slot.Nodes[0].Addr
+		if first, ok := firstSlots[master]; !ok || slot.Start < first {
+			firstSlots[master] = slot.Start
+		}
+	}
+	result := make([]int, 0, len(firstSlots))
+	for _, first := range firstSlots {
+		result = append(result, first)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// ScanCommand is executed on masters of redis cluster in turn, it can not be pipelined or queued in MULTI.
+type ScanCommand struct {
+	cursor  uint64
+	pattern string
+	count   int64
+	keyType string
+	commonCommand
+}
+
+func NewScanCommand(args []string) (Commander, error) {
+	command := &ScanCommand{}
+	command.init(args)
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	command.cursor = cursor
+	for index := 2; index < len(args); index += 2 {
+		switch strings.ToLower(args[index]) {
+		case "match":
+			command.pattern = args[index+1]
+		case "count":
+			count, err := strconv.ParseInt(args[index+1], 10, 64)
+			if err != nil {
+				return nil, errInvalidInteger
+			}
+			if count < 1 {
+				return nil, errSyntaxError
+			}
+			command.count = count
+		case "type":
+			command.keyType = args[index+1]
+		default:
+			return nil, errSyntaxError
+		}
+	}
+	return command, nil
+}
+
+// nodeArgs returns args of SCAN sent to node, pattern is limited to keys in namespace.
+func (command *ScanCommand) nodeArgs(nodeCursor uint64) []interface{} {
+	args := []interface{}{command.name, nodeCursor}
+	pattern := command.pattern
+	if keyNamespace != "" {
+		if pattern == "" {
+			pattern = "*"
+		}
+		pattern = escapeGlobPattern(keyNamespace) + ":" + pattern
+	}
+	if pattern != "" {
+		args = append(args, "match", pattern)
+	}
+	if command.count > 0 {
+		args = append(args, "count", command.count)
+	}
+	if command.keyType != "" {
+		args = append(args, "type", command.keyType)
+	}
+	return args
+}
+
+func (command *ScanCommand) Cmd() redis.Cmder {
+	return redis.NewScanCmd(contextTODO, nil, command.nodeArgs(command.cursor)...)
+}
+
+// scan scans the master in cursor, and moves cursor to the next master if it is exhausted.
+func (command *ScanCommand) scan(ctx context.Context, redisCluster *redis.ClusterClient) RESPData {
+	firstSlots, err := masterFirstSlots(ctx, redisCluster)
+	if err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	slot, nodeCursor := decodeScanCursor(command.cursor)
+	nodeIndex := sort.SearchInts(firstSlots, slot)
+	if nodeIndex == len(firstSlots) || firstSlots[nodeIndex] != slot {
+		return ConvertErrorToRESPData(errInvalidCursor)
+	}
+
+	tx, err := redisCluster.NewTransation(ctx, slotKey(slot))
+	if err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	defer tx.Close(ctx)
+	cmd := redis.NewScanCmd(ctx, nil, command.nodeArgs(nodeCursor)...)
+	if err := tx.Process(ctx, cmd); err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	keys, nextNodeCursor := cmd.Val()
+
+	var cursor uint64
+	if nextNodeCursor != 0 {
+		if cursor, err = encodeScanCursor(slot, nextNodeCursor); err != nil {
+			return ConvertErrorToRESPData(err)
+		}
+	} else if nodeIndex+1 < len(firstSlots) {
+		cursor, _ = encodeScanCursor(firstSlots[nodeIndex+1], 0)
+	}
+
+	value := make([]RESPData, 0, len(keys))
+	for _, key := range keys {
+		if keyNamespace != "" {
+			// "namespace:{foo}" becomes "{foo}", which is rewritten to the same key.
+			key = strings.TrimPrefix(key, keyNamespace+":")
+		}
+		value = append(value, RESPData{DataType: BulkStringRespType, Value: key})
+	}
+	return RESPData{
+		DataType: ArrayRespType,
+		Value: []RESPData{
+			{DataType: BulkStringRespType, Value: strconv.FormatUint(cursor, 10)},
+			{DataType: ArrayRespType, Value: value},
+		},
+	}
+}
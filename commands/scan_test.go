@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"bytepower_room/base"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanCursor(t *testing.T) {
+	cursor, err := encodeScanCursor(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), cursor)
+
+	cursor, err = encodeScanCursor(5461, 1234)
+	assert.Nil(t, err)
+	slot, nodeCursor := decodeScanCursor(cursor)
+	assert.Equal(t, 5461, slot)
+	assert.Equal(t, uint64(1234), nodeCursor)
+
+	_, err = encodeScanCursor(0, 1<<60)
+	assert.Equal(t, errNodeCursorTooLarge, err)
+
+	for _, slot := range []int{0, 100, clusterSlotCount - 1} {
+		assert.Equal(t, slot, keySlot(slotKey(slot)))
+	}
+}
+
+func TestNewScanCommand(t *testing.T) {
+	command, err := NewScanCommand([]string{"scan", "0", "MATCH", "{a}*", "COUNT", "10", "TYPE", "string"})
+	assert.Nil(t, err)
+	scanCommand := command.(*ScanCommand)
+	assert.Equal(t, "{a}*", scanCommand.pattern)
+	assert.Equal(t, int64(10), scanCommand.count)
+	assert.Equal(t, "string", scanCommand.keyType)
+
+	testCases := [][]string{
+		{"scan"},
+		{"scan", "-1"},
+		{"scan", "0", "match"},
+		{"scan", "0", "count", "0"},
+		{"scan", "0", "count", "a"},
+		{"scan", "0", "limit", "10"},
+	}
+	for _, args := range testCases {
+		_, err := NewScanCommand(args)
+		assert.NotNil(t, err, args)
+	}
+
+	SetKeyNamespace("ns*")
+	defer SetKeyNamespace("")
+	assert.Equal(t, []interface{}{"scan", uint64(0), "match", `ns\*:{a}*`}, scanCommand.nodeArgs(0)[:4])
+}
+
+// keys in many hash tags are spread over masters, they are all returned by walking cursor to 0.
+func TestScanCommand(t *testing.T) {
+	dep := base.GetServerDependency()
+	keys := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("{scan_%d}key", i)
+		assert.Nil(t, dep.Redis.Set(context.TODO(), key, "1", 0).Err())
+		keys = append(keys, key)
+	}
+	defer testEmptyKeysInRedis(keys...)
+	assert.Nil(t, dep.Redis.HSet(context.TODO(), "{scan_hash}key", "field", "1").Err())
+	defer testEmptyKeysInRedis("{scan_hash}key")
+
+	scannedKeys := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		assert.True(t, i < 1000, "scan does not finish")
+		command, _ := NewScanCommand([]string{"scan", cursor, "match", "{scan_*", "count", "10", "type", "string"})
+		result := ExecuteCommand(context.TODO(), dep.Redis, command)
+		assert.Equal(t, ArrayRespType, result.DataType)
+		value := result.Value.([]RESPData)
+		cursor = value[0].Value.(string)
+		for _, key := range value[1].Value.([]RESPData) {
+			scannedKeys[key.Value.(string)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, len(keys), len(scannedKeys))
+	for _, key := range keys {
+		assert.True(t, scannedKeys[key], key)
+	}
+
+	command, _ := NewScanCommand([]string{"scan", "1"})
+	result := ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, ConvertErrorToRESPData(errInvalidCursor), result)
+
+	// scan can not be queued in multi
+	transaction := NewTransaction(dep)
+	multi, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), multi)
+	command, _ = NewScanCommand([]string{"scan", "0"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, ConvertErrorToRESPData(errScanInMulti), result)
+	testCloseTransaction(t, transaction)
+}
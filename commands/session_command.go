@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ProtocolVersionRESP2 = 2
+	ProtocolVersionRESP3 = 3
+
+	serverName    = "redis"
+	serverVersion = "6.0.0"
+)
+
+var (
+	errInvalidProtocolVersion     = errors.New("ERR Protocol version is not an integer or out of range")
+	errUnsupportedProtocolVersion = errors.New("NOPROTO sorry this protocol version is not supported")
+)
+
+var sessionIDCounter int64
+
+// Session holds the state of a client connection which is not related to transactions.
+type Session struct {
+	id              int64
+	name            string
+	protocolVersion int
+	// sequence of the last exec with SEQ option
+	lastExecSequence int64
+}
+
+func NewSession() *Session {
+	return &Session{
+		id:              atomic.AddInt64(&sessionIDCounter, 1),
+		protocolVersion: ProtocolVersionRESP2,
+	}
+}
+
+func (session *Session) ID() int64 {
+	return session.id
+}
+
+func (session *Session) Name() string {
+	return session.name
+}
+
+func (session *Session) ProtocolVersion() int {
+	return session.protocolVersion
+}
+
+// AcceptExecSequence checks sequence of exec is greater than that of the last exec,
+// and records it if so. Exec without SEQ option and dry run are always accepted,
+// since dry run does not execute transaction.
+func (session *Session) AcceptExecSequence(command Commander) error {
+	execCommand, ok := command.(*ExecCommand)
+	if !ok || execCommand.sequence == 0 || execCommand.dryRun {
+		return nil
+	}
+	if execCommand.sequence <= session.lastExecSequence {
+		return newStaleSequenceError(execCommand.sequence, session.lastExecSequence)
+	}
+	session.lastExecSequence = execCommand.sequence
+	return nil
+}
+
+func IsSessionCommand(command Commander) bool {
+	switch command.Name() {
+	case "hello", "cluster", "unsubscribe", "punsubscribe":
+		return true
+	}
+	return false
+}
+
+func (session *Session) Process(command Commander) RESPData {
+	var result RESPData
+	switch c := command.(type) {
+	case *HelloCommand:
+		result = session.hello(c)
+	case *ClusterCommand:
+		result = c.topology()
+	case *UnsubscribeCommand, *PUnsubscribeCommand:
+		result = ConvertErrorToRESPData(errUnsubscribeNotInSubscribeMode)
+	default:
+		result = ConvertErrorToRESPData(newUnknownCommand(command.Name(), command.Args()[1:]))
+	}
+	return result
+}
+
+func (session *Session) hello(command *HelloCommand) RESPData {
+	if command.protocolVersion != 0 {
+		session.protocolVersion = command.protocolVersion
+	}
+	if command.clientName != nil {
+		session.name = *command.clientName
+	}
+	return RESPData{
+		DataType: MapRespType,
+		Value: []RESPData{
+			{DataType: BulkStringRespType, Value: "server"},
+			{DataType: BulkStringRespType, Value: serverName},
+			{DataType: BulkStringRespType, Value: "version"},
+			{DataType: BulkStringRespType, Value: serverVersion},
+			{DataType: BulkStringRespType, Value: "proto"},
+			{DataType: IntegerRespType, Value: int64(session.protocolVersion)},
+			{DataType: BulkStringRespType, Value: "id"},
+			{DataType: IntegerRespType, Value: session.id},
+			{DataType: BulkStringRespType, Value: "mode"},
+			{DataType: BulkStringRespType, Value: "standalone"},
+			{DataType: BulkStringRespType, Value: "role"},
+			{DataType: BulkStringRespType, Value: "master"},
+			{DataType: BulkStringRespType, Value: "modules"},
+			{DataType: ArrayRespType, Value: []RESPData{}},
+		},
+	}
+}
+
+// HelloCommand is processed by the session, it is never sent to redis cluster.
+type HelloCommand struct {
+	protocolVersion int
+	clientName      *string
+	commonCommand
+}
+
+func NewHelloCommand(args []string) (Commander, error) {
+	command := &HelloCommand{}
+	command.init(args)
+	if len(args) == 1 {
+		return command, nil
+	}
+	version, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errInvalidProtocolVersion
+	}
+	if version != ProtocolVersionRESP2 && version != ProtocolVersionRESP3 {
+		return nil, errUnsupportedProtocolVersion
+	}
+	command.protocolVersion = version
+	for index := 2; index < len(args); index++ {
+		switch strings.ToLower(args[index]) {
+		case "setname":
+			if index+1 >= len(args) {
+				return nil, errSyntaxError
+			}
+			command.clientName = &args[index+1]
+			index++
+		default:
+			return nil, errSyntaxError
+		}
+	}
+	return command, nil
+}
+
+func (command *HelloCommand) Cmd() redis.Cmder {
+	return redis.NewSliceCmd(contextTODO, command.argsToInterfaceSlice()...)
+}
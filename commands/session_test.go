@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionHello(t *testing.T) {
+	session := NewSession()
+	assert.Equal(t, ProtocolVersionRESP2, session.ProtocolVersion())
+
+	command, err := NewHelloCommand([]string{"hello", "3", "setname", "client"})
+	assert.Nil(t, err)
+	assert.True(t, IsSessionCommand(command))
+
+	result := session.Process(command)
+	assert.Equal(t, MapRespType, result.DataType)
+	assert.Equal(t, ProtocolVersionRESP3, session.ProtocolVersion())
+	assert.Equal(t, "client", session.Name())
+
+	values := result.Value.([]RESPData)
+	assert.Equal(t, 0, len(values)%2)
+	fields := make(map[string]interface{})
+	for index := 0; index < len(values); index += 2 {
+		fields[values[index].Value.(string)] = values[index+1].Value
+	}
+	assert.Equal(t, int64(ProtocolVersionRESP3), fields["proto"])
+	assert.Equal(t, session.ID(), fields["id"])
+
+	command, _ = NewHelloCommand([]string{"hello"})
+	session.Process(command)
+	assert.Equal(t, ProtocolVersionRESP3, session.ProtocolVersion())
+
+	assert.NotEqual(t, session.ID(), NewSession().ID())
+}
+
+func TestSessionCluster(t *testing.T) {
+	SetClusterEndpoint("10.0.0.1", 6380)
+	defer SetClusterEndpoint("127.0.0.1", 6379)
+	session := NewSession()
+
+	command, err := NewClusterCommand([]string{"cluster", "SLOTS"})
+	assert.Nil(t, err)
+	assert.True(t, IsSessionCommand(command))
+	result := session.Process(command)
+	assert.Equal(t, ArrayRespType, result.DataType)
+	slots := result.Value.([]RESPData)
+	assert.Equal(t, 1, len(slots))
+	slot := slots[0].Value.([]RESPData)
+	assert.Equal(t, int64(0), slot[0].Value)
+	assert.Equal(t, int64(16383), slot[1].Value)
+	node := slot[2].Value.([]RESPData)
+	assert.Equal(t, "10.0.0.1", node[0].Value)
+	assert.Equal(t, int64(6380), node[1].Value)
+	nodeID := node[2].Value.(string)
+	assert.Equal(t, 40, len(nodeID))
+
+	command, err = NewClusterCommand([]string{"cluster", "nodes"})
+	assert.Nil(t, err)
+	result = session.Process(command)
+	assert.Equal(t, VerbatimStringRespType, result.DataType)
+	assert.Equal(t, nodeID+" 10.0.0.1:6380@16380 myself,master - 0 0 1 connected 0-16383\n", result.Value)
+
+	command, err = NewClusterCommand([]string{"cluster", "myid"})
+	assert.Nil(t, err)
+	assert.Equal(t, nodeID, session.Process(command).Value)
+
+	_, err = NewClusterCommand([]string{"cluster"})
+	assert.NotNil(t, err)
+	_, err = NewClusterCommand([]string{"cluster", "slots", "a"})
+	assert.NotNil(t, err)
+	_, err = NewClusterCommand([]string{"cluster", "addslots", "1"})
+	assert.NotNil(t, err)
+}
+
+func TestSessionAcceptExecSequence(t *testing.T) {
+	for _, args := range [][]string{
+		{"exec", "seq"}, {"exec", "seq", "0"}, {"exec", "seq", "a"},
+		{"exec", "seq", "1", "seq", "2"}, {"exec", "a"},
+	} {
+		_, err := NewExecCommand(args)
+		assert.NotNil(t, err, args)
+	}
+
+	session := NewSession()
+	command, _ := NewExecCommand([]string{"exec"})
+	assert.Nil(t, session.AcceptExecSequence(command))
+	command, _ = NewMultiCommand([]string{"multi"})
+	assert.Nil(t, session.AcceptExecSequence(command))
+
+	command, err := NewExecCommand([]string{"exec", "seq", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), command.(*ExecCommand).Sequence())
+	assert.Nil(t, session.AcceptExecSequence(command))
+
+	// duplicate and out of order sequences are rejected
+	for _, sequence := range []string{"2", "1"} {
+		command, _ = NewExecCommand([]string{"exec", "seq", sequence})
+		err = session.AcceptExecSequence(command)
+		var txErr *TransactionError
+		assert.True(t, errors.As(err, &txErr))
+		assert.Equal(t, TransactionErrorCodeStaleSeq, txErr.Code)
+	}
+
+	// dry run does not record sequence
+	command, _ = NewExecCommand([]string{"exec", "dryrun", "seq", "5"})
+	assert.Nil(t, session.AcceptExecSequence(command))
+	command, _ = NewExecCommand([]string{"exec", "seq", "3"})
+	assert.Nil(t, session.AcceptExecSequence(command))
+
+	// sequences are per session
+	assert.Nil(t, NewSession().AcceptExecSequence(command))
+}
@@ -3,9 +3,13 @@ package commands
 import (
 	"bytepower_room/base"
 	"bytepower_room/base/log"
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -13,16 +17,16 @@ import (
 type TransactionCloseReason string
 
 const (
-	TransactionCloseReasonTxClosed                 TransactionCloseReason = "transaction is closed"
-	TransactionCloseReasonConnClosed               TransactionCloseReason = "connection is closed"
-	TransactionCloseReasonInvalidCommand           TransactionCloseReason = "command is invalid"
-	TransactionCloseReasonDiscard                  TransactionCloseReason = "execute discard command"
-	TransactionCloseReasonUnwatch                  TransactionCloseReason = "execute unwatch command"
-	TransactionCloseReasonExec                     TransactionCloseReason = "execute exec command"
-	TransactionCloseReasonReset                    TransactionCloseReason = "reset old transaction"
-	TransactionCloseReasonResetInWatch             TransactionCloseReason = "reset old transaction in watch command"
-	TransactionCloseReasonResetInExec              TransactionCloseReason = "reset old transaction in exec command"
-	TransactionCloseReasonWatchedKeysNotInSameSlot TransactionCloseReason = "watched keys not in the same slot"
+	TransactionCloseReasonTxClosed       TransactionCloseReason = "transaction is closed"
+	TransactionCloseReasonConnClosed     TransactionCloseReason = "connection is closed"
+	TransactionCloseReasonInvalidCommand TransactionCloseReason = "command is invalid"
+	TransactionCloseReasonDiscard        TransactionCloseReason = "execute discard command"
+	TransactionCloseReasonUnwatch        TransactionCloseReason = "execute unwatch command"
+	TransactionCloseReasonExec           TransactionCloseReason = "execute exec command"
+	TransactionCloseReasonReset          TransactionCloseReason = "reset old transaction"
+	TransactionCloseReasonResetInExec    TransactionCloseReason = "reset old transaction in exec command"
+	TransactionCloseReasonResetCommand   TransactionCloseReason = "execute reset command"
+	TransactionCloseReasonStaleSequence  TransactionCloseReason = "sequence of exec is stale"
 )
 
 type TransactionStatus string
@@ -34,28 +38,190 @@ const (
 )
 
 type Transaction struct {
+	// mutex makes State safe to be called by other goroutines
+	mutex       sync.Mutex
 	tx          *redis.Tx
 	watchedKeys []string
+	watchedSlot keysSlot
 	keys        []string
+	keysSlot    keysSlot
 	status      TransactionStatus
 	commands    []redis.Cmder
 	dep         base.Dependency
+	// bytes of queued commands and watched keys accounted in transactionMemoryBytes
+	memoryBytes int64
+	// dirty is true if a command failed to be queued, exec is aborted then like redis does
+	dirty bool
+}
+
+// keysSlot tracks whether keys added are in the same slot,
+// so keys need not to be checked again as transaction grows.
+type keysSlot struct {
+	firstKey  string
+	count     int
+	crossSlot bool
+}
+
+func newKeysSlot(keys ...string) keysSlot {
+	slot := keysSlot{}
+	slot.add(keys...)
+	return slot
+}
+
+func (slot *keysSlot) add(keys ...string) {
+	for _, key := range keys {
+		if slot.crossSlot {
+			return
+		}
+		if slot.count == 0 {
+			slot.firstKey = key
+		} else if !redis.AreKeysInSameSlot(slot.firstKey, key) {
+			slot.crossSlot = true
+		}
+		slot.count++
+	}
+}
+
+func (slot keysSlot) inSameSlot() bool {
+	return !slot.crossSlot
+}
+
+func (slot keysSlot) inSameSlotWith(other keysSlot) bool {
+	if slot.crossSlot || other.crossSlot {
+		return false
+	}
+	if slot.count == 0 || other.count == 0 {
+		return true
+	}
+	return redis.AreKeysInSameSlot(slot.firstKey, other.firstKey)
+}
+
+const clusterSlotCount = 16384
+
+// keySlot returns cluster slot of key like redis does.
+func keySlot(key string) int {
+	if hashTag := ExtractHashTagFromKey(key); hashTag != "" {
+		key = hashTag
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlotCount
+}
+
+// slot is -1 if there is no key.
+func (slot keysSlot) slot() int {
+	if slot.count == 0 {
+		return -1
+	}
+	return keySlot(slot.firstKey)
+}
+
+var execDiagnosticsEnabled bool
+
+// SetExecDiagnostics logs slot and node of successful exec if enabled, it is for debugging.
+// It should be called before serving commands.
+func SetExecDiagnostics(enabled bool) {
+	execDiagnosticsEnabled = enabled
+}
+
+// 0 means exec is not timed out.
+var execTimeout time.Duration
+
+// SetExecTimeout limits time of executing commands of transaction in exec, 0 means no limit.
+// It should be called before serving commands.
+func SetExecTimeout(timeout time.Duration) {
+	execTimeout = timeout
+}
+
+func newExecContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if execTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, execTimeout)
 }
 
 func NewTransaction(dep base.Dependency) *Transaction {
 	return &Transaction{status: TransactionStatusInited, dep: dep}
 }
 
-var errTxKeysNotInSameSlot = errors.New("ERR keys in transaction should be in the same slot")
+type TransactionErrorCode string
 
-func newRedisTransaction(redisCluster *redis.ClusterClient, keys ...string) (*redis.Tx, error) {
-	if len(keys) == 0 {
-		return redisCluster.NewTransation(contextTODO, "")
+const (
+	TransactionErrorCodeCrossSlot   TransactionErrorCode = "CROSSSLOT"
+	TransactionErrorCodeExecAbort   TransactionErrorCode = "EXECABORT"
+	TransactionErrorCodeWatchFailed TransactionErrorCode = "WATCHFAILED"
+	TransactionErrorCodeExecTimeout TransactionErrorCode = "EXECTIMEOUT"
+	TransactionErrorCodeStaleSeq    TransactionErrorCode = "STALESEQ"
+)
+
+// TransactionError is returned in RESPData when transaction fails,
+// use errors.As to get the code, the wrapped error is sent to client.
+type TransactionError struct {
+	Code TransactionErrorCode
+	err  error
+}
+
+func (err *TransactionError) Error() string {
+	return err.err.Error()
+}
+
+func (err *TransactionError) Unwrap() error {
+	return err.err
+}
+
+var errTxKeysNotInSameSlot = &TransactionError{
+	Code: TransactionErrorCodeCrossSlot,
+	err:  errors.New("ERR keys in transaction should be in the same slot"),
+}
+
+// errTxWatchKeysCrossSlot is returned like redis cluster does when keys of WATCH span slots,
+// keys watched before are kept.
+var errTxWatchKeysCrossSlot = &TransactionError{
+	Code: TransactionErrorCodeCrossSlot,
+	err:  errors.New("CROSSSLOT Keys in request don't hash to the same slot"),
+}
+
+var errTxExecAbortDirty = &TransactionError{
+	Code: TransactionErrorCodeExecAbort,
+	err:  errors.New("EXECABORT Transaction discarded because of previous errors."),
+}
+
+// commands may have been executed by redis when exec times out.
+var errTxExecTimeout = &TransactionError{
+	Code: TransactionErrorCodeExecTimeout,
+	err:  fmt.Errorf("EXECTIMEOUT transaction is not finished in time, it may or may not be executed: %w", context.DeadlineExceeded),
+}
+
+func convertTransactionExecError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errTxExecTimeout
+	}
+	if errors.Is(err, redis.TxFailedErr) {
+		return &TransactionError{Code: TransactionErrorCodeWatchFailed, err: err}
+	}
+	if strings.HasPrefix(err.Error(), string(TransactionErrorCodeExecAbort)) {
+		return &TransactionError{Code: TransactionErrorCodeExecAbort, err: err}
 	}
-	if !redis.AreKeysInSameSlot(keys...) {
+	return err
+}
+
+func newRedisTransaction(ctx context.Context, redisCluster *redis.ClusterClient, slot keysSlot) (*redis.Tx, error) {
+	if slot.count == 0 {
+		return redisCluster.NewTransation(ctx, "")
+	}
+	if !slot.inSameSlot() {
 		return nil, errTxKeysNotInSameSlot
 	}
-	return redisCluster.NewTransation(contextTODO, keys[0])
+	return redisCluster.NewTransation(ctx, slot.firstKey)
 }
 
 func (transaction *Transaction) multi() RESPData {
@@ -66,22 +232,30 @@ func (transaction *Transaction) multi() RESPData {
 	return RESPData{DataType: SimpleStringRespType, Value: "OK"}
 }
 
-func (transaction *Transaction) reset(reason TransactionCloseReason, status TransactionStatus) error {
+// reset always leaves transaction fully reset, even if closing tx fails,
+// since tx can not be used again after close. Close error is recorded and returned.
+// Closing tx sends UNWATCH, so no watch is left on the connection if it is reused.
+func (transaction *Transaction) reset(ctx context.Context, reason TransactionCloseReason, status TransactionStatus) error {
+	var err error
 	if transaction.tx != nil {
-		if err := transaction.tx.Close(contextTODO); err != nil {
+		if err = transaction.tx.Close(ctx); err != nil {
 			recordTransactionCloseError(transaction.dep.Logger, transaction.dep.Metric, err, reason)
-			return err
 		}
 		transaction.tx = nil
 	}
 	transaction.watchedKeys = make([]string, 0)
+	transaction.watchedSlot = keysSlot{}
 	transaction.keys = make([]string, 0)
+	transaction.keysSlot = keysSlot{}
 	transaction.commands = make([]redis.Cmder, 0)
+	transaction.releaseMemory(transaction.memoryBytes)
+	transaction.dirty = false
 	transaction.status = status
-	return nil
+	return err
 }
 
-func (transaction *Transaction) watch(keys ...string) RESPData {
+// watch inside MULTI is rejected like redis does, it takes no effect and the transaction goes on.
+func (transaction *Transaction) watch(ctx context.Context, keys ...string) RESPData {
 	if transaction.IsStarted() {
 		return RESPData{DataType: ErrorRespType, Value: errors.New("ERR WATCH inside MULTI is not allowed")}
 	}
@@ -89,20 +263,21 @@ func (transaction *Transaction) watch(keys ...string) RESPData {
 		return ConvertErrorToRESPData(newWrongNumberOfArgumentsError("watch"))
 	}
 
-	if transaction.tx != nil {
-		if len(transaction.watchedKeys) != 0 && !redis.AreKeysInSameSlot(append(transaction.watchedKeys, keys...)...) {
-			if err := transaction.reset(TransactionCloseReasonResetInWatch, TransactionStatusInited); err != nil {
-				return ConvertErrorToRESPData(err)
-			}
-		}
+	// keys watched before are kept, client should unwatch them before watching keys in another slot.
+	slot := newKeysSlot(keys...)
+	if !slot.inSameSlot() || (len(transaction.watchedKeys) != 0 && !transaction.watchedSlot.inSameSlotWith(slot)) {
+		return ConvertErrorToRESPData(errTxWatchKeysCrossSlot)
+	}
+
+	// keys are watched once like redis does, watching them again takes no effect.
+	keys = transaction.unwatchedKeys(keys)
+	if len(keys) == 0 {
+		return RESPData{DataType: SimpleStringRespType, Value: "OK"}
 	}
 
 	if transaction.tx == nil {
-		tx, err := newRedisTransaction(transaction.dep.Redis, keys...)
+		tx, err := newRedisTransaction(ctx, transaction.dep.Redis, slot)
 		if err != nil {
-			if err == errTxKeysNotInSameSlot {
-				transaction.Close(TransactionCloseReasonWatchedKeysNotInSameSlot)
-			}
 			return ConvertErrorToRESPData(err)
 		}
 		transaction.tx = tx
@@ -113,48 +288,98 @@ func (transaction *Transaction) watch(keys ...string) RESPData {
 			"execute transaction command: %s %s",
 			"watch", strings.Join(keys, " "),
 		))
-	if _, err := transaction.tx.Watch(contextTODO, keys...).Result(); err != nil {
+	bytes := argsMemoryBytes(keys)
+	if err := transaction.reserveMemory(bytes); err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	if _, err := transaction.tx.Watch(ctx, keys...).Result(); err != nil {
+		transaction.releaseMemory(bytes)
 		return ConvertErrorToRESPData(err)
 	}
 	transaction.watchedKeys = append(transaction.watchedKeys, keys...)
+	transaction.watchedSlot.add(keys...)
 	transaction.status = TransactionStatusInited
 	return RESPData{DataType: SimpleStringRespType, Value: "OK"}
 }
 
-func (transaction *Transaction) addCommand(command Commander) RESPData {
+// unwatchedKeys returns keys not watched yet without duplicates, in the order of keys.
+func (transaction *Transaction) unwatchedKeys(keys []string) []string {
+	seen := make(map[string]bool, len(transaction.watchedKeys)+len(keys))
+	for _, key := range transaction.watchedKeys {
+		seen[key] = true
+	}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func (transaction *Transaction) addCommand(ctx context.Context, command Commander) RESPData {
 	var result RESPData
 	if transaction.IsStarted() {
-		transaction.commands = append(transaction.commands, command.Cmd())
-		transaction.keys = append(transaction.keys, append(command.ReadKeys(), command.WriteKeys()...)...)
-		result = RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}
+		result = withCommandMiddlewares(transaction.queueCommand)(ctx, command)
+		if result.DataType == ErrorRespType {
+			transaction.dirty = true
+		}
 	} else {
-		result = ExecuteCommand(transaction.dep.Redis, command)
+		result = ExecuteCommand(ctx, transaction.dep.Redis, command)
 	}
 	return result
 }
 
-func (transaction *Transaction) exec() RESPData {
+func (transaction *Transaction) queueCommand(ctx context.Context, command Commander) RESPData {
+	if err := checkCommandAllowed(command); err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	if _, ok := command.(*ScanCommand); ok {
+		return ConvertErrorToRESPData(errScanInMulti)
+	}
+	if err := transaction.reserveMemory(argsMemoryBytes(command.Args())); err != nil {
+		return ConvertErrorToRESPData(err)
+	}
+	keys := append(command.ReadKeys(), command.WriteKeys()...)
+	transaction.commands = append(transaction.commands, command.Cmd())
+	transaction.keys = append(transaction.keys, keys...)
+	transaction.keysSlot.add(keys...)
+	return RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}
+}
+
+func (transaction *Transaction) exec(ctx context.Context) RESPData {
 	if !transaction.IsStarted() {
 		return ConvertErrorToRESPData(errors.New("ERR EXEC without MULTI"))
 	}
 	defer func() {
-		transaction.Close(TransactionCloseReasonExec)
+		transaction.close(ctx, TransactionCloseReasonExec)
 	}()
-	if !redis.AreKeysInSameSlot(transaction.keys...) {
+	if transaction.dirty {
+		return ConvertErrorToRESPData(errTxExecAbortDirty)
+	}
+	if !transaction.keysSlot.inSameSlot() {
 		return ConvertErrorToRESPData(errTxKeysNotInSameSlot)
 	}
-	if len(transaction.watchedKeys) != 0 && !redis.AreKeysInSameSlot(append(transaction.keys, transaction.watchedKeys...)...) {
+	if len(transaction.watchedKeys) != 0 && !transaction.keysSlot.inSameSlotWith(transaction.watchedSlot) {
 		if transaction.tx != nil {
-			if err := transaction.tx.Close(contextTODO); err != nil {
+			if err := transaction.tx.Close(ctx); err != nil {
 				recordTransactionCloseError(transaction.dep.Logger, transaction.dep.Metric, err, TransactionCloseReasonResetInExec)
 			}
 			transaction.tx = nil
 			transaction.watchedKeys = make([]string, 0)
+			transaction.watchedSlot = keysSlot{}
 		}
 	}
 
+	execCtx, cancel := newExecContext(ctx)
+	defer cancel()
+	if len(transaction.commands) == 0 {
+		return transaction.execWithoutCommands(execCtx)
+	}
+
 	if transaction.tx == nil {
-		tx, err := newRedisTransaction(transaction.dep.Redis, transaction.keys...)
+		tx, err := newRedisTransaction(ctx, transaction.dep.Redis, transaction.keysSlot)
 		if err != nil {
 			return ConvertErrorToRESPData(err)
 		}
@@ -166,16 +391,19 @@ func (transaction *Transaction) exec() RESPData {
 		transaction.dep.Logger.Debug(
 			fmt.Sprintf("execute transaction command: %s", cmd.String()),
 		)
-		if err := pipeline.Process(contextTODO, cmd); err != nil {
+		if err := pipeline.Process(execCtx, cmd); err != nil {
 			return ConvertErrorToRESPData(err)
 		}
 	}
 
-	commands, err := pipeline.Exec(contextTODO)
+	commands, err := pipeline.Exec(execCtx)
 	if err != nil {
-		return ConvertErrorToRESPData(err)
+		return convertExecErrorToRESPData(execCtx, err)
 	}
 
+	if execDiagnosticsEnabled {
+		transaction.logExecDiagnostics()
+	}
 	result := RESPData{DataType: ArrayRespType}
 	value := make([]RESPData, 0)
 	for _, command := range commands {
@@ -186,11 +414,103 @@ func (transaction *Transaction) exec() RESPData {
 	return result
 }
 
+// execWithoutCommands sends MULTI and EXEC to the node of watched keys,
+// since pipeline sends nothing without commands and watched keys would not be checked.
+// It returns empty array without watched keys like redis does.
+func (transaction *Transaction) execWithoutCommands(ctx context.Context) RESPData {
+	result := RESPData{DataType: ArrayRespType, Value: make([]RESPData, 0)}
+	if transaction.tx == nil {
+		return result
+	}
+	if err := transaction.tx.Process(ctx, redis.NewStatusCmd(ctx, "multi")); err != nil {
+		return convertExecErrorToRESPData(ctx, err)
+	}
+	if err := transaction.tx.Process(ctx, redis.NewSliceCmd(ctx, "exec")); err != nil {
+		// exec replies nil when watched keys are modified
+		if err == redis.Nil {
+			err = redis.TxFailedErr
+		}
+		return convertExecErrorToRESPData(ctx, err)
+	}
+	return result
+}
+
+func convertExecErrorToRESPData(ctx context.Context, err error) RESPData {
+	// redis client reports i/o timeout instead of ctx error when deadline of ctx is exceeded
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	return ConvertErrorToRESPData(convertTransactionExecError(err))
+}
+
+func (transaction *Transaction) logExecDiagnostics() {
+	slot := transaction.keysSlot
+	if slot.count == 0 {
+		slot = transaction.watchedSlot
+	}
+	transaction.dep.Logger.Info(
+		"exec diagnostics",
+		log.Int("slot", slot.slot()),
+		log.String("node", transaction.tx.String()),
+		log.Int("command_count", len(transaction.commands)),
+	)
+}
+
+// execDryRun checks queued commands like exec does, but commands are not executed and transaction is kept.
+// Name of queued commands are returned in order.
+func (transaction *Transaction) execDryRun() RESPData {
+	if !transaction.IsStarted() {
+		return ConvertErrorToRESPData(errors.New("ERR EXEC without MULTI"))
+	}
+	if transaction.dirty {
+		return ConvertErrorToRESPData(errTxExecAbortDirty)
+	}
+	if !transaction.keysSlot.inSameSlot() {
+		return ConvertErrorToRESPData(errTxKeysNotInSameSlot)
+	}
+	value := make([]RESPData, 0, len(transaction.commands))
+	for _, cmd := range transaction.commands {
+		value = append(value, RESPData{DataType: BulkStringRespType, Value: cmd.Name()})
+	}
+	return RESPData{DataType: ArrayRespType, Value: value}
+}
+
+// Slot returns the cluster slot exec of transaction is routed to, so clients can check routing before exec.
+// It is the slot of queued keys, or that of watched keys if no key is queued, -1 if there is no key at all.
+// Watched keys in another slot than queued keys are unwatched by exec, they do not affect the slot.
+func (transaction *Transaction) Slot() (int, error) {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	if !transaction.keysSlot.inSameSlot() {
+		return -1, errTxKeysNotInSameSlot
+	}
+	if transaction.keysSlot.count == 0 {
+		return transaction.watchedSlot.slot(), nil
+	}
+	return transaction.keysSlot.slot(), nil
+}
+
+// Close closes transaction with background context, it is used when connection is closed.
 func (transaction *Transaction) Close(reason TransactionCloseReason) error {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	return transaction.close(contextTODO, reason)
+}
+
+func (transaction *Transaction) close(ctx context.Context, reason TransactionCloseReason) error {
 	if transaction.IsClosed() {
 		return nil
 	}
-	return transaction.reset(reason, TransactionStatusClosed)
+	return transaction.reset(ctx, reason, TransactionStatusClosed)
+}
+
+// MarkDirty makes exec of started transaction aborted, it is called when a command can not be parsed in MULTI.
+func (transaction *Transaction) MarkDirty() {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	if transaction.IsStarted() {
+		transaction.dirty = true
+	}
 }
 
 func (transaction *Transaction) IsClosed() bool {
@@ -205,42 +525,77 @@ func (transaction *Transaction) Status() TransactionStatus {
 	return transaction.status
 }
 
-func (transaction *Transaction) discard() RESPData {
+// TransactionState is a snapshot of transaction for inspection.
+type TransactionState struct {
+	InMulti            bool     `json:"in_multi"`
+	QueuedCommandCount int      `json:"queued_command_count"`
+	WatchedKeys        []string `json:"watched_keys"`
+}
+
+// State can be called concurrently with Process, it waits for the command in process.
+func (transaction *Transaction) State() TransactionState {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	watchedKeys := make([]string, len(transaction.watchedKeys))
+	copy(watchedKeys, transaction.watchedKeys)
+	return TransactionState{
+		InMulti:            transaction.IsStarted(),
+		QueuedCommandCount: len(transaction.commands),
+		WatchedKeys:        watchedKeys,
+	}
+}
+
+func (transaction *Transaction) discard(ctx context.Context) RESPData {
 	if !transaction.IsStarted() {
 		return ConvertErrorToRESPData(errors.New("ERR DISCARD without MULTI"))
 	}
-	if err := transaction.Close(TransactionCloseReasonDiscard); err != nil {
-		return ConvertErrorToRESPData(err)
-	}
+	// close error is recorded, queued commands are discarded anyway.
+	transaction.close(ctx, TransactionCloseReasonDiscard)
 	return RESPData{DataType: SimpleStringRespType, Value: "OK"}
 }
 
-func (transaction *Transaction) unwatch() RESPData {
+func (transaction *Transaction) unwatch(ctx context.Context) RESPData {
 	if transaction.IsStarted() {
 		command, _ := NewUnwatchCommand([]string{"unwatch"})
-		return transaction.addCommand(command)
-	}
-	if err := transaction.Close(TransactionCloseReasonUnwatch); err != nil {
-		return ConvertErrorToRESPData(err)
+		return transaction.addCommand(ctx, command)
 	}
+	// close error is recorded, keys are not watched anymore since tx is dropped.
+	transaction.close(ctx, TransactionCloseReasonUnwatch)
 	return RESPData{DataType: SimpleStringRespType, Value: "OK"}
 }
 
-func (transaction *Transaction) Process(command Commander) RESPData {
+// resetState discards queued commands, unwatches keys and aborts MULTI like RESET of redis does,
+// it works even if transaction is closed, so client can always recover with it.
+func (transaction *Transaction) resetState(ctx context.Context) RESPData {
+	// close error is recorded, transaction is reset anyway.
+	transaction.reset(ctx, TransactionCloseReasonResetCommand, TransactionStatusClosed)
+	return RESPData{DataType: SimpleStringRespType, Value: "RESET"}
+}
+
+// Process executes command of transaction, redis operations are canceled when ctx is done.
+func (transaction *Transaction) Process(ctx context.Context, command Commander) RESPData {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
 	var result RESPData
 	switch command.Name() {
 	case "watch":
-		result = transaction.watch(command.ReadKeys()...)
+		result = transaction.watch(ctx, command.ReadKeys()...)
 	case "multi":
 		result = transaction.multi()
 	case "exec":
-		result = transaction.exec()
+		if execCommand, ok := command.(*ExecCommand); ok && execCommand.dryRun {
+			result = transaction.execDryRun()
+		} else {
+			result = transaction.exec(ctx)
+		}
 	case "discard":
-		result = transaction.discard()
+		result = transaction.discard(ctx)
 	case "unwatch":
-		result = transaction.unwatch()
+		result = transaction.unwatch(ctx)
+	case "reset":
+		result = transaction.resetState(ctx)
 	default:
-		result = transaction.addCommand(command)
+		result = transaction.addCommand(ctx, command)
 	}
 	return result
 }
@@ -289,23 +644,59 @@ func (command *MultiCommand) Cmd() redis.Cmder {
 	return redis.NewStatusCmd(contextTODO, command.name)
 }
 
+// ExecCommand with DRYRUN option checks the transaction without executing it.
+// With SEQ option, exec is rejected unless sequence is greater than that of the last exec of session,
+// so a replayed transaction is never executed twice.
 type ExecCommand struct {
+	dryRun bool
+	// 0 means sequence is not checked
+	sequence int64
 	commonCommand
 }
 
 func NewExecCommand(args []string) (Commander, error) {
 	command := &ExecCommand{}
 	command.init(args)
-	if len(args) != 1 {
+	if len(args) > 4 {
 		return nil, newWrongNumberOfArgumentsError(command.name)
 	}
+	for index := 1; index < len(args); index++ {
+		switch strings.ToLower(args[index]) {
+		case "dryrun":
+			command.dryRun = true
+		case "seq":
+			if index+1 >= len(args) || command.sequence != 0 {
+				return nil, errSyntaxError
+			}
+			sequence, err := strconv.ParseInt(args[index+1], 10, 64)
+			if err != nil || sequence <= 0 {
+				return nil, errInvalidInteger
+			}
+			command.sequence = sequence
+			index++
+		default:
+			return nil, errSyntaxError
+		}
+	}
 	return command, nil
 }
 
+func newStaleSequenceError(sequence int64, lastSequence int64) error {
+	return &TransactionError{
+		Code: TransactionErrorCodeStaleSeq,
+		err:  fmt.Errorf("STALESEQ sequence %d is not greater than sequence %d of the last exec, transaction is discarded", sequence, lastSequence),
+	}
+}
+
 func (command *ExecCommand) Cmd() redis.Cmder {
 	return redis.NewSliceCmd(contextTODO, command.name)
 }
 
+// Sequence is 0 if exec has no SEQ option.
+func (command *ExecCommand) Sequence() int64 {
+	return command.sequence
+}
+
 type DiscardCommand struct {
 	commonCommand
 }
@@ -340,6 +731,24 @@ func (command *UnwatchCommand) Cmd() redis.Cmder {
 	return redis.NewStatusCmd(contextTODO, command.name)
 }
 
+// ResetCommand is never queued in MULTI, it resets transaction immediately.
+type ResetCommand struct {
+	commonCommand
+}
+
+func NewResetCommand(args []string) (Commander, error) {
+	command := &ResetCommand{}
+	command.init(args)
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgumentsError(command.name)
+	}
+	return command, nil
+}
+
+func (command *ResetCommand) Cmd() redis.Cmder {
+	return redis.NewStatusCmd(contextTODO, command.name)
+}
+
 func recordTransactionCloseError(logger *log.Logger, metric *base.MetricClient, err error, reason TransactionCloseReason) {
 	logger.Error(
 		"transaction close error",
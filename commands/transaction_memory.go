@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errTransactionMemoryLimitExceeded = errors.New("BUSY memory of queued commands and watched keys of transactions exceeds limit")
+
+// 0 means memory of transactions is not limited.
+var transactionMemoryLimit int64
+
+// bytes of queued commands and watched keys held by all open transactions.
+var transactionMemoryBytes int64
+
+// SetTransactionMemoryLimit limits bytes of queued commands and watched keys of all transactions,
+// commands and watches exceeding the limit are rejected. 0 means no limit.
+// It should be called before serving commands.
+func SetTransactionMemoryLimit(limit int64) {
+	transactionMemoryLimit = limit
+}
+
+// GetTransactionMemoryUsage returns bytes of queued commands and watched keys of all open transactions.
+func GetTransactionMemoryUsage() int64 {
+	return atomic.LoadInt64(&transactionMemoryBytes)
+}
+
+// argsMemoryBytes counts bytes of args only, it is an estimate of memory held by them.
+func argsMemoryBytes(args []string) int64 {
+	var bytes int64
+	for _, arg := range args {
+		bytes += int64(len(arg))
+	}
+	return bytes
+}
+
+// reserveMemory accounts bytes to transaction, they are released when transaction is reset.
+func (transaction *Transaction) reserveMemory(bytes int64) error {
+	total := atomic.AddInt64(&transactionMemoryBytes, bytes)
+	if limit := transactionMemoryLimit; limit > 0 && total > limit {
+		atomic.AddInt64(&transactionMemoryBytes, -bytes)
+		return errTransactionMemoryLimitExceeded
+	}
+	transaction.memoryBytes += bytes
+	return nil
+}
+
+func (transaction *Transaction) releaseMemory(bytes int64) {
+	atomic.AddInt64(&transactionMemoryBytes, -bytes)
+	transaction.memoryBytes -= bytes
+}
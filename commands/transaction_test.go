@@ -2,7 +2,10 @@ package commands
 
 import (
 	"bytepower_room/base"
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
@@ -29,12 +32,23 @@ func TestTransactionWatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	keys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, keys...))
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 	assert.Equal(t, TransactionStatusInited, transaction.Status())
 	testCloseTransaction(t, transaction)
 }
 
+func TestTransactionProcessCanceledContext(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	command, _ := NewWatchCommand([]string{"watch", "{a}1"})
+	result := transaction.Process(ctx, command)
+	assert.Equal(t, ErrorRespType, result.DataType)
+	testCloseTransaction(t, transaction)
+}
+
 // tested commands:
 // watch {a}1 {b}1
 func TestTransactionWatchKeysCrossSlots(t *testing.T) {
@@ -42,42 +56,137 @@ func TestTransactionWatchKeysCrossSlots(t *testing.T) {
 	transaction := NewTransaction(dep)
 	keys := []string{"{a}1", "{b}1"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, keys...))
-	result := transaction.Process(command)
-	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxKeysNotInSameSlot}, result)
-	assert.True(t, transaction.IsClosed(), true)
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxWatchKeysCrossSlot}, result)
+	assert.Equal(t, "CROSSSLOT Keys in request don't hash to the same slot", result.Value.(error).Error())
+	assert.Equal(t, TransactionStatusInited, transaction.Status())
+	assert.Equal(t, 0, len(transaction.State().WatchedKeys))
+	testCloseTransaction(t, transaction)
+}
+
+// tested commands:
+// watch a{1}
+// watch b{2}
+// watch c{1}
+func TestTransactionWatchAnotherSlot(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "a{1}"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+
+	command, _ = NewWatchCommand([]string{"watch", "b{2}"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxWatchKeysCrossSlot}, result)
+	assert.Equal(t, []string{"a{1}"}, transaction.State().WatchedKeys)
+	assert.NotNil(t, transaction.tx)
+
+	// keys in the same slot are still watched
+	command, _ = NewWatchCommand([]string{"watch", "c{1}"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.Equal(t, []string{"a{1}", "c{1}"}, transaction.State().WatchedKeys)
+	testCloseTransaction(t, transaction)
+}
+
+// tx is closed before reset to make closing it fail.
+func TestTransactionResetCloseError(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "{a}1"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.Nil(t, transaction.tx.Close(context.TODO()))
+
+	err := transaction.reset(context.TODO(), TransactionCloseReasonReset, TransactionStatusInited)
+	assert.NotNil(t, err)
+	assert.Nil(t, transaction.tx)
+	assert.Equal(t, 0, len(transaction.watchedKeys))
+	assert.Equal(t, keysSlot{}, transaction.watchedSlot)
+	assert.Equal(t, int64(0), transaction.memoryBytes)
+	assert.Equal(t, TransactionStatusInited, transaction.Status())
+
+	// transaction works after reset
+	command, _ = NewWatchCommand([]string{"watch", "{b}1"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.Equal(t, []string{"{b}1"}, transaction.State().WatchedKeys)
+
+	// unwatch succeeds though closing tx fails
+	assert.Nil(t, transaction.tx.Close(context.TODO()))
+	command, _ = NewUnwatchCommand([]string{"unwatch"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.True(t, transaction.IsClosed())
+	assert.Nil(t, transaction.tx)
 }
 
 // test commands:
 // watch {a}1 {a}2
 // watch {a}3 {a}4
 // watch {b}1 {b}2
+// unwatch
+// watch {b}1 {b}2
 func TestTransactionMultipleWatches(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	keys1 := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, keys1...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 	assert.Equal(t, transaction.watchedKeys, keys1)
 
 	keys2 := []string{"{a}3", "{a}4"}
 	command, _ = NewWatchCommand(append([]string{"watch"}, keys2...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 	assert.Equal(t, transaction.watchedKeys, append(keys1, keys2...))
 
+	// watch keys in another slot is rejected, watched keys are kept
 	keys3 := []string{"{b}1", "{b}2"}
 	command, _ = NewWatchCommand(append([]string{"watch"}, keys3...))
-	transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxWatchKeysCrossSlot}, result)
+	assert.Equal(t, transaction.watchedKeys, append(keys1, keys2...))
+	assert.NotNil(t, transaction.tx)
+	assert.False(t, transaction.IsClosed())
+
+	command, _ = NewUnwatchCommand([]string{"unwatch"})
+	transaction.Process(context.TODO(), command)
+	transaction = NewTransaction(dep)
+	command, _ = NewWatchCommand(append([]string{"watch"}, keys3...))
+	transaction.Process(context.TODO(), command)
 	assert.Equal(t, transaction.watchedKeys, keys3)
 	testCloseTransaction(t, transaction)
 }
 
+// keys watched again are not duplicated, memory is accounted once.
+func TestTransactionWatchSameKeys(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "{a}1", "{a}1", "{a}2"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.Equal(t, []string{"{a}1", "{a}2"}, transaction.watchedKeys)
+	memoryBytes := transaction.memoryBytes
+
+	for i := 0; i < 3; i++ {
+		command, _ = NewWatchCommand([]string{"watch", "{a}2", "{a}1"})
+		result = transaction.Process(context.TODO(), command)
+		assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	}
+	command, _ = NewWatchCommand([]string{"watch", "{a}1", "{a}3"})
+	transaction.Process(context.TODO(), command)
+	assert.Equal(t, []string{"{a}1", "{a}2", "{a}3"}, transaction.watchedKeys)
+	assert.Equal(t, memoryBytes+int64(len("{a}3")), transaction.memoryBytes)
+	testCloseTransaction(t, transaction)
+}
+
 // test commands:
 // multi
 func TestMulti(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	command, _ := NewMultiCommand([]string{"multi"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 	assert.Equal(t, TransactionStatusStarted, transaction.Status())
 	testCloseTransaction(t, transaction)
@@ -92,21 +201,21 @@ func TestNestedMulti(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	command, _ := NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, ErrorRespType, result.DataType)
 	assert.Equal(t, TransactionStatusStarted, transaction.status)
 
 	key := "{a}1"
 	value := "a"
 	command, _ = NewSetCommand([]string{"set", key, value})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewExecCommand([]string{"exec"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(
 		t,
 		RESPData{
@@ -123,21 +232,60 @@ func TestWatchInMulti(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	command, _ := NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 	keys := []string{"{a}1", "{a}2"}
 	command, _ = NewWatchCommand(append([]string{"watch"}, keys...))
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, ErrorRespType, result.DataType)
 	testCloseTransaction(t, transaction)
 }
 
+// test commands:
+// multi
+// set {a}1 10
+// watch {a}1 {a}2
+// set {a}2 100
+// exec
+func TestWatchInMultiTakesNoEffect(t *testing.T) {
+	dep := base.GetServerDependency()
+	defer testEmptyKeysInRedis("{a}1", "{a}2")
+	transaction := NewTransaction(dep)
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
+	transaction.Process(context.TODO(), command)
+
+	command, _ = NewWatchCommand([]string{"watch", "{a}1", "{a}2"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errors.New("ERR WATCH inside MULTI is not allowed")}, result)
+	assert.Equal(t, TransactionStatusStarted, transaction.Status())
+	assert.Nil(t, transaction.tx)
+	assert.Equal(t, 0, len(transaction.watchedKeys))
+	assert.Equal(t, 1, len(transaction.commands))
+
+	command, _ = NewSetCommand([]string{"set", "{a}2", "100"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(
+		t,
+		RESPData{
+			DataType: ArrayRespType,
+			Value: []RESPData{
+				{DataType: SimpleStringRespType, Value: "OK"},
+				{DataType: SimpleStringRespType, Value: "OK"},
+			}},
+		result)
+	assert.True(t, transaction.IsClosed())
+}
+
 // test commands:
 // exec
 func TestExecWithoutMulti(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	command, _ := NewExecCommand([]string{"exec"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, ErrorRespType, result.DataType)
 	assert.Equal(t, TransactionStatusInited, transaction.Status())
 }
@@ -150,10 +298,10 @@ func TestExecWithoutMultiAfterWatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewExecCommand([]string{"exec"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, ErrorRespType, result.DataType)
 	assert.Equal(t, TransactionStatusInited, transaction.Status())
 	assert.Equal(t, 2, len(transaction.watchedKeys))
@@ -161,6 +309,77 @@ func TestExecWithoutMultiAfterWatch(t *testing.T) {
 	testCloseTransaction(t, transaction)
 }
 
+// test commands:
+// set {a}1 10
+// multi
+// type {a}1
+// exec
+func TestExecWithTypeCommand(t *testing.T) {
+	dep := base.GetServerDependency()
+	key := "{a}1"
+	defer testEmptyKeysInRedis(key)
+	assert.Nil(t, dep.Redis.Set(contextTODO, key, "10", 0).Err())
+
+	transaction := NewTransaction(dep)
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+
+	command, _ = NewTypeCommand([]string{"type", key})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
+	assert.Equal(t, []string{key}, transaction.keys)
+
+	command, _ = NewExecCommand([]string{"exec"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(
+		t,
+		RESPData{
+			DataType: ArrayRespType,
+			Value:    []RESPData{{DataType: SimpleStringRespType, Value: "string"}}},
+		result)
+}
+
+// test commands:
+// multi
+// set {a}1 10
+// get {a}1
+// exec dryrun
+// set {b}1 10
+// exec dryrun
+func TestExecDryRun(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	transaction.Process(context.TODO(), command)
+
+	command, _ = NewExecCommand([]string{"exec", "dryrun"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(
+		t,
+		RESPData{
+			DataType: ArrayRespType,
+			Value: []RESPData{
+				{DataType: BulkStringRespType, Value: "set"},
+				{DataType: BulkStringRespType, Value: "get"},
+			}},
+		result)
+	assert.Equal(t, TransactionStatusStarted, transaction.Status())
+	assert.Nil(t, transaction.tx)
+
+	command, _ = NewSetCommand([]string{"set", "{b}1", "10"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec", "dryrun"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxKeysNotInSameSlot}, result)
+	assert.Equal(t, TransactionStatusStarted, transaction.Status())
+	assert.Equal(t, 3, len(transaction.commands))
+	testCloseTransaction(t, transaction)
+}
+
 // test commands:
 // watch {a}1 {a}2
 // multi
@@ -174,31 +393,31 @@ func TestExec(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewSetCommand([]string{"set", "{a}2", "100"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewGetCommand([]string{"get", "{a}2"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	assert.Equal(t, TransactionStatusStarted, transaction.Status())
 
 	command, _ = NewExecCommand([]string{"exec"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	expectedResult := RESPData{
 		DataType: ArrayRespType,
 		Value: []RESPData{
@@ -213,6 +432,92 @@ func TestExec(t *testing.T) {
 	testEmptyKeysInRedis("{a}1", "{a}2")
 }
 
+// test commands:
+// multi
+// exec
+func TestExecWithoutCommands(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ArrayRespType, Value: []RESPData{}}, result)
+	assert.True(t, transaction.IsClosed())
+	assert.Nil(t, transaction.tx)
+}
+
+// test commands:
+// watch {a}1
+// multi
+// set {b}1 1
+// set {b}2 2
+// set {c}1 1
+func TestTransactionSlot(t *testing.T) {
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	slot, err := transaction.Slot()
+	assert.Nil(t, err)
+	assert.Equal(t, -1, slot)
+
+	command, _ := NewWatchCommand([]string{"watch", "{a}1"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	slot, err = transaction.Slot()
+	assert.Nil(t, err)
+	assert.Equal(t, keySlot("{a}1"), slot)
+
+	// queued keys decide the slot
+	command, _ = NewSetCommand([]string{"set", "{b}1", "1"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{b}2", "2"})
+	transaction.Process(context.TODO(), command)
+	slot, err = transaction.Slot()
+	assert.Nil(t, err)
+	assert.Equal(t, keySlot("{b}1"), slot)
+
+	command, _ = NewSetCommand([]string{"set", "{c}1", "1"})
+	transaction.Process(context.TODO(), command)
+	_, err = transaction.Slot()
+	assert.Equal(t, errTxKeysNotInSameSlot, err)
+	assert.True(t, transaction.IsStarted())
+	testCloseTransaction(t, transaction)
+}
+
+// test commands:
+// watch {a}1
+// multi
+// exec
+func TestExecWithoutCommandsAfterWatch(t *testing.T) {
+	dep := base.GetServerDependency()
+	defer testEmptyKeysInRedis("{a}1")
+
+	transaction := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "{a}1"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ArrayRespType, Value: []RESPData{}}, result)
+	assert.True(t, transaction.IsClosed())
+
+	// watched key is modified by another client
+	transaction = NewTransaction(dep)
+	command, _ = NewWatchCommand([]string{"watch", "{a}1"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "a"})
+	ExecuteCommand(context.TODO(), dep.Redis, command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, NilArrayRespType, result.DataType)
+	assert.True(t, errors.Is(result.Value.(error), redis.TxFailedErr))
+	assert.True(t, transaction.IsClosed())
+}
+
 // test commands:
 // tx1: multi
 // tx2: multi
@@ -286,12 +591,12 @@ func TestConcurrentTransactionSuccess(t *testing.T) {
 
 func testExecuteTransaction(tx *Transaction, result chan<- RESPData, commands ...Commander) {
 	command, _ := NewMultiCommand([]string{"multi"})
-	tx.Process(command)
+	tx.Process(context.TODO(), command)
 	for _, command := range commands {
-		tx.Process(command)
+		tx.Process(context.TODO(), command)
 	}
 	command, _ = NewExecCommand([]string{"exec"})
-	result <- tx.Process(command)
+	result <- tx.Process(context.TODO(), command)
 }
 
 // test commands:
@@ -306,35 +611,101 @@ func TestTransactionFail(t *testing.T) {
 	tx1 := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 
 	tx2 := NewTransaction(dep)
 	command, _ = NewSetCommand([]string{"set", "{a}1", "a"})
-	tx2.Process(command)
+	tx2.Process(context.TODO(), command)
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewSetCommand([]string{"set", "{a}2", "b"})
-	result := tx1.Process(command)
+	result := tx1.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewExecCommand([]string{"exec"})
-	result = tx1.Process(command)
+	result = tx1.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: redis.TxFailedErr}, result)
 	assert.True(t, tx1.IsClosed())
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "a"}, result)
 
 	command, _ = NewGetCommand([]string{"get", "{a}2"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, result)
 
 	testCloseTransaction(t, tx1, tx2)
 	testEmptyKeysInRedis("{a}1")
 }
 
+// test commands:
+// tx1: watch {a}1
+// tx1: multi
+// tx1: discard
+// tx2: set {a}1 a
+// tx1: multi
+// tx1: set {a}1 b
+// tx1: exec
+func TestTransactionDiscardUnwatch(t *testing.T) {
+	dep := base.GetServerDependency()
+	tx1 := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "{a}1"})
+	tx1.Process(context.TODO(), command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	tx1.Process(context.TODO(), command)
+	hook := &unwatchCountHook{}
+	tx1.tx.AddHook(hook)
+	command, _ = NewDiscardCommand([]string{"discard"})
+	result := tx1.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	// closing tx sends exactly one unwatch
+	assert.Equal(t, 1, hook.count)
+
+	tx2 := NewTransaction(dep)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "a"})
+	tx2.Process(context.TODO(), command)
+
+	// key changed after discard does not abort next transaction
+	resultChan := make(chan RESPData, 1)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "b"})
+	testExecuteTransaction(tx1, resultChan, command)
+	result = <-resultChan
+	assert.Equal(t, RESPData{DataType: ArrayRespType, Value: []RESPData{{DataType: SimpleStringRespType, Value: "OK"}}}, result)
+
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "b"}, result)
+
+	testCloseTransaction(t, tx1, tx2)
+	testEmptyKeysInRedis("{a}1")
+}
+
+// unwatchCountHook counts unwatch commands sent by tx.
+type unwatchCountHook struct {
+	count int
+}
+
+func (hook *unwatchCountHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "unwatch" {
+		hook.count++
+	}
+	return ctx, nil
+}
+
+func (hook *unwatchCountHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (hook *unwatchCountHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (hook *unwatchCountHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
 // test commands:
 // watch {a}1 {a}2
 // set {a}1 10
@@ -346,28 +717,28 @@ func TestTransactionFailSameClient(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 	command, _ = NewSetCommand([]string{"set", "{a}2", "100"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
 
 	command, _ = NewExecCommand([]string{"exec"})
-	result = transaction.Process(command)
+	result = transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: redis.TxFailedErr}, result)
 	assert.True(t, transaction.IsClosed())
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "10"}, result)
 
 	command, _ = NewGetCommand([]string{"get", "{a}2"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, result)
 
 	testCloseTransaction(t, transaction)
@@ -384,13 +755,13 @@ func TestDiscard(t *testing.T) {
 	dep := base.GetServerDependency()
 	transaction := NewTransaction(dep)
 	command, _ := NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "x"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewDiscardCommand([]string{"discard"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 
 	assert.True(t, transaction.IsClosed())
@@ -400,11 +771,61 @@ func TestDiscard(t *testing.T) {
 	assert.Nil(t, transaction.tx)
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, result)
 
 }
 
+// test commands:
+// watch {a}1 {a}2
+// multi
+// set {a}1 x
+// reset
+// reset
+// multi
+// exec
+// reset
+func TestResetCommand(t *testing.T) {
+	_, err := NewResetCommand([]string{"reset", "a"})
+	assert.NotNil(t, err)
+
+	dep := base.GetServerDependency()
+	transaction := NewTransaction(dep)
+	command, _ := NewWatchCommand([]string{"watch", "{a}1", "{a}2"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "x"})
+	transaction.Process(context.TODO(), command)
+
+	reset, _ := NewResetCommand([]string{"reset"})
+	result := transaction.Process(context.TODO(), reset)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "RESET"}, result)
+	assert.True(t, transaction.IsClosed())
+	assert.Equal(t, 0, len(transaction.watchedKeys))
+	assert.Equal(t, 0, len(transaction.commands))
+	assert.Nil(t, transaction.tx)
+	assert.Equal(t, int64(0), transaction.memoryBytes)
+
+	// reset of closed transaction
+	result = transaction.Process(context.TODO(), reset)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "RESET"}, result)
+
+	// reset after exec
+	transaction = NewTransaction(dep)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewExecCommand([]string{"exec"})
+	transaction.Process(context.TODO(), command)
+	assert.True(t, transaction.IsClosed())
+	result = transaction.Process(context.TODO(), reset)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "RESET"}, result)
+
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
+	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, result)
+}
+
 // test commands:
 // watch {a}1 {a}2
 // multi
@@ -415,17 +836,17 @@ func TestDiscardWithWatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 	assert.Equal(t, 2, len(watchedKeys))
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "x"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewDiscardCommand([]string{"discard"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 
 	assert.True(t, transaction.IsClosed())
@@ -435,7 +856,7 @@ func TestDiscardWithWatch(t *testing.T) {
 	assert.Nil(t, transaction.tx)
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: NilRespType, Value: nil}, result)
 }
 
@@ -445,7 +866,7 @@ func TestDiscardWithWatch(t *testing.T) {
 func TestDiscardWithoutMulti(t *testing.T) {
 	dep := base.GetServerDependency()
 	command, _ := NewDiscardCommand([]string{"discard"})
-	result := ExecuteCommand(dep.Redis, command)
+	result := ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, ErrorRespType, result.DataType)
 }
 
@@ -457,10 +878,10 @@ func TestDiscardWithoutMultiAfterWatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewDiscardCommand([]string{"discard"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 
 	assert.Equal(t, ErrorRespType, result.DataType)
 	assert.Equal(t, 2, len(transaction.watchedKeys))
@@ -484,21 +905,21 @@ func TestDiscardUnwatchSuccess(t *testing.T) {
 
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
-	tx2.Process(command)
+	tx2.Process(context.TODO(), command)
 
 	command, _ = NewMultiCommand([]string{"multi"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewDiscardCommand([]string{"discard"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewMultiCommand([]string{"multi"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewSetCommand([]string{"set", "{a}1", "100"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewExecCommand([]string{"exec"})
-	result := tx1.Process(command)
+	result := tx1.Process(context.TODO(), command)
 	assert.Equal(
 		t,
 		RESPData{
@@ -509,7 +930,7 @@ func TestDiscardUnwatchSuccess(t *testing.T) {
 	)
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "100"}, result)
 	testCloseTransaction(t, tx1, tx2)
 	testEmptyKeysInRedis("{a}1")
@@ -523,10 +944,10 @@ func TestUnwatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewUnwatchCommand([]string{"unwatch"})
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 
 	assert.True(t, transaction.IsClosed())
@@ -544,14 +965,14 @@ func TestWatchAfterUnwatch(t *testing.T) {
 	transaction := NewTransaction(dep)
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	command, _ = NewUnwatchCommand([]string{"unwatch"})
-	transaction.Process(command)
+	transaction.Process(context.TODO(), command)
 
 	watchedKeys2 := []string{"{b}1", "{b}2"}
 	command, _ = NewWatchCommand(append([]string{"watch"}, watchedKeys2...))
-	result := transaction.Process(command)
+	result := transaction.Process(context.TODO(), command)
 
 	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
 	assert.Equal(t, TransactionStatusInited, transaction.Status())
@@ -574,19 +995,19 @@ func TestExecAfterUnWatch(t *testing.T) {
 
 	watchedKeys := []string{"{a}1", "{a}2"}
 	command, _ := NewWatchCommand(append([]string{"watch"}, watchedKeys...))
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 
 	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
-	tx2.Process(command)
+	tx2.Process(context.TODO(), command)
 
 	command, _ = NewUnwatchCommand([]string{"unwatch"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewMultiCommand([]string{"multi"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewSetCommand([]string{"set", "{a}1", "100"})
-	tx1.Process(command)
+	tx1.Process(context.TODO(), command)
 	command, _ = NewExecCommand([]string{"exec"})
-	result := tx1.Process(command)
+	result := tx1.Process(context.TODO(), command)
 	assert.Equal(
 		t,
 		RESPData{
@@ -597,8 +1018,188 @@ func TestExecAfterUnWatch(t *testing.T) {
 	)
 
 	command, _ = NewGetCommand([]string{"get", "{a}1"})
-	result = ExecuteCommand(dep.Redis, command)
+	result = ExecuteCommand(context.TODO(), dep.Redis, command)
 	assert.Equal(t, RESPData{DataType: BulkStringRespType, Value: "100"}, result)
 	testCloseTransaction(t, tx1, tx2)
 	testEmptyKeysInRedis("{a}1")
 }
+
+func TestKeysSlot(t *testing.T) {
+	slot := newKeysSlot()
+	assert.True(t, slot.inSameSlot())
+	assert.True(t, slot.inSameSlotWith(newKeysSlot("{b}1")))
+
+	slot.add("{a}1", "{a}2")
+	assert.True(t, slot.inSameSlot())
+	assert.True(t, slot.inSameSlotWith(newKeysSlot("{a}3")))
+	assert.False(t, slot.inSameSlotWith(newKeysSlot("{b}1")))
+	assert.False(t, slot.inSameSlotWith(newKeysSlot("{a}3", "{b}1")))
+
+	slot.add("{b}1")
+	assert.False(t, slot.inSameSlot())
+	slot.add("{a}3")
+	assert.False(t, slot.inSameSlot())
+	assert.False(t, slot.inSameSlotWith(newKeysSlot()))
+}
+
+func TestTransactionError(t *testing.T) {
+	var txErr *TransactionError
+
+	result := ConvertErrorToRESPData(convertTransactionExecError(redis.TxFailedErr))
+	assert.Equal(t, NilArrayRespType, result.DataType)
+	assert.True(t, errors.As(result.Value.(error), &txErr))
+	assert.Equal(t, TransactionErrorCodeWatchFailed, txErr.Code)
+	assert.True(t, errors.Is(result.Value.(error), redis.TxFailedErr))
+
+	abortErr := errors.New("EXECABORT Transaction discarded because of previous errors.")
+	result = ConvertErrorToRESPData(convertTransactionExecError(abortErr))
+	assert.Equal(t, ErrorRespType, result.DataType)
+	assert.True(t, errors.As(result.Value.(error), &txErr))
+	assert.Equal(t, TransactionErrorCodeExecAbort, txErr.Code)
+	assert.Equal(t, abortErr.Error(), txErr.Error())
+
+	result = ConvertErrorToRESPData(errTxKeysNotInSameSlot)
+	assert.True(t, errors.As(result.Value.(error), &txErr))
+	assert.Equal(t, TransactionErrorCodeCrossSlot, txErr.Code)
+	assert.Equal(t, "ERR keys in transaction should be in the same slot", txErr.Error())
+
+	result = ConvertErrorToRESPData(convertTransactionExecError(context.DeadlineExceeded))
+	assert.Equal(t, ErrorRespType, result.DataType)
+	assert.True(t, errors.As(result.Value.(error), &txErr))
+	assert.Equal(t, TransactionErrorCodeExecTimeout, txErr.Code)
+	assert.True(t, errors.Is(result.Value.(error), context.DeadlineExceeded))
+
+	otherErr := errors.New("ERR other")
+	assert.Equal(t, otherErr, convertTransactionExecError(otherErr))
+}
+
+func TestNewExecContext(t *testing.T) {
+	defer SetExecTimeout(0)
+
+	ctx, cancel := newExecContext(context.TODO())
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+	cancel()
+
+	SetExecTimeout(time.Millisecond)
+	ctx, cancel = newExecContext(context.TODO())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.True(t, ok)
+	<-ctx.Done()
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+func TestTransactionState(t *testing.T) {
+	dep := base.GetServerDependency()
+	defer testEmptyKeysInRedis("{a}1", "{a}2")
+	transaction := NewTransaction(dep)
+	assert.Equal(t, TransactionState{WatchedKeys: []string{}}, transaction.State())
+
+	command, _ := NewWatchCommand([]string{"watch", "{a}1", "{a}2"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "10"})
+	transaction.Process(context.TODO(), command)
+
+	done := make(chan TransactionState)
+	go func() {
+		done <- transaction.State()
+	}()
+	state := <-done
+	assert.Equal(t, TransactionState{InMulti: true, QueuedCommandCount: 1, WatchedKeys: []string{"{a}1", "{a}2"}}, state)
+
+	command, _ = NewExecCommand([]string{"exec"})
+	transaction.Process(context.TODO(), command)
+	assert.Equal(t, TransactionState{WatchedKeys: []string{}}, transaction.State())
+}
+
+func TestKeySlot(t *testing.T) {
+	testCases := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"{}foo", 9500},
+		{"foo{}", 5542},
+		{"foo{}{bar}", 8363},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.slot, keySlot(testCase.key), testCase.key)
+	}
+	assert.Equal(t, keySlot("bar"), keySlot("foo{bar}"))
+	assert.Equal(t, keySlot("{user1000}.following"), keySlot("{user1000}.followers"))
+	assert.Equal(t, -1, newKeysSlot().slot())
+	assert.Equal(t, keySlot("{a}1"), newKeysSlot("{a}1", "{a}2").slot())
+}
+
+func TestTransactionMemoryLimit(t *testing.T) {
+	usage := GetTransactionMemoryUsage()
+	defer SetTransactionMemoryLimit(0)
+	SetTransactionMemoryLimit(usage + 20)
+
+	transaction := NewTransaction(base.GetServerDependency())
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "0123456789"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
+	assert.Equal(t, usage+17, GetTransactionMemoryUsage())
+
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTransactionMemoryLimitExceeded}, result)
+	assert.Equal(t, usage+17, GetTransactionMemoryUsage())
+
+	// memory is released when transaction is closed
+	command, _ = NewDiscardCommand([]string{"discard"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "OK"}, result)
+	assert.Equal(t, usage, GetTransactionMemoryUsage())
+}
+
+// test commands:
+// multi
+// set {a}1 1 (not allowed)
+// get {a}1
+// exec
+func TestExecAbortAfterQueueError(t *testing.T) {
+	defer SetAllowedCommands(nil)
+	assert.Nil(t, SetAllowedCommands([]string{"get"}))
+	dep := base.GetServerDependency()
+
+	transaction := NewTransaction(dep)
+	command, _ := NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	command, _ = NewSetCommand([]string{"set", "{a}1", "1"})
+	result := transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: newCommandNotAllowedError("set")}, result)
+	command, _ = NewGetCommand([]string{"get", "{a}1"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: SimpleStringRespType, Value: "QUEUED"}, result)
+
+	command, _ = NewExecCommand([]string{"exec", "dryrun"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxExecAbortDirty}, result)
+
+	command, _ = NewExecCommand([]string{"exec"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxExecAbortDirty}, result)
+	var txErr *TransactionError
+	assert.True(t, errors.As(result.Value.(error), &txErr))
+	assert.Equal(t, TransactionErrorCodeExecAbort, txErr.Code)
+	assert.True(t, transaction.IsClosed())
+	assert.False(t, transaction.dirty)
+
+	// command failed to be parsed in MULTI
+	transaction = NewTransaction(dep)
+	transaction.MarkDirty()
+	assert.False(t, transaction.dirty)
+	command, _ = NewMultiCommand([]string{"multi"})
+	transaction.Process(context.TODO(), command)
+	transaction.MarkDirty()
+	command, _ = NewExecCommand([]string{"exec"})
+	result = transaction.Process(context.TODO(), command)
+	assert.Equal(t, RESPData{DataType: ErrorRespType, Value: errTxExecAbortDirty}, result)
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bytepower_room/base"
+	"bytepower_room/base/log"
+
+	"go.uber.org/ratelimit"
+)
+
+// results of at most maxAckFailures failed hash tags are posted
+const maxAckFailures = 10
+
+var (
+	errAckURLInvalid       = errors.New("ack url should be an absolute http or https url")
+	errAckHostNotAllowed   = errors.New("host of ack url is not allowed")
+	errAckAddressForbidden = errors.New("ack can not be posted to private, loopback or link-local address")
+	errAckPendingFull      = errors.New("too many pending acks, retry later")
+)
+
+// AckResult is posted to ack url of a request after events of the request are saved to db or timeout,
+// counts except accepted count are of hash tags, since events of the same hash tag are merged.
+type AckResult struct {
+	url string
+
+	AcceptedCount    int          `json:"accepted_count"`
+	SavedTagCount    int          `json:"saved_tag_count"`
+	FailedTagCount   int          `json:"failed_tag_count"`
+	TimedOutTagCount int          `json:"timed_out_tag_count"`
+	Failures         []AckFailure `json:"failures,omitempty"`
+}
+
+type AckFailure struct {
+	HashTag string `json:"hash_tag"`
+	Error   string `json:"error"`
+}
+
+type pendingAck struct {
+	result AckResult
+	// the latest access time of events of every hash tag not saved yet,
+	// a saved event with a later access time has the event merged.
+	waiting  map[string]time.Time
+	expireAt time.Time
+}
+
+// ackTracker keeps acks of requests until all their hash tags are saved or failed. Nil tracker keeps nothing.
+type ackTracker struct {
+	maxPending int
+	timeout    time.Duration
+
+	mutex   sync.Mutex
+	nextID  int64
+	acks    map[int64]*pendingAck
+	tagAcks map[string]map[int64]bool
+}
+
+func newAckTracker(maxPending int, timeout time.Duration) *ackTracker {
+	return &ackTracker{
+		maxPending: maxPending,
+		timeout:    timeout,
+		acks:       make(map[int64]*pendingAck),
+		tagAcks:    make(map[string]map[int64]bool),
+	}
+}
+
+func (tracker *ackTracker) add(ackURL string, events []base.HashTagEvent, t time.Time) (int64, error) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if len(tracker.acks) >= tracker.maxPending {
+		return 0, errAckPendingFull
+	}
+	ack := &pendingAck{
+		result:   AckResult{url: ackURL, AcceptedCount: len(events)},
+		waiting:  make(map[string]time.Time),
+		expireAt: t.Add(tracker.timeout),
+	}
+	for _, event := range events {
+		if accessTime, ok := ack.waiting[event.HashTag]; !ok || event.AccessTime.After(accessTime) {
+			ack.waiting[event.HashTag] = event.AccessTime
+		}
+	}
+	tracker.nextID++
+	id := tracker.nextID
+	tracker.acks[id] = ack
+	for hashTag := range ack.waiting {
+		if tracker.tagAcks[hashTag] == nil {
+			tracker.tagAcks[hashTag] = make(map[int64]bool)
+		}
+		tracker.tagAcks[hashTag][id] = true
+	}
+	return id, nil
+}
+
+// remove drops ack without result, e.g. events of request are not accepted.
+func (tracker *ackTracker) remove(id int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.removeAck(id)
+}
+
+func (tracker *ackTracker) removeAck(id int64) {
+	ack, ok := tracker.acks[id]
+	if !ok {
+		return
+	}
+	for hashTag := range ack.waiting {
+		delete(tracker.tagAcks[hashTag], id)
+		if len(tracker.tagAcks[hashTag]) == 0 {
+			delete(tracker.tagAcks, hashTag)
+		}
+	}
+	delete(tracker.acks, id)
+}
+
+// resolve marks hash tag of event saved, or failed if err is not nil, and returns completed results.
+func (tracker *ackTracker) resolve(event base.HashTagEvent, err error) []AckResult {
+	if tracker == nil {
+		return nil
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var results []AckResult
+	for id := range tracker.tagAcks[event.HashTag] {
+		ack := tracker.acks[id]
+		if event.AccessTime.Before(ack.waiting[event.HashTag]) {
+			continue
+		}
+		delete(ack.waiting, event.HashTag)
+		delete(tracker.tagAcks[event.HashTag], id)
+		if err == nil {
+			ack.result.SavedTagCount++
+		} else {
+			ack.result.FailedTagCount++
+			if len(ack.result.Failures) < maxAckFailures {
+				ack.result.Failures = append(ack.result.Failures, AckFailure{HashTag: event.HashTag, Error: err.Error()})
+			}
+		}
+		if len(ack.waiting) == 0 {
+			results = append(results, ack.result)
+			delete(tracker.acks, id)
+		}
+	}
+	if len(tracker.tagAcks[event.HashTag]) == 0 {
+		delete(tracker.tagAcks, event.HashTag)
+	}
+	return results
+}
+
+// expire returns results of acks timed out, hash tags not saved yet are counted as timed out.
+func (tracker *ackTracker) expire(t time.Time) []AckResult {
+	if tracker == nil {
+		return nil
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var results []AckResult
+	for id, ack := range tracker.acks {
+		if t.Before(ack.expireAt) {
+			continue
+		}
+		ack.result.TimedOutTagCount = len(ack.waiting)
+		results = append(results, ack.result)
+		tracker.removeAck(id)
+	}
+	return results
+}
+
+func (tracker *ackTracker) pendingCount() int {
+	if tracker == nil {
+		return 0
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return len(tracker.acks)
+}
+
+func checkAckURL(ackURL string, allowedHosts []string) error {
+	u, err := url.Parse(ackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w, url is %s", errAckURLInvalid, ackURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil && isForbiddenAckIP(ip) {
+		return fmt.Errorf("%w, url is %s", errAckAddressForbidden, ackURL)
+	}
+	if !isAllowedAckHost(host, allowedHosts) {
+		return fmt.Errorf("%w, url is %s", errAckHostNotAllowed, ackURL)
+	}
+	return nil
+}
+
+// isAllowedAckHost matches host against allowed hosts, "*.example.com" matches subdomains of example.com.
+func isAllowedAckHost(host string, allowedHosts []string) bool {
+	for _, allowedHost := range allowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if strings.HasPrefix(allowedHost, "*.") {
+			if strings.HasSuffix(host, allowedHost[1:]) {
+				return true
+			}
+		} else if host == allowedHost {
+			return true
+		}
+	}
+	return false
+}
+
+// private networks of RFC 1918 and RFC 4193
+var privateAckNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isForbiddenAckIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateAckNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAckClient checks address resolved when connecting, so allowed host resolved to forbidden address is not posted to.
+func newAckClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isForbiddenAckIP(ip) {
+				return fmt.Errorf("%w, address is %s", errAckAddressForbidden, address)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// redirect may point to forbidden host
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// resolveAcks is called after event is saved to db or failed.
+func (service *CollectEventService) resolveAcks(event base.HashTagEvent, err error) {
+	for _, result := range service.ackTracker.resolve(event, err) {
+		service.enqueueAckResult(result)
+	}
+}
+
+func (service *CollectEventService) enqueueAckResult(result AckResult) {
+	select {
+	case service.ackResults <- result:
+	default:
+		service.metric.MetricIncrease("ack.discard")
+	}
+}
+
+// sendAcks posts results of acks in rate limit, and expires acks not completed in timeout.
+func (service *CollectEventService) sendAcks() {
+	jobName := "send acks"
+	ticker := time.NewTicker(time.Second)
+	defer func() {
+		service.logger.Info(
+			fmt.Sprintf("stop %s", jobName),
+			log.String("time", time.Now().String()),
+		)
+		ticker.Stop()
+		service.wg.Done()
+	}()
+	service.logger.Info(
+		fmt.Sprintf("start %s", jobName),
+		log.String("time", time.Now().String()),
+	)
+	config := service.config.Ack
+	client := newAckClient(time.Duration(config.RequestTimeoutMS) * time.Millisecond)
+	ratelimitBucket := ratelimit.New(config.RateLimitPerSecond)
+	var budget *retryBudget
+	if config.RetryBudgetPerSecond > 0 {
+		budget = newRetryBudget(config.RetryBudgetPerSecond)
+	}
+	for {
+		select {
+		case result := <-service.ackResults:
+			ratelimitBucket.Take()
+			service.sendAck(client, budget, result)
+		case t := <-ticker.C:
+			for _, result := range service.ackTracker.expire(t) {
+				service.metric.MetricIncrease("ack.timeout")
+				service.enqueueAckResult(result)
+			}
+			service.recordGaugeMetric(metricAckPendingCount, int64(service.ackTracker.pendingCount()))
+		case <-service.stopCh:
+			return
+		}
+	}
+}
+
+func (service *CollectEventService) sendAck(client *http.Client, budget *retryBudget, result AckResult) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		service.recordError("ack.marshal", err, nil)
+		return
+	}
+	for i := 0; i < service.config.Ack.RetryTimes; i++ {
+		if i > 0 {
+			if budget != nil && !budget.allow(time.Now()) {
+				service.metric.MetricIncrease("ack.retry_budget_exhausted")
+				break
+			}
+			service.metric.MetricIncrease("ack.retry")
+			if !service.waitAckRetry(time.Duration(service.config.Ack.RetryIntervalMS) * time.Millisecond) {
+				service.metric.MetricIncrease("ack.stopped")
+				return
+			}
+		}
+		if err = postAck(client, result.url, body); err == nil {
+			service.metric.MetricIncrease("ack.sent")
+			return
+		}
+	}
+	service.recordError("ack.send", err, map[string]string{"url": result.url})
+}
+
+// waitAckRetry waits interval before retry, false is returned if service is stopping.
+func (service *CollectEventService) waitAckRetry(interval time.Duration) bool {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-service.stopCh:
+		return false
+	}
+}
+
+func postAck(client *http.Client, ackURL string, body []byte) error {
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set(HTTPHeaderContentType, base.HTTPContentTypeJSON)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("ack response status code is %d", response.StatusCode)
+	}
+	return nil
+}
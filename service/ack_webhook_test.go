@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+	"bytepower_room/utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckTracker(t *testing.T) {
+	tracker := newAckTracker(2, time.Minute)
+	now := time.Now()
+	event := func(hashTag string, accessTime time.Time) base.HashTagEvent {
+		return base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: accessTime}
+	}
+
+	_, err := tracker.add("http://a", []base.HashTagEvent{event("a", now), event("b", now), event("a", now.Add(time.Second))}, now)
+	assert.Nil(t, err)
+	id, err := tracker.add("http://b", []base.HashTagEvent{event("a", now)}, now)
+	assert.Nil(t, err)
+	_, err = tracker.add("http://c", []base.HashTagEvent{event("c", now)}, now)
+	assert.Equal(t, errAckPendingFull, err)
+
+	// saved event earlier than events of request does not resolve it
+	assert.Equal(t, 0, len(tracker.resolve(event("a", now.Add(-time.Second)), nil)))
+	results := tracker.resolve(event("a", now), nil)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "http://b", results[0].url)
+	assert.Equal(t, 1, results[0].SavedTagCount)
+
+	assert.Equal(t, 0, len(tracker.resolve(event("a", now.Add(time.Second)), nil)))
+	results = tracker.resolve(event("b", now), errors.New("save error"))
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, AckResult{
+		url: "http://a", AcceptedCount: 3, SavedTagCount: 1, FailedTagCount: 1,
+		Failures: []AckFailure{{HashTag: "b", Error: "save error"}},
+	}, results[0])
+	assert.Equal(t, 0, tracker.pendingCount())
+	assert.Equal(t, 0, len(tracker.tagAcks))
+
+	// timeout
+	_, err = tracker.add("http://a", []base.HashTagEvent{event("a", now), event("b", now)}, now)
+	assert.Nil(t, err)
+	tracker.resolve(event("a", now), nil)
+	assert.Equal(t, 0, len(tracker.expire(now)))
+	results = tracker.expire(now.Add(time.Minute))
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, 1, results[0].SavedTagCount)
+	assert.Equal(t, 1, results[0].TimedOutTagCount)
+	assert.Equal(t, 0, len(tracker.tagAcks))
+
+	id, _ = tracker.add("http://a", []base.HashTagEvent{event("a", now)}, now)
+	tracker.remove(id)
+	assert.Equal(t, 0, tracker.pendingCount())
+
+	var nilTracker *ackTracker
+	assert.Nil(t, nilTracker.resolve(event("a", now), nil))
+	assert.Nil(t, nilTracker.expire(now))
+}
+
+func TestSendAck(t *testing.T) {
+	requestCount := 0
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestCount++
+		if requestCount == 1 {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bs, _ := ioutil.ReadAll(request.Body)
+		body = string(bs)
+	}))
+	defer server.Close()
+
+	service := testNewCollectEventService()
+	service.config.Ack = base.CollectEventServiceAckConfig{RetryTimes: 2}
+	client := &http.Client{Timeout: time.Second}
+	service.sendAck(client, nil, AckResult{url: server.URL, AcceptedCount: 2, SavedTagCount: 1})
+	assert.Equal(t, 2, requestCount)
+	assert.Equal(t, `{"accepted_count":2,"saved_tag_count":1,"failed_tag_count":0,"timed_out_tag_count":0}`, body)
+
+	// retry is limited by budget
+	requestCount = 0
+	budget := newRetryBudget(1)
+	budget.allow(time.Now())
+	service.sendAck(client, budget, AckResult{url: server.URL})
+	assert.Equal(t, 1, requestCount)
+
+	// retry is not waited when service is stopping
+	requestCount = 0
+	service.config.Ack.RetryIntervalMS = int(time.Hour / time.Millisecond)
+	service.stopCh = make(chan bool)
+	close(service.stopCh)
+	service.sendAck(client, nil, AckResult{url: server.URL})
+	assert.Equal(t, 1, requestCount)
+
+	// ack client does not connect to loopback address
+	requestCount = 0
+	service.sendAck(newAckClient(time.Second), nil, AckResult{url: server.URL})
+	assert.Equal(t, 0, requestCount)
+}
+
+func TestCheckAckURL(t *testing.T) {
+	allowedHosts := []string{"ack.example.com", "*.example.org"}
+	assert.Nil(t, checkAckURL("https://ack.example.com/ack", allowedHosts))
+	assert.Nil(t, checkAckURL("http://ACK.example.com:8080/ack", allowedHosts))
+	assert.Nil(t, checkAckURL("https://a.b.example.org/ack", allowedHosts))
+
+	assert.True(t, errors.Is(checkAckURL("ftp://ack.example.com", allowedHosts), errAckURLInvalid))
+	assert.True(t, errors.Is(checkAckURL("/ack", allowedHosts), errAckURLInvalid))
+	assert.True(t, errors.Is(checkAckURL("https://example.com/ack", allowedHosts), errAckHostNotAllowed))
+	assert.True(t, errors.Is(checkAckURL("https://example.org/ack", allowedHosts), errAckHostNotAllowed))
+	assert.True(t, errors.Is(checkAckURL("https://ack.example.com.evil.com/ack", allowedHosts), errAckHostNotAllowed))
+	assert.True(t, errors.Is(checkAckURL("https://8.8.8.8/ack", allowedHosts), errAckHostNotAllowed))
+	for _, address := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "[::1]", "[fd00::1]", "[fe80::1]", "0.0.0.0"} {
+		assert.True(t, errors.Is(checkAckURL("http://"+address+"/ack", append(allowedHosts, strings.Trim(address, "[]"))), errAckAddressForbidden), address)
+	}
+}
+
+func TestPostEventsHandlerAckURL(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.ackTracker = newAckTracker(1, time.Minute)
+	service.config.Ack.AllowedHosts = []string{"example.com"}
+
+	post := func(ackURL string) int {
+		body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		request.Header.Set(HTTPHeaderAckURL, ackURL)
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, post("ftp://example.com"))
+	assert.Equal(t, http.StatusBadRequest, post("http://example.org/ack"))
+	assert.Equal(t, http.StatusBadRequest, post("http://127.0.0.1/ack"))
+	assert.Equal(t, http.StatusOK, post("http://example.com/ack"))
+	assert.Equal(t, 1, service.ackTracker.pendingCount())
+	assert.Equal(t, http.StatusTooManyRequests, post("http://example.com/ack"))
+	// ack url is optional
+	assert.Equal(t, http.StatusOK, post(""))
+}
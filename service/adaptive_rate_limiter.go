@@ -0,0 +1,60 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveRateLimiter implements ratelimit.Limiter, its rate is adjusted by observed latency in AIMD way:
+// rate is halved when latency exceeds target latency, and increased by 1 otherwise.
+type adaptiveRateLimiter struct {
+	mutex         sync.Mutex
+	limit         int
+	minLimit      int
+	maxLimit      int
+	targetLatency time.Duration
+	last          time.Time
+}
+
+func newAdaptiveRateLimiter(minLimit, maxLimit int, targetLatency time.Duration) *adaptiveRateLimiter {
+	return &adaptiveRateLimiter{
+		limit:         maxLimit,
+		minLimit:      minLimit,
+		maxLimit:      maxLimit,
+		targetLatency: targetLatency,
+	}
+}
+
+// Take blocks until next request is allowed with current rate.
+func (limiter *adaptiveRateLimiter) Take() time.Time {
+	limiter.mutex.Lock()
+	now := time.Now()
+	next := limiter.last.Add(time.Second / time.Duration(limiter.limit))
+	if now.Before(next) {
+		now = next
+	}
+	limiter.last = now
+	limiter.mutex.Unlock()
+
+	time.Sleep(time.Until(now))
+	return now
+}
+
+func (limiter *adaptiveRateLimiter) observe(latency time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if latency > limiter.targetLatency {
+		limiter.limit = limiter.limit / 2
+		if limiter.limit < limiter.minLimit {
+			limiter.limit = limiter.minLimit
+		}
+	} else if limiter.limit < limiter.maxLimit {
+		limiter.limit++
+	}
+}
+
+func (limiter *adaptiveRateLimiter) currentLimit() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.limit
+}
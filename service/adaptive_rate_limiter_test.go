@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveRateLimiter(t *testing.T) {
+	limiter := newAdaptiveRateLimiter(10, 100, 100*time.Millisecond)
+	assert.Equal(t, 100, limiter.currentLimit())
+
+	limiter.observe(50 * time.Millisecond)
+	assert.Equal(t, 100, limiter.currentLimit())
+
+	limiter.observe(200 * time.Millisecond)
+	assert.Equal(t, 50, limiter.currentLimit())
+	limiter.observe(200 * time.Millisecond)
+	limiter.observe(200 * time.Millisecond)
+	assert.Equal(t, 12, limiter.currentLimit())
+	limiter.observe(200 * time.Millisecond)
+	assert.Equal(t, 10, limiter.currentLimit())
+
+	limiter.observe(50 * time.Millisecond)
+	assert.Equal(t, 11, limiter.currentLimit())
+
+	// 11 requests per second
+	startTime := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Take()
+	}
+	assert.True(t, time.Since(startTime) >= 2*time.Second/11)
+}
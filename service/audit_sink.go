@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bytepower_room/base/log"
+)
+
+// AuditRecord is written to audit outputs for every accepted request.
+type AuditRecord struct {
+	Client string
+	// prefix of sha256 of bearer token, the token itself is never written
+	TokenID    string
+	EventCount int
+	AcceptedAt time.Time
+}
+
+// auditSink writes records to its own logger in a separate goroutine,
+// records are dropped instead of blocking requests when buffer is full. Nil sink writes nothing.
+type auditSink struct {
+	logger  *log.Logger
+	records chan AuditRecord
+}
+
+func newAuditSink(logger *log.Logger, bufferSize int) *auditSink {
+	return &auditSink{logger: logger, records: make(chan AuditRecord, bufferSize)}
+}
+
+// add returns false if record is dropped.
+func (sink *auditSink) add(record AuditRecord) bool {
+	if sink == nil {
+		return true
+	}
+	select {
+	case sink.records <- record:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sink *auditSink) write(record AuditRecord) {
+	sink.logger.Info(
+		"event_accepted",
+		log.String("client", record.Client),
+		log.String("token_id", record.TokenID),
+		log.Int("event_count", record.EventCount),
+		log.String("accepted_at", record.AcceptedAt.Format(time.RFC3339Nano)),
+	)
+}
+
+// auditTokenID is empty if request has no bearer token.
+func auditTokenID(request *http.Request) string {
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (service *CollectEventService) auditRequest(request *http.Request, eventCount int, t time.Time) {
+	if service.auditSink == nil {
+		return
+	}
+	record := AuditRecord{
+		Client:     service.clientAddress(request),
+		TokenID:    auditTokenID(request),
+		EventCount: eventCount,
+		AcceptedAt: t,
+	}
+	if !service.auditSink.add(record) {
+		service.metric.MetricIncrease("audit.dropped")
+	}
+}
+
+// writeAuditRecords writes records left in buffer before it stops,
+// server is stopped before stopCh is closed so no record is added after that.
+func (service *CollectEventService) writeAuditRecords() {
+	jobName := "write audit records"
+	defer func() {
+		service.logger.Info(
+			fmt.Sprintf("stop %s", jobName),
+			log.String("time", time.Now().String()),
+		)
+		service.wg.Done()
+	}()
+	service.logger.Info(
+		fmt.Sprintf("start %s", jobName),
+		log.String("time", time.Now().String()),
+	)
+	for {
+		select {
+		case record := <-service.auditSink.records:
+			service.auditSink.write(record)
+		case <-service.stopCh:
+			for {
+				select {
+				case record := <-service.auditSink.records:
+					service.auditSink.write(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
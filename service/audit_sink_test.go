@@ -0,0 +1,65 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditSink(t *testing.T) {
+	var sink *auditSink
+	assert.True(t, sink.add(AuditRecord{}))
+
+	sink = newAuditSink(base.GetServerDependency().Logger, 1)
+	assert.True(t, sink.add(AuditRecord{EventCount: 1}))
+	// records are dropped when buffer is full
+	assert.False(t, sink.add(AuditRecord{EventCount: 2}))
+	assert.Equal(t, 1, (<-sink.records).EventCount)
+}
+
+func TestAuditTokenID(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/events", nil)
+	assert.Equal(t, "", auditTokenID(request))
+	request.Header.Set("Authorization", "Bearer secret")
+	tokenID := auditTokenID(request)
+	assert.Equal(t, 16, len(tokenID))
+	assert.NotContains(t, tokenID, "secret")
+	request.Header.Set("Authorization", "Bearer other")
+	assert.NotEqual(t, tokenID, auditTokenID(request))
+}
+
+func TestPostEventsHandlerAudit(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.Server.TrustForwardedFor = true
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.auditSink = newAuditSink(service.logger, 10)
+
+	post := func(query string) int {
+		body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"},
+			{"hash_tag": "def", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/events"+query, strings.NewReader(body))
+		request.Header.Set(HTTPHeaderForwardedFor, "1.2.3.4")
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(""))
+	record := <-service.auditSink.records
+	assert.Equal(t, "1.2.3.4", record.Client)
+	assert.Equal(t, 2, record.EventCount)
+	assert.NotEqual(t, "", record.TokenID)
+	assert.WithinDuration(t, time.Now(), record.AcceptedAt, time.Minute)
+
+	// dry run is not audited since events are not accepted
+	assert.Equal(t, http.StatusOK, post("?dry_run=true"))
+	assert.Equal(t, 0, len(service.auditSink.records))
+}
@@ -0,0 +1,48 @@
+package service
+
+import "sync"
+
+// bufferedTagIndex counts events of every hash tag from they are added to they are saved to db,
+// a merged event is counted once. Nil index counts nothing.
+type bufferedTagIndex struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func newBufferedTagIndex() *bufferedTagIndex {
+	return &bufferedTagIndex{counts: make(map[string]int)}
+}
+
+func (index *bufferedTagIndex) add(hashTag string) {
+	if index == nil {
+		return
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.counts[hashTag]++
+}
+
+// remove ignores hash tags not in index, e.g. events in files left by last process.
+func (index *bufferedTagIndex) remove(hashTag string) {
+	if index == nil {
+		return
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if count, ok := index.counts[hashTag]; ok {
+		if count <= 1 {
+			delete(index.counts, hashTag)
+		} else {
+			index.counts[hashTag] = count - 1
+		}
+	}
+}
+
+func (index *bufferedTagIndex) contains(hashTag string) bool {
+	if index == nil {
+		return false
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	return index.counts[hashTag] > 0
+}
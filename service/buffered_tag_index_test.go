@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedTagIndex(t *testing.T) {
+	index := newBufferedTagIndex()
+	assert.False(t, index.contains("a"))
+
+	index.add("a")
+	index.add("a")
+	index.remove("a")
+	assert.True(t, index.contains("a"))
+	index.remove("a")
+	assert.False(t, index.contains("a"))
+
+	// hash tag not in index
+	index.remove("b")
+	assert.False(t, index.contains("b"))
+	index.add("b")
+	assert.True(t, index.contains("b"))
+
+	var nilIndex *bufferedTagIndex
+	nilIndex.add("a")
+	nilIndex.remove("a")
+	assert.False(t, nilIndex.contains("a"))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// clientRateLimiter keeps a token bucket for every client in LRU order,
+// buckets of least recently seen clients are evicted when there are more than maxClients.
+// Nil limiter allows everything.
+type clientRateLimiter struct {
+	ratePerSecond float64
+	burst         float64
+	maxClients    int
+
+	mutex    sync.Mutex
+	buckets  map[string]*list.Element
+	useOrder *list.List
+}
+
+type clientBucket struct {
+	client string
+	tokens float64
+	last   time.Time
+}
+
+func newClientRateLimiter(ratePerSecond float64, burst int, maxClients int) *clientRateLimiter {
+	return &clientRateLimiter{
+		ratePerSecond: ratePerSecond,
+		burst:         float64(burst),
+		maxClients:    maxClients,
+		buckets:       make(map[string]*list.Element),
+		useOrder:      list.New(),
+	}
+}
+
+// allow takes a token of client without blocking, false is returned if client exceeds the limit.
+// Evicted client starts again with a full bucket.
+func (limiter *clientRateLimiter) allow(client string, t time.Time) bool {
+	if limiter == nil {
+		return true
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	element, ok := limiter.buckets[client]
+	if ok {
+		limiter.useOrder.MoveToFront(element)
+	} else {
+		for limiter.useOrder.Len() >= limiter.maxClients {
+			bucket := limiter.useOrder.Remove(limiter.useOrder.Back()).(*clientBucket)
+			delete(limiter.buckets, bucket.client)
+		}
+		element = limiter.useOrder.PushFront(&clientBucket{client: client, tokens: limiter.burst, last: t})
+		limiter.buckets[client] = element
+	}
+	bucket := element.Value.(*clientBucket)
+	if t.After(bucket.last) {
+		bucket.tokens += t.Sub(bucket.last).Seconds() * limiter.ratePerSecond
+		if bucket.tokens > limiter.burst {
+			bucket.tokens = limiter.burst
+		}
+		bucket.last = t
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// retryAfterSeconds is the time for a token to be refilled, it is at least 1 second.
+func (limiter *clientRateLimiter) retryAfterSeconds() int {
+	seconds := int(1/limiter.ratePerSecond + 0.999999)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
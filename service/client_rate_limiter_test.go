@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	var limiter *clientRateLimiter
+	assert.True(t, limiter.allow("a", time.Now()))
+
+	now := time.Now()
+	limiter = newClientRateLimiter(2, 2, 2)
+	assert.True(t, limiter.allow("a", now))
+	assert.True(t, limiter.allow("a", now))
+	assert.False(t, limiter.allow("a", now))
+	// other clients are not affected
+	assert.True(t, limiter.allow("b", now))
+	// token is refilled at rate
+	assert.False(t, limiter.allow("a", now.Add(100*time.Millisecond)))
+	assert.True(t, limiter.allow("a", now.Add(500*time.Millisecond)))
+	assert.False(t, limiter.allow("a", now.Add(500*time.Millisecond)))
+	// tokens are not more than burst
+	assert.True(t, limiter.allow("a", now.Add(time.Hour)))
+	assert.True(t, limiter.allow("a", now.Add(time.Hour)))
+	assert.False(t, limiter.allow("a", now.Add(time.Hour)))
+
+	// b is least recently seen and evicted
+	assert.True(t, limiter.allow("c", now.Add(time.Hour)))
+	assert.Equal(t, 2, limiter.useOrder.Len())
+	_, ok := limiter.buckets["b"]
+	assert.False(t, ok)
+	_, ok = limiter.buckets["a"]
+	assert.True(t, ok)
+
+	assert.Equal(t, 1, limiter.retryAfterSeconds())
+	assert.Equal(t, 4, newClientRateLimiter(0.25, 1, 1).retryAfterSeconds())
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"bytepower_room/base/log"
+	"net/http"
+	"sync/atomic"
+)
+
+// CollectEventStats are counted since service starts or stats are reset.
+type CollectEventStats struct {
+	SavedEventCount          int64 `json:"saved_event_count"`
+	DroppedEventCount        int64 `json:"dropped_event_count"`
+	ErrorCount               int64 `json:"error_count"`
+	EventBufferHighWaterMark int64 `json:"event_buffer_high_water_mark"`
+}
+
+// collectEventStatsCounter is replaced as a whole when stats are reset,
+// so all counters are reset at the same time. Nil counter counts nothing.
+type collectEventStatsCounter struct {
+	savedEventCount          int64
+	droppedEventCount        int64
+	errorCount               int64
+	eventBufferHighWaterMark int64
+}
+
+func (counter *collectEventStatsCounter) addSavedEvent() {
+	if counter != nil {
+		atomic.AddInt64(&counter.savedEventCount, 1)
+	}
+}
+
+func (counter *collectEventStatsCounter) addDroppedEvent() {
+	if counter != nil {
+		atomic.AddInt64(&counter.droppedEventCount, 1)
+	}
+}
+
+func (counter *collectEventStatsCounter) addError() {
+	if counter != nil {
+		atomic.AddInt64(&counter.errorCount, 1)
+	}
+}
+
+func (counter *collectEventStatsCounter) updateEventBufferHighWaterMark(count int64) {
+	if counter == nil {
+		return
+	}
+	for {
+		mark := atomic.LoadInt64(&counter.eventBufferHighWaterMark)
+		if count <= mark || atomic.CompareAndSwapInt64(&counter.eventBufferHighWaterMark, mark, count) {
+			return
+		}
+	}
+}
+
+func (counter *collectEventStatsCounter) stats() CollectEventStats {
+	if counter == nil {
+		return CollectEventStats{}
+	}
+	return CollectEventStats{
+		SavedEventCount:          atomic.LoadInt64(&counter.savedEventCount),
+		DroppedEventCount:        atomic.LoadInt64(&counter.droppedEventCount),
+		ErrorCount:               atomic.LoadInt64(&counter.errorCount),
+		EventBufferHighWaterMark: atomic.LoadInt64(&counter.eventBufferHighWaterMark),
+	}
+}
+
+func (service *CollectEventService) statsCounter() *collectEventStatsCounter {
+	service.statsMutex.RLock()
+	defer service.statsMutex.RUnlock()
+	return service.currentStatsCounter
+}
+
+func (service *CollectEventService) GetStats() CollectEventStats {
+	return service.statsCounter().stats()
+}
+
+// ResetStats resets stats to zero and returns stats before reset, events in process are not affected.
+// Metrics sent to metric server are not reset.
+func (service *CollectEventService) ResetStats() CollectEventStats {
+	service.statsMutex.Lock()
+	counter := service.currentStatsCounter
+	service.currentStatsCounter = &collectEventStatsCounter{}
+	service.statsMutex.Unlock()
+	return counter.stats()
+}
+
+func (service *CollectEventService) resetStatsHandler(writer http.ResponseWriter, request *http.Request) {
+	if !service.checkAdminRequest(writer, request, http.MethodPost, "reset_stats") {
+		return
+	}
+	stats := service.ResetStats()
+	service.logger.Info("reset stats", log.Any("stats", stats))
+	if err := writeResponse(writer, http.StatusOK, stats); err != nil {
+		service.recordWriteResponseError(err, []byte{})
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"bytepower_room/base"
+)
+
+// limit of items returned by admin endpoints
+const (
+	defaultAdminLimit = 100
+	maxAdminLimit     = 1000
+)
+
+var (
+	errAdminTokenNotConfigured = errors.New("admin endpoints are disabled, server.admin_token is empty")
+	errAdminTokenInvalid       = errors.New("admin token is invalid")
+)
+
+// DebugBufferResponse has a sample of aggregated events not saved yet,
+// events in channel buffers can not be peeked, only their counts are returned.
+type DebugBufferResponse struct {
+	Events                       []base.HashTagEvent `json:"events"`
+	AggregatedEventCount         int                 `json:"aggregated_event_count"`
+	EventBufferCount             int64               `json:"event_buffer_count"`
+	HighPriorityEventBufferCount int64               `json:"high_priority_event_buffer_count"`
+	CollectedEventBufferCount    int64               `json:"collected_event_buffer_count"`
+}
+
+// PeekAggregatedEvents returns copies of at most limit aggregated events, events are not removed.
+func (service *CollectEventService) PeekAggregatedEvents(limit int) ([]base.HashTagEvent, int) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	events := make([]base.HashTagEvent, 0, limit)
+	for _, event := range service.events {
+		if len(events) >= limit {
+			break
+		}
+		events = append(events, event.Copy())
+	}
+	return events, len(service.events)
+}
+
+// checkAdminToken checks "Authorization: Bearer <server.admin_token>" header,
+// admin endpoints are disabled if admin token is empty.
+func (service *CollectEventService) checkAdminToken(request *http.Request) (int, error) {
+	token := service.config.Server.AdminToken
+	if token == "" {
+		return http.StatusForbidden, errAdminTokenNotConfigured
+	}
+	requestToken := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) != 1 {
+		return http.StatusUnauthorized, errAdminTokenInvalid
+	}
+	return http.StatusOK, nil
+}
+
+// checkAdminRequest checks method and admin token of request to admin endpoint,
+// error response is written and false is returned if request is rejected.
+func (service *CollectEventService) checkAdminRequest(writer http.ResponseWriter, request *http.Request, method, endpoint string) bool {
+	if request.Method != method {
+		err := fmt.Errorf("method %s is not allowed", request.Method)
+		service.recordError("method_not_allowed", err, nil)
+		if err = writeErrorResponse(writer, http.StatusMethodNotAllowed, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return false
+	}
+	if code, err := service.checkAdminToken(request); err != nil {
+		service.recordRequestError(request, endpoint+".auth", err, nil)
+		if err = writeErrorResponse(writer, code, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return false
+	}
+	return true
+}
+
+// parseAdminLimit parses limit query of admin endpoint, defaultAdminLimit is returned if it is absent.
+func parseAdminLimit(request *http.Request) (int, error) {
+	rawLimit := request.URL.Query().Get("limit")
+	if rawLimit == "" {
+		return defaultAdminLimit, nil
+	}
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit <= 0 || limit > maxAdminLimit {
+		return 0, fmt.Errorf("limit is %s, it should be an integer in [1, %d]", rawLimit, maxAdminLimit)
+	}
+	return limit, nil
+}
+
+func (service *CollectEventService) debugBufferHandler(writer http.ResponseWriter, request *http.Request) {
+	if !service.checkAdminRequest(writer, request, http.MethodGet, "debug_buffer") {
+		return
+	}
+	limit, err := parseAdminLimit(request)
+	if err != nil {
+		if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	events, aggregatedCount := service.PeekAggregatedEvents(limit)
+	response := DebugBufferResponse{
+		Events:                       events,
+		AggregatedEventCount:         aggregatedCount,
+		EventBufferCount:             atomic.LoadInt64(&service.eventCountInEventBuffer),
+		HighPriorityEventBufferCount: atomic.LoadInt64(&service.eventCountInHighPriorityEventBuffer),
+		CollectedEventBufferCount:    atomic.LoadInt64(&service.eventCountInCollectedEventBuffer),
+	}
+	if err = writeResponse(writer, http.StatusOK, response); err != nil {
+		service.recordWriteResponseError(err, []byte{})
+	}
+}
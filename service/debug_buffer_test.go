@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+	"bytepower_room/utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugBufferHandler(t *testing.T) {
+	service := testNewCollectEventService()
+	service.events = make(map[string]base.HashTagEvent)
+	for _, hashTag := range []string{"a", "b", "c"} {
+		service.events[hashTag] = base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: time.Now()}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/debug/buffer", nil)
+	recorder := httptest.NewRecorder()
+	service.debugBufferHandler(recorder, request)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	service.config.Server.AdminToken = "secret"
+	request = httptest.NewRequest(http.MethodGet, "/debug/buffer", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder = httptest.NewRecorder()
+	service.debugBufferHandler(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	for _, limit := range []string{"0", "abc", "1001"} {
+		request = httptest.NewRequest(http.MethodGet, "/debug/buffer?limit="+limit, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder = httptest.NewRecorder()
+		service.debugBufferHandler(recorder, request)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, limit)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/debug/buffer?limit=2", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	service.debugBufferHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response DebugBufferResponse
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, 2, len(response.Events))
+	assert.Equal(t, 3, response.AggregatedEventCount)
+
+	// events are not removed
+	assert.Equal(t, 3, len(service.events))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// deletedTagSet is a LRU set of recently deleted hash tags with their delete time,
+// tags expire after ttl since they are added. Nil set keeps nothing.
+type deletedTagSet struct {
+	size int
+	ttl  time.Duration
+
+	mutex    sync.Mutex
+	items    map[string]*list.Element
+	useOrder *list.List
+}
+
+type deletedTagSetItem struct {
+	hashTag    string
+	deleteTime time.Time
+	expireAt   time.Time
+}
+
+func newDeletedTagSet(size int, ttl time.Duration) *deletedTagSet {
+	return &deletedTagSet{
+		size:     size,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		useOrder: list.New(),
+	}
+}
+
+// add keeps the latest delete time of hash tag.
+func (set *deletedTagSet) add(hashTag string, deleteTime time.Time, t time.Time) {
+	if set == nil {
+		return
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	if element, ok := set.items[hashTag]; ok {
+		item := element.Value.(deletedTagSetItem)
+		if deleteTime.After(item.deleteTime) {
+			item.deleteTime = deleteTime
+		}
+		item.expireAt = t.Add(set.ttl)
+		element.Value = item
+		set.useOrder.MoveToFront(element)
+		return
+	}
+	for set.useOrder.Len() >= set.size {
+		set.removeElement(set.useOrder.Back())
+	}
+	item := deletedTagSetItem{hashTag: hashTag, deleteTime: deleteTime, expireAt: t.Add(set.ttl)}
+	set.items[hashTag] = set.useOrder.PushFront(item)
+}
+
+// isDeletedAt returns true if hash tag is known to be deleted at or after accessTime,
+// such access is removed by the delete and should not be saved again.
+func (set *deletedTagSet) isDeletedAt(hashTag string, accessTime time.Time, t time.Time) bool {
+	if set == nil {
+		return false
+	}
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	element, ok := set.items[hashTag]
+	if !ok {
+		return false
+	}
+	item := element.Value.(deletedTagSetItem)
+	if !t.Before(item.expireAt) {
+		set.removeElement(element)
+		return false
+	}
+	return !accessTime.After(item.deleteTime)
+}
+
+func (set *deletedTagSet) removeElement(element *list.Element) {
+	item := set.useOrder.Remove(element).(deletedTagSetItem)
+	delete(set.items, item.hashTag)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedTagSet(t *testing.T) {
+	set := newDeletedTagSet(2, time.Minute)
+	now := time.Now()
+
+	assert.False(t, set.isDeletedAt("a", now, now))
+
+	set.add("a", now, now)
+	assert.True(t, set.isDeletedAt("a", now, now))
+	assert.True(t, set.isDeletedAt("a", now.Add(-time.Second), now))
+	// accessed again after delete
+	assert.False(t, set.isDeletedAt("a", now.Add(time.Second), now))
+
+	// older delete time is ignored
+	set.add("a", now.Add(-time.Hour), now)
+	assert.True(t, set.isDeletedAt("a", now, now))
+
+	// a is least recently added
+	set.add("b", now, now)
+	set.add("c", now, now)
+	assert.False(t, set.isDeletedAt("a", now, now))
+	assert.True(t, set.isDeletedAt("b", now, now))
+
+	// expired
+	assert.False(t, set.isDeletedAt("b", now, now.Add(time.Minute)))
+	assert.Equal(t, 1, set.useOrder.Len())
+
+	var nilSet *deletedTagSet
+	nilSet.add("a", now, now)
+	assert.False(t, nilSet.isDeletedAt("a", now, now))
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// errorWindowOtherReason counts errors of reasons more than max reason count in a bucket.
+const errorWindowOtherReason = "other"
+
+// errorWindow counts errors by reason in a sliding window,
+// window is split into buckets and the oldest bucket is reused when window slides.
+type errorWindow struct {
+	bucketDuration time.Duration
+	maxReasonCount int
+
+	mutex   sync.Mutex
+	buckets []errorWindowBucket
+}
+
+type errorWindowBucket struct {
+	start  time.Time
+	counts map[string]int64
+}
+
+func newErrorWindow(window time.Duration, bucketCount, maxReasonCount int) *errorWindow {
+	return &errorWindow{
+		bucketDuration: window / time.Duration(bucketCount),
+		maxReasonCount: maxReasonCount,
+		buckets:        make([]errorWindowBucket, bucketCount),
+	}
+}
+
+func (window *errorWindow) add(reason string, t time.Time) {
+	window.mutex.Lock()
+	defer window.mutex.Unlock()
+	start := t.Truncate(window.bucketDuration)
+	bucket := &window.buckets[int(start.UnixNano()/int64(window.bucketDuration))%len(window.buckets)]
+	if !bucket.start.Equal(start) {
+		bucket.start = start
+		bucket.counts = make(map[string]int64)
+	}
+	if _, ok := bucket.counts[reason]; !ok && len(bucket.counts) >= window.maxReasonCount {
+		reason = errorWindowOtherReason
+	}
+	bucket.counts[reason]++
+}
+
+// counts returns error counts by reason in window ending at t.
+func (window *errorWindow) counts(t time.Time) map[string]int64 {
+	window.mutex.Lock()
+	defer window.mutex.Unlock()
+	counts := make(map[string]int64)
+	oldestStart := t.Truncate(window.bucketDuration).Add(-window.bucketDuration * time.Duration(len(window.buckets)-1))
+	for _, bucket := range window.buckets {
+		if bucket.start.Before(oldestStart) || bucket.start.After(t) {
+			continue
+		}
+		for reason, count := range bucket.counts {
+			counts[reason] += count
+		}
+	}
+	return counts
+}
+
+// GetErrorCounts returns error counts by reason in the last error_window.window,
+// it is empty if error counts are not kept.
+func (service *CollectEventService) GetErrorCounts() map[string]int64 {
+	if service.errorWindow == nil {
+		return map[string]int64{}
+	}
+	return service.errorWindow.counts(time.Now())
+}
+
+func (service *CollectEventService) GetErrorCount(reason string) int64 {
+	return service.GetErrorCounts()[reason]
+}
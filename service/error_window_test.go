@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorWindow(t *testing.T) {
+	window := newErrorWindow(time.Minute, 6, 2)
+	startTime := time.Date(2021, 6, 25, 11, 30, 0, 0, time.UTC)
+
+	window.add("a", startTime)
+	window.add("a", startTime.Add(15*time.Second))
+	window.add("b", startTime.Add(25*time.Second))
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1}, window.counts(startTime.Add(30*time.Second)))
+
+	// reasons more than max reason count in a bucket
+	window.add("c", startTime.Add(25*time.Second))
+	window.add("d", startTime.Add(25*time.Second))
+	window.add("b", startTime.Add(25*time.Second))
+	assert.Equal(t, map[string]int64{"a": 2, "b": 2, "c": 1, "other": 1}, window.counts(startTime.Add(30*time.Second)))
+
+	// errors out of window
+	assert.Equal(t, map[string]int64{"a": 1, "b": 2, "c": 1, "other": 1}, window.counts(startTime.Add(69*time.Second)))
+	assert.Equal(t, map[string]int64{}, window.counts(startTime.Add(80*time.Second)))
+
+	// bucket is reused
+	window.add("e", startTime.Add(60*time.Second))
+	assert.Equal(t, map[string]int64{"a": 1, "b": 2, "c": 1, "e": 1, "other": 1}, window.counts(startTime.Add(60*time.Second)))
+}
+
+func TestGetErrorCounts(t *testing.T) {
+	service := testNewCollectEventService()
+	assert.Equal(t, map[string]int64{}, service.GetErrorCounts())
+
+	service.errorWindow = newErrorWindow(time.Minute, 6, 10)
+	for i := 0; i < 3; i++ {
+		service.recordError("unmarshal_body", fmt.Errorf("error %d", i), nil)
+	}
+	service.recordError("read_body", nil, nil)
+	assert.Equal(t, int64(3), service.GetErrorCount("unmarshal_body"))
+	assert.Equal(t, int64(1), service.GetErrorCount("read_body"))
+	assert.Equal(t, int64(0), service.GetErrorCount("add_event"))
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"bytepower_room/base"
+)
+
+// EventBuffer keeps events added to service until they are aggregated, it is chosen by buffer_strategy.
+// Events are dequeued in the order they are enqueued, except that priority buffer dequeues events of high priority first.
+type EventBuffer interface {
+	// Enqueue returns false if event is not added since buffer is full.
+	Enqueue(event base.HashTagEvent) bool
+	// Dequeue returns the channel events are received from, it is closed by Close.
+	Dequeue() <-chan base.HashTagEvent
+	Len() int
+	// Close is called when no event is enqueued anymore, events left can still be dequeued.
+	Close()
+}
+
+// newEventBuffer returns buffer of config.BufferStrategy, errors of overflow buffer are recorded by recordError.
+func newEventBuffer(
+	config *base.RoomCollectEventConfig, metric *base.MetricClient,
+	recordError func(reason string, err error, extra map[string]string)) (EventBuffer, error) {
+	switch config.BufferStrategy {
+	case base.EventBufferStrategyBoundedBlock:
+		return newBlockingEventBuffer(config.BufferLimit, config.BufferBlockTimeout), nil
+	case base.EventBufferStrategyOverflow:
+		overflow, err := newOverflowBuffer(config.OverflowBuffer, config.SaveFile.FileDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("new overflow buffer error %w", err)
+		}
+		return newOverflowEventBuffer(config.BufferLimit, overflow, metric, recordError), nil
+	case base.EventBufferStrategyPriority:
+		return newPriorityEventBuffer(config.BufferLimit, config.HighPriorityAccessModes), nil
+	default:
+		return newDroppingEventBuffer(config.BufferLimit), nil
+	}
+}
+
+// droppingEventBuffer never blocks, events are not added when it is full.
+type droppingEventBuffer struct {
+	events chan base.HashTagEvent
+}
+
+func newDroppingEventBuffer(limit int) *droppingEventBuffer {
+	return &droppingEventBuffer{events: make(chan base.HashTagEvent, limit)}
+}
+
+func (buffer *droppingEventBuffer) Enqueue(event base.HashTagEvent) bool {
+	select {
+	case buffer.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (buffer *droppingEventBuffer) Dequeue() <-chan base.HashTagEvent {
+	return buffer.events
+}
+
+func (buffer *droppingEventBuffer) Len() int {
+	return len(buffer.events)
+}
+
+func (buffer *droppingEventBuffer) Close() {
+	close(buffer.events)
+}
+
+// blockingEventBuffer waits at most timeout for room when it is full,
+// so short bursts slow down requests instead of dropping events.
+type blockingEventBuffer struct {
+	droppingEventBuffer
+	timeout time.Duration
+}
+
+func newBlockingEventBuffer(limit int, timeout time.Duration) *blockingEventBuffer {
+	return &blockingEventBuffer{
+		droppingEventBuffer: droppingEventBuffer{events: make(chan base.HashTagEvent, limit)},
+		timeout:             timeout,
+	}
+}
+
+func (buffer *blockingEventBuffer) Enqueue(event base.HashTagEvent) bool {
+	if buffer.droppingEventBuffer.Enqueue(event) {
+		return true
+	}
+	timer := time.NewTimer(buffer.timeout)
+	defer timer.Stop()
+	select {
+	case buffer.events <- event:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// overflowEventBuffer keeps events in overflow buffer when primary buffer is full.
+// Events are added to overflow buffer until it is empty, and they are dequeued after events in primary buffer,
+// so events are still dequeued in the order they are enqueued.
+type overflowEventBuffer struct {
+	primary  *droppingEventBuffer
+	overflow overflowBuffer
+	// notified when an event is pushed to overflow buffer
+	notifyCh chan bool
+	events   chan base.HashTagEvent
+
+	metric      *base.MetricClient
+	recordError func(reason string, err error, extra map[string]string)
+}
+
+func newOverflowEventBuffer(
+	limit int, overflow overflowBuffer, metric *base.MetricClient,
+	recordError func(reason string, err error, extra map[string]string)) *overflowEventBuffer {
+	buffer := &overflowEventBuffer{
+		primary:     newDroppingEventBuffer(limit),
+		overflow:    overflow,
+		notifyCh:    make(chan bool, 1),
+		events:      make(chan base.HashTagEvent),
+		metric:      metric,
+		recordError: recordError,
+	}
+	go buffer.forwardEvents()
+	return buffer
+}
+
+func (buffer *overflowEventBuffer) Enqueue(event base.HashTagEvent) bool {
+	if buffer.overflow.len() == 0 && buffer.primary.Enqueue(event) {
+		buffer.metric.MetricIncrease("add_event.primary")
+		return true
+	}
+	ok, err := buffer.overflow.push(event)
+	if err != nil {
+		buffer.recordError("push_overflow_event", err, map[string]string{"hash_tag": event.HashTag})
+	}
+	if !ok {
+		// primary buffer may have room again
+		if buffer.primary.Enqueue(event) {
+			buffer.metric.MetricIncrease("add_event.primary")
+			return true
+		}
+		return false
+	}
+	buffer.metric.MetricIncrease("add_event.overflow")
+	select {
+	case buffer.notifyCh <- true:
+	default:
+	}
+	return true
+}
+
+func (buffer *overflowEventBuffer) Dequeue() <-chan base.HashTagEvent {
+	return buffer.events
+}
+
+func (buffer *overflowEventBuffer) Len() int {
+	return buffer.primary.Len() + buffer.overflow.len()
+}
+
+func (buffer *overflowEventBuffer) overflowLen() int {
+	return buffer.overflow.len()
+}
+
+func (buffer *overflowEventBuffer) Close() {
+	buffer.primary.Close()
+}
+
+// forwardEvents sends events of overflow buffer when primary buffer is empty,
+// events left in overflow buffer are sent after primary buffer is closed.
+func (buffer *overflowEventBuffer) forwardEvents() {
+	defer close(buffer.events)
+	primaryEvents := buffer.primary.Dequeue()
+	for {
+		select {
+		case event, ok := <-primaryEvents:
+			if !ok {
+				buffer.forwardOverflowEvents()
+				return
+			}
+			buffer.events <- event
+			continue
+		default:
+		}
+		if event, ok := buffer.popOverflowEvent(); ok {
+			buffer.events <- event
+			buffer.metric.MetricIncrease("move_overflow_event")
+			continue
+		}
+		select {
+		case event, ok := <-primaryEvents:
+			if !ok {
+				buffer.forwardOverflowEvents()
+				return
+			}
+			buffer.events <- event
+		case <-buffer.notifyCh:
+		}
+	}
+}
+
+func (buffer *overflowEventBuffer) forwardOverflowEvents() {
+	for {
+		event, ok := buffer.popOverflowEvent()
+		if !ok {
+			break
+		}
+		buffer.events <- event
+	}
+	if err := buffer.overflow.close(); err != nil {
+		buffer.recordError("close_overflow_buffer", err, nil)
+	}
+}
+
+func (buffer *overflowEventBuffer) popOverflowEvent() (base.HashTagEvent, bool) {
+	event, ok, err := buffer.overflow.pop()
+	if err != nil {
+		buffer.recordError("pop_overflow_event", err, nil)
+	}
+	return event, ok
+}
+
+// priorityEventBuffer keeps events of high priority access modes in their own buffer,
+// they are dequeued before other events.
+type priorityEventBuffer struct {
+	high   *droppingEventBuffer
+	normal *droppingEventBuffer
+	modes  map[base.HashTagAccessMode]bool
+	events chan base.HashTagEvent
+}
+
+func newPriorityEventBuffer(limit int, modes []base.HashTagAccessMode) *priorityEventBuffer {
+	buffer := &priorityEventBuffer{
+		high:   newDroppingEventBuffer(limit),
+		normal: newDroppingEventBuffer(limit),
+		modes:  make(map[base.HashTagAccessMode]bool),
+		events: make(chan base.HashTagEvent),
+	}
+	for _, mode := range modes {
+		buffer.modes[mode] = true
+	}
+	go buffer.forwardEvents()
+	return buffer
+}
+
+func (buffer *priorityEventBuffer) isHighPriority(event base.HashTagEvent) bool {
+	return buffer.modes[event.AccessMode()]
+}
+
+func (buffer *priorityEventBuffer) Enqueue(event base.HashTagEvent) bool {
+	if buffer.isHighPriority(event) {
+		return buffer.high.Enqueue(event)
+	}
+	return buffer.normal.Enqueue(event)
+}
+
+func (buffer *priorityEventBuffer) Dequeue() <-chan base.HashTagEvent {
+	return buffer.events
+}
+
+func (buffer *priorityEventBuffer) Len() int {
+	return buffer.high.Len() + buffer.normal.Len()
+}
+
+func (buffer *priorityEventBuffer) highPriorityLen() int {
+	return buffer.high.Len()
+}
+
+func (buffer *priorityEventBuffer) Close() {
+	buffer.high.Close()
+	buffer.normal.Close()
+}
+
+// forwardEvents sends high priority events first, until both buffers are closed and empty.
+func (buffer *priorityEventBuffer) forwardEvents() {
+	defer close(buffer.events)
+	high, normal := buffer.high.Dequeue(), buffer.normal.Dequeue()
+	for high != nil || normal != nil {
+		select {
+		case event, ok := <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+			buffer.events <- event
+			continue
+		default:
+		}
+		select {
+		case event, ok := <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+			buffer.events <- event
+		case event, ok := <-normal:
+			if !ok {
+				normal = nil
+				continue
+			}
+			buffer.events <- event
+		}
+	}
+}
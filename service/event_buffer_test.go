@@ -0,0 +1,137 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDroppingEventBuffer(t *testing.T) {
+	buffer := newDroppingEventBuffer(1)
+	assert.True(t, buffer.Enqueue(base.HashTagEvent{HashTag: "a"}))
+	assert.False(t, buffer.Enqueue(base.HashTagEvent{HashTag: "b"}))
+	assert.Equal(t, 1, buffer.Len())
+
+	assert.Equal(t, "a", (<-buffer.Dequeue()).HashTag)
+	assert.True(t, buffer.Enqueue(base.HashTagEvent{HashTag: "c"}))
+	buffer.Close()
+	hashTags := make([]string, 0)
+	for event := range buffer.Dequeue() {
+		hashTags = append(hashTags, event.HashTag)
+	}
+	assert.Equal(t, []string{"c"}, hashTags)
+}
+
+func TestBlockingEventBuffer(t *testing.T) {
+	buffer := newBlockingEventBuffer(1, 20*time.Millisecond)
+	assert.True(t, buffer.Enqueue(base.HashTagEvent{HashTag: "a"}))
+
+	// full buffer waits for timeout
+	startTime := time.Now()
+	assert.False(t, buffer.Enqueue(base.HashTagEvent{HashTag: "b"}))
+	assert.True(t, time.Since(startTime) >= 20*time.Millisecond)
+
+	// event is added when room is made in timeout
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-buffer.Dequeue()
+	}()
+	assert.True(t, buffer.Enqueue(base.HashTagEvent{HashTag: "c"}))
+	assert.Equal(t, "c", (<-buffer.Dequeue()).HashTag)
+}
+
+func TestOverflowEventBuffer(t *testing.T) {
+	service := testNewCollectEventService()
+	buffer := newOverflowEventBuffer(1, newMemoryOverflowBuffer(2), service.metric, service.recordError)
+	event := func(hashTag string) base.HashTagEvent {
+		return base.HashTagEvent{HashTag: hashTag}
+	}
+	for _, hashTag := range []string{"a", "b", "c"} {
+		assert.True(t, buffer.Enqueue(event(hashTag)))
+	}
+	assert.False(t, buffer.Enqueue(event("d")))
+	assert.Equal(t, 3, buffer.Len())
+	assert.Equal(t, 2, buffer.overflowLen())
+
+	// events in overflow buffer are dequeued after events in primary buffer
+	hashTags := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		hashTags = append(hashTags, (<-buffer.Dequeue()).HashTag)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, hashTags)
+
+	// events left in overflow buffer can be dequeued after close
+	for _, hashTag := range []string{"e", "f", "g"} {
+		assert.True(t, buffer.Enqueue(event(hashTag)))
+	}
+	buffer.Close()
+	hashTags = make([]string, 0)
+	for event := range buffer.Dequeue() {
+		hashTags = append(hashTags, event.HashTag)
+	}
+	assert.Equal(t, []string{"e", "f", "g"}, hashTags)
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestPriorityEventBuffer(t *testing.T) {
+	buffer := newPriorityEventBuffer(2, []base.HashTagAccessMode{base.HashTagAccessModeWrite})
+	read, _ := base.NewHashTagEvent("read", nil, base.HashTagAccessModeRead, time.Now())
+	write, _ := base.NewHashTagEvent("write", []string{"{write}a"}, base.HashTagAccessModeWrite, time.Now())
+	assert.False(t, buffer.isHighPriority(read))
+	assert.True(t, buffer.isHighPriority(write))
+
+	assert.True(t, buffer.Enqueue(read))
+	assert.True(t, buffer.Enqueue(read))
+	// wait until a read event is held by forwarder
+	for buffer.Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, buffer.Enqueue(read))
+	assert.False(t, buffer.Enqueue(read))
+	assert.True(t, buffer.Enqueue(write))
+	assert.True(t, buffer.Enqueue(write))
+	assert.Equal(t, 4, buffer.Len())
+	assert.Equal(t, 2, buffer.highPriorityLen())
+
+	// high priority events are dequeued first, except the read event held by forwarder
+	buffer.Close()
+	hashTags := make([]string, 0)
+	for event := range buffer.Dequeue() {
+		hashTags = append(hashTags, event.HashTag)
+	}
+	assert.Equal(t, []string{"read", "write", "write", "read", "read"}, hashTags)
+}
+
+func TestNewEventBuffer(t *testing.T) {
+	service := testNewCollectEventService()
+	newBuffer := func(config *base.RoomCollectEventConfig) EventBuffer {
+		buffer, err := newEventBuffer(config, service.metric, service.recordError)
+		assert.Nil(t, err)
+		return buffer
+	}
+	config := &base.RoomCollectEventConfig{BufferLimit: 2}
+	_, ok := newBuffer(config).(*droppingEventBuffer)
+	assert.True(t, ok)
+
+	config.BufferStrategy = base.EventBufferStrategyBoundedBlock
+	config.BufferBlockTimeout = time.Millisecond
+	_, ok = newBuffer(config).(*blockingEventBuffer)
+	assert.True(t, ok)
+
+	config.BufferStrategy = base.EventBufferStrategyOverflow
+	config.OverflowBuffer = base.CollectEventServiceOverflowBufferConfig{Limit: 2, Backing: base.OverflowBufferBackingMemory}
+	buffer := newBuffer(config)
+	_, ok = buffer.(*overflowEventBuffer)
+	assert.True(t, ok)
+	buffer.Close()
+
+	config.BufferStrategy = base.EventBufferStrategyPriority
+	config.HighPriorityAccessModes = []base.HashTagAccessMode{base.HashTagAccessModeWrite}
+	buffer = newBuffer(config)
+	_, ok = buffer.(*priorityEventBuffer)
+	assert.True(t, ok)
+	buffer.Close()
+}
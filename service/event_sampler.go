@@ -0,0 +1,50 @@
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// eventSampler keeps at least minEventsPerTag events of every hash tag in every window,
+// further events of hot tags are kept in hotTagKeepRatio, and further events of other tags are all kept.
+// Nil sampler keeps all events.
+type eventSampler struct {
+	window          time.Duration
+	minEventsPerTag int
+	hotTagKeepRatio float64
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	// kept events of every hash tag in current window
+	counts map[string]int
+	random *rand.Rand
+}
+
+func newEventSampler(window time.Duration, minEventsPerTag int, hotTagKeepRatio float64) *eventSampler {
+	return &eventSampler{
+		window:          window,
+		minEventsPerTag: minEventsPerTag,
+		hotTagKeepRatio: hotTagKeepRatio,
+		counts:          make(map[string]int),
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (sampler *eventSampler) allow(hashTag string, isHot bool, t time.Time) bool {
+	if sampler == nil {
+		return true
+	}
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	if t.Sub(sampler.windowStart) >= sampler.window {
+		sampler.windowStart = t
+		sampler.counts = make(map[string]int)
+	}
+	count := sampler.counts[hashTag]
+	if count >= sampler.minEventsPerTag && isHot && sampler.random.Float64() >= sampler.hotTagKeepRatio {
+		return false
+	}
+	sampler.counts[hashTag] = count + 1
+	return true
+}
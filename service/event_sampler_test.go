@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventSampler(t *testing.T) {
+	sampler := newEventSampler(time.Second, 2, 0)
+	now := time.Now()
+
+	// events of hot tag beyond min events are dropped
+	assert.True(t, sampler.allow("hot", true, now))
+	assert.True(t, sampler.allow("hot", true, now))
+	assert.False(t, sampler.allow("hot", true, now))
+
+	// rare tags are kept
+	for i := 0; i < 10; i++ {
+		assert.True(t, sampler.allow("cold", false, now))
+	}
+
+	// min events are kept again in next window
+	assert.True(t, sampler.allow("hot", true, now.Add(time.Second)))
+	assert.Equal(t, 1, len(sampler.counts))
+
+	sampler = newEventSampler(time.Second, 1, 1)
+	for i := 0; i < 10; i++ {
+		assert.True(t, sampler.allow("hot", true, now))
+	}
+
+	var nilSampler *eventSampler
+	assert.True(t, nilSampler.allow("hot", true, now))
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var errCorruptGzipBody = errors.New("request body is not a valid gzip stream")
+
+// isGzipRequest returns true if body of request is compressed with gzip.
+func isGzipRequest(request *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(request.Header.Get(httpHeaderContentEncoding)), httpEncodingGzip)
+}
+
+// sourceReader keeps error of reading compressed body,
+// so it can be told from errors of decompression.
+type sourceReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (reader *sourceReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+	return n, err
+}
+
+// gzipBodyReader decompresses body, errors of decompression are wrapped with errCorruptGzipBody,
+// errors of reading body like errBodyReadTooSlow are returned as they are.
+type gzipBodyReader struct {
+	source *sourceReader
+	reader *gzip.Reader
+}
+
+func newGzipBodyReader(reader io.Reader) (*gzipBodyReader, error) {
+	source := &sourceReader{reader: reader}
+	gzipReader, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, wrapGzipBodyError(source, err)
+	}
+	return &gzipBodyReader{source: source, reader: gzipReader}, nil
+}
+
+func (reader *gzipBodyReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = wrapGzipBodyError(reader.source, err)
+	}
+	return n, err
+}
+
+func wrapGzipBodyError(source *sourceReader, err error) error {
+	if source.err != nil {
+		return source.err
+	}
+	return fmt.Errorf("%w, %v", errCorruptGzipBody, err)
+}
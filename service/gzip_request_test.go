@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostEventsHandlerGzipBody(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(body))
+		request.Header.Set(httpHeaderContentEncoding, "GZIP")
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	compressedBody, _ := gzipBytes([]byte(body))
+	recorder := post(compressedBody)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	event := <-service.eventBuffer.Dequeue()
+	assert.Equal(t, "abc", event.HashTag)
+
+	// not gzip at all
+	recorder = post([]byte(body))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errCorruptGzipBody.Error())
+
+	// truncated gzip stream
+	recorder = post(compressedBody[:len(compressedBody)-4])
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errCorruptGzipBody.Error())
+
+	// body is limited after decompression
+	service.config.Server.MaxBodyBytes = 1024
+	largeBody := `{"events": [], "padding": "` + strings.Repeat("a", 10240) + `"}`
+	compressedBody, _ = gzipBytes([]byte(largeBody))
+	assert.True(t, len(compressedBody) < 1024)
+	recorder = post(compressedBody)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}
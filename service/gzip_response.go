@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	httpHeaderAcceptEncoding  = "Accept-Encoding"
+	httpHeaderContentEncoding = "Content-Encoding"
+	httpHeaderContentLength   = "Content-Length"
+	httpHeaderVary            = "Vary"
+	httpEncodingGzip          = "gzip"
+)
+
+// bufferedResponseWriter keeps response in memory, so it can be compressed after handler returns.
+type bufferedResponseWriter struct {
+	writer http.ResponseWriter
+	code   int
+	body   bytes.Buffer
+}
+
+func (writer *bufferedResponseWriter) Header() http.Header {
+	return writer.writer.Header()
+}
+
+func (writer *bufferedResponseWriter) WriteHeader(code int) {
+	if writer.code == 0 {
+		writer.code = code
+	}
+}
+
+func (writer *bufferedResponseWriter) Write(p []byte) (int, error) {
+	writer.WriteHeader(http.StatusOK)
+	return writer.body.Write(p)
+}
+
+// gzipHandler compresses responses not shorter than minBytes with gzip if client accepts gzip.
+// Responses are written once by handlers, so they are buffered before compression.
+func (service *CollectEventService) gzipHandler(handler http.Handler, minBytes int) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Add(httpHeaderVary, httpHeaderAcceptEncoding)
+		if !isGzipAccepted(request.Header.Get(httpHeaderAcceptEncoding)) {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		bufferedWriter := &bufferedResponseWriter{writer: writer}
+		handler.ServeHTTP(bufferedWriter, request)
+		if bufferedWriter.code == 0 {
+			return
+		}
+		body := bufferedWriter.body.Bytes()
+		if len(body) >= minBytes {
+			compressedBody, err := gzipBytes(body)
+			if err != nil {
+				service.recordError("gzip_response", err, nil)
+			} else {
+				body = compressedBody
+				writer.Header().Set(httpHeaderContentEncoding, httpEncodingGzip)
+			}
+		}
+		writer.Header().Set(httpHeaderContentLength, strconv.Itoa(len(body)))
+		writer.WriteHeader(bufferedWriter.code)
+		n, err := writer.Write(body)
+		if n > 0 && n < len(body) {
+			if err == nil {
+				err = io.ErrShortWrite
+			}
+			err = &partialWriteError{written: n, total: len(body), err: err}
+		}
+		if err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+	})
+}
+
+func gzipBytes(p []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	if _, err := gzipWriter.Write(p); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// isGzipAccepted parses Accept-Encoding header, gzip with q=0 is not accepted,
+// "*" is used only if gzip is not listed.
+func isGzipAccepted(acceptEncoding string) bool {
+	wildcardAccepted := false
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		encoding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if encoding != httpEncodingGzip && encoding != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if encoding == httpEncodingGzip {
+			return accepted
+		}
+		wildcardAccepted = accepted
+	}
+	return wildcardAccepted
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsGzipAccepted(t *testing.T) {
+	cases := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, GZIP;q=0.5":  true,
+		"gzip;q=0":             false,
+		"*":                    true,
+		"*, gzip;q=0":          false,
+		"*;q=0":                false,
+		"br, deflate":          false,
+		"gzip;q=abc, deflate":  false,
+		"identity, *;q=0.1":    true,
+		" gzip ; q=1.0 , br  ": true,
+	}
+	for acceptEncoding, accepted := range cases {
+		assert.Equal(t, accepted, isGzipAccepted(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	service := testNewCollectEventService()
+	longBody := strings.Repeat("a", 100)
+	handler := service.gzipHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/long":
+			_ = writeErrorResponse(writer, http.StatusBadRequest, errors.New(longBody))
+		case "/short":
+			_ = writeSuccessResponse(writer, CollectEventsResponse{Count: 1})
+		}
+	}), 50)
+
+	request := httptest.NewRequest(http.MethodGet, "/long", nil)
+	request.Header.Set(httpHeaderAcceptEncoding, "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, httpEncodingGzip, recorder.Header().Get(httpHeaderContentEncoding))
+	assert.Equal(t, HTTPContentTypeJSON, recorder.Header().Get(HTTPHeaderContentType))
+	assert.Equal(t, httpHeaderAcceptEncoding, recorder.Header().Get(httpHeaderVary))
+	reader, err := gzip.NewReader(recorder.Body)
+	assert.Nil(t, err)
+	body, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"error":"`+longBody+`"}`, string(body))
+
+	// short response
+	request = httptest.NewRequest(http.MethodGet, "/short", nil)
+	request.Header.Set(httpHeaderAcceptEncoding, "gzip")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "", recorder.Header().Get(httpHeaderContentEncoding))
+	assert.Equal(t, `{"count":1}`, recorder.Body.String())
+
+	// gzip is not accepted
+	request = httptest.NewRequest(http.MethodGet, "/long", nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, "", recorder.Header().Get(httpHeaderContentEncoding))
+	assert.Equal(t, `{"error":"`+longBody+`"}`, recorder.Body.String())
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// hashTagKeysRecordCache is a LRU cache of records by hash tag,
+// records expire after ttl since they are added.
+type hashTagKeysRecordCache struct {
+	size int
+	ttl  time.Duration
+
+	mutex    sync.Mutex
+	items    map[string]*list.Element
+	useOrder *list.List
+}
+
+type hashTagKeysRecordCacheItem struct {
+	record   HashTagKeysRecord
+	expireAt time.Time
+}
+
+func newHashTagKeysRecordCache(size int, ttl time.Duration) *hashTagKeysRecordCache {
+	return &hashTagKeysRecordCache{
+		size:     size,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		useOrder: list.New(),
+	}
+}
+
+func (cache *hashTagKeysRecordCache) get(hashTag string, t time.Time) (HashTagKeysRecord, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.items[hashTag]
+	if !ok {
+		return HashTagKeysRecord{}, false
+	}
+	item := element.Value.(hashTagKeysRecordCacheItem)
+	if !t.Before(item.expireAt) {
+		cache.removeElement(element)
+		return HashTagKeysRecord{}, false
+	}
+	cache.useOrder.MoveToFront(element)
+	return item.record, true
+}
+
+func (cache *hashTagKeysRecordCache) add(record HashTagKeysRecord, t time.Time) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	item := hashTagKeysRecordCacheItem{record: record, expireAt: t.Add(cache.ttl)}
+	if element, ok := cache.items[record.HashTag]; ok {
+		element.Value = item
+		cache.useOrder.MoveToFront(element)
+		return
+	}
+	for cache.useOrder.Len() >= cache.size {
+		cache.removeElement(cache.useOrder.Back())
+	}
+	cache.items[record.HashTag] = cache.useOrder.PushFront(item)
+}
+
+func (cache *hashTagKeysRecordCache) remove(hashTag string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[hashTag]; ok {
+		cache.removeElement(element)
+	}
+}
+
+func (cache *hashTagKeysRecordCache) removeElement(element *list.Element) {
+	item := cache.useOrder.Remove(element).(hashTagKeysRecordCacheItem)
+	delete(cache.items, item.record.HashTag)
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashTagKeysRecordCache(t *testing.T) {
+	cache := newHashTagKeysRecordCache(2, time.Minute)
+	now := time.Now()
+
+	_, ok := cache.get("a", now)
+	assert.False(t, ok)
+
+	cache.add(HashTagKeysRecord{HashTag: "a", Keys: []string{"{a}1"}}, now)
+	cache.add(HashTagKeysRecord{HashTag: "b"}, now)
+	record, ok := cache.get("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"{a}1"}, record.Keys)
+
+	// b is least recently used
+	cache.add(HashTagKeysRecord{HashTag: "c"}, now)
+	_, ok = cache.get("b", now)
+	assert.False(t, ok)
+	_, ok = cache.get("a", now)
+	assert.True(t, ok)
+
+	// update
+	cache.add(HashTagKeysRecord{HashTag: "a", Keys: []string{"{a}2"}}, now)
+	record, _ = cache.get("a", now)
+	assert.Equal(t, []string{"{a}2"}, record.Keys)
+
+	// expired
+	_, ok = cache.get("a", now.Add(time.Minute))
+	assert.False(t, ok)
+
+	cache.remove("c")
+	_, ok = cache.get("c", now)
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.useOrder.Len())
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// idempotencyCache saves responses of handled requests by idempotency key,
+// the oldest key is removed when cache is full.
+// Key is marked in flight before request is handled, so a concurrent request with the same key is not handled twice.
+type idempotencyCache struct {
+	size int
+	ttl  time.Duration
+
+	mutex    sync.Mutex
+	items    map[string]*list.Element
+	keyOrder *list.List
+}
+
+type idempotencyCacheItem struct {
+	key      string
+	bodyHash [sha256.Size]byte
+	// response is valid only if request is not in flight
+	inFlight bool
+	response CollectEventsResponse
+	expireAt time.Time
+}
+
+type idempotencyKeyState int
+
+const (
+	// key is marked in flight, request should be handled
+	idempotencyKeyNew idempotencyKeyState = iota
+	// response of request with key is cached
+	idempotencyKeyDone
+	// request with key is being handled
+	idempotencyKeyInFlight
+	// key is used by request with another body
+	idempotencyKeyBodyMismatch
+)
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		size:     size,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		keyOrder: list.New(),
+	}
+}
+
+// idempotencyCacheKey scopes idempotency key to client, so keys of different clients do not collide.
+func idempotencyCacheKey(client, key string) string {
+	return client + "\n" + key
+}
+
+// begin marks key in flight if it is new, response is returned if state is idempotencyKeyDone.
+func (cache *idempotencyCache) begin(key string, body []byte, t time.Time) (CollectEventsResponse, idempotencyKeyState) {
+	bodyHash := sha256.Sum256(body)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[key]; ok {
+		item := element.Value.(idempotencyCacheItem)
+		if t.Before(item.expireAt) {
+			switch {
+			case item.bodyHash != bodyHash:
+				return CollectEventsResponse{}, idempotencyKeyBodyMismatch
+			case item.inFlight:
+				return CollectEventsResponse{}, idempotencyKeyInFlight
+			default:
+				return item.response, idempotencyKeyDone
+			}
+		}
+		cache.remove(element)
+	}
+	cache.add(idempotencyCacheItem{key: key, bodyHash: bodyHash, inFlight: true, expireAt: t.Add(cache.ttl)})
+	return CollectEventsResponse{}, idempotencyKeyNew
+}
+
+// finish caches response of key marked in flight.
+func (cache *idempotencyCache) finish(key string, response CollectEventsResponse, t time.Time) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.items[key]
+	if !ok {
+		return
+	}
+	item := element.Value.(idempotencyCacheItem)
+	cache.remove(element)
+	item.inFlight = false
+	item.response = response
+	item.expireAt = t.Add(cache.ttl)
+	cache.add(item)
+}
+
+// abort removes key marked in flight, so request failed can be retried with the same key.
+func (cache *idempotencyCache) abort(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[key]; ok && element.Value.(idempotencyCacheItem).inFlight {
+		cache.remove(element)
+	}
+}
+
+func (cache *idempotencyCache) len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.keyOrder.Len()
+}
+
+func (cache *idempotencyCache) add(item idempotencyCacheItem) {
+	for cache.keyOrder.Len() >= cache.size {
+		cache.remove(cache.keyOrder.Front())
+	}
+	cache.items[item.key] = cache.keyOrder.PushBack(item)
+}
+
+func (cache *idempotencyCache) remove(element *list.Element) {
+	item := cache.keyOrder.Remove(element).(idempotencyCacheItem)
+	delete(cache.items, item.key)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	cache := newIdempotencyCache(2, time.Minute)
+	now := time.Now()
+	body := []byte("body")
+
+	_, state := cache.begin("a", body, now)
+	assert.Equal(t, idempotencyKeyNew, state)
+	_, state = cache.begin("a", body, now)
+	assert.Equal(t, idempotencyKeyInFlight, state)
+	_, state = cache.begin("a", []byte("other"), now)
+	assert.Equal(t, idempotencyKeyBodyMismatch, state)
+
+	cache.finish("a", CollectEventsResponse{Count: 1}, now)
+	response, state := cache.begin("a", body, now)
+	assert.Equal(t, idempotencyKeyDone, state)
+	assert.Equal(t, CollectEventsResponse{Count: 1}, response)
+	_, state = cache.begin("a", []byte("other"), now)
+	assert.Equal(t, idempotencyKeyBodyMismatch, state)
+	// abort does not remove finished key
+	cache.abort("a")
+	assert.Equal(t, 1, cache.len())
+
+	// aborted key can be used again
+	_, state = cache.begin("b", body, now)
+	assert.Equal(t, idempotencyKeyNew, state)
+	cache.abort("b")
+	assert.Equal(t, 1, cache.len())
+	_, state = cache.begin("b", []byte("other"), now)
+	assert.Equal(t, idempotencyKeyNew, state)
+	cache.finish("b", CollectEventsResponse{Count: 2, IDs: []string{"id1", "id2"}}, now)
+
+	// expired
+	_, state = cache.begin("a", []byte("other"), now.Add(time.Minute))
+	assert.Equal(t, idempotencyKeyNew, state)
+	assert.Equal(t, 2, cache.len())
+
+	// oldest key is removed when cache is full
+	_, state = cache.begin("c", body, now)
+	assert.Equal(t, idempotencyKeyNew, state)
+	assert.Equal(t, 2, cache.len())
+	_, state = cache.begin("b", []byte("other"), now)
+	assert.Equal(t, idempotencyKeyNew, state)
+	_, state = cache.begin("c", body, now)
+	assert.Equal(t, idempotencyKeyInFlight, state)
+}
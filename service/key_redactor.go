@@ -0,0 +1,75 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"bytepower_room/base"
+	"bytepower_room/utility"
+)
+
+// keyRedactor replaces sensitive segments of keys with placeholder,
+// "{hash_tag}" segment of key is kept as it is. Nil redactor keeps keys.
+type keyRedactor struct {
+	patterns    []*regexp.Regexp
+	prefixes    []string
+	placeholder string
+}
+
+// newKeyRedactor returns nil if there is no rule.
+func newKeyRedactor(config base.CollectEventServiceKeyRedactionConfig) (*keyRedactor, error) {
+	if len(config.Patterns) == 0 && len(config.Prefixes) == 0 {
+		return nil, nil
+	}
+	redactor := &keyRedactor{prefixes: config.Prefixes, placeholder: config.Placeholder}
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		redactor.patterns = append(redactor.patterns, re)
+	}
+	return redactor, nil
+}
+
+func (redactor *keyRedactor) redactKey(key string) string {
+	before, hashTag, after := key, "", ""
+	if tag := base.ExtractHashTagFromKey(key); tag != "" {
+		index := strings.Index(key, "{")
+		hashTag = key[index : index+len(tag)+2]
+		before, after = key[:index], key[index+len(hashTag):]
+	} else {
+		before, after = "", key
+	}
+	for _, prefix := range redactor.prefixes {
+		if strings.HasPrefix(after, prefix) && len(after) > len(prefix) {
+			after = prefix + redactor.placeholder
+			break
+		}
+	}
+	for _, re := range redactor.patterns {
+		before = re.ReplaceAllLiteralString(before, redactor.placeholder)
+		after = re.ReplaceAllLiteralString(after, redactor.placeholder)
+	}
+	return before + hashTag + after
+}
+
+// redactEvent returns event with redacted keys, keys of the given event are not changed.
+func (redactor *keyRedactor) redactEvent(event base.HashTagEvent) base.HashTagEvent {
+	if redactor == nil || event.Keys == nil {
+		return event
+	}
+	keys := utility.NewStringSet()
+	redacted := false
+	for _, key := range event.Keys.ToSlice() {
+		redactedKey := redactor.redactKey(key)
+		if redactedKey != key {
+			redacted = true
+		}
+		keys.Add(redactedKey)
+	}
+	if redacted {
+		event.Keys = keys
+	}
+	return event
+}
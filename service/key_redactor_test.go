@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+	"bytepower_room/utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRedactor(t *testing.T) {
+	redactor, err := newKeyRedactor(base.CollectEventServiceKeyRedactionConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, redactor)
+
+	_, err = newKeyRedactor(base.CollectEventServiceKeyRedactionConfig{Patterns: []string{"("}, Placeholder: "*"})
+	assert.NotNil(t, err)
+
+	redactor, err = newKeyRedactor(base.CollectEventServiceKeyRedactionConfig{
+		Patterns:    []string{`\d{11}`, `[a-z0-9.]+@[a-z0-9.]+`},
+		Prefixes:    []string{":token:"},
+		Placeholder: "<redacted>",
+	})
+	assert.Nil(t, err)
+	testCases := []struct {
+		key      string
+		redacted string
+	}{
+		{"{user}:name", "{user}:name"},
+		{"{user}:phone:13800000000", "{user}:phone:<redacted>"},
+		{"a@b.com:{user}:email", "<redacted>:{user}:email"},
+		// hash tag is never redacted
+		{"{13800000000}:phone", "{13800000000}:phone"},
+		{"{user}:token:abc", "{user}:token:<redacted>"},
+		{"{user}:token:", "{user}:token:"},
+		{"13800000000", "<redacted>"},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.redacted, redactor.redactKey(testCase.key), testCase.key)
+	}
+
+	keys := utility.NewStringSet("{user}:name", "{user}:token:abc", "{user}:token:def")
+	event := base.HashTagEvent{HashTag: "user", Keys: keys, AccessTime: time.Now()}
+	redactedEvent := redactor.redactEvent(event)
+	assert.ElementsMatch(t, []string{"{user}:name", "{user}:token:<redacted>"}, redactedEvent.Keys.ToSlice())
+	// keys of event are not changed
+	assert.Equal(t, 3, event.Keys.Len())
+
+	var nilRedactor *keyRedactor
+	assert.Equal(t, event, nilRedactor.redactEvent(event))
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyReservoir keeps a uniform sample of latencies added since last reset.
+type latencyReservoir struct {
+	mutex     sync.Mutex
+	size      int
+	count     int64
+	latencies []time.Duration
+	random    *rand.Rand
+}
+
+func newLatencyReservoir(size int) *latencyReservoir {
+	return &latencyReservoir{
+		size:      size,
+		latencies: make([]time.Duration, 0, size),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (reservoir *latencyReservoir) add(latency time.Duration) {
+	reservoir.mutex.Lock()
+	defer reservoir.mutex.Unlock()
+	reservoir.count++
+	if len(reservoir.latencies) < reservoir.size {
+		reservoir.latencies = append(reservoir.latencies, latency)
+		return
+	}
+	if index := reservoir.random.Int63n(reservoir.count); index < int64(reservoir.size) {
+		reservoir.latencies[index] = latency
+	}
+}
+
+// percentilesAndReset returns latency of each percentile in [0, 100],
+// ok is false if no latency is added since last reset.
+func (reservoir *latencyReservoir) percentilesAndReset(percentiles ...float64) ([]time.Duration, bool) {
+	reservoir.mutex.Lock()
+	latencies := reservoir.latencies
+	reservoir.latencies = make([]time.Duration, 0, reservoir.size)
+	reservoir.count = 0
+	reservoir.mutex.Unlock()
+
+	if len(latencies) == 0 {
+		return nil, false
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	results := make([]time.Duration, 0, len(percentiles))
+	for _, percentile := range percentiles {
+		index := int(percentile / 100 * float64(len(latencies)-1))
+		results = append(results, latencies[index])
+	}
+	return results, true
+}
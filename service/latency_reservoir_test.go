@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyReservoir(t *testing.T) {
+	reservoir := newLatencyReservoir(1000)
+	_, ok := reservoir.percentilesAndReset(50)
+	assert.False(t, ok)
+
+	for i := 100; i >= 1; i-- {
+		reservoir.add(time.Duration(i) * time.Millisecond)
+	}
+	results, ok := reservoir.percentilesAndReset(0, 50, 99, 100)
+	assert.True(t, ok)
+	assert.Equal(t, []time.Duration{
+		time.Millisecond, 50 * time.Millisecond, 99 * time.Millisecond, 100 * time.Millisecond,
+	}, results)
+
+	_, ok = reservoir.percentilesAndReset(50)
+	assert.False(t, ok)
+
+	// reservoir size is limited
+	reservoir = newLatencyReservoir(10)
+	for i := 0; i < 1000; i++ {
+		reservoir.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 10, len(reservoir.latencies))
+	assert.Equal(t, int64(1000), reservoir.count)
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytepower_room/base"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// roomLease is held by one instance at a time, other instances can acquire it after it expires.
+// It is used to run background work in only one of the instances.
+type roomLease struct {
+	tableName struct{} `pg:"_"`
+
+	Name      string    `pg:"name,pk"`
+	Holder    string    `pg:"holder"`
+	ExpiresAt time.Time `pg:"expires_at"`
+	CreatedAt time.Time `pg:"created_at"`
+	UpdatedAt time.Time `pg:"updated_at"`
+	Version   int64     `pg:"version"`
+}
+
+func (model *roomLease) ShardingKey() string {
+	return model.Name
+}
+
+func (model *roomLease) GetTablePrefix() string {
+	return "room_lease"
+}
+
+// NewLeaseHolder returns holder name of current process, it is unique among instances.
+func NewLeaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// TryAcquireLease acquires lease for duration since t if it is not held by others or expired,
+// lease already held by holder is renewed. False is returned if lease is held by others.
+func TryAcquireLease(ctx context.Context, dbCluster *base.DBCluster, name, holder string, duration time.Duration, t time.Time) (bool, error) {
+	model := &roomLease{Name: name}
+	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(model)
+	if err != nil {
+		return false, err
+	}
+	acquired := false
+	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		err := tx.ModelContext(ctx, model).Table(tableName).WherePK().Select()
+		if err != nil && !errors.Is(err, pg.ErrNoRows) {
+			return err
+		}
+		if err != nil && errors.Is(err, pg.ErrNoRows) {
+			model = &roomLease{
+				Name:      name,
+				Holder:    holder,
+				ExpiresAt: t.Add(duration),
+				CreatedAt: t,
+				UpdatedAt: t,
+				Version:   0,
+			}
+			if _, err = tx.ModelContext(ctx, model).Table(tableName).Insert(); err != nil {
+				return err
+			}
+			acquired = true
+			return nil
+		}
+		if model.Holder != holder && t.Before(model.ExpiresAt) {
+			return nil
+		}
+		result, err := tx.ModelContext(ctx, model).Table(tableName).
+			Set("holder=?", holder).
+			Set("expires_at=?", t.Add(duration)).
+			Set("updated_at=?", t).
+			Set("version=?", model.Version+1).
+			WherePK().
+			Where("version=?", model.Version).
+			Update()
+		if err != nil {
+			return err
+		}
+		acquired = result.RowsAffected() == 1
+		return nil
+	})
+	// lease is acquired by others at the same time
+	if isRetryErrorForUpdateInTx(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return acquired, nil
+}
+
+// RenewLease extends lease held by holder for duration since t,
+// false is returned if lease is acquired by others after it expired.
+func RenewLease(ctx context.Context, dbCluster *base.DBCluster, name, holder string, duration time.Duration, t time.Time) (bool, error) {
+	model := &roomLease{Name: name}
+	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(model)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.ModelContext(ctx, model).Table(tableName).
+		Set("expires_at=?", t.Add(duration)).
+		Set("updated_at=?", t).
+		Set("version=version+1").
+		WherePK().
+		Where("holder=?", holder).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ReleaseLease releases lease held by holder, so that others can acquire it before it expires.
+func ReleaseLease(ctx context.Context, dbCluster *base.DBCluster, name, holder string) error {
+	model := &roomLease{Name: name}
+	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(model)
+	if err != nil {
+		return err
+	}
+	_, err = db.ModelContext(ctx, model).Table(tableName).
+		WherePK().
+		Where("holder=?", holder).
+		Delete()
+	return err
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"bytepower_room/base"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDeleteLeaseInDB(name string) {
+	db := base.GetServerDependency().DB
+	model := &roomLease{Name: name}
+	query, _ := db.Model(model)
+	query.WherePK().ForceDelete()
+}
+
+func TestLease(t *testing.T) {
+	db := base.GetServerDependency().DB
+	ctx := context.Background()
+	name := "test_lease"
+	testDeleteLeaseInDB(name)
+	defer testDeleteLeaseInDB(name)
+
+	now := time.Now()
+	acquired, err := TryAcquireLease(ctx, db, name, "a", time.Minute, now)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	// lease held by others can not be acquired or renewed
+	acquired, err = TryAcquireLease(ctx, db, name, "b", time.Minute, now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	renewed, err := RenewLease(ctx, db, name, "b", time.Minute, now.Add(time.Second))
+	assert.Nil(t, err)
+	assert.False(t, renewed)
+
+	// holder renews lease
+	acquired, err = TryAcquireLease(ctx, db, name, "a", time.Minute, now.Add(30*time.Second))
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	renewed, err = RenewLease(ctx, db, name, "a", time.Minute, now.Add(40*time.Second))
+	assert.Nil(t, err)
+	assert.True(t, renewed)
+	acquired, err = TryAcquireLease(ctx, db, name, "b", time.Minute, now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+
+	// expired lease is acquired by others
+	acquired, err = TryAcquireLease(ctx, db, name, "b", time.Minute, now.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	renewed, err = RenewLease(ctx, db, name, "a", time.Minute, now.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, renewed)
+
+	// released lease is acquired before expired
+	assert.Nil(t, ReleaseLease(ctx, db, name, "a"))
+	acquired, err = TryAcquireLease(ctx, db, name, "a", time.Minute, now.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Nil(t, ReleaseLease(ctx, db, name, "b"))
+	acquired, err = TryAcquireLease(ctx, db, name, "a", time.Minute, now.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+}
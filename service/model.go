@@ -223,9 +223,12 @@ type roomHashTagKeys struct {
 	AccessedAt time.Time         `pg:"accessed_at"`
 	WrittenAt  time.Time         `pg:"written_at"`
 	SyncedAt   time.Time         `pg:"synced_at"`
+	ExpiresAt  time.Time         `pg:"expires_at"`
 	CreatedAt  time.Time         `pg:"created_at"`
 	UpdatedAt  time.Time         `pg:"updated_at"`
 	Status     HashTagKeysStatus `pg:"status"`
+	DC         string            `pg:"dc"`
+	EventID    string            `pg:"event_id"`
 	Version    int64             `pg:"version"`
 }
 
@@ -288,9 +291,22 @@ func (model *roomHashTagKeys) updateFromEvent(event base.HashTagEvent) []string
 		toBeUpdatedColumns = append(toBeUpdatedColumns, "keys")
 	}
 
+	// ttl is set by the latest expire or write event
+	if !event.ExpireTime.IsZero() && !event.ExpireTime.Equal(model.ExpiresAt) && !event.AccessTime.Before(model.AccessedAt) {
+		model.ExpiresAt = event.ExpireTime
+		toBeUpdatedColumns = append(toBeUpdatedColumns, "expires_at")
+	}
 	if event.AccessTime.After(model.AccessedAt) {
 		model.AccessedAt = event.AccessTime
 		toBeUpdatedColumns = append(toBeUpdatedColumns, "accessed_at")
+		if event.DC != "" && event.DC != model.DC {
+			model.DC = event.DC
+			toBeUpdatedColumns = append(toBeUpdatedColumns, "dc")
+		}
+		if event.ID != "" && event.ID != model.EventID {
+			model.EventID = event.ID
+			toBeUpdatedColumns = append(toBeUpdatedColumns, "event_id")
+		}
 	}
 	if event.WriteTime.After(model.WrittenAt) {
 		model.WrittenAt = event.WriteTime
@@ -311,63 +327,150 @@ func (model *roomHashTagKeys) updateFromEvent(event base.HashTagEvent) []string
 }
 
 func upsertHashTagKeysRecordByEvent(ctx context.Context, dbCluster *base.DBCluster, event base.HashTagEvent, currentTime time.Time) error {
-	model := &roomHashTagKeys{HashTag: event.HashTag}
-	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(model)
+	_, err := _upsertHashTagKeysRecordByEvent(ctx, dbCluster, event, currentTime)
+	return err
+}
+
+// _upsertHashTagKeysRecordByEvent returns the record saved in db.
+func _upsertHashTagKeysRecordByEvent(ctx context.Context, dbCluster *base.DBCluster, event base.HashTagEvent, currentTime time.Time) (*roomHashTagKeys, error) {
+	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(&roomHashTagKeys{HashTag: event.HashTag})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var model *roomHashTagKeys
 	err = db.RunInTransaction(ctx, func(tx *pg.Tx) error {
-		err := tx.Model(model).Table(tableName).WherePK().Select()
-		if err != nil && !errors.Is(err, pg.ErrNoRows) {
-			return err
+		model, err = upsertHashTagKeysRecordInTx(tx, tableName, event, currentTime)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func upsertHashTagKeysRecordInTx(tx *pg.Tx, tableName string, event base.HashTagEvent, currentTime time.Time) (*roomHashTagKeys, error) {
+	model := &roomHashTagKeys{HashTag: event.HashTag}
+	err := tx.Model(model).Table(tableName).WherePK().Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, err
+	}
+	// Insert new row
+	if err != nil && errors.Is(err, pg.ErrNoRows) {
+		model = &roomHashTagKeys{
+			HashTag:    event.HashTag,
+			Keys:       event.Keys.ToSlice(),
+			AccessedAt: event.AccessTime,
+			ExpiresAt:  event.ExpireTime,
+			DC:         event.DC,
+			EventID:    event.ID,
+			CreatedAt:  currentTime,
+			UpdatedAt:  currentTime,
+			Version:    0,
 		}
-		// Insert new row
-		if err != nil && errors.Is(err, pg.ErrNoRows) {
-			model = &roomHashTagKeys{
-				HashTag:    event.HashTag,
-				Keys:       event.Keys.ToSlice(),
-				AccessedAt: event.AccessTime,
-				CreatedAt:  currentTime,
-				UpdatedAt:  currentTime,
-				Version:    0,
-			}
-			if !event.WriteTime.IsZero() {
-				model.WrittenAt = event.WriteTime
-			}
-			if event.Keys.Len() == 0 && event.WriteTime.IsZero() {
-				model.Status = HashTagKeysStatusSynced
-			} else {
-				model.Status = HashTagKeysStatusNeedSynced
-			}
-			_, err = tx.Model(model).Table(tableName).Insert()
-			return err
+		if !event.WriteTime.IsZero() {
+			model.WrittenAt = event.WriteTime
 		}
-		// update
-		originVersion := model.Version
-		toBeUpdatedColumns := model.updateFromEvent(event)
-		if len(toBeUpdatedColumns) == 0 {
-			return nil
+		if event.Keys.Len() == 0 && event.WriteTime.IsZero() {
+			model.Status = HashTagKeysStatusSynced
+		} else {
+			model.Status = HashTagKeysStatusNeedSynced
 		}
-		model.Version = model.Version + 1
-		model.UpdatedAt = currentTime
-		toBeUpdatedColumns = append(toBeUpdatedColumns, "version", "updated_at")
-		query := tx.Model(model).Table(tableName)
-		for _, column := range toBeUpdatedColumns {
-			query.Column(column)
+		if _, err = tx.Model(model).Table(tableName).Insert(); err != nil {
+			return nil, err
 		}
-		result, err := query.WherePK().Where("version=?", originVersion).Update()
+		return model, nil
+	}
+	// update
+	originVersion := model.Version
+	toBeUpdatedColumns := model.updateFromEvent(event)
+	if len(toBeUpdatedColumns) == 0 {
+		return model, nil
+	}
+	model.Version = model.Version + 1
+	model.UpdatedAt = currentTime
+	toBeUpdatedColumns = append(toBeUpdatedColumns, "version", "updated_at")
+	query := tx.Model(model).Table(tableName)
+	for _, column := range toBeUpdatedColumns {
+		query.Column(column)
+	}
+	result, err := query.WherePK().Where("version=?", originVersion).Update()
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected() != 1 {
+		return nil, errNoRowsUpdated
+	}
+	return model, nil
+}
+
+// upsertHashTagKeysRecordsByEvents upserts records of events in one transaction per db client,
+// events of the same hash tag are upserted in order. Every event is upserted in a savepoint,
+// so a failed event does not roll back others. Records and errors are returned by index of events,
+// all events of a db client fail with the error if its transaction fails.
+func upsertHashTagKeysRecordsByEvents(ctx context.Context, dbCluster *base.DBCluster, events []base.HashTagEvent, currentTime time.Time) ([]*roomHashTagKeys, []error) {
+	models := make([]*roomHashTagKeys, len(events))
+	errs := make([]error, len(events))
+	type indexedEvent struct {
+		index     int
+		tableName string
+	}
+	dbs := make([]*pg.DB, 0)
+	dbEvents := make(map[*pg.DB][]indexedEvent)
+	for index, event := range events {
+		tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(&roomHashTagKeys{HashTag: event.HashTag})
 		if err != nil {
-			return err
+			errs[index] = err
+			continue
 		}
-		if result.RowsAffected() != 1 {
-			return errNoRowsUpdated
+		if _, ok := dbEvents[db]; !ok {
+			dbs = append(dbs, db)
 		}
-		return nil
-	})
+		dbEvents[db] = append(dbEvents[db], indexedEvent{index: index, tableName: tableName})
+	}
+	for _, db := range dbs {
+		err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+			for _, e := range dbEvents[db] {
+				if _, err := tx.Exec("SAVEPOINT upsert_event"); err != nil {
+					return err
+				}
+				model, err := upsertHashTagKeysRecordInTx(tx, e.tableName, events[e.index], currentTime)
+				if err != nil {
+					errs[e.index] = err
+					if _, err := tx.Exec("ROLLBACK TO SAVEPOINT upsert_event"); err != nil {
+						return err
+					}
+					continue
+				}
+				models[e.index] = model
+				if _, err := tx.Exec("RELEASE SAVEPOINT upsert_event"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			for _, e := range dbEvents[db] {
+				models[e.index] = nil
+				errs[e.index] = err
+			}
+		}
+	}
+	return models, errs
+}
+
+// deleteHashTagKeysRecordByEvent removes the record of a deleted hash tag,
+// records accessed after the deletion are kept.
+func deleteHashTagKeysRecordByEvent(ctx context.Context, dbCluster *base.DBCluster, event base.HashTagEvent) error {
+	model := &roomHashTagKeys{HashTag: event.HashTag}
+	tableName, db, err := dbCluster.GetTableNameAndDBClientByModel(model)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = db.ModelContext(ctx, model).Table(tableName).
+		WherePK().
+		Where("accessed_at <= ?", event.DeleteTime).
+		Delete()
+	return err
 }
 
 type dbWhereCondition struct {
@@ -410,3 +513,35 @@ func loadHashTagKeysModelsByCondition(db *base.DBCluster, count int, startIndex
 	}
 	return shardingCount, nil, nil
 }
+
+// loadHashTagKeysModelsByHashTags loads records table by table,
+// at most pageSize hash tags are queried in one query.
+func loadHashTagKeysModelsByHashTags(ctx context.Context, db *base.DBCluster, hashTags []string, pageSize int) ([]*roomHashTagKeys, error) {
+	hashTagsByIndex := make(map[int][]string)
+	for _, hashTag := range hashTags {
+		index := db.GetShardingIndex(hashTag)
+		hashTagsByIndex[index] = append(hashTagsByIndex[index], hashTag)
+	}
+	tablePrefix := (&roomHashTagKeys{}).GetTablePrefix()
+	models := make([]*roomHashTagKeys, 0, len(hashTags))
+	for index := 0; index < db.GetShardingCount(); index++ {
+		tags := hashTagsByIndex[index]
+		for start := 0; start < len(tags); start += pageSize {
+			end := start + pageSize
+			if end > len(tags) {
+				end = len(tags)
+			}
+			var pageModels []*roomHashTagKeys
+			query, err := db.Models(&pageModels, tablePrefix, index)
+			if err != nil {
+				return nil, err
+			}
+			err = query.Context(ctx).Where("hash_tag in (?)", pg.In(tags[start:end])).Select()
+			if err != nil && !errors.Is(err, pg.ErrNoRows) {
+				return nil, err
+			}
+			models = append(models, pageModels...)
+		}
+	}
+	return models, nil
+}
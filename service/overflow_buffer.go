@@ -0,0 +1,166 @@
+package service
+
+import (
+	"bufio"
+	"bytepower_room/base"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// overflowBuffer keeps events added when event buffer is full, events are popped in the order they are pushed.
+type overflowBuffer interface {
+	// push returns false if buffer is full
+	push(event base.HashTagEvent) (bool, error)
+	// pop returns false if buffer is empty
+	pop() (base.HashTagEvent, bool, error)
+	len() int
+	close() error
+}
+
+func newOverflowBuffer(config base.CollectEventServiceOverflowBufferConfig, directory string) (overflowBuffer, error) {
+	switch config.Backing {
+	case base.OverflowBufferBackingDisk:
+		return newDiskOverflowBuffer(directory, config.Limit)
+	default:
+		return newMemoryOverflowBuffer(config.Limit), nil
+	}
+}
+
+type memoryOverflowBuffer struct {
+	events chan base.HashTagEvent
+}
+
+func newMemoryOverflowBuffer(limit int) *memoryOverflowBuffer {
+	return &memoryOverflowBuffer{events: make(chan base.HashTagEvent, limit)}
+}
+
+func (buffer *memoryOverflowBuffer) push(event base.HashTagEvent) (bool, error) {
+	select {
+	case buffer.events <- event:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (buffer *memoryOverflowBuffer) pop() (base.HashTagEvent, bool, error) {
+	select {
+	case event := <-buffer.events:
+		return event, true, nil
+	default:
+		return base.HashTagEvent{}, false, nil
+	}
+}
+
+func (buffer *memoryOverflowBuffer) len() int {
+	return len(buffer.events)
+}
+
+func (buffer *memoryOverflowBuffer) close() error {
+	return nil
+}
+
+// diskOverflowBuffer appends events to a file and reads them from the beginning,
+// the file is truncated when all events are read.
+type diskOverflowBuffer struct {
+	name  string
+	limit int
+
+	mutex      sync.Mutex
+	writeFile  *os.File
+	readFile   *os.File
+	reader     *bufio.Reader
+	eventCount int
+}
+
+func newDiskOverflowBuffer(directory string, limit int) (*diskOverflowBuffer, error) {
+	name := filepath.Join(directory, fmt.Sprintf("overflow_event_%d.queue", os.Getpid()))
+	writeFile, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	readFile, err := os.Open(name)
+	if err != nil {
+		writeFile.Close()
+		return nil, err
+	}
+	return &diskOverflowBuffer{
+		name:      name,
+		limit:     limit,
+		writeFile: writeFile,
+		readFile:  readFile,
+		reader:    bufio.NewReader(readFile),
+	}, nil
+}
+
+func (buffer *diskOverflowBuffer) push(event base.HashTagEvent) (bool, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if buffer.eventCount >= buffer.limit {
+		return false, nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	if _, err = buffer.writeFile.Write(append(line, '\n')); err != nil {
+		return false, err
+	}
+	buffer.eventCount++
+	return true, nil
+}
+
+func (buffer *diskOverflowBuffer) pop() (base.HashTagEvent, bool, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	var event base.HashTagEvent
+	if buffer.eventCount == 0 {
+		return event, false, nil
+	}
+	line, err := buffer.reader.ReadBytes('\n')
+	if err != nil {
+		return event, false, err
+	}
+	buffer.eventCount--
+	if buffer.eventCount == 0 {
+		if err := buffer.reset(); err != nil {
+			return event, false, err
+		}
+	}
+	if err = json.Unmarshal(line, &event); err != nil {
+		return event, false, err
+	}
+	return event, true, nil
+}
+
+func (buffer *diskOverflowBuffer) reset() error {
+	if err := buffer.writeFile.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := buffer.writeFile.Seek(0, 0); err != nil {
+		return err
+	}
+	if _, err := buffer.readFile.Seek(0, 0); err != nil {
+		return err
+	}
+	buffer.reader.Reset(buffer.readFile)
+	return nil
+}
+
+func (buffer *diskOverflowBuffer) len() int {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.eventCount
+}
+
+func (buffer *diskOverflowBuffer) close() error {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	buffer.readFile.Close()
+	if err := buffer.writeFile.Close(); err != nil {
+		return err
+	}
+	return os.Remove(buffer.name)
+}
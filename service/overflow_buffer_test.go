@@ -0,0 +1,49 @@
+package service
+
+import (
+	"bytepower_room/base"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowBuffer(t *testing.T) {
+	diskBuffer, err := newDiskOverflowBuffer(t.TempDir(), 3)
+	assert.Nil(t, err)
+	buffers := map[string]overflowBuffer{
+		base.OverflowBufferBackingMemory: newMemoryOverflowBuffer(3),
+		base.OverflowBufferBackingDisk:   diskBuffer,
+	}
+	accessTime := time.Now().UTC().Truncate(time.Millisecond)
+	for backing, buffer := range buffers {
+		_, ok, err := buffer.pop()
+		assert.Nil(t, err)
+		assert.False(t, ok, backing)
+
+		for round := 0; round < 2; round++ {
+			for i := 0; i < 4; i++ {
+				event, _ := base.NewHashTagEvent(fmt.Sprintf("tag%d", i), []string{fmt.Sprintf("{tag%d}a", i)}, base.HashTagAccessModeWrite, accessTime)
+				ok, err := buffer.push(event)
+				assert.Nil(t, err)
+				// the 4th event is not pushed since buffer is full
+				assert.Equal(t, i < 3, ok, backing)
+			}
+			assert.Equal(t, 3, buffer.len(), backing)
+			for i := 0; i < 3; i++ {
+				event, ok, err := buffer.pop()
+				assert.Nil(t, err)
+				assert.True(t, ok, backing)
+				assert.Equal(t, fmt.Sprintf("tag%d", i), event.HashTag, backing)
+				assert.Equal(t, []string{fmt.Sprintf("{tag%d}a", i)}, event.Keys.ToSlice(), backing)
+				assert.True(t, accessTime.Equal(event.WriteTime), backing)
+			}
+			_, ok, err = buffer.pop()
+			assert.Nil(t, err)
+			assert.False(t, ok, backing)
+			assert.Equal(t, 0, buffer.len(), backing)
+		}
+		assert.Nil(t, buffer.close(), backing)
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytepower_room/base/log"
+	"bytepower_room/commands"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/redcon"
+)
+
+var errSubscribeInMulti = errors.New("ERR SUBSCRIBE inside MULTI is not allowed")
+
+var subscriptionManager = SubscriptionManager{
+	connSubscriptionMap: make(map[redcon.Conn]*subscribingConn),
+	mutex:               &sync.Mutex{},
+}
+
+// subscribingConn is a connection in subscribe mode, it is detached from redcon server.
+type subscribingConn struct {
+	detachedConn redcon.DetachedConn
+	session      *commands.Session
+	subscription *commands.Subscription
+	// commands received with subscribe command, they are processed in subscribe mode first.
+	cmds []redcon.Command
+}
+
+// SubscriptionManager keeps connections in subscribe mode by their original connections.
+type SubscriptionManager struct {
+	connSubscriptionMap map[redcon.Conn]*subscribingConn
+	mutex               *sync.Mutex
+}
+
+func (manager *SubscriptionManager) addSubscription(conn redcon.Conn, subscribing *subscribingConn) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.connSubscriptionMap[conn] = subscribing
+}
+
+func (manager *SubscriptionManager) getSubscription(conn redcon.Conn) *subscribingConn {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.connSubscriptionMap[conn]
+}
+
+func (manager *SubscriptionManager) removeSubscription(conn redcon.Conn) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	delete(manager.connSubscriptionMap, conn)
+}
+
+func (manager *SubscriptionManager) subscriptionCount() int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return len(manager.connSubscriptionMap)
+}
+
+// enterSubscribeMode detaches conn from redcon server, so messages can be sent to it at any time,
+// cmds are processed in subscribe mode first. Connection stays in subscribe mode until it is closed,
+// even if all channels and patterns are unsubscribed.
+// Detached connection is served after redcon calls connCloseHandler for detach, so it is never closed twice.
+func (service *RoomService) enterSubscribeMode(conn redcon.Conn, session *commands.Session, cmds []redcon.Command) {
+	subscriptionManager.addSubscription(conn, &subscribingConn{
+		detachedConn: conn.Detach(),
+		session:      session,
+		subscription: commands.NewSubscription(service.dep.Redis),
+		cmds:         cmds,
+	})
+	service.dep.Metric.MetricIncrease("pubsub.subscribe_mode")
+	service.dep.Metric.MetricGauge("pubsub.connection.total", subscriptionManager.subscriptionCount())
+}
+
+// serveSubscription is the only writer of detached connection, commands and messages are written in turn.
+func (service *RoomService) serveSubscription(conn redcon.Conn, subscribing *subscribingConn) {
+	detachedConn, session, subscription := subscribing.detachedConn, subscribing.session, subscribing.subscription
+	var err error
+	done := make(chan bool)
+	defer func() {
+		close(done)
+		if closeErr := subscription.Close(); closeErr != nil {
+			service.dep.Metric.MetricIncrease("error.pubsub.close")
+			service.logWithAddressAndPid(log.LevelError, "error.pubsub.close", log.Error(closeErr))
+		}
+		detachedConn.Close()
+		subscriptionManager.removeSubscription(conn)
+		service.dep.Metric.MetricGauge("pubsub.connection.total", subscriptionManager.subscriptionCount())
+		service.connCloseHandler(conn, err)
+	}()
+
+	cmdCh := make(chan redcon.Command)
+	// read error is sent once, reading stops after that.
+	readErrCh := make(chan error, 1)
+	go func() {
+		for _, cmd := range subscribing.cmds {
+			select {
+			case cmdCh <- cmd:
+			case <-done:
+				return
+			}
+		}
+		for {
+			cmd, err := detachedConn.ReadCommand()
+			if err != nil {
+				readErrCh <- err
+				return
+			}
+			select {
+			case cmdCh <- cmd:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case cmd := <-cmdCh:
+			quit := service.processSubscriptionCommand(detachedConn, session, subscription, cmd)
+			if err = detachedConn.Flush(); err != nil || quit {
+				return
+			}
+		case message := <-subscription.Messages():
+			service.dep.Metric.MetricIncrease("pubsub.message")
+			writeDataToConnection(detachedConn, commands.ConvertMessageToRESPData(message), session.ProtocolVersion())
+			if err = detachedConn.Flush(); err != nil {
+				return
+			}
+		case err = <-readErrCh:
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+// processSubscriptionCommand returns true if client quits.
+func (service *RoomService) processSubscriptionCommand(conn redcon.Conn, session *commands.Session, subscription *commands.Subscription, cmd redcon.Command) bool {
+	args := make([]string, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		args = append(args, string(arg))
+	}
+	if len(args) > 0 && strings.ToLower(args[0]) == "quit" {
+		conn.WriteString("OK")
+		return true
+	}
+	service.dep.Metric.MetricIncrease("pubsub.receive.command")
+	command, err := commands.ParseCommand(commands.RewriteCommandKeys(args))
+	if err != nil {
+		writeDataToConnection(conn, commands.ConvertErrorToRESPData(err), session.ProtocolVersion())
+		return false
+	}
+	for _, result := range subscription.Process(contextTODO, command) {
+		writeDataToConnection(conn, result, session.ProtocolVersion())
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"bytepower_room/base"
+)
+
+var errRecentEventsNotKept = errors.New("recent events are not kept, recent_event_count is 0")
+
+// RecentEvent is an accepted event with the time it is accepted.
+type RecentEvent struct {
+	Event      base.HashTagEvent `json:"event"`
+	AcceptedAt time.Time         `json:"accepted_at"`
+}
+
+// RecentEventsResponse has the latest accepted events, newest first.
+type RecentEventsResponse struct {
+	Events []RecentEvent `json:"events"`
+	Size   int           `json:"size"`
+}
+
+// recentEventRing keeps copies of the latest accepted events,
+// oldest event is overwritten when ring is full.
+type recentEventRing struct {
+	mutex  sync.Mutex
+	events []RecentEvent
+	next   int
+	count  int
+}
+
+func newRecentEventRing(size int) *recentEventRing {
+	return &recentEventRing{events: make([]RecentEvent, size)}
+}
+
+// add is safe to call on nil ring, events are copied before lock is held.
+func (ring *recentEventRing) add(events []base.HashTagEvent, acceptedAt time.Time) {
+	if ring == nil || len(events) == 0 {
+		return
+	}
+	size := len(ring.events)
+	if len(events) > size {
+		events = events[len(events)-size:]
+	}
+	copies := make([]base.HashTagEvent, 0, len(events))
+	for _, event := range events {
+		copies = append(copies, event.Copy())
+	}
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	for _, event := range copies {
+		ring.events[ring.next] = RecentEvent{Event: event, AcceptedAt: acceptedAt}
+		ring.next = (ring.next + 1) % size
+		if ring.count < size {
+			ring.count++
+		}
+	}
+}
+
+// list returns at most limit events, newest first.
+func (ring *recentEventRing) list(limit int) []RecentEvent {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	if limit > ring.count {
+		limit = ring.count
+	}
+	size := len(ring.events)
+	events := make([]RecentEvent, 0, limit)
+	for i := 1; i <= limit; i++ {
+		event := ring.events[(ring.next-i+size)%size]
+		event.Event = event.Event.Copy()
+		events = append(events, event)
+	}
+	return events
+}
+
+func (service *CollectEventService) recentEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	if !service.checkAdminRequest(writer, request, http.MethodGet, "recent_events") {
+		return
+	}
+	if service.recentEvents == nil {
+		if err := writeErrorResponse(writer, http.StatusNotFound, errRecentEventsNotKept); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	limit, err := parseAdminLimit(request)
+	if err != nil {
+		if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	response := RecentEventsResponse{
+		Events: service.recentEvents.list(limit),
+		Size:   len(service.recentEvents.events),
+	}
+	if err = writeResponse(writer, http.StatusOK, response); err != nil {
+		service.recordWriteResponseError(err, []byte{})
+	}
+}
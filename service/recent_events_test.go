@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bytepower_room/base"
+	"bytepower_room/utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentEventRing(t *testing.T) {
+	ring := newRecentEventRing(3)
+	now := time.Now()
+	events := make([]base.HashTagEvent, 0)
+	for _, hashTag := range []string{"a", "b", "c", "d"} {
+		events = append(events, base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: now})
+	}
+	ring.add(events[:1], now)
+	assert.Equal(t, 1, len(ring.list(10)))
+
+	ring.add(events[1:], now)
+	recentEvents := ring.list(10)
+	assert.Equal(t, 3, len(recentEvents))
+	for i, hashTag := range []string{"d", "c", "b"} {
+		assert.Equal(t, hashTag, recentEvents[i].Event.HashTag)
+	}
+	assert.Equal(t, 2, len(ring.list(2)))
+
+	// more events than size are added at once
+	ring.add(events, now)
+	recentEvents = ring.list(10)
+	assert.Equal(t, "d", recentEvents[0].Event.HashTag)
+	assert.Equal(t, "b", recentEvents[2].Event.HashTag)
+
+	// events kept are copies
+	events[3].Keys.Add("{d}a")
+	assert.Equal(t, 0, ring.list(1)[0].Event.Keys.Len())
+
+	var nilRing *recentEventRing
+	nilRing.add(events, now)
+}
+
+func TestRecentEventsHandler(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.Server.AdminToken = "secret"
+
+	request := httptest.NewRequest(http.MethodGet, "/debug/recent_events", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	service.recentEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	service.recentEvents = newRecentEventRing(10)
+	request = httptest.NewRequest(http.MethodGet, "/debug/recent_events", nil)
+	recorder = httptest.NewRecorder()
+	service.recentEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	events := make([]base.HashTagEvent, 0)
+	for _, hashTag := range []string{"a", "b", "c"} {
+		events = append(events, base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: time.Now()})
+	}
+	service.recentEvents.add(events, time.Now())
+	request = httptest.NewRequest(http.MethodGet, "/debug/recent_events?limit=2", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	service.recentEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response RecentEventsResponse
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, 2, len(response.Events))
+	assert.Equal(t, "c", response.Events[0].Event.HashTag)
+	assert.Equal(t, 10, response.Size)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errRequestQueueFull    = errors.New("too many concurrent requests, queue is full, retry later")
+	errRequestQueueTimeout = errors.New("too many concurrent requests, timeout in queue, retry later")
+)
+
+// requestConcurrencyLimiter bounds requests executing at the same time,
+// at most queueSize requests wait for a slot, each at most queueTimeout.
+// Nil limiter allows everything.
+type requestConcurrencyLimiter struct {
+	slots        chan struct{}
+	queueSize    int64
+	queueTimeout time.Duration
+	queued       int64
+}
+
+func newRequestConcurrencyLimiter(maxRequests int, queueSize int, queueTimeout time.Duration) *requestConcurrencyLimiter {
+	return &requestConcurrencyLimiter{
+		slots:        make(chan struct{}, maxRequests),
+		queueSize:    int64(queueSize),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire gets a slot, release should be called after request is done if error is nil.
+func (limiter *requestConcurrencyLimiter) acquire(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+	select {
+	case limiter.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&limiter.queued, 1) > limiter.queueSize {
+		atomic.AddInt64(&limiter.queued, -1)
+		return errRequestQueueFull
+	}
+	defer atomic.AddInt64(&limiter.queued, -1)
+	timer := time.NewTimer(limiter.queueTimeout)
+	defer timer.Stop()
+	select {
+	case limiter.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errRequestQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (limiter *requestConcurrencyLimiter) release() {
+	if limiter == nil {
+		return
+	}
+	<-limiter.slots
+}
+
+func (limiter *requestConcurrencyLimiter) inFlightCount() int64 {
+	return int64(len(limiter.slots))
+}
+
+func (limiter *requestConcurrencyLimiter) queuedCount() int64 {
+	return atomic.LoadInt64(&limiter.queued)
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestConcurrencyLimiter(t *testing.T) {
+	var limiter *requestConcurrencyLimiter
+	assert.Nil(t, limiter.acquire(context.Background()))
+	limiter.release()
+
+	limiter = newRequestConcurrencyLimiter(1, 1, 50*time.Millisecond)
+	assert.Nil(t, limiter.acquire(context.Background()))
+	assert.Equal(t, int64(1), limiter.inFlightCount())
+
+	// request waits in queue and times out
+	assert.Equal(t, errRequestQueueTimeout, limiter.acquire(context.Background()))
+	assert.Equal(t, int64(0), limiter.queuedCount())
+
+	// request in queue gets slot after release
+	acquired := make(chan error)
+	go func() {
+		acquired <- limiter.acquire(context.Background())
+	}()
+	for limiter.queuedCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// queue is full
+	assert.Equal(t, errRequestQueueFull, limiter.acquire(context.Background()))
+	limiter.release()
+	assert.Nil(t, <-acquired)
+	assert.Equal(t, int64(1), limiter.inFlightCount())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, limiter.acquire(ctx))
+
+	limiter.release()
+	assert.Equal(t, int64(0), limiter.inFlightCount())
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// retryBudget is a token bucket shared by all retries,
+// tokens are refilled at ratePerSecond up to ratePerSecond.
+type retryBudget struct {
+	mutex         sync.Mutex
+	ratePerSecond int
+	tokens        float64
+	last          time.Time
+}
+
+func newRetryBudget(ratePerSecond int) *retryBudget {
+	return &retryBudget{
+		ratePerSecond: ratePerSecond,
+		tokens:        float64(ratePerSecond),
+		last:          time.Now(),
+	}
+}
+
+// allow takes a token without blocking, false is returned if budget is exhausted.
+func (budget *retryBudget) allow(t time.Time) bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	if t.After(budget.last) {
+		budget.tokens += t.Sub(budget.last).Seconds() * float64(budget.ratePerSecond)
+		if budget.tokens > float64(budget.ratePerSecond) {
+			budget.tokens = float64(budget.ratePerSecond)
+		}
+		budget.last = t
+	}
+	if budget.tokens < 1 {
+		return false
+	}
+	budget.tokens--
+	return true
+}
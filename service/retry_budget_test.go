@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(2)
+	now := budget.last
+	assert.True(t, budget.allow(now))
+	assert.True(t, budget.allow(now))
+	assert.False(t, budget.allow(now))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, budget.allow(now))
+	assert.False(t, budget.allow(now))
+
+	// tokens are not more than rate
+	now = now.Add(time.Minute)
+	assert.True(t, budget.allow(now))
+	assert.True(t, budget.allow(now))
+	assert.False(t, budget.allow(now))
+}
@@ -5,57 +5,177 @@ import (
 	"bytepower_room/base"
 	"bytepower_room/base/log"
 	"bytepower_room/utility"
+	"bytes"
 	"context"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 
 	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/go-pg/pg/v10"
 	"go.uber.org/ratelimit"
 )
 
 const (
-	HTTPHeaderContentType = "Content-Type"
-	HTTPContentTypeJSON   = "application/json"
-	eventFilePrefix       = "collect_event"
+	HTTPHeaderContentType  = "Content-Type"
+	HTTPContentTypeJSON    = "application/json"
+	HTTPContentTypeForm    = "application/x-www-form-urlencoded"
+	formEventKey           = "event"
+	HTTPHeaderIdempotency  = "Idempotency-Key"
+	HTTPHeaderForwardedFor = "X-Forwarded-For"
+	HTTPHeaderAckURL       = "X-Room-Ack-URL"
+	eventFilePrefix        = "collect_event"
 )
 
 const (
 	metricEventCountInEventBuffer          = "event_in_buffer.total"
 	metricEventBufferMemoryUsage           = "event_buffer_memory_usage.total"
+	metricEventCountInHighPriorityBuffer   = "event_in_high_priority_buffer.total"
 	metricEventCountInCollectedEventBuffer = "event_in_collected_buffer.total"
 	metricCollectedEventBufferMemoryUsage  = "collected_event_buffer_memory_usage.total"
 	metricAggregatedEventCount             = "aggregated_event.total"
 	metricAggregatedEventMemoryUsage       = "aggregated_event_memory_usage.total"
 	metricEventFileCount                   = "event_file.total"
 	metricRequestBodyLength                = "request_body_length.total"
+	metricMergeRatio                       = "merge_ratio"
+	metricSaveLatency                      = "save_latency"
+	metricSaveRateLimit                    = "save_db.rate_limit"
+	metricPausedShardCount                 = "paused_shard.total"
+	metricEventCountInOverflowBuffer       = "event_in_overflow_buffer.total"
+	metricOldestBufferedEventAge           = "oldest_buffered_age"
+	metricDBPool                           = "db_pool"
+	metricOverloaded                       = "overloaded"
+	metricAckPendingCount                  = "ack.pending"
+	metricStuckWorkers                     = "stuck_workers"
+	metricInFlightRequests                 = "add_event.in_flight_requests"
+	metricQueuedRequests                   = "add_event.queued_requests"
 )
 
+var saveLatencyPercentiles = []float64{50, 95, 99}
+
 type CollectEventService struct {
 	config *base.RoomCollectEventConfig
 
-	eventBuffer             chan base.HashTagEvent
+	eventBuffer EventBuffer
+	// events in eventBuffer except high priority events of priority buffer
 	eventCountInEventBuffer int64
+	// enqueueMutex is held for reading while events are enqueued and for writing when buffers are closed,
+	// so no event is sent to a closed buffer during shutdown.
+	enqueueMutex      sync.RWMutex
+	eventBufferClosed bool
+	// enqueue time in unix nanoseconds of the event dequeued last, events in buffer are enqueued after it,
+	// so it is the upper bound of enqueue time of the oldest event in buffer. The same for other buffers.
+	eventBufferHeadTime int64
+
+	// high priority events in eventBuffer if buffer strategy is priority
+	eventCountInHighPriorityEventBuffer int64
+	highPriorityEventBufferHeadTime     int64
 
 	mutex  sync.Mutex
 	events map[string]base.HashTagEvent
+	// events of paused shards are kept in events until shards are resumed
+	pausedShards map[int]bool
+	// merge count of events since they are collected, for finding hot tags
+	mergeCounts map[string]int
+	// hot tags are not collected again within interval since hotTagCollectedAt
+	hotTagCollectedAt map[string]time.Time
+
+	// nil if events are not sampled when buffer is overloaded
+	overloadSampler *eventSampler
+
+	// nil if latency is not sampled
+	saveLatencyReservoir *latencyReservoir
+
+	// nil if recent accepted events are not kept
+	recentEvents *recentEventRing
+
+	// nil if records are not cached
+	recordCache *hashTagKeysRecordCache
+
+	// nil if events of deleted hash tags are not filtered
+	deletedTags *deletedTagSet
+
+	saveLocks tagSaveLocks
+
+	// nil if requests of clients are not limited
+	clientRateLimiter *clientRateLimiter
+
+	// nil if concurrent requests are not limited
+	requestLimiter *requestConcurrencyLimiter
+
+	// nil if keys are saved as they are
+	keyRedactor *keyRedactor
+
+	// nil if requests are not audited
+	auditSink *auditSink
+
+	// nil if ack url of requests is ignored
+	ackTracker *ackTracker
+	ackResults chan AckResult
+
+	// hash tags of events not saved to db yet
+	bufferedTags *bufferedTagIndex
+
+	// nil if error counts are not kept
+	errorWindow *errorWindow
+
+	// nil if retries are not limited by a shared budget
+	saveRetryBudget *retryBudget
+
+	// nil if rate limit of saving events to db is fixed
+	saveRateLimiter *adaptiveRateLimiter
+
+	// nil if events read from files are not reused
+	eventPool *sync.Pool
+
+	// monitor intervals in a row with buffer depth above warning ratio
+	bufferWarningTicks int
+
+	// 1 if requests are rejected for overload
+	overloaded int32
+
+	// nil if workers are not checked for stuck
+	workerHeartbeats *workerHeartbeats
+
+	// holder of leases shared with other instances
+	leaseHolder string
+
+	// db pool stats of last monitor interval, for counts in interval
+	lastDBPoolStats map[string]base.DBPoolStats
+
+	// replaced when stats are reset
+	currentStatsCounter *collectEventStatsCounter
+	statsMutex          sync.RWMutex
+
+	// counted in monitor interval for merge ratio
+	aggregatedEventCount int64
+	mergedEventCount     int64
 
 	collectedEventBuffer             chan base.HashTagEvent
 	eventCountInCollectedEventBuffer int64
 
-	logger *log.Logger
-	metric *base.MetricClient
-	db     *base.DBCluster
+	logger     *log.Logger
+	logSampler *log.Sampler
+	metric     *base.MetricClient
+	db         *base.DBCluster
 
 	wg     sync.WaitGroup
 	stopCh chan bool
@@ -64,6 +184,15 @@ type CollectEventService struct {
 	server                 *http.Server
 	serverRequestCtxCancel context.CancelFunc
 
+	idempotencyCache *idempotencyCache
+
+	// onSaved is called with saved events in a separate goroutine
+	onSaved          func([]base.HashTagEvent)
+	savedEventBuffer chan base.HashTagEvent
+
+	// empty if all events passing checks are accepted
+	acceptFuncs []AcceptFunc
+
 	file *EventFile
 }
 
@@ -88,22 +217,35 @@ func NewCollectEventService(
 	if err != nil {
 		return nil, fmt.Errorf("new event file error %w", err)
 	}
+	if config.ServiceLog.Level != "" {
+		logger = logger.WithLevel(log.MakeLevelWithName(config.ServiceLog.Level))
+	}
+	var logSampler *log.Sampler
+	if sampling := config.ServiceLog.Sampling; sampling.Enabled {
+		logSampler = log.NewSampler(sampling.Interval, sampling.First, sampling.Thereafter)
+	}
 	logger.Info("create event file", log.String("name", file.Name()))
 	service := &CollectEventService{
 		config: config,
 
-		eventBuffer:             make(chan base.HashTagEvent, config.BufferLimit),
 		eventCountInEventBuffer: 0,
 
-		mutex:  sync.Mutex{},
-		events: make(map[string]base.HashTagEvent),
+		mutex:        sync.Mutex{},
+		events:       make(map[string]base.HashTagEvent),
+		pausedShards: make(map[int]bool),
+		mergeCounts:  make(map[string]int),
+
+		hotTagCollectedAt: make(map[string]time.Time),
+
+		bufferedTags: newBufferedTagIndex(),
 
 		collectedEventBuffer:             make(chan base.HashTagEvent, config.BufferLimit),
 		eventCountInCollectedEventBuffer: 0,
 
-		logger: logger,
-		metric: metric,
-		db:     db,
+		logger:     logger,
+		logSampler: logSampler,
+		metric:     metric,
+		db:         db,
 
 		wg:     sync.WaitGroup{},
 		stopCh: make(chan bool),
@@ -114,23 +256,136 @@ func NewCollectEventService(
 
 	go service.file.StartFileRotation()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/events", service.postEventsHandler)
+	mux := service.newServeMux()
+	var handler http.Handler = mux
+	if config.Server.GzipMinBytes > 0 {
+		handler = service.gzipHandler(mux, config.Server.GzipMinBytes)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	server := &http.Server{
 		Addr:         service.config.Server.URL,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  time.Duration(service.config.Server.ReadTimeoutMS) * time.Millisecond,
 		WriteTimeout: time.Duration(service.config.Server.WriteTimeoutMS) * time.Millisecond,
 		IdleTimeout:  time.Duration(service.config.Server.IdleTimeoutMS) * time.Millisecond,
 		BaseContext:  func(_ net.Listener) context.Context { return ctx },
+		ConnContext:  saveConnInContext,
+
+		ReadHeaderTimeout: time.Duration(service.config.Server.ReadHeaderTimeoutMS) * time.Millisecond,
 	}
 	service.server = server
 	service.serverRequestCtxCancel = cancel
+	service.currentStatsCounter = &collectEventStatsCounter{}
+	if service.eventBuffer, err = newEventBuffer(config, service.metric, service.recordError); err != nil {
+		return nil, err
+	}
+	// events left in disk overflow buffer by last process
+	service.eventCountInEventBuffer = int64(service.eventBuffer.Len())
+	if config.ErrorWindow.Window > 0 {
+		service.errorWindow = newErrorWindow(config.ErrorWindow.Window, config.ErrorWindow.BucketCount, config.ErrorWindow.MaxReasonCount)
+	}
+	if config.LatencyReservoirSize > 0 {
+		service.saveLatencyReservoir = newLatencyReservoir(config.LatencyReservoirSize)
+	}
+	if config.RecentEventCount > 0 {
+		service.recentEvents = newRecentEventRing(config.RecentEventCount)
+	}
+	if config.RecordCache.Size > 0 {
+		service.recordCache = newHashTagKeysRecordCache(config.RecordCache.Size, config.RecordCache.TTL)
+	}
+	if config.DeletedTagFilter.Size > 0 {
+		service.deletedTags = newDeletedTagSet(config.DeletedTagFilter.Size, config.DeletedTagFilter.TTL)
+	}
+	service.leaseHolder = NewLeaseHolder()
+	if config.WorkerStaleThreshold > 0 {
+		service.workerHeartbeats = newWorkerHeartbeats([]string{workerSaveEventsToFile, workerSaveEventsToDB}, time.Now())
+	}
+	keyRedactor, err := newKeyRedactor(config.KeyRedaction)
+	if err != nil {
+		return nil, fmt.Errorf("new key redactor error %w", err)
+	}
+	service.keyRedactor = keyRedactor
+	if len(config.Audit.Log) > 0 {
+		auditLogger, err := base.NewLoggerFromConfig("room.collect_event.audit", config.Audit.Log)
+		if err != nil {
+			return nil, fmt.Errorf("new audit logger error %w", err)
+		}
+		service.auditSink = newAuditSink(auditLogger, config.Audit.BufferSize)
+	}
+	if config.ClientRateLimit.RequestsPerSecond > 0 {
+		service.clientRateLimiter = newClientRateLimiter(
+			config.ClientRateLimit.RequestsPerSecond, config.ClientRateLimit.Burst, config.ClientRateLimit.MaxClients)
+	}
+	if config.ConcurrencyLimit.MaxRequests > 0 {
+		service.requestLimiter = newRequestConcurrencyLimiter(
+			config.ConcurrencyLimit.MaxRequests, config.ConcurrencyLimit.QueueSize, config.ConcurrencyLimit.QueueTimeout)
+	}
+	if config.Ack.MaxPending > 0 {
+		service.ackTracker = newAckTracker(config.Ack.MaxPending, config.Ack.Timeout)
+		service.ackResults = make(chan AckResult, config.Ack.MaxPending)
+	}
+	if config.SaveDB.RetryBudgetPerSecond > 0 {
+		service.saveRetryBudget = newRetryBudget(config.SaveDB.RetryBudgetPerSecond)
+	}
+	if config.SaveDB.AdaptiveTargetLatencyMS > 0 {
+		service.saveRateLimiter = newAdaptiveRateLimiter(
+			config.SaveDB.AdaptiveMinRateLimitPerSecond, config.SaveDB.RateLimitPerSecond,
+			time.Duration(config.SaveDB.AdaptiveTargetLatencyMS)*time.Millisecond)
+	}
+	if config.SaveDB.PoolEvents {
+		service.eventPool = &sync.Pool{
+			New: func() interface{} {
+				return &base.HashTagEvent{}
+			},
+		}
+	}
+	if sampling := config.OverloadSampling; sampling.BufferRatio > 0 {
+		service.overloadSampler = newEventSampler(sampling.Window, sampling.MinEventsPerTag, sampling.HotTagKeepRatio)
+	}
+	if config.Server.IdempotencyCacheSize > 0 {
+		service.idempotencyCache = newIdempotencyCache(config.Server.IdempotencyCacheSize, config.Server.IdempotencyKeyTTL)
+	}
 
 	return service, nil
 }
 
+// newServeMux registers /events and optional endpoints not in server.disabled_endpoints,
+// requests to disabled endpoints get 404.
+func (service *CollectEventService) newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", service.postEventsHandler)
+	optionalHandlers := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{path: "/stats/reset", handler: service.resetStatsHandler},
+		{path: "/events/status", handler: service.getEventStatusHandler},
+		{path: "/debug/buffer", handler: service.debugBufferHandler},
+		{path: "/debug/recent_events", handler: service.recentEventsHandler},
+	}
+	for _, optionalHandler := range optionalHandlers {
+		if isEndpointDisabled(service.config.Server.DisabledEndpoints, optionalHandler.path) {
+			service.logger.Info("endpoint is disabled", log.String("path", optionalHandler.path))
+			continue
+		}
+		mux.HandleFunc(optionalHandler.path, optionalHandler.handler)
+	}
+	return mux
+}
+
+// isEndpointDisabled matches path with disabled endpoints, "/debug/*" matches all paths under "/debug/".
+func isEndpointDisabled(disabledEndpoints []string, path string) bool {
+	for _, endpoint := range disabledEndpoints {
+		if endpoint == path {
+			return true
+		}
+		if strings.HasSuffix(endpoint, "/*") && strings.HasPrefix(path, strings.TrimSuffix(endpoint, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
 func (service *CollectEventService) Config() *base.RoomCollectEventConfig {
 	return service.config
 }
@@ -153,6 +408,118 @@ func (service *CollectEventService) Run() {
 
 	service.wg.Add(1)
 	go service.mointor(service.config.MonitorInterval)
+
+	if service.config.SelfTest.Enabled {
+		service.wg.Add(1)
+		go service.selfTest(service.config.SelfTest.Interval)
+	}
+
+	if service.onSaved != nil {
+		service.wg.Add(1)
+		go service.callOnSaved()
+	}
+
+	if service.ackTracker != nil {
+		service.wg.Add(1)
+		go service.sendAcks()
+	}
+
+	if service.auditSink != nil {
+		service.wg.Add(1)
+		go service.writeAuditRecords()
+	}
+}
+
+// SetOnSaved sets callback for events saved to db, it should be called before Run.
+// Callback is called in a separate goroutine, events are discarded if callback is too slow.
+func (service *CollectEventService) SetOnSaved(callback func([]base.HashTagEvent)) {
+	service.onSaved = callback
+	service.savedEventBuffer = make(chan base.HashTagEvent, service.config.BufferLimit)
+}
+
+// AcceptFunc decides whether event of request is accepted, reason is returned to client if it is rejected.
+type AcceptFunc func(event base.HashTagEvent) (bool, string)
+
+// AddAcceptFunc adds fn consulted for every event of requests after checks, it should be called before Run.
+// Events are accepted only if all funcs accept them, funcs are called in the order they are added.
+func (service *CollectEventService) AddAcceptFunc(fn AcceptFunc) {
+	service.acceptFuncs = append(service.acceptFuncs, fn)
+}
+
+var errEventRejected = errors.New("event is rejected")
+
+func (service *CollectEventService) checkEventAccepted(event base.HashTagEvent) error {
+	for _, accept := range service.acceptFuncs {
+		if ok, reason := accept(event); !ok {
+			return fmt.Errorf("%w, %s", errEventRejected, reason)
+		}
+	}
+	return nil
+}
+
+func (service *CollectEventService) addSavedEvent(event base.HashTagEvent) {
+	if service.savedEventBuffer == nil {
+		return
+	}
+	select {
+	case service.savedEventBuffer <- event:
+	default:
+		service.recordError("on_saved.discard", nil, map[string]string{"event": service.eventLogString(event)})
+	}
+}
+
+const onSavedBatchSize = 100
+
+func (service *CollectEventService) callOnSaved() {
+	jobName := "call on saved"
+	defer func() {
+		service.logger.Info(
+			fmt.Sprintf("stop %s", jobName),
+			log.String("time", time.Now().String()),
+		)
+		service.wg.Done()
+	}()
+	service.logger.Info(
+		fmt.Sprintf("start %s", jobName),
+		log.String("time", time.Now().String()),
+	)
+	for {
+		select {
+		case event := <-service.savedEventBuffer:
+			service.safeCallOnSaved(service.collectSavedEvents(event, onSavedBatchSize))
+		case <-service.stopCh:
+			return
+		}
+	}
+}
+
+func (service *CollectEventService) collectSavedEvents(event base.HashTagEvent, count int) []base.HashTagEvent {
+	events := []base.HashTagEvent{event}
+	for len(events) < count {
+		select {
+		case event = <-service.savedEventBuffer:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+func (service *CollectEventService) safeCallOnSaved(events []base.HashTagEvent) {
+	startTime := time.Now()
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			service.recordError(
+				"on_saved.panic",
+				fmt.Errorf("%v", panicInfo),
+				map[string]string{"stack": string(debug.Stack())},
+			)
+			service.metric.Flush()
+		}
+	}()
+	service.onSaved(events)
+	service.recordSuccessWithDuration("on_saved", time.Since(startTime))
 }
 
 func (service *CollectEventService) startServer() {
@@ -188,17 +555,40 @@ func (service *CollectEventService) aggregateEvents() {
 
 	for {
 		select {
-		case event := <-service.eventBuffer:
-			atomic.AddInt64(&service.eventCountInEventBuffer, -1)
-			if err := service.aggregateEvent(event); err != nil {
-				service.recordError("agg_event", err, map[string]string{"event": event.String()})
-			}
+		case event := <-service.eventBuffer.Dequeue():
+			service.eventDequeued(event)
+			service.aggregateEventAndRecordError(event)
 		case <-service.stopCh:
 			return
 		}
 	}
 }
 
+// isHighPriorityEvent returns true if event is kept in high priority buffer of priority buffer strategy.
+func (service *CollectEventService) isHighPriorityEvent(event base.HashTagEvent) bool {
+	buffer, ok := service.eventBuffer.(*priorityEventBuffer)
+	return ok && buffer.isHighPriority(event)
+}
+
+// eventDequeued updates count and head time of buffer after event is dequeued.
+func (service *CollectEventService) eventDequeued(event base.HashTagEvent) {
+	counter, headTime := &service.eventCountInEventBuffer, &service.eventBufferHeadTime
+	if service.isHighPriorityEvent(event) {
+		counter, headTime = &service.eventCountInHighPriorityEventBuffer, &service.highPriorityEventBufferHeadTime
+	}
+	atomic.AddInt64(counter, -1)
+	// events left in disk by process of an old version have no enqueue time
+	if !event.EnqueueTime.IsZero() {
+		atomic.StoreInt64(headTime, event.EnqueueTime.UnixNano())
+	}
+}
+
+func (service *CollectEventService) aggregateEventAndRecordError(event base.HashTagEvent) {
+	if err := service.aggregateEvent(event); err != nil {
+		service.recordError("agg_event", err, map[string]string{"event": service.eventLogString(event)})
+	}
+}
+
 func (service *CollectEventService) aggregateEvent(event base.HashTagEvent) error {
 	if event.WriteTime.IsZero() {
 		event.Keys = utility.NewStringSet([]string{}...)
@@ -207,11 +597,18 @@ func (service *CollectEventService) aggregateEvent(event base.HashTagEvent) erro
 	defer service.mutex.Unlock()
 	var newEvent base.HashTagEvent
 	var err error
+	atomic.AddInt64(&service.aggregatedEventCount, 1)
 	if savedEvent, ok := service.events[event.HashTag]; ok {
-		newEvent, err = base.MergeEvents(savedEvent, event)
+		newEvent, err = base.MergeEventsWithMode(service.config.EventMergeMode, savedEvent, event)
+		// event is dropped or merged into saved event
+		service.bufferedTags.remove(event.HashTag)
 		if err != nil {
 			return err
 		}
+		atomic.AddInt64(&service.mergedEventCount, 1)
+		if service.config.HotTag.MergeThreshold > 0 {
+			service.mergeCounts[event.HashTag]++
+		}
 	} else {
 		newEvent = event
 	}
@@ -252,13 +649,75 @@ func (service *CollectEventService) collectEvents() []base.HashTagEvent {
 	events := make([]base.HashTagEvent, 0)
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
+	hotTagConfig := service.config.HotTag
+	currentTime := time.Now()
+	hotCount, coalescedHotCount := 0, 0
 	for hashTag, event := range service.events {
+		if len(service.pausedShards) > 0 && service.pausedShards[service.db.GetShardingIndex(hashTag)] {
+			continue
+		}
+		if hotTagConfig.MergeThreshold > 0 && service.mergeCounts[hashTag] >= hotTagConfig.MergeThreshold {
+			if collectedAt, ok := service.hotTagCollectedAt[hashTag]; ok && currentTime.Sub(collectedAt) < hotTagConfig.Interval {
+				coalescedHotCount++
+				continue
+			}
+			service.hotTagCollectedAt[hashTag] = currentTime
+			hotCount++
+		}
+		delete(service.mergeCounts, hashTag)
 		events = append(events, event)
 		delete(service.events, hashTag)
 	}
+	if hotTagConfig.MergeThreshold > 0 {
+		for hashTag, collectedAt := range service.hotTagCollectedAt {
+			if currentTime.Sub(collectedAt) >= hotTagConfig.Interval {
+				delete(service.hotTagCollectedAt, hashTag)
+			}
+		}
+		service.metric.MetricCount("collect_events.hot", hotCount)
+		service.metric.MetricCount("collect_events.hot_coalesced", coalescedHotCount)
+		service.metric.MetricCount("collect_events.cold", len(events)-hotCount)
+	}
 	return events
 }
 
+var errShardOutOfRange = errors.New("shard is out of range")
+
+// PauseShard stops saving events of shard to db, events of shard are still aggregated until shard is resumed.
+// Events already saved to files are not paused.
+func (service *CollectEventService) PauseShard(shard int) error {
+	if shard < 0 || shard >= service.db.GetShardingCount() {
+		return fmt.Errorf("%w, shard %d", errShardOutOfRange, shard)
+	}
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.pausedShards[shard] = true
+	service.logger.Info("pause shard", log.Int("shard", shard))
+	return nil
+}
+
+func (service *CollectEventService) ResumeShard(shard int) error {
+	if shard < 0 || shard >= service.db.GetShardingCount() {
+		return fmt.Errorf("%w, shard %d", errShardOutOfRange, shard)
+	}
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	delete(service.pausedShards, shard)
+	service.logger.Info("resume shard", log.Int("shard", shard))
+	return nil
+}
+
+func (service *CollectEventService) GetPausedShards() []int {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	shards := make([]int, 0, len(service.pausedShards))
+	for shard := range service.pausedShards {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
 func (service *CollectEventService) saveEventsToFile() {
 	jobName := "save events to file"
 	metricMsg := "save_events_to_file"
@@ -276,13 +735,22 @@ func (service *CollectEventService) saveEventsToFile() {
 		log.String("time", time.Now().String()),
 	)
 
+	var heartbeatCh <-chan time.Time
+	if service.workerHeartbeats != nil {
+		heartbeatTicker := time.NewTicker(workerHeartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeatCh = heartbeatTicker.C
+	}
 	for {
+		service.workerHeartbeats.beat(workerSaveEventsToFile, time.Now())
 		select {
+		case <-heartbeatCh:
 		case event := <-service.collectedEventBuffer:
 			atomic.AddInt64(&service.eventCountInCollectedEventBuffer, -1)
 			err := service.file.Write(event)
 			if err != nil {
-				service.recordError(metricMsg, err, map[string]string{"event": event.String()})
+				service.bufferedTags.remove(event.HashTag)
+				service.recordError(metricMsg, err, map[string]string{"event": service.eventLogString(event)})
 			} else {
 				service.recordSuccessWithCount(metricMsg, 1)
 			}
@@ -312,6 +780,7 @@ func (service *CollectEventService) saveEventsToDB() {
 	directory := service.config.SaveFile.FileDirectory
 	interval := 5 * time.Second
 	for {
+		service.workerHeartbeats.beat(workerSaveEventsToDB, time.Now())
 		files, err := listEventFilesInDirectory(directory)
 		if err != nil {
 			service.recordError(metricMsg, err, map[string]string{"dir": directory})
@@ -420,6 +889,24 @@ func isEventFileNeededToProcess(file os.DirEntry, fileAge time.Duration, t time.
 	return info.ModTime().Add(fileAge).Before(t), nil
 }
 
+// newEvent returns an event to read from file, it is taken from pool if events are reused.
+func (service *CollectEventService) newEvent() *base.HashTagEvent {
+	if service.eventPool == nil {
+		return &base.HashTagEvent{}
+	}
+	return service.eventPool.Get().(*base.HashTagEvent)
+}
+
+// releaseEvent puts event back to pool after it is saved, event must not be used after it is released.
+// Events saved are copies of event, so they do not refer to it.
+func (service *CollectEventService) releaseEvent(event *base.HashTagEvent) {
+	if service.eventPool == nil {
+		return
+	}
+	*event = base.HashTagEvent{}
+	service.eventPool.Put(event)
+}
+
 func (service *CollectEventService) _saveEventsFromFileToDB(name, metricMsg string) (int, bool, []error) {
 	var errors []error
 	var successCount int
@@ -440,12 +927,58 @@ func (service *CollectEventService) _saveEventsFromFileToDB(name, metricMsg stri
 		}
 	}()
 	scanner := bufio.NewScanner(file)
-	ratelimitBucket := ratelimit.New(service.config.SaveDB.RateLimitPerSecond)
+	var ratelimitBucket ratelimit.Limiter
+	if service.saveRateLimiter != nil {
+		ratelimitBucket = service.saveRateLimiter
+	} else {
+		ratelimitBucket = ratelimit.New(service.config.SaveDB.RateLimitPerSecond)
+	}
+	batchSize := service.config.SaveDB.BatchSize
+	batchInterval := time.Duration(service.config.SaveDB.BatchIntervalMS) * time.Millisecond
+	// events in batch are saved together, lines are kept to record errors of events.
+	var batch []base.HashTagEvent
+	var batchLines []string
+	// events read for batch are released after batch is saved or abandoned
+	var batchReadEvents []*base.HashTagEvent
+	var batchStartTime time.Time
+	releaseBatch := func() {
+		for _, event := range batchReadEvents {
+			service.releaseEvent(event)
+		}
+		batch, batchLines, batchReadEvents = nil, nil, nil
+	}
+	saveBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		saveStartTime := time.Now()
+		errs := service.saveEvents(batch)
+		if service.saveRateLimiter != nil {
+			service.saveRateLimiter.observe(time.Since(saveStartTime) / time.Duration(len(batch)))
+		}
+		for index, err := range errs {
+			service.bufferedTags.remove(batch[index].HashTag)
+			if err != nil {
+				errors = append(errors, err)
+				service.recordError(
+					fmt.Sprintf("%s.save_event", metricMsg),
+					err,
+					map[string]string{
+						"name":  name,
+						"event": batchLines[index],
+					})
+				continue
+			}
+			successCount += 1
+		}
+		releaseBatch()
+	}
 loop:
 	for scanner.Scan() {
-		var event base.HashTagEvent
-		err := json.Unmarshal(scanner.Bytes(), &event)
+		event := service.newEvent()
+		err := json.Unmarshal(scanner.Bytes(), event)
 		if err != nil {
+			service.releaseEvent(event)
 			errors = append(errors, err)
 			service.recordError(
 				fmt.Sprintf("%s.unmarshal_event", metricMsg),
@@ -459,11 +992,34 @@ loop:
 		}
 		select {
 		case <-service.stopCh:
+			// events in batch are not saved, file is processed again after restart.
+			service.releaseEvent(event)
+			releaseBatch()
 			quit = true
 			break loop
 		default:
+			service.workerHeartbeats.beat(workerSaveEventsToDB, time.Now())
 			ratelimitBucket.Take()
-			if err := service.saveEvent(event); err != nil {
+			if batchSize > 1 {
+				if len(batch) == 0 {
+					batchStartTime = time.Now()
+				}
+				batch = append(batch, *event)
+				batchLines = append(batchLines, scanner.Text())
+				batchReadEvents = append(batchReadEvents, event)
+				if len(batch) >= batchSize || time.Since(batchStartTime) >= batchInterval {
+					saveBatch()
+				}
+				continue
+			}
+			saveStartTime := time.Now()
+			err := service.saveEvent(*event)
+			service.bufferedTags.remove(event.HashTag)
+			service.releaseEvent(event)
+			if service.saveRateLimiter != nil {
+				service.saveRateLimiter.observe(time.Since(saveStartTime))
+			}
+			if err != nil {
 				errors = append(errors, err)
 				service.recordError(
 					fmt.Sprintf("%s.save_event", metricMsg),
@@ -477,6 +1033,9 @@ loop:
 			successCount += 1
 		}
 	}
+	if !quit {
+		saveBatch()
+	}
 	if err := scanner.Err(); err != nil {
 		service.recordError(fmt.Sprintf("%s.scan", metricMsg), err, map[string]string{"name": name})
 		errors = append(errors, err)
@@ -484,45 +1043,322 @@ loop:
 	return successCount, quit, errors
 }
 
+// paths saving events, successes of saving events are counted by path.
+const (
+	savePathAsyncSingle = "async.single"
+	savePathAsyncBatch  = "async.batch"
+	savePathSyncSingle  = "sync.single"
+)
+
 func (service *CollectEventService) saveEvent(event base.HashTagEvent) error {
-	var err error
-	if err = event.Check(); err != nil {
+	return service.saveEventInPath(event, savePathAsyncSingle)
+}
+
+func (service *CollectEventService) saveEventInPath(event base.HashTagEvent, path string) error {
+	event = service.keyRedactor.redactEvent(event)
+	unlock := service.saveLocks.lock(event.HashTag)
+	if service.isEventOfDeletedTag(event) {
+		unlock()
+		return nil
+	}
+	err := service._saveEvent(event)
+	unlock()
+	return service.recordSaveResult(event, path, err)
+}
+
+// SaveEventSync saves event to db without buffering it, error of saving is returned.
+// Saves of a hash tag by SaveEventSync and by saving buffered events never run at the same time,
+// but events are not saved in order of submission. They are safe to save in any order because
+// access times of a record only move forward, a delete keeps records accessed after it,
+// and an event accessed before a saved delete is dropped.
+func (service *CollectEventService) SaveEventSync(event base.HashTagEvent) error {
+	return service.saveEventInPath(event, savePathSyncSingle)
+}
+
+func (service *CollectEventService) isEventOfDeletedTag(event base.HashTagEvent) bool {
+	if !event.IsDelete() && service.deletedTags.isDeletedAt(event.HashTag, event.AccessTime, time.Now()) {
+		service.metric.MetricIncrease("save_event_to_db.deleted_tag_dropped")
+		service.resolveAcks(event, nil)
+		return true
+	}
+	return false
+}
+
+// recordSaveResult returns err after result of saving event in path is recorded.
+func (service *CollectEventService) recordSaveResult(event base.HashTagEvent, path string, err error) error {
+	// results are counted by sharding index of db, so an unhealthy shard can be found.
+	shard := service.db.GetShardingIndex(event.HashTag)
+	if err != nil {
+		service.metric.MetricIncrease(fmt.Sprintf("save_event_to_db.shard_%d.error", shard))
+		service.resolveAcks(event, err)
 		return err
 	}
-	config := service.config.SaveDB
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.TimeoutMS)*time.Millisecond)
-	defer cancel()
-	retryInterval := time.Duration(config.RetryIntervalMS) * time.Millisecond
-	for i := 0; i < config.RetryTimes; i++ {
-		err = upsertHashTagKeysRecordByEvent(ctx, service.db, event, time.Now())
-		if err != nil {
-			if isRetryErrorForUpdateInTx(err) {
-				service.logger.Warn(
-					"save_event_to_db_retry",
-					log.Error(err),
-					log.String("event", event.String()),
-					log.Int("retry_times", i),
-				)
-				service.recordSuccessWithCount("save_event_to_db_retry", 1)
-				time.Sleep(retryInterval)
-				continue
+	service.metric.MetricIncrease(fmt.Sprintf("save_event_to_db.shard_%d.success", shard))
+	service.metric.MetricIncrease(fmt.Sprintf("success.save_event_to_db.%s", path))
+	service.resolveAcks(event, nil)
+	// events in files written before enqueue time is kept have no enqueue time.
+	if service.saveLatencyReservoir != nil && !event.EnqueueTime.IsZero() {
+		service.saveLatencyReservoir.add(time.Since(event.EnqueueTime))
+	}
+	service.statsCounter().addSavedEvent()
+	service.addSavedEvent(event)
+	return nil
+}
+
+// saveEvents returns errors by index of events. Events to upsert records are saved in batch,
+// events deleting records are saved one by one after events before them, so events of a hash tag are saved in order.
+// Hash tags of a batch are locked while it is saved.
+func (service *CollectEventService) saveEvents(events []base.HashTagEvent) []error {
+	errs := make([]error, len(events))
+	upsertEvents := make([]base.HashTagEvent, 0, len(events))
+	upsertIndexes := make([]int, 0, len(events))
+	upsertPendingEvents := func() {
+		if len(upsertEvents) == 0 {
+			return
+		}
+		hashTags := make([]string, 0, len(upsertEvents))
+		for _, event := range upsertEvents {
+			hashTags = append(hashTags, event.HashTag)
+		}
+		unlock := service.saveLocks.lock(hashTags...)
+		// hash tags may be deleted by SaveEventSync before they are locked
+		savingEvents, savingIndexes := upsertEvents[:0], upsertIndexes[:0]
+		for i, event := range upsertEvents {
+			if !service.isEventOfDeletedTag(event) {
+				savingEvents = append(savingEvents, event)
+				savingIndexes = append(savingIndexes, upsertIndexes[i])
 			}
-			return err
 		}
-		break
+		upsertErrs := service.upsertEvents(savingEvents)
+		unlock()
+		for i, index := range savingIndexes {
+			errs[index] = service.recordSaveResult(savingEvents[i], savePathAsyncBatch, upsertErrs[i])
+		}
+		upsertEvents, upsertIndexes = upsertEvents[:0], upsertIndexes[:0]
 	}
-	return err
+	for index, event := range events {
+		if !isBatchUpsertEvent(event) {
+			upsertPendingEvents()
+			errs[index] = service.saveEvent(event)
+			continue
+		}
+		event = service.keyRedactor.redactEvent(event)
+		if err := service.checkEventToSave(event); err != nil {
+			errs[index] = service.recordSaveResult(event, savePathAsyncBatch, err)
+			continue
+		}
+		upsertEvents = append(upsertEvents, event)
+		upsertIndexes = append(upsertIndexes, index)
+	}
+	upsertPendingEvents()
+	return errs
 }
 
-func (service *CollectEventService) mointor(interval time.Duration) {
-	jobName := "mointor"
+// isBatchUpsertEvent returns true if event only upserts record, so it can be saved in batch.
+func isBatchUpsertEvent(event base.HashTagEvent) bool {
+	switch event.AccessMode() {
+	case base.HashTagAccessModeRead, base.HashTagAccessModeWrite, base.HashTagAccessModeExpire:
+		return event.PriorDeleteTime.IsZero()
+	}
+	return false
+}
 
-	ticker := time.NewTicker(interval)
-	defer func() {
-		service.logger.Info(
-			fmt.Sprintf("stop %s", jobName),
-			log.String("time", time.Now().String()),
-		)
+var errEventAgedOut = errors.New("event is too old to save")
+
+var errRetryBudgetExhausted = errors.New("retry budget is exhausted")
+
+func (service *CollectEventService) checkEventToSave(event base.HashTagEvent) error {
+	if err := event.Check(); err != nil {
+		return err
+	}
+	if maxEventAgeMS := service.config.SaveDB.MaxEventAgeMS; maxEventAgeMS > 0 {
+		age := time.Since(event.AccessTime)
+		if age > time.Duration(maxEventAgeMS)*time.Millisecond {
+			service.metric.MetricIncrease("save_event_to_db.aged_out")
+			return fmt.Errorf("%w, age %s", errEventAgedOut, age.String())
+		}
+	}
+	return nil
+}
+
+func (service *CollectEventService) _saveEvent(event base.HashTagEvent) error {
+	var err error
+	if err = service.checkEventToSave(event); err != nil {
+		return err
+	}
+	config := service.config.SaveDB
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	switch mode := event.AccessMode(); mode {
+	case base.HashTagAccessModeDelete:
+		return service.deleteRecord(ctx, event, event.DeleteTime)
+	case base.HashTagAccessModeRead, base.HashTagAccessModeWrite, base.HashTagAccessModeExpire:
+		// record is upserted, expire event updates expires_at of record
+	default:
+		return fmt.Errorf("%w, access_mode is %s", base.ErrEventAccessModeWrong, mode)
+	}
+	// record deleted before the access is removed first, so keys deleted are not unioned into it.
+	if !event.PriorDeleteTime.IsZero() {
+		if err = service.deleteRecord(ctx, event, event.PriorDeleteTime); err != nil {
+			return err
+		}
+	}
+	var model *roomHashTagKeys
+	err = service.saveWithRetry(ctx, event, func(ctx context.Context) error {
+		var upsertErr error
+		model, upsertErr = upsertHashTagKeysRecord(ctx, service.db, event, time.Now())
+		return upsertErr
+	})
+	if err == nil && service.recordCache != nil {
+		service.recordCache.add(newHashTagKeysRecord(model), time.Now())
+	}
+	return err
+}
+
+// deleteRecord removes record of hash tag of event accessed not later than deleteTime.
+func (service *CollectEventService) deleteRecord(ctx context.Context, event base.HashTagEvent, deleteTime time.Time) error {
+	if service.recordCache != nil {
+		service.recordCache.remove(event.HashTag)
+	}
+	deleteEvent := base.HashTagEvent{HashTag: event.HashTag, AccessTime: deleteTime, DeleteTime: deleteTime}
+	err := service.saveWithRetry(ctx, event, func(ctx context.Context) error {
+		return deleteHashTagKeysRecord(ctx, service.db, deleteEvent)
+	})
+	if err != nil {
+		return err
+	}
+	service.deletedTags.add(event.HashTag, deleteTime, time.Now())
+	return nil
+}
+
+// saveWithRetry calls save in attempts until it succeeds, fails with an error not retryable,
+// or retry_times or retry budget is exhausted.
+func (service *CollectEventService) saveWithRetry(ctx context.Context, event base.HashTagEvent, save func(ctx context.Context) error) error {
+	config := service.config.SaveDB
+	retryInterval := time.Duration(config.RetryIntervalMS) * time.Millisecond
+	var err error
+	for i := 0; i < config.RetryTimes; i++ {
+		attemptCtx, cancel := service.attemptContext(ctx)
+		err = save(attemptCtx)
+		cancel()
+		if err == nil || !service.isRetryableSaveError(ctx, err) {
+			return err
+		}
+		if i+1 < config.RetryTimes && service.saveRetryBudget != nil && !service.saveRetryBudget.allow(time.Now()) {
+			service.metric.MetricIncrease("save_event_to_db.retry_budget_exhausted")
+			return fmt.Errorf("%w, %v", errRetryBudgetExhausted, err)
+		}
+		service.logger.Warn(
+			"save_event_to_db_retry",
+			log.Error(err),
+			log.String("event", service.eventLogString(event)),
+			log.Int("retry_times", i),
+		)
+		service.recordSuccessWithCount("save_event_to_db_retry", 1)
+		time.Sleep(retryInterval)
+	}
+	return err
+}
+
+// isRetryableSaveError returns true if saving is retried after err.
+func (service *CollectEventService) isRetryableSaveError(ctx context.Context, err error) bool {
+	// an attempt timed out is retried if there is time left in timeout_ms
+	return isRetryErrorForUpdateInTx(err) ||
+		(service.config.SaveDB.AttemptTimeoutMS > 0 && isTimeoutError(err) && ctx.Err() == nil)
+}
+
+// upsertEvents upserts records of events in batch, events failed with retryable errors are retried together.
+// A retry of the batch costs one token of retry budget. Errors are returned by index of events.
+func (service *CollectEventService) upsertEvents(events []base.HashTagEvent) []error {
+	config := service.config.SaveDB
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	retryInterval := time.Duration(config.RetryIntervalMS) * time.Millisecond
+	errs := make([]error, len(events))
+	pendingIndexes := make([]int, len(events))
+	for index := range events {
+		pendingIndexes[index] = index
+	}
+	for i := 0; i < config.RetryTimes && len(pendingIndexes) > 0; i++ {
+		if i > 0 {
+			if service.saveRetryBudget != nil && !service.saveRetryBudget.allow(time.Now()) {
+				service.metric.MetricIncrease("save_event_to_db.retry_budget_exhausted")
+				for _, index := range pendingIndexes {
+					errs[index] = fmt.Errorf("%w, %v", errRetryBudgetExhausted, errs[index])
+				}
+				break
+			}
+			service.logger.Warn(
+				"save_events_to_db_retry",
+				log.Error(errs[pendingIndexes[0]]),
+				log.Int("count", len(pendingIndexes)),
+				log.Int("retry_times", i-1),
+			)
+			service.recordSuccessWithCount("save_event_to_db_retry", len(pendingIndexes))
+			time.Sleep(retryInterval)
+		}
+		batch := make([]base.HashTagEvent, 0, len(pendingIndexes))
+		for _, index := range pendingIndexes {
+			batch = append(batch, events[index])
+		}
+		models, batchErrs := service.upsertRecordsInAttempt(ctx, batch)
+		retryIndexes := make([]int, 0)
+		for j, index := range pendingIndexes {
+			errs[index] = batchErrs[j]
+			if batchErrs[j] == nil {
+				if service.recordCache != nil {
+					service.recordCache.add(newHashTagKeysRecord(models[j]), time.Now())
+				}
+				continue
+			}
+			if service.isRetryableSaveError(ctx, batchErrs[j]) {
+				retryIndexes = append(retryIndexes, index)
+			}
+		}
+		pendingIndexes = retryIndexes
+	}
+	return errs
+}
+
+// upsertHashTagKeysRecord and deleteHashTagKeysRecord are replaced in tests to simulate db errors.
+var (
+	upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent
+	deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent
+)
+
+// upsertHashTagKeysRecords is replaced in tests to simulate db errors of events in batch.
+var upsertHashTagKeysRecords = upsertHashTagKeysRecordsByEvents
+
+func (service *CollectEventService) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if attemptTimeoutMS := service.config.SaveDB.AttemptTimeoutMS; attemptTimeoutMS > 0 {
+		return context.WithTimeout(ctx, time.Duration(attemptTimeoutMS)*time.Millisecond)
+	}
+	return ctx, func() {}
+}
+
+func (service *CollectEventService) upsertRecordsInAttempt(ctx context.Context, events []base.HashTagEvent) ([]*roomHashTagKeys, []error) {
+	ctx, cancel := service.attemptContext(ctx)
+	defer cancel()
+	return upsertHashTagKeysRecords(ctx, service.db, events, time.Now())
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (service *CollectEventService) mointor(interval time.Duration) {
+	jobName := "mointor"
+
+	ticker := time.NewTicker(interval)
+	defer func() {
+		service.logger.Info(
+			fmt.Sprintf("stop %s", jobName),
+			log.String("time", time.Now().String()),
+		)
 		ticker.Stop()
 		service.wg.Done()
 	}()
@@ -534,18 +1370,314 @@ func (service *CollectEventService) mointor(interval time.Duration) {
 		select {
 		case <-ticker.C:
 			service.recordGauge(metricEventCountInEventBuffer, atomic.LoadInt64(&service.eventCountInEventBuffer))
+			service.checkEventBufferDepth(atomic.LoadInt64(&service.eventCountInEventBuffer))
 			service.recordGauge(metricEventBufferMemoryUsage, int64(reflect.TypeOf(service.eventBuffer).Size()))
+			switch buffer := service.eventBuffer.(type) {
+			case *overflowEventBuffer:
+				service.recordGauge(metricEventCountInOverflowBuffer, int64(buffer.overflowLen()))
+			case *priorityEventBuffer:
+				service.recordGauge(metricEventCountInHighPriorityBuffer, atomic.LoadInt64(&service.eventCountInHighPriorityEventBuffer))
+			}
+			service.recordGauge(metricOldestBufferedEventAge, service.GetOldestBufferedEventAge().Milliseconds())
 			service.recordGauge(metricEventCountInCollectedEventBuffer, atomic.LoadInt64(&service.eventCountInCollectedEventBuffer))
 			service.recordGauge(metricCollectedEventBufferMemoryUsage, int64(reflect.TypeOf(service.collectedEventBuffer).Size()))
 			service.recordGauge(metricAggregatedEventCount, service.GetAggregatedEventCount())
 			service.recordGauge(metricAggregatedEventMemoryUsage, service.GetAggregatedEventMemoryUsage())
 			service.recordGauge(metricEventFileCount, service.GetEventFileCount())
+			service.recordMergeRatio()
+			pausedShards := service.GetPausedShards()
+			if len(pausedShards) > 0 {
+				service.logger.Info("paused shards", log.Any("shards", pausedShards))
+			}
+			service.recordGaugeMetric(metricPausedShardCount, int64(len(pausedShards)))
+			service.recordGaugeMetric(metricOverloaded, int64(atomic.LoadInt32(&service.overloaded)))
+			service.recordSaveLatencyPercentiles()
+			service.recordDBPoolStats()
+			service.recordStuckWorkers()
+			if service.saveRateLimiter != nil {
+				service.recordGauge(metricSaveRateLimit, int64(service.saveRateLimiter.currentLimit()))
+			}
+			if service.requestLimiter != nil {
+				service.recordGauge(metricInFlightRequests, service.requestLimiter.inFlightCount())
+				service.recordGauge(metricQueuedRequests, service.requestLimiter.queuedCount())
+			}
+			service.logger.Info("stats", log.Any("stats", service.GetStats()))
+			if service.errorWindow != nil {
+				service.logger.Info("error counts in window", log.Any("counts", service.GetErrorCounts()))
+			}
+		case <-service.stopCh:
+			return
+		}
+	}
+}
+
+func (service *CollectEventService) recordStuckWorkers() {
+	if service.workerHeartbeats == nil {
+		return
+	}
+	stuckWorkers := service.workerHeartbeats.staleWorkers(service.config.WorkerStaleThreshold, time.Now())
+	if len(stuckWorkers) > 0 {
+		service.logger.Warn("stuck workers", log.Any("workers", stuckWorkers))
+	}
+	service.recordGaugeMetric(metricStuckWorkers, int64(len(stuckWorkers)))
+}
+
+var errSelfTestEventNotSaved = errors.New("self test event is not saved to db")
+
+// selfTest adds an event with reserved hash tag every interval,
+// and checks whether the event added in last round is saved to db.
+func (service *CollectEventService) selfTest(interval time.Duration) {
+	jobName := "self test"
+
+	ticker := time.NewTicker(interval)
+	defer func() {
+		service.logger.Info(
+			fmt.Sprintf("stop %s", jobName),
+			log.String("time", time.Now().String()),
+		)
+		ticker.Stop()
+		service.wg.Done()
+	}()
+	service.logger.Info(
+		fmt.Sprintf("start %s", jobName),
+		log.String("time", time.Now().String()),
+	)
+	var lastAccessTime time.Time
+	leaseHeld := false
+	defer func() {
+		if leaseHeld {
+			service.releaseLease(selfTestLeaseName)
+		}
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			if service.config.SelfTest.LeaseDuration > 0 {
+				held, err := service.tryAcquireLease(selfTestLeaseName, service.config.SelfTest.LeaseDuration)
+				if err != nil {
+					service.recordError("selftest.acquire_lease", err, nil)
+				}
+				leaseHeld = held
+				// event added in last round is not checked by instance without lease
+				if !held {
+					lastAccessTime = time.Time{}
+					continue
+				}
+			}
+			if !lastAccessTime.IsZero() {
+				if err := service.checkSelfTestEvent(lastAccessTime); err != nil {
+					service.recordError(
+						"selftest",
+						err,
+						map[string]string{"access_time": lastAccessTime.String()},
+					)
+				} else {
+					service.recordSuccessWithDuration("selftest", time.Since(lastAccessTime))
+				}
+			}
+			accessTime, err := service.addSelfTestEvent(time.Now())
+			if err != nil {
+				service.recordError("selftest.add_event", err, nil)
+				lastAccessTime = time.Time{}
+				continue
+			}
+			lastAccessTime = accessTime
 		case <-service.stopCh:
 			return
 		}
 	}
 }
 
+const selfTestLeaseName = "collect_event_service.self_test"
+
+func (service *CollectEventService) tryAcquireLease(name string, duration time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(service.config.SaveDB.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	return TryAcquireLease(ctx, service.db, name, service.leaseHolder, duration, time.Now())
+}
+
+func (service *CollectEventService) releaseLease(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(service.config.SaveDB.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	if err := ReleaseLease(ctx, service.db, name, service.leaseHolder); err != nil {
+		service.recordError("release_lease", err, map[string]string{"name": name})
+	}
+}
+
+func (service *CollectEventService) addSelfTestEvent(t time.Time) (time.Time, error) {
+	// db saves time in microseconds
+	accessTime := t.Truncate(time.Millisecond)
+	event, err := base.NewHashTagEvent(service.config.SelfTest.HashTag, nil, base.HashTagAccessModeRead, accessTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	event.DC = service.config.DC
+	return accessTime, service.addEvent(event)
+}
+
+func (service *CollectEventService) checkSelfTestEvent(accessTime time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(service.config.SaveDB.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	model := &roomHashTagKeys{HashTag: service.config.SelfTest.HashTag}
+	tableName, db, err := service.db.GetTableNameAndDBClientByModel(model)
+	if err != nil {
+		return err
+	}
+	if err := db.ModelContext(ctx, model).Table(tableName).WherePK().Select(); err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return errSelfTestEventNotSaved
+		}
+		return err
+	}
+	if model.AccessedAt.Before(accessTime) {
+		return fmt.Errorf("%w, accessed_at %s", errSelfTestEventNotSaved, model.AccessedAt.String())
+	}
+	return nil
+}
+
+func (service *CollectEventService) isSelfTestEvent(event base.HashTagEvent) bool {
+	return service.config.SelfTest.Enabled && event.HashTag == service.config.SelfTest.HashTag
+}
+
+var errServiceOverloaded = errors.New("service is overloaded, retry later")
+
+var errClientRateLimited = errors.New("too many requests from client, retry later")
+
+// isOverloaded enters overload when both event buffer and collected event buffer are above thresholds,
+// and exits overload when event buffer is below exit threshold.
+func (service *CollectEventService) isOverloaded() bool {
+	config := service.config
+	if config.Overload.BufferRatio <= 0 {
+		return false
+	}
+	limit := float64(config.BufferLimit)
+	count := atomic.LoadInt64(&service.eventCountInEventBuffer)
+	if atomic.LoadInt32(&service.overloaded) == 1 {
+		if count >= int64(config.Overload.ExitBufferRatio*limit) {
+			return true
+		}
+		if atomic.CompareAndSwapInt32(&service.overloaded, 1, 0) {
+			service.logger.Info("exit overload", log.Int64("count", count))
+			service.metric.MetricIncrease("overload.exit")
+		}
+		return false
+	}
+	collectedCount := atomic.LoadInt64(&service.eventCountInCollectedEventBuffer)
+	if count < int64(config.Overload.BufferRatio*limit) || collectedCount < int64(config.Overload.CollectedBufferRatio*limit) {
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&service.overloaded, 0, 1) {
+		service.logger.Warn("enter overload", log.Int64("count", count), log.Int64("collected_count", collectedCount))
+		service.metric.MetricIncrease("overload.enter")
+	}
+	return true
+}
+
+// checkEventBufferDepth warns when buffer depth stays above warning ratio,
+// warning is logged every buffer_warning_ticks intervals until depth recovers.
+func (service *CollectEventService) checkEventBufferDepth(count int64) {
+	config := service.config
+	if config.BufferWarningRatio <= 0 {
+		return
+	}
+	threshold := int64(config.BufferWarningRatio * float64(config.BufferLimit))
+	if count <= threshold {
+		if service.bufferWarningTicks >= config.BufferWarningTicks {
+			service.logger.Info("event buffer depth recovers", log.Int64("count", count), log.Int64("threshold", threshold))
+		}
+		service.bufferWarningTicks = 0
+		return
+	}
+	service.bufferWarningTicks++
+	if service.bufferWarningTicks%config.BufferWarningTicks == 0 {
+		service.logger.Warn(
+			"event buffer depth is above threshold",
+			log.Int64("count", count),
+			log.Int64("threshold", threshold),
+			log.Int("limit", config.BufferLimit),
+			log.Int("ticks", service.bufferWarningTicks),
+		)
+		service.metric.MetricIncrease("event_buffer.warning")
+	}
+}
+
+// recordMergeRatio records ratio of events merged into aggregated events since last call.
+func (service *CollectEventService) recordMergeRatio() {
+	total := atomic.SwapInt64(&service.aggregatedEventCount, 0)
+	merged := atomic.SwapInt64(&service.mergedEventCount, 0)
+	if total == 0 {
+		return
+	}
+	ratio := float64(merged) / float64(total)
+	service.logger.Info(
+		metricMergeRatio,
+		log.Any("ratio", ratio),
+		log.Int64("merged", merged),
+		log.Int64("total", total),
+	)
+	service.metric.MetricGauge(metricMergeRatio, ratio)
+}
+
+// recordDBPoolStats records connections of every db client and saturation in percent of pool size,
+// misses and timeouts are counted in monitor interval.
+func (service *CollectEventService) recordDBPoolStats() {
+	lastStats := service.lastDBPoolStats
+	service.lastDBPoolStats = make(map[string]base.DBPoolStats)
+	for _, stats := range service.db.PoolStats() {
+		service.lastDBPoolStats[stats.Name] = stats
+		prefix := fmt.Sprintf("%s.%s", metricDBPool, stats.Name)
+		service.recordGauge(prefix+".in_use", int64(stats.InUseConns))
+		service.recordGauge(prefix+".idle", int64(stats.IdleConns))
+		if stats.PoolSize > 0 {
+			service.recordGaugeMetric(prefix+".saturation", int64(stats.InUseConns)*100/int64(stats.PoolSize))
+		}
+		last := lastStats[stats.Name]
+		service.recordGaugeMetric(prefix+".misses", int64(stats.Misses-last.Misses))
+		service.recordGaugeMetric(prefix+".timeouts", int64(stats.Timeouts-last.Timeouts))
+	}
+}
+
+func (service *CollectEventService) recordSaveLatencyPercentiles() {
+	if service.saveLatencyReservoir == nil {
+		return
+	}
+	latencies, ok := service.saveLatencyReservoir.percentilesAndReset(saveLatencyPercentiles...)
+	if !ok {
+		return
+	}
+	for index, percentile := range saveLatencyPercentiles {
+		metricName := fmt.Sprintf("%s.p%d", metricSaveLatency, int(percentile))
+		service.recordGauge(metricName, latencies[index].Milliseconds())
+	}
+}
+
+// GetOldestBufferedEventAge returns how long the oldest event in event buffers has been waiting for aggregation,
+// 0 means no event is in buffers.
+func (service *CollectEventService) GetOldestBufferedEventAge() time.Duration {
+	var oldest int64
+	headTimes := []int64{
+		bufferHeadTime(&service.eventCountInEventBuffer, &service.eventBufferHeadTime),
+		bufferHeadTime(&service.eventCountInHighPriorityEventBuffer, &service.highPriorityEventBufferHeadTime),
+	}
+	for _, headTime := range headTimes {
+		if headTime > 0 && (oldest == 0 || headTime < oldest) {
+			oldest = headTime
+		}
+	}
+	if oldest == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, oldest))
+}
+
+// bufferHeadTime returns head time of buffer with events counted by counter, 0 if buffer is empty.
+func bufferHeadTime(counter, headTime *int64) int64 {
+	if atomic.LoadInt64(counter) <= 0 {
+		return 0
+	}
+	return atomic.LoadInt64(headTime)
+}
+
 func (service *CollectEventService) GetAggregatedEventCount() int64 {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
@@ -568,20 +1700,61 @@ func (service *CollectEventService) GetEventFileCount() int64 {
 	return int64(len(files))
 }
 
+var errEventBufferClosed = errors.New("event buffer is closed since service is stopping")
+
 func (service *CollectEventService) addEvent(event base.HashTagEvent) error {
 	var err error
 	if err = event.Check(); err != nil {
 		return err
 	}
-	select {
-	case service.eventBuffer <- event:
-		atomic.AddInt64(&service.eventCountInEventBuffer, 1)
-	default:
-		err = fmt.Errorf(
-			"buffer is full with limit %d, event %s is discarded",
-			service.config.BufferLimit, event.String())
+	if service.isSampledOut(event) {
+		service.metric.MetricIncrease("add_event.sampled_out")
+		return nil
 	}
-	return err
+	service.enqueueMutex.RLock()
+	defer service.enqueueMutex.RUnlock()
+	if service.eventBufferClosed {
+		return errEventBufferClosed
+	}
+	counter, headTime := &service.eventCountInEventBuffer, &service.eventBufferHeadTime
+	if service.isHighPriorityEvent(event) {
+		counter, headTime = &service.eventCountInHighPriorityEventBuffer, &service.highPriorityEventBufferHeadTime
+	}
+	// enqueue time is assigned by server, value from client is not trusted
+	event.EnqueueTime = time.Now()
+	if service.eventBuffer.Enqueue(event) {
+		service.bufferedTags.add(event.HashTag)
+		count := atomic.AddInt64(counter, 1)
+		// event is the head of buffer empty before it
+		if count == 1 {
+			atomic.StoreInt64(headTime, event.EnqueueTime.UnixNano())
+		}
+		service.statsCounter().updateEventBufferHighWaterMark(count)
+		return nil
+	}
+	service.statsCounter().addDroppedEvent()
+	return fmt.Errorf(
+		"buffer is full with limit %d, event %s is discarded",
+		service.config.BufferLimit, service.eventLogString(event))
+}
+
+// isSampledOut returns true if read event of hot tag is dropped by sampling when buffer is overloaded.
+func (service *CollectEventService) isSampledOut(event base.HashTagEvent) bool {
+	if service.overloadSampler == nil || event.AccessMode() != base.HashTagAccessModeRead {
+		return false
+	}
+	config := service.config
+	threshold := int64(config.OverloadSampling.BufferRatio * float64(config.BufferLimit))
+	if atomic.LoadInt64(&service.eventCountInEventBuffer) < threshold {
+		return false
+	}
+	return !service.overloadSampler.allow(event.HashTag, service.isHotTag(event.HashTag), time.Now())
+}
+
+func (service *CollectEventService) isHotTag(hashTag string) bool {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	return service.mergeCounts[hashTag] >= service.config.HotTag.MergeThreshold
 }
 
 func (service *CollectEventService) addEvents(events []base.HashTagEvent) error {
@@ -602,6 +1775,10 @@ func (service *CollectEventService) Stop() {
 	}
 }
 
+// stopServer waits active requests to finish within server_shutdown_timeout_seconds,
+// requests still running after that are canceled.
+// Server listens plain HTTP/1.1 without h2c, so there are no HTTP/2 streams to send GOAWAY to,
+// if HTTP/2 is enabled later, Shutdown sends GOAWAY and waits active streams in the same deadline.
 func (service *CollectEventService) stopServer() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(service.config.ServerShutdownTimeoutSeconds)*time.Second)
 	defer cancel()
@@ -616,21 +1793,63 @@ func (service *CollectEventService) stopServer() {
 	service.logger.Info("cancel all server requests with context cancel function")
 }
 
+// DrainTo stops service like Stop, but buffered events are returned instead of being saved,
+// so they can be handed off to another process. Events are aggregated by hash tag.
+// Events already saved to files are not returned, they are left in the directory.
+// Nil is returned if service is already stopped.
+func (service *CollectEventService) DrainTo() []base.HashTagEvent {
+	if !atomic.CompareAndSwapInt32(&service.stop, 0, 1) {
+		return nil
+	}
+	service.stopServer()
+	close(service.stopCh)
+	service.wg.Wait()
+	defer service.closeFile("drain_to")
+
+	service.aggregateBufferedEvents()
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	events := make([]base.HashTagEvent, 0, len(service.events))
+	for hashTag, event := range service.events {
+		events = append(events, event)
+		delete(service.events, hashTag)
+		service.bufferedTags.remove(hashTag)
+	}
+	service.logger.Info("events are drained to caller", log.Int("count", len(events)))
+	return events
+}
+
+func (service *CollectEventService) closeFile(metricMsg string) {
+	if err := service.file.Close(); err != nil {
+		service.recordError(
+			fmt.Sprintf("%s.close_file", metricMsg),
+			err,
+			map[string]string{"name": service.file.Name()},
+		)
+	}
+}
+
+// aggregateBufferedEvents closes buffers and aggregates events left in them, it is called after workers stop.
+func (service *CollectEventService) aggregateBufferedEvents() {
+	// requests still running after server shutdown may add events, they are rejected from now on.
+	service.enqueueMutex.Lock()
+	service.eventBufferClosed = true
+	service.enqueueMutex.Unlock()
+
+	service.closeAndEmptifyChannel(service.collectedEventBuffer, &service.eventCountInCollectedEventBuffer)
+	service.eventBuffer.Close()
+	for event := range service.eventBuffer.Dequeue() {
+		service.eventDequeued(event)
+		service.aggregateEventAndRecordError(event)
+	}
+}
+
 func (service *CollectEventService) drainEvents() {
 	metricMsg := "drain_events"
-	defer func() {
-		if err := service.file.Close(); err != nil {
-			service.recordError(
-				fmt.Sprintf("%s.close_file", metricMsg),
-				err,
-				map[string]string{"name": service.file.Name()},
-			)
-		}
-	}()
+	defer service.closeFile(metricMsg)
 
 	startTime := time.Now()
-	service.closeAndEmptifyChannel(service.collectedEventBuffer, &service.eventCountInCollectedEventBuffer)
-	service.closeAndEmptifyChannel(service.eventBuffer, &service.eventCountInEventBuffer)
+	service.aggregateBufferedEvents()
 
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
@@ -641,7 +1860,7 @@ func (service *CollectEventService) drainEvents() {
 			service.recordError(
 				fmt.Sprintf("%s.save_events_to_file", metricMsg),
 				err,
-				map[string]string{"event": event.String()},
+				map[string]string{"event": service.eventLogString(event)},
 			)
 		} else {
 			service.recordSuccessWithCount(
@@ -654,10 +1873,15 @@ func (service *CollectEventService) drainEvents() {
 
 func (service *CollectEventService) closeAndEmptifyChannel(ch chan base.HashTagEvent, counter *int64) {
 	close(ch)
+	service.emptifyChannel(ch, counter)
+}
+
+// emptifyChannel aggregates events until channel is closed and empty.
+func (service *CollectEventService) emptifyChannel(ch <-chan base.HashTagEvent, counter *int64) {
 	for event := range ch {
 		atomic.AddInt64(counter, -1)
 		if err := service.aggregateEvent(event); err != nil {
-			service.recordError("agg_event", err, map[string]string{"event": event.String()})
+			service.recordError("agg_event", err, map[string]string{"event": service.eventLogString(event)})
 		}
 	}
 }
@@ -689,15 +1913,26 @@ func (service *CollectEventService) recordGaugeMetric(metricName string, count i
 	service.metric.MetricGauge(metricName, count)
 }
 
+// eventLogString is string of event in logs and errors, events with many keys are truncated if configured.
+func (service *CollectEventService) eventLogString(event base.HashTagEvent) string {
+	return event.TruncatedString(service.config.ServiceLog.EventKeyLimit)
+}
+
 func (service *CollectEventService) recordError(reason string, err error, info map[string]string) {
-	logPairs := make([]log.LogPair, 0)
-	for key, value := range info {
-		logPairs = append(logPairs, log.String(key, value))
+	if service.logSampler == nil || service.logSampler.Allow(reason) {
+		logPairs := make([]log.LogPair, 0)
+		for key, value := range info {
+			logPairs = append(logPairs, log.String(key, value))
+		}
+		if err != nil {
+			logPairs = append(logPairs, log.Error(err))
+		}
+		service.logger.Error(reason, logPairs...)
 	}
-	if err != nil {
-		logPairs = append(logPairs, log.Error(err))
+	service.statsCounter().addError()
+	if service.errorWindow != nil {
+		service.errorWindow.add(reason, time.Now())
 	}
-	service.logger.Error(reason, logPairs...)
 
 	errorMetricName := "error"
 	service.metric.MetricIncrease(errorMetricName)
@@ -705,9 +1940,58 @@ func (service *CollectEventService) recordError(reason string, err error, info m
 	service.metric.MetricIncrease(specificErrorMetricName)
 }
 
+// recordRequestError records error with address of client, errors are also counted by bucket of client.
+func (service *CollectEventService) recordRequestError(request *http.Request, reason string, err error, info map[string]string) {
+	client := service.clientAddress(request)
+	if info == nil {
+		info = make(map[string]string)
+	}
+	info["client"] = client
+	info["client_bucket"] = clientMetricName(client)
+	service.recordError(reason, err, info)
+	service.metric.MetricIncrease(fmt.Sprintf("error_by_client.%s", info["client_bucket"]))
+}
+
+// clientAddress returns the first address in X-Forwarded-For header if it is trusted,
+// otherwise host of remote address of request.
+func (service *CollectEventService) clientAddress(request *http.Request) string {
+	if service.config.Server.TrustForwardedFor {
+		if forwardedFor := request.Header.Get(HTTPHeaderForwardedFor); forwardedFor != "" {
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+const clientMetricBucketCount = 64
+
+// clientMetricName returns bucket of client by hash of its address, so count of metric names is bounded
+// however many clients there are. Addresses of a bucket are found in logs by client_bucket.
+func clientMetricName(client string) string {
+	if client == "" {
+		return "unknown"
+	}
+	return fmt.Sprintf("bucket_%d", crc32.ChecksumIEEE([]byte(client))%clientMetricBucketCount)
+}
+
 func (service *CollectEventService) recordWriteResponseError(err error, body []byte) {
 	failedReasonWriteToClient := "write_to_client"
-	service.recordError(failedReasonWriteToClient, err, map[string]string{"body": string(body)})
+	info := map[string]string{"body": string(body)}
+	var partialErr *partialWriteError
+	if errors.As(err, &partialErr) {
+		info["written"] = fmt.Sprintf("%d/%d", partialErr.written, partialErr.total)
+	}
+	switch {
+	case isBrokenPipeError(err):
+		failedReasonWriteToClient = "write_to_client.broken_pipe"
+	case partialErr != nil:
+		failedReasonWriteToClient = "write_to_client.partial"
+	}
+	service.recordError(failedReasonWriteToClient, err, info)
 }
 
 func (service *CollectEventService) recordSuccessWithDuration(metricName string, duration time.Duration) {
@@ -726,85 +2010,566 @@ type CollectEventsRequestBody struct {
 	Events []base.HashTagEvent `json:"events"`
 }
 
+// CollectEventsResponse is the response of accepted events,
+// IDs are in the order of events if server assigns event ids.
+type CollectEventsResponse struct {
+	Count int      `json:"count"`
+	IDs   []string `json:"ids,omitempty"`
+	// shards are db sharding indexes of events in the same order, they are returned for dry run only.
+	DryRun bool  `json:"dry_run,omitempty"`
+	Shards []int `json:"shards,omitempty"`
+}
+
+// isDryRun returns true if request has dry_run=true query,
+// events of dry run request are checked and routed but not added.
+func isDryRun(request *http.Request) bool {
+	dryRun, err := strconv.ParseBool(request.URL.Query().Get("dry_run"))
+	return err == nil && dryRun
+}
+
+func assignEventIDs(events []base.HashTagEvent, t time.Time) ([]string, error) {
+	ids := make([]string, len(events))
+	for i := range events {
+		id, err := utility.GenerateULID(t)
+		if err != nil {
+			return nil, err
+		}
+		events[i].ID = id
+		ids[i] = id
+	}
+	return ids, nil
+}
+
 func (service *CollectEventService) postEventsHandler(writer http.ResponseWriter, request *http.Request) {
 	startTime := time.Now()
 	if request.Method != http.MethodPost {
 		err := fmt.Errorf("method %s is not allowed", request.Method)
-		service.recordError("method_not_allowed", err, nil)
+		service.recordRequestError(request, "method_not_allowed", err, nil)
 		if err = writeErrorResponse(writer, http.StatusMethodNotAllowed, err); err != nil {
 			service.recordWriteResponseError(err, []byte{})
 		}
 		return
 	}
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		service.recordError("read_body", err, nil)
-		if err = writeErrorResponse(writer, http.StatusInternalServerError, err); err != nil {
+	// requests are rejected before body is read, they are counted apart from errors and not logged one by one.
+	if service.isOverloaded() {
+		service.metric.MetricIncrease("add_event.overloaded")
+		writer.Header().Set("Retry-After", strconv.Itoa(service.config.Overload.RetryAfterSeconds))
+		if err := writeErrorResponse(writer, http.StatusServiceUnavailable, errServiceOverloaded); err != nil {
 			service.recordWriteResponseError(err, []byte{})
 		}
 		return
 	}
+	if service.clientRateLimiter != nil {
+		if client := service.clientAddress(request); !service.clientRateLimiter.allow(client, startTime) {
+			clientBucket := clientMetricName(client)
+			if service.logSampler == nil || service.logSampler.Allow("add_event.rate_limited") {
+				service.logger.Warn("add_event.rate_limited", log.String("client", client), log.String("client_bucket", clientBucket))
+			}
+			service.metric.MetricIncrease("add_event.rate_limited")
+			service.metric.MetricIncrease(fmt.Sprintf("add_event.rate_limited_by_client.%s", clientBucket))
+			writer.Header().Set("Retry-After", strconv.Itoa(service.clientRateLimiter.retryAfterSeconds()))
+			if err := writeErrorResponse(writer, http.StatusTooManyRequests, errClientRateLimited); err != nil {
+				service.recordWriteResponseError(err, []byte{})
+			}
+			return
+		}
+	}
+	// slot is held until body is parsed and events are added, so memory of concurrent bodies is bounded.
+	if err := service.requestLimiter.acquire(request.Context()); err != nil {
+		if service.isRequestCanceled(request, "concurrency_limit") {
+			return
+		}
+		service.metric.MetricIncrease("add_event.concurrency_limited")
+		writer.Header().Set("Retry-After", "1")
+		if err = writeErrorResponse(writer, http.StatusTooManyRequests, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	defer service.requestLimiter.release()
+	if service.config.Server.StrictContentType && !isSupportedContentType(request) {
+		err := fmt.Errorf("%w, content type is %q", errUnsupportedContentType, request.Header.Get(HTTPHeaderContentType))
+		service.recordRequestError(request, "unsupported_content_type", err, nil)
+		if err = writeErrorResponse(writer, http.StatusUnsupportedMediaType, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	dryRun := isDryRun(request)
+	idempotencyKey := ""
+	if service.idempotencyCache != nil && !dryRun {
+		idempotencyKey = request.Header.Get(HTTPHeaderIdempotency)
+	}
+	body, err := service.readRequestBody(request, startTime)
 	service.recordGaugeMetric(metricRequestBodyLength, int64(len(body)))
-	requestBodyStruct := CollectEventsRequestBody{}
-	if err = json.Unmarshal(body, &requestBodyStruct); err != nil {
-		service.recordError("unmarshal_body", err, map[string]string{"body": string(body)})
-		if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
-			service.recordWriteResponseError(err, body)
+	// request context is canceled by read deadline of slow body, client is still waiting for response.
+	if !errors.Is(err, errBodyReadTooSlow) && service.isRequestCanceled(request, "read_body") {
+		return
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		reason := "read_body"
+		if errors.Is(err, errRequestBodyTooLarge) {
+			code = http.StatusRequestEntityTooLarge
+			reason = "body_too_large"
+		} else if errors.Is(err, errBodyReadTooSlow) {
+			code = http.StatusRequestTimeout
+			reason = "body_read_too_slow"
+		} else if errors.Is(err, errCorruptGzipBody) {
+			code = http.StatusBadRequest
+			reason = "corrupt_gzip_body"
+		}
+		service.recordRequestError(request, reason, err, nil)
+		if err = writeErrorResponse(writer, code, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
 		}
 		return
 	}
-	events := requestBodyStruct.Events
-	for _, event := range events {
-		if err = event.Check(); err != nil {
-			service.recordError("event_check", err, map[string]string{"event": event.String()})
+	if idempotencyKey != "" {
+		idempotencyKey = idempotencyCacheKey(service.clientAddress(request), idempotencyKey)
+		response, state := service.idempotencyCache.begin(idempotencyKey, body, startTime)
+		switch state {
+		case idempotencyKeyDone:
+			if err = writeSuccessResponse(writer, response); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			service.recordSuccessWithDuration("add_event.idempotent_hit", time.Since(startTime))
+			return
+		case idempotencyKeyInFlight, idempotencyKeyBodyMismatch:
+			code, reason, err := http.StatusConflict, "idempotency_key_in_flight", errIdempotencyKeyInFlight
+			if state == idempotencyKeyBodyMismatch {
+				code, reason, err = http.StatusUnprocessableEntity, "idempotency_key_reused", errIdempotencyKeyReused
+			}
+			service.recordRequestError(request, reason, err, nil)
+			if err = writeErrorResponse(writer, code, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
+		// key is released if request fails, so that client can retry with it
+		defer func() {
+			if idempotencyKey != "" {
+				service.idempotencyCache.abort(idempotencyKey)
+			}
+		}()
+	}
+	var events []base.HashTagEvent
+	if isFormContentType(request) {
+		if events, err = parseFormEvents(body); err != nil {
+			service.recordRequestError(request, "parse_form", err, map[string]string{"body": string(body)})
 			if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
 				service.recordWriteResponseError(err, body)
 			}
 			return
 		}
+	} else {
+		if isJSONArray(body) {
+			err = errRequestBodyIsArray
+			service.recordRequestError(request, "body_is_array", err, map[string]string{"body": string(body)})
+			if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
+		requestBodyStruct := CollectEventsRequestBody{}
+		if err = json.Unmarshal(body, &requestBodyStruct); err != nil {
+			service.recordRequestError(request, "unmarshal_body", err, map[string]string{"body": string(body)})
+			if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
+		events = requestBodyStruct.Events
+	}
+	for i, event := range events {
+		// ages are resolved by time of receipt, so that clock skew of client does not matter
+		err = events[i].ResolveAges(startTime)
+		if err == nil {
+			event = events[i]
+			err = event.Check()
+		}
+		if err == nil && service.config.StrictKeyCheck {
+			err = event.CheckKeysHashTag()
+		}
+		if err == nil && service.isSelfTestEvent(event) {
+			err = errReservedHashTag
+		}
+		reason := "event_check"
+		if err == nil {
+			if err = service.checkEventAccepted(event); err != nil {
+				reason = "event_rejected"
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("events[%d]: %w", i, err)
+			service.recordRequestError(request, reason, err, map[string]string{"event": service.eventLogString(event)})
+			if err = writeErrorResponse(writer, http.StatusBadRequest, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
+		// dc is assigned by server, value from client is not trusted
+		events[i].DC = service.config.DC
+		events[i].ID = ""
+	}
+	response := CollectEventsResponse{Count: len(events)}
+	if service.config.Server.AssignEventID {
+		if response.IDs, err = assignEventIDs(events, startTime); err != nil {
+			service.recordRequestError(request, "assign_event_id", err, nil)
+			if err = writeErrorResponse(writer, http.StatusInternalServerError, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
 	}
 
+	if dryRun {
+		response.DryRun = true
+		response.Shards = make([]int, len(events))
+		for i, event := range events {
+			response.Shards[i] = service.db.GetShardingIndex(event.HashTag)
+		}
+		if err = writeSuccessResponse(writer, response); err != nil {
+			service.recordWriteResponseError(err, body)
+		}
+		service.recordSuccessWithDuration("add_event.dry_run", time.Since(startTime))
+		return
+	}
+	if service.isRequestCanceled(request, "add_event") {
+		return
+	}
+	var ackID int64
+	if ackURL := request.Header.Get(HTTPHeaderAckURL); ackURL != "" && service.ackTracker != nil {
+		code := http.StatusBadRequest
+		err = checkAckURL(ackURL, service.config.Ack.AllowedHosts)
+		if err == nil {
+			code = http.StatusTooManyRequests
+			ackID, err = service.ackTracker.add(ackURL, events, startTime)
+		}
+		if err != nil {
+			service.recordRequestError(request, "ack", err, nil)
+			if err = writeErrorResponse(writer, code, err); err != nil {
+				service.recordWriteResponseError(err, body)
+			}
+			return
+		}
+	}
 	err = service.addEvents(events)
 	if err != nil {
-		service.recordError("add_event", err, map[string]string{"body": string(body)})
-		if err = writeErrorResponse(writer, http.StatusInternalServerError, err); err != nil {
+		if ackID != 0 {
+			service.ackTracker.remove(ackID)
+		}
+		service.recordRequestError(request, "add_event", err, map[string]string{"body": string(body)})
+		code := http.StatusInternalServerError
+		if errors.Is(err, errEventBufferClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		if err = writeErrorResponse(writer, code, err); err != nil {
 			service.recordWriteResponseError(err, body)
 		}
 		return
 	}
-	if err = writeSuccessResponse(writer, len(events)); err != nil {
+	service.recentEvents.add(events, time.Now())
+	service.auditRequest(request, len(events), time.Now())
+	if idempotencyKey != "" {
+		service.idempotencyCache.finish(idempotencyKey, response, time.Now())
+		idempotencyKey = ""
+	}
+	if err = writeSuccessResponse(writer, response); err != nil {
 		service.recordWriteResponseError(err, body)
 	}
 	service.recordSuccessWithDuration("add_event", time.Since(startTime))
 	service.recordSuccessWithCount("add_event.events", len(events))
 }
 
+// isRequestCanceled checks whether client has gone,
+// no response is needed for canceled request.
+func (service *CollectEventService) isRequestCanceled(request *http.Request, stage string) bool {
+	err := request.Context().Err()
+	if err == nil {
+		return false
+	}
+	service.logger.Info("client_canceled", log.String("stage", stage), log.Error(err))
+	service.metric.MetricIncrease("client_canceled")
+	return true
+}
+
+var (
+	errIdempotencyKeyInFlight = errors.New("request with the same idempotency key is being handled")
+	errIdempotencyKeyReused   = errors.New("idempotency key is used by request with different body")
+)
+
+var errUnsupportedContentType = fmt.Errorf("content type should be %s or %s", base.HTTPContentTypeJSON, HTTPContentTypeForm)
+
+// isSupportedContentType accepts json and form media types with parameters e.g. charset.
+func isSupportedContentType(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get(HTTPHeaderContentType))
+	return err == nil && (mediaType == base.HTTPContentTypeJSON || mediaType == HTTPContentTypeForm)
+}
+
+func isFormContentType(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get(HTTPHeaderContentType))
+	return err == nil && mediaType == HTTPContentTypeForm
+}
+
+// parseFormEvents parses events from form body like `event={...}&event={...}`,
+// each event value is an event in json.
+func parseFormEvents(body []byte) ([]base.HashTagEvent, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	events := make([]base.HashTagEvent, 0, len(values[formEventKey]))
+	for _, value := range values[formEventKey] {
+		var event base.HashTagEvent
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			return nil, fmt.Errorf("unmarshal event %s error %w", value, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+var errRequestBodyTooLarge = errors.New("request body is too large")
+
+var errReservedHashTag = errors.New("hash_tag is reserved for self test")
+
+var errRequestBodyIsArray = errors.New(`request body should be a json object like {"events": [...]}, not a json array`)
+
+func isJSONArray(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// readRequestBody stops reading as soon as the body exceeds config.Server.MaxBodyBytes,
+// so oversized bodies are never buffered entirely.
+// Body compressed with gzip is decompressed. startTime is the time request is received.
+func (service *CollectEventService) readRequestBody(request *http.Request, startTime time.Time) ([]byte, error) {
+	config := service.config.Server
+	var reader io.Reader = request.Body
+	if config.MinBodyBytesPerSecond > 0 {
+		var readDeadline time.Time
+		if config.ReadTimeoutMS > 0 {
+			readDeadline = startTime.Add(time.Duration(config.ReadTimeoutMS) * time.Millisecond)
+		}
+		reader = newMinRateBodyReader(
+			reader, getConnFromContext(request.Context()),
+			config.MinBodyBytesPerSecond, time.Duration(config.MinBodyRateGraceMS)*time.Millisecond,
+			startTime, readDeadline)
+	}
+	// max_body_bytes limits decompressed body, so a small gzip body can not expand without limit.
+	if isGzipRequest(request) {
+		gzipReader, err := newGzipBodyReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	}
+	maxBodyBytes := config.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		return ioutil.ReadAll(reader)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(reader, maxBodyBytes+1))
+	if err != nil {
+		return body, err
+	}
+	if int64(len(body)) > maxBodyBytes {
+		return body[:maxBodyBytes], fmt.Errorf("%w, limit is %d bytes", errRequestBodyTooLarge, maxBodyBytes)
+	}
+	return body, nil
+}
+
 func writeErrorResponse(writer http.ResponseWriter, code int, err error) error {
-	writer.Header().Set(HTTPHeaderContentType, HTTPContentTypeJSON)
-	writer.WriteHeader(code)
-	body := map[string]string{"error": err.Error()}
+	return writeResponse(writer, code, map[string]string{"error": err.Error()})
+}
+
+func writeSuccessResponse(writer http.ResponseWriter, response CollectEventsResponse) error {
+	return writeResponse(writer, http.StatusOK, response)
+}
+
+// partialWriteError means response is partially sent to client,
+// nothing more should be written to client since connection is likely broken.
+type partialWriteError struct {
+	written int
+	total   int
+	err     error
+}
+
+func (err *partialWriteError) Error() string {
+	return fmt.Sprintf("partial write %d/%d bytes: %v", err.written, err.total, err.err)
+}
+
+func (err *partialWriteError) Unwrap() error {
+	return err.err
+}
+
+func writeResponse(writer http.ResponseWriter, code int, body interface{}) error {
 	bodyInBytes, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
-	_, err = writer.Write(bodyInBytes)
+	writer.Header().Set(HTTPHeaderContentType, HTTPContentTypeJSON)
+	writer.WriteHeader(code)
+	n, err := writer.Write(bodyInBytes)
+	if n > 0 && n < len(bodyInBytes) {
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return &partialWriteError{written: n, total: len(bodyInBytes), err: err}
+	}
 	return err
 }
 
-func writeSuccessResponse(writer http.ResponseWriter, count int) error {
-	writer.Header().Set(HTTPHeaderContentType, HTTPContentTypeJSON)
-	writer.WriteHeader(http.StatusOK)
-	body := map[string]int{"count": count}
-	bodyInBytes, err := json.Marshal(body)
+func isBrokenPipeError(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+const getRecordsPageSize = 100
+
+// HashTagKeysRecord is the saved state of a hash tag.
+type HashTagKeysRecord struct {
+	HashTag    string            `json:"hash_tag"`
+	Keys       []string          `json:"keys"`
+	AccessedAt time.Time         `json:"accessed_at"`
+	WrittenAt  time.Time         `json:"written_at"`
+	SyncedAt   time.Time         `json:"synced_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+	Status     HashTagKeysStatus `json:"status"`
+	DC         string            `json:"dc"`
+	// EventID is id of the latest event saved to record
+	EventID string `json:"event_id"`
+}
+
+// GetRecords loads records of hash tags from db, hash tags without record are ignored.
+// Records are returned in the order of hash tags.
+func (service *CollectEventService) GetRecords(ctx context.Context, hashTags []string) ([]HashTagKeysRecord, error) {
+	currentTime := time.Now()
+	recordMap := make(map[string]HashTagKeysRecord)
+	toBeLoadedHashTags := make([]string, 0)
+	for _, hashTag := range utility.NewStringSet(hashTags...).ToSlice() {
+		if service.recordCache != nil {
+			if record, ok := service.recordCache.get(hashTag, currentTime); ok {
+				recordMap[hashTag] = record
+				continue
+			}
+		}
+		toBeLoadedHashTags = append(toBeLoadedHashTags, hashTag)
+	}
+	models, err := loadHashTagKeysModelsByHashTags(ctx, service.db, toBeLoadedHashTags, getRecordsPageSize)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = writer.Write(bodyInBytes)
-	return err
+	for _, model := range models {
+		record := newHashTagKeysRecord(model)
+		recordMap[model.HashTag] = record
+		if service.recordCache != nil {
+			service.recordCache.add(record, currentTime)
+		}
+	}
+	records := make([]HashTagKeysRecord, 0, len(recordMap))
+	for _, hashTag := range hashTags {
+		record, ok := recordMap[hashTag]
+		if !ok {
+			continue
+		}
+		delete(recordMap, hashTag)
+		records = append(records, record)
+	}
+	return records, nil
 }
 
+// EventStatus tells whether events of hash tag are buffered and whether hash tag has record in db,
+// events added before buffered is false are saved to db.
+type EventStatus struct {
+	HashTag   string             `json:"hash_tag"`
+	Buffered  bool               `json:"buffered"`
+	Persisted bool               `json:"persisted"`
+	Record    *HashTagKeysRecord `json:"record,omitempty"`
+}
+
+func (service *CollectEventService) GetEventStatus(ctx context.Context, hashTag string) (EventStatus, error) {
+	// buffered is checked before db, so event is not missed when it is saved between the two checks.
+	status := EventStatus{HashTag: hashTag, Buffered: service.bufferedTags.contains(hashTag)}
+	records, err := service.GetRecords(ctx, []string{hashTag})
+	if err != nil {
+		return status, err
+	}
+	if len(records) > 0 {
+		status.Persisted = true
+		status.Record = &records[0]
+	}
+	return status, nil
+}
+
+var errEventStatusTagEmpty = errors.New("tag is empty")
+
+func (service *CollectEventService) getEventStatusHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		err := fmt.Errorf("method %s is not allowed", request.Method)
+		service.recordError("method_not_allowed", err, nil)
+		if err = writeErrorResponse(writer, http.StatusMethodNotAllowed, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	hashTag := request.URL.Query().Get("tag")
+	if hashTag == "" {
+		if err := writeErrorResponse(writer, http.StatusBadRequest, errEventStatusTagEmpty); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), time.Duration(service.config.SaveDB.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	status, err := service.GetEventStatus(ctx, hashTag)
+	if err != nil {
+		service.recordError("get_event_status", err, map[string]string{"hash_tag": hashTag})
+		if err = writeErrorResponse(writer, http.StatusInternalServerError, err); err != nil {
+			service.recordWriteResponseError(err, []byte{})
+		}
+		return
+	}
+	if err = writeResponse(writer, http.StatusOK, status); err != nil {
+		service.recordWriteResponseError(err, []byte{})
+	}
+}
+
+func newHashTagKeysRecord(model *roomHashTagKeys) HashTagKeysRecord {
+	keys := make([]string, len(model.Keys))
+	copy(keys, model.Keys)
+	return HashTagKeysRecord{
+		HashTag:    model.HashTag,
+		Keys:       keys,
+		AccessedAt: model.AccessedAt,
+		WrittenAt:  model.WrittenAt,
+		SyncedAt:   model.SyncedAt,
+		ExpiresAt:  model.ExpiresAt,
+		Status:     model.Status,
+		DC:         model.DC,
+		EventID:    model.EventID,
+	}
+}
+
+// SaveEvent saves event to db without service, record of hash tag is removed by delete event.
 func SaveEvent(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, saveTime time.Time) error {
-	return upsertHashTagKeysRecordByEvent(ctx, db, event, saveTime)
+	if err := event.Check(); err != nil {
+		return err
+	}
+	switch mode := event.AccessMode(); mode {
+	case base.HashTagAccessModeDelete:
+		return deleteHashTagKeysRecord(ctx, db, event)
+	case base.HashTagAccessModeRead, base.HashTagAccessModeWrite, base.HashTagAccessModeExpire:
+	default:
+		return fmt.Errorf("%w, access_mode is %s", base.ErrEventAccessModeWrong, mode)
+	}
+	if !event.PriorDeleteTime.IsZero() {
+		deleteEvent := base.HashTagEvent{HashTag: event.HashTag, AccessTime: event.PriorDeleteTime, DeleteTime: event.PriorDeleteTime}
+		if err := deleteHashTagKeysRecord(ctx, db, deleteEvent); err != nil {
+			return err
+		}
+	}
+	_, err := upsertHashTagKeysRecord(ctx, db, event, saveTime)
+	return err
 }
 
 type EventFile struct {
@@ -0,0 +1,1584 @@
+package service
+
+import (
+	"bytepower_room/base"
+	"bytepower_room/utility"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func testNewCollectEventService() *CollectEventService {
+	dep := base.GetServerDependency()
+	config := &base.RoomCollectEventConfig{
+		SaveDB: base.CollectEventServiceSaveDBConfig{
+			RetryTimes:      3,
+			RetryIntervalMS: 10,
+			TimeoutMS:       2000,
+		},
+	}
+	return &CollectEventService{config: config, logger: dep.Logger, metric: dep.Metric, db: dep.DB}
+}
+
+func testLoadHashTagKeysModels(hashTag string) []*roomHashTagKeys {
+	db := base.GetServerDependency().DB
+	_, models, _ := loadHashTagKeysModelsByCondition(db, 100, 0, dbWhereCondition{column: "hash_tag", operator: "=?", parameter: hashTag})
+	return models
+}
+
+func TestSaveDeleteEvent(t *testing.T) {
+	service := testNewCollectEventService()
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	accessTime := time.Now().Add(-time.Minute)
+	event, _ := base.NewHashTagEvent(hashTag, []string{"{abc}a", "{abc}b"}, base.HashTagAccessModeWrite, accessTime)
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 1, len(testLoadHashTagKeysModels(hashTag)))
+
+	// delete event earlier than the last access does not remove the record
+	event, _ = base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeDelete, accessTime.Add(-time.Second))
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 1, len(testLoadHashTagKeysModels(hashTag)))
+
+	// delete event removes the prior record
+	event, _ = base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeDelete, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 0, len(testLoadHashTagKeysModels(hashTag)))
+
+	// delete event for a not existed record
+	assert.Nil(t, service.saveEvent(event))
+}
+
+// access merged with an earlier delete removes keys saved before the delete.
+func TestSaveEventWithPriorDelete(t *testing.T) {
+	service := testNewCollectEventService()
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	accessTime := time.Now().Add(-time.Minute)
+	event, _ := base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, accessTime)
+	assert.Nil(t, service.saveEvent(event))
+
+	deleteEvent, _ := base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeDelete, accessTime.Add(time.Second))
+	writeEvent, _ := base.NewHashTagEvent(hashTag, []string{"{abc}b"}, base.HashTagAccessModeWrite, accessTime.Add(2*time.Second))
+	event, _ = base.MergeEvents(deleteEvent, writeEvent)
+	assert.Equal(t, []error{nil}, service.saveEvents([]base.HashTagEvent{event}))
+	models := testLoadHashTagKeysModels(hashTag)
+	assert.Equal(t, 1, len(models))
+	assert.Equal(t, []string{"{abc}b"}, models[0].Keys)
+}
+
+func TestSaveDeleteEventRetry(t *testing.T) {
+	service := testNewCollectEventService()
+	attemptCount := 0
+	deleteHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent) error {
+		attemptCount++
+		if attemptCount == 1 {
+			return pg.ErrTxDone
+		}
+		return nil
+	}
+	defer func() { deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent }()
+
+	event, _ := base.NewHashTagEvent("abc", []string{}, base.HashTagAccessModeDelete, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 2, attemptCount)
+}
+
+// events replayed by SaveEvent are saved by access mode like the service does.
+func TestSaveEventReplay(t *testing.T) {
+	calls := make([]string, 0)
+	deleteHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent) error {
+		calls = append(calls, fmt.Sprintf("delete %d", event.DeleteTime.Unix()))
+		return nil
+	}
+	upsertHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, t time.Time) (*roomHashTagKeys, error) {
+		calls = append(calls, fmt.Sprintf("upsert %d", event.AccessTime.Unix()))
+		return &roomHashTagKeys{HashTag: event.HashTag}, nil
+	}
+	defer func() {
+		deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent
+		upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent
+	}()
+
+	deleteTime := time.Unix(1000, 0)
+	deleteEvent, _ := base.NewHashTagEvent("abc", []string{}, base.HashTagAccessModeDelete, deleteTime)
+	assert.Nil(t, SaveEvent(context.TODO(), nil, deleteEvent, time.Now()))
+	assert.Equal(t, []string{"delete 1000"}, calls)
+
+	// access merged with an earlier delete removes the record first
+	calls = calls[:0]
+	writeEvent, _ := base.NewHashTagEvent("abc", []string{"{abc}a"}, base.HashTagAccessModeWrite, deleteTime.Add(time.Second))
+	event, _ := base.MergeEvents(deleteEvent, writeEvent)
+	assert.Nil(t, SaveEvent(context.TODO(), nil, event, time.Now()))
+	assert.Equal(t, []string{"delete 1000", "upsert 1001"}, calls)
+
+	calls = calls[:0]
+	// delete event carrying keys is rejected
+	deleteEvent.Keys = utility.NewStringSet("{abc}a")
+	err := SaveEvent(context.TODO(), nil, deleteEvent, time.Now())
+	assert.True(t, errors.Is(err, base.ErrDeleteEventWithKeys))
+	assert.Equal(t, 0, len(calls))
+}
+
+func TestSaveEventOfAccessModes(t *testing.T) {
+	service := testNewCollectEventService()
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	// read event saves access time only
+	accessTime := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	event, _ := base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeRead, accessTime)
+	assert.Nil(t, service.saveEvent(event))
+	models := testLoadHashTagKeysModels(hashTag)
+	assert.Equal(t, 1, len(models))
+	assert.True(t, models[0].AccessedAt.Equal(accessTime))
+	assert.True(t, models[0].WrittenAt.IsZero())
+	assert.True(t, models[0].ExpiresAt.IsZero())
+	assert.Equal(t, HashTagKeysStatusSynced, models[0].Status)
+
+	// write event saves keys and write time
+	writeTime := accessTime.Add(time.Second)
+	event, _ = base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, writeTime)
+	assert.Nil(t, service.saveEvent(event))
+	models = testLoadHashTagKeysModels(hashTag)
+	assert.True(t, models[0].WrittenAt.Equal(writeTime))
+	assert.Equal(t, []string{"{abc}a"}, models[0].Keys)
+	assert.Equal(t, HashTagKeysStatusNeedSynced, models[0].Status)
+
+	// expire event saves expire time
+	expireAccessTime := writeTime.Add(time.Second)
+	expireTime := expireAccessTime.Add(time.Hour)
+	event = base.HashTagEvent{
+		HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: expireAccessTime, ExpireTime: expireTime,
+		Mode: base.HashTagAccessModeExpire,
+	}
+	assert.Nil(t, service.saveEvent(event))
+	models = testLoadHashTagKeysModels(hashTag)
+	assert.True(t, models[0].ExpiresAt.Equal(expireTime))
+	assert.True(t, models[0].AccessedAt.Equal(expireAccessTime))
+	assert.True(t, models[0].WrittenAt.Equal(writeTime))
+
+	// earlier expire event does not override ttl set later
+	event.AccessTime = writeTime
+	event.ExpireTime = expireTime.Add(time.Hour)
+	assert.Nil(t, service.saveEvent(event))
+	models = testLoadHashTagKeysModels(hashTag)
+	assert.True(t, models[0].ExpiresAt.Equal(expireTime))
+
+	// delete event removes the record
+	event, _ = base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeDelete, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 0, len(testLoadHashTagKeysModels(hashTag)))
+
+	// unknown mode is rejected
+	event = base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: time.Now(), Mode: "touch"}
+	assert.True(t, errors.Is(service.saveEvent(event), base.ErrEventAccessModeWrong))
+}
+
+func TestSaveEventOfDeletedTag(t *testing.T) {
+	service := testNewCollectEventService()
+	service.deletedTags = newDeletedTagSet(10, time.Minute)
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	deleteTime := time.Now()
+	event, _ := base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeDelete, deleteTime)
+	assert.Nil(t, service.saveEvent(event))
+
+	// event accessing before deleted is dropped
+	event, _ = base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, deleteTime.Add(-time.Second))
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 0, len(testLoadHashTagKeysModels(hashTag)))
+
+	// event accessing after deleted is saved
+	event, _ = base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, deleteTime.Add(time.Second))
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 1, len(testLoadHashTagKeysModels(hashTag)))
+}
+
+func TestPostEventsHandlerBodyTooLarge(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.Server.MaxBodyBytes = 16
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}
+
+func TestPostEventsHandlerArrayBody(t *testing.T) {
+	service := testNewCollectEventService()
+
+	body := `[{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "not a json array")
+}
+
+func TestPostEventsHandlerOverloaded(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.Overload = base.CollectEventServiceOverloadConfig{
+		BufferRatio: 0.5, CollectedBufferRatio: 0.5, ExitBufferRatio: 0.2, RetryAfterSeconds: 3,
+	}
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	post := func() *httptest.ResponseRecorder {
+		body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+
+	// buffer is above threshold, but collected events are saved in time
+	service.eventCountInEventBuffer = 6
+	assert.Equal(t, http.StatusOK, post().Code)
+
+	service.eventCountInCollectedEventBuffer = 5
+	recorder := post()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "3", recorder.Header().Get("Retry-After"))
+	assert.Contains(t, recorder.Body.String(), errServiceOverloaded.Error())
+
+	// overload is kept until buffer is below exit threshold
+	service.eventCountInEventBuffer = 3
+	service.eventCountInCollectedEventBuffer = 0
+	assert.Equal(t, http.StatusServiceUnavailable, post().Code)
+	service.eventCountInEventBuffer = 1
+	assert.Equal(t, http.StatusOK, post().Code)
+	assert.False(t, service.isOverloaded())
+}
+
+func TestPostEventsHandlerClientRateLimit(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.Server.TrustForwardedFor = true
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.clientRateLimiter = newClientRateLimiter(0.5, 1, 10)
+
+	post := func(client string) *httptest.ResponseRecorder {
+		body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		request.Header.Set(HTTPHeaderForwardedFor, client)
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, post("1.2.3.4").Code)
+	recorder := post("1.2.3.4")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+	assert.Contains(t, recorder.Body.String(), errClientRateLimited.Error())
+	assert.Equal(t, http.StatusOK, post("5.6.7.8").Code)
+}
+
+func TestPostEventsHandlerAcceptFunc(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.AddAcceptFunc(func(event base.HashTagEvent) (bool, string) {
+		return true, ""
+	})
+	service.AddAcceptFunc(func(event base.HashTagEvent) (bool, string) {
+		if strings.HasPrefix(event.HashTag, "deny") {
+			return false, "hash tag is denied"
+		}
+		return true, ""
+	})
+
+	post := func(hashTag string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"events": [{"hash_tag": "%s", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`, hashTag)
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, post("abc").Code)
+	recorder := post("deny_abc")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "hash tag is denied")
+	assert.Equal(t, 1, service.eventBuffer.Len())
+}
+
+func TestNewServeMuxDisabledEndpoints(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.Server.DisabledEndpoints = []string{"/stats/reset", "/debug/*"}
+	mux := service.newServeMux()
+
+	testCases := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/stats/reset", http.StatusNotFound},
+		{http.MethodGet, "/debug/buffer", http.StatusNotFound},
+		{http.MethodPost, "/events/status", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/events", http.StatusMethodNotAllowed},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(testCase.method, testCase.path, nil)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		assert.Equal(t, testCase.code, recorder.Code, testCase.path)
+	}
+
+	assert.True(t, isEndpointDisabled([]string{"/debug/*"}, "/debug/buffer"))
+	assert.False(t, isEndpointDisabled([]string{"/debug/*"}, "/debugging"))
+	assert.False(t, isEndpointDisabled(nil, "/stats/reset"))
+}
+
+func TestPostEventsHandlerStrictContentType(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	post := func(contentType string) *httptest.ResponseRecorder {
+		body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		if contentType != "" {
+			request.Header.Set(HTTPHeaderContentType, contentType)
+		}
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+	assert.Equal(t, http.StatusOK, post("").Code)
+	assert.Equal(t, http.StatusOK, post("text/plain").Code)
+
+	service.config.Server.StrictContentType = true
+	recorder := post("text/plain")
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "text/plain")
+	assert.Equal(t, http.StatusUnsupportedMediaType, post("").Code)
+	assert.Equal(t, http.StatusOK, post("application/json").Code)
+	assert.Equal(t, http.StatusOK, post("application/json; charset=utf-8").Code)
+}
+
+func TestPostEventsHandlerDryRun(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.idempotencyCache = newIdempotencyCache(10, time.Minute)
+	service.ackTracker = newAckTracker(10, time.Minute)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}, {"hash_tag": "bcd", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	request := httptest.NewRequest(http.MethodPost, "/events?dry_run=true", strings.NewReader(body))
+	request.Header.Set(HTTPHeaderIdempotency, "key")
+	request.Header.Set(HTTPHeaderAckURL, "http://example.com/ack")
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response CollectEventsResponse
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.DryRun)
+	assert.Equal(t, 2, response.Count)
+	assert.Equal(t, []int{service.db.GetShardingIndex("abc"), service.db.GetShardingIndex("bcd")}, response.Shards)
+
+	// no side effects
+	assert.Equal(t, 0, service.eventBuffer.Len())
+	assert.Equal(t, 0, service.idempotencyCache.len())
+	assert.Equal(t, 0, service.ackTracker.pendingCount())
+
+	// invalid events are still rejected
+	body = `{"events": [{"hash_tag": "", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	request = httptest.NewRequest(http.MethodPost, "/events?dry_run=true", strings.NewReader(body))
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestPostEventsHandlerNullEvent(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}, null]}`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "events[1]: "+base.ErrEventEmpty.Error())
+	assert.Equal(t, 0, service.eventBuffer.Len())
+}
+
+func TestPostEventsHandlerEventAge(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_age_ms": 60000}]}`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	event := <-service.eventBuffer.Dequeue()
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), event.AccessTime, 5*time.Second)
+	assert.Nil(t, event.AccessAgeMS)
+
+	body = `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z", "access_age_ms": 60000}]}`
+	request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), base.ErrEventTimeAndAgeBoth.Error())
+
+	body = `{"events": [{"hash_tag": "abc", "keys": [], "access_age_ms": 1.5}]}`
+	request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "access_age_ms is 1.5")
+}
+
+func TestClientAddress(t *testing.T) {
+	service := testNewCollectEventService()
+	request := httptest.NewRequest(http.MethodPost, "/events", nil)
+	request.RemoteAddr = "10.0.0.1:12345"
+	request.Header.Set(HTTPHeaderForwardedFor, "1.2.3.4, 10.0.0.2")
+	assert.Equal(t, "10.0.0.1", service.clientAddress(request))
+
+	service.config.Server.TrustForwardedFor = true
+	assert.Equal(t, "1.2.3.4", service.clientAddress(request))
+
+	request.Header.Del(HTTPHeaderForwardedFor)
+	assert.Equal(t, "10.0.0.1", service.clientAddress(request))
+
+	request.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", service.clientAddress(request))
+
+	// clients are counted in bounded buckets
+	assert.Equal(t, clientMetricName("1.2.3.4"), clientMetricName("1.2.3.4"))
+	buckets := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		buckets[clientMetricName(fmt.Sprintf("10.0.%d.%d", i/256, i%256))] = true
+	}
+	assert.Equal(t, clientMetricBucketCount, len(buckets))
+	assert.Equal(t, "unknown", clientMetricName(""))
+}
+
+func TestPostEventsHandlerSelfTestHashTag(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.SelfTest = base.CollectEventServiceSelfTestConfig{Enabled: true, HashTag: "__room_self_test__"}
+
+	body := `{"events": [{"hash_tag": "__room_self_test__", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errReservedHashTag.Error())
+}
+
+func TestCheckSelfTestEvent(t *testing.T) {
+	service := testNewCollectEventService()
+	hashTag := "__room_self_test__"
+	service.config.SelfTest = base.CollectEventServiceSelfTestConfig{Enabled: true, HashTag: hashTag}
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	accessTime := time.Now().Truncate(time.Millisecond)
+	assert.True(t, errors.Is(service.checkSelfTestEvent(accessTime), errSelfTestEventNotSaved))
+
+	event, _ := base.NewHashTagEvent(hashTag, nil, base.HashTagAccessModeRead, accessTime)
+	assert.Nil(t, service.saveEvent(event))
+	assert.Nil(t, service.checkSelfTestEvent(accessTime))
+	assert.True(t, errors.Is(service.checkSelfTestEvent(accessTime.Add(time.Second)), errSelfTestEventNotSaved))
+}
+
+func TestGetRecords(t *testing.T) {
+	service := testNewCollectEventService()
+	hashTags := []string{"abc", "def", "ghi"}
+	for _, hashTag := range hashTags {
+		defer testEmptyHashTagKeysRecordInDB(hashTag)
+	}
+
+	for _, hashTag := range hashTags[:2] {
+		event, _ := base.NewHashTagEvent(hashTag, []string{fmt.Sprintf("{%s}a", hashTag)}, base.HashTagAccessModeWrite, time.Now())
+		assert.Nil(t, service.saveEvent(event))
+	}
+
+	records, err := service.GetRecords(context.TODO(), []string{"ghi", "def", "abc", "def"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "def", records[0].HashTag)
+	assert.Equal(t, []string{"{def}a"}, records[0].Keys)
+	assert.Equal(t, HashTagKeysStatusNeedSynced, records[0].Status)
+	assert.Equal(t, "abc", records[1].HashTag)
+
+	records, err = service.GetRecords(context.TODO(), []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestRecordMergeRatio(t *testing.T) {
+	service := testNewCollectEventService()
+	service.events = make(map[string]base.HashTagEvent)
+
+	for _, hashTag := range []string{"abc", "abc", "def", "abc"} {
+		event, _ := base.NewHashTagEvent(hashTag, nil, base.HashTagAccessModeRead, time.Now())
+		assert.Nil(t, service.aggregateEvent(event))
+	}
+	assert.Equal(t, int64(4), service.aggregatedEventCount)
+	assert.Equal(t, int64(2), service.mergedEventCount)
+
+	service.recordMergeRatio()
+	assert.Equal(t, int64(0), service.aggregatedEventCount)
+	assert.Equal(t, int64(0), service.mergedEventCount)
+}
+
+func TestPostEventsHandlerRequestCanceled(t *testing.T) {
+	service := testNewCollectEventService()
+	service.eventBuffer = newDroppingEventBuffer(1)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)).WithContext(ctx)
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, 0, service.eventBuffer.Len())
+	assert.Equal(t, 0, recorder.Body.Len())
+}
+
+func TestPostEventsHandlerIdempotencyKey(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.idempotencyCache = newIdempotencyCache(10, time.Minute)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		request.Header.Set(HTTPHeaderIdempotency, "key")
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), `"count":1`)
+	}
+	assert.Equal(t, 1, service.eventBuffer.Len())
+
+	post := func(body, client string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		request.Header.Set(HTTPHeaderIdempotency, "key")
+		request.RemoteAddr = client
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		return recorder
+	}
+
+	// the same key with different body
+	otherBody := `{"events": [{"hash_tag": "xyz", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	recorder := post(otherBody, "192.0.2.1:1234")
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errIdempotencyKeyReused.Error())
+	assert.Equal(t, 1, service.eventBuffer.Len())
+
+	// key is scoped to client
+	recorder = post(otherBody, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 2, service.eventBuffer.Len())
+
+	// request with key in flight
+	key := idempotencyCacheKey("10.0.0.2", "key")
+	_, state := service.idempotencyCache.begin(key, []byte(body), time.Now())
+	assert.Equal(t, idempotencyKeyNew, state)
+	recorder = post(body, "10.0.0.2:1234")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errIdempotencyKeyInFlight.Error())
+	assert.Equal(t, 2, service.eventBuffer.Len())
+
+	// key is released when request fails
+	service.idempotencyCache.abort(key)
+	recorder = post(`{"events": [{"hash_tag": "", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`, "10.0.0.2:1234")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder = post(body, "10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 3, service.eventBuffer.Len())
+}
+
+func TestPostEventsHandlerAssignEventID(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.Server.AssignEventID = true
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.idempotencyCache = newIdempotencyCache(10, time.Minute)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z", "id": "client"}, {"hash_tag": "xyz", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	responses := make([]CollectEventsResponse, 0)
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		request.Header.Set(HTTPHeaderIdempotency, "key")
+		recorder := httptest.NewRecorder()
+		service.postEventsHandler(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var response CollectEventsResponse
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		responses = append(responses, response)
+	}
+	assert.Equal(t, 2, responses[0].Count)
+	assert.Equal(t, 2, len(responses[0].IDs))
+	assert.NotEqual(t, responses[0].IDs[0], responses[0].IDs[1])
+	// idempotent request gets the same ids
+	assert.Equal(t, responses[0], responses[1])
+	assert.Equal(t, 2, service.eventBuffer.Len())
+	for _, id := range responses[0].IDs {
+		assert.Equal(t, id, (<-service.eventBuffer.Dequeue()).ID)
+	}
+
+	service.config.Server.AssignEventID = false
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	assert.Equal(t, `{"count":2}`, recorder.Body.String())
+	assert.Equal(t, "", (<-service.eventBuffer.Dequeue()).ID)
+}
+
+func TestAddEventWithOverflowBuffer(t *testing.T) {
+	service := testNewCollectEventService()
+	service.stopCh = make(chan bool)
+	service.events = make(map[string]base.HashTagEvent)
+	buffer := newOverflowEventBuffer(1, newMemoryOverflowBuffer(2), service.metric, service.recordError)
+	service.eventBuffer = buffer
+	service.collectedEventBuffer = make(chan base.HashTagEvent, 1)
+
+	for i := 0; i < 3; i++ {
+		event, _ := base.NewHashTagEvent(fmt.Sprintf("tag%d", i), nil, base.HashTagAccessModeRead, time.Now())
+		assert.Nil(t, service.addEvent(event))
+	}
+	event, _ := base.NewHashTagEvent("tag3", nil, base.HashTagAccessModeRead, time.Now())
+	assert.NotNil(t, service.addEvent(event))
+	assert.Equal(t, 2, buffer.overflowLen())
+	assert.Equal(t, int64(3), service.eventCountInEventBuffer)
+
+	hashTags := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		event := <-service.eventBuffer.Dequeue()
+		service.eventDequeued(event)
+		hashTags = append(hashTags, event.HashTag)
+	}
+	assert.Equal(t, []string{"tag0", "tag1", "tag2"}, hashTags)
+
+	// events left in overflow buffer are aggregated when service stops
+	assert.Nil(t, service.addEvent(event))
+	assert.Nil(t, service.addEvent(event))
+	assert.Nil(t, service.addEvent(event))
+	close(service.stopCh)
+	service.aggregateBufferedEvents()
+	assert.Equal(t, 0, buffer.overflowLen())
+	assert.Equal(t, int64(0), service.eventCountInEventBuffer)
+	assert.Contains(t, service.events, "tag3")
+}
+
+// enqueue time is assigned when event is added, the earliest one is kept in aggregation.
+func TestAddEventEnqueueTime(t *testing.T) {
+	service := testNewCollectEventService()
+	service.events = make(map[string]base.HashTagEvent)
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	startTime := time.Now()
+	event, _ := base.NewHashTagEvent("abc", nil, base.HashTagAccessModeRead, startTime)
+	event.EnqueueTime = startTime.Add(-time.Hour)
+	assert.Nil(t, service.addEvent(event))
+	firstEvent := <-service.eventBuffer.Dequeue()
+	assert.False(t, firstEvent.EnqueueTime.Before(startTime))
+
+	event.AccessTime = startTime.Add(time.Second)
+	assert.Nil(t, service.addEvent(event))
+	secondEvent := <-service.eventBuffer.Dequeue()
+	assert.False(t, secondEvent.EnqueueTime.Before(firstEvent.EnqueueTime))
+
+	assert.Nil(t, service.aggregateEvent(secondEvent))
+	assert.Nil(t, service.aggregateEvent(firstEvent))
+	assert.Equal(t, firstEvent.EnqueueTime, service.events["abc"].EnqueueTime)
+	assert.Equal(t, secondEvent.AccessTime, service.events["abc"].AccessTime)
+}
+
+func TestAddEventWithHighPriority(t *testing.T) {
+	service := testNewCollectEventService()
+	service.events = make(map[string]base.HashTagEvent)
+	service.collectedEventBuffer = make(chan base.HashTagEvent, 1)
+	service.eventBuffer = newPriorityEventBuffer(10, []base.HashTagAccessMode{base.HashTagAccessModeWrite})
+
+	event, _ := base.NewHashTagEvent("abc", nil, base.HashTagAccessModeRead, time.Now())
+	assert.Nil(t, service.addEvent(event))
+	event, _ = base.NewHashTagEvent("abc", []string{"{abc}a"}, base.HashTagAccessModeWrite, time.Now())
+	assert.Nil(t, service.addEvent(event))
+	event, _ = base.NewHashTagEvent("abc", nil, base.HashTagAccessModeDelete, time.Now())
+	assert.Nil(t, service.addEvent(event))
+
+	// a read event may be held by forwarder of buffer
+	assert.LessOrEqual(t, service.eventBuffer.Len(), 3)
+	assert.Equal(t, int64(2), service.eventCountInEventBuffer)
+	assert.Equal(t, 1, service.eventBuffer.(*priorityEventBuffer).highPriorityLen())
+	assert.Equal(t, int64(1), service.eventCountInHighPriorityEventBuffer)
+
+	service.aggregateBufferedEvents()
+	assert.Equal(t, int64(0), service.eventCountInEventBuffer)
+	assert.Equal(t, int64(0), service.eventCountInHighPriorityEventBuffer)
+	assert.Equal(t, time.Duration(0), service.GetOldestBufferedEventAge())
+}
+
+func TestAddEventWithOverloadSampling(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.OverloadSampling.BufferRatio = 0.2
+	service.config.HotTag.MergeThreshold = 2
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.mergeCounts = map[string]int{"hot": 2, "cold": 1}
+	service.overloadSampler = newEventSampler(time.Minute, 1, 0)
+
+	// not overloaded
+	for i := 0; i < 2; i++ {
+		event, _ := base.NewHashTagEvent("hot", nil, base.HashTagAccessModeRead, time.Now())
+		assert.Nil(t, service.addEvent(event))
+	}
+	assert.Equal(t, 2, service.eventBuffer.Len())
+
+	for i := 0; i < 3; i++ {
+		event, _ := base.NewHashTagEvent("hot", nil, base.HashTagAccessModeRead, time.Now())
+		assert.Nil(t, service.addEvent(event))
+		event, _ = base.NewHashTagEvent("cold", nil, base.HashTagAccessModeRead, time.Now())
+		assert.Nil(t, service.addEvent(event))
+	}
+	// one event of hot tag is kept
+	assert.Equal(t, 6, service.eventBuffer.Len())
+
+	// write events are not sampled
+	event, _ := base.NewHashTagEvent("hot", []string{"{hot}a"}, base.HashTagAccessModeWrite, time.Now())
+	assert.Nil(t, service.addEvent(event))
+	assert.Equal(t, 7, service.eventBuffer.Len())
+}
+
+func TestRecordDBPoolStats(t *testing.T) {
+	service := testNewCollectEventService()
+	stats := service.db.PoolStats()
+	assert.True(t, len(stats) > 0)
+	for _, s := range stats {
+		assert.Equal(t, s.TotalConns-s.IdleConns, s.InUseConns)
+	}
+	service.recordDBPoolStats()
+	assert.Equal(t, len(stats), len(service.lastDBPoolStats))
+}
+
+func TestGetOldestBufferedEventAge(t *testing.T) {
+	service := testNewCollectEventService()
+	service.events = make(map[string]base.HashTagEvent)
+	service.eventBuffer = newDroppingEventBuffer(2)
+	service.collectedEventBuffer = make(chan base.HashTagEvent, 1)
+	assert.Equal(t, time.Duration(0), service.GetOldestBufferedEventAge())
+
+	event, _ := base.NewHashTagEvent("tag", nil, base.HashTagAccessModeRead, time.Now())
+	assert.Nil(t, service.addEvent(event))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, service.addEvent(event))
+	// dropped event is not counted
+	assert.NotNil(t, service.addEvent(event))
+	assert.GreaterOrEqual(t, int64(service.GetOldestBufferedEventAge()), int64(20*time.Millisecond))
+
+	// age is kept by the event dequeued last until buffer is empty
+	service.eventDequeued(<-service.eventBuffer.Dequeue())
+	assert.GreaterOrEqual(t, int64(service.GetOldestBufferedEventAge()), int64(20*time.Millisecond))
+	service.eventDequeued(<-service.eventBuffer.Dequeue())
+	assert.Equal(t, time.Duration(0), service.GetOldestBufferedEventAge())
+
+	// event added to empty buffer is the head
+	assert.Nil(t, service.addEvent(event))
+	assert.Less(t, int64(service.GetOldestBufferedEventAge()), int64(20*time.Millisecond))
+	service.aggregateBufferedEvents()
+	assert.Equal(t, time.Duration(0), service.GetOldestBufferedEventAge())
+
+	// events left in disk by last process keep their enqueue time
+	service.eventBuffer = newDroppingEventBuffer(2)
+	event.EnqueueTime = time.Now().Add(-time.Hour)
+	service.eventBuffer.Enqueue(event)
+	service.eventBuffer.Enqueue(event)
+	service.eventCountInEventBuffer = int64(service.eventBuffer.Len())
+	service.eventDequeued(<-service.eventBuffer.Dequeue())
+	assert.GreaterOrEqual(t, int64(service.GetOldestBufferedEventAge()), int64(time.Hour))
+}
+
+func TestOnSaved(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.stopCh = make(chan bool)
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	savedCh := make(chan []base.HashTagEvent, 2)
+	service.SetOnSaved(func(events []base.HashTagEvent) {
+		savedCh <- events
+		panic("callback panics")
+	})
+	service.wg.Add(1)
+	go service.callOnSaved()
+
+	event, _ := base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	events := <-savedCh
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, hashTag, events[0].HashTag)
+
+	// callback is still called after panic
+	assert.Nil(t, service.saveEvent(event))
+	events = <-savedCh
+	assert.Equal(t, 1, len(events))
+
+	close(service.stopCh)
+	service.wg.Wait()
+}
+
+func TestSaveAgedOutEvent(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.SaveDB.MaxEventAgeMS = 60 * 1000
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	event, _ := base.NewHashTagEvent(hashTag, nil, base.HashTagAccessModeRead, time.Now().Add(-2*time.Minute))
+	assert.True(t, errors.Is(service.saveEvent(event), errEventAgedOut))
+	assert.Equal(t, 0, len(testLoadHashTagKeysModels(hashTag)))
+
+	event, _ = base.NewHashTagEvent(hashTag, nil, base.HashTagAccessModeRead, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 1, len(testLoadHashTagKeysModels(hashTag)))
+}
+
+func TestPostEventsHandlerFormBody(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	form := url.Values{}
+	form.Add("event", `{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}`)
+	form.Add("event", `{"hash_tag": "def", "keys": [], "access_time": "2021-06-25T11:30:25Z"}`)
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(form.Encode()))
+	request.Header.Set(HTTPHeaderContentType, HTTPContentTypeForm)
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 2, service.eventBuffer.Len())
+
+	// invalid event
+	form = url.Values{}
+	form.Add("event", `{"hash_tag": "", "keys": [], "access_time": "2021-06-25T11:30:25Z"}`)
+	request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(form.Encode()))
+	request.Header.Set(HTTPHeaderContentType, HTTPContentTypeForm+"; charset=utf-8")
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// invalid json
+	form = url.Values{}
+	form.Add("event", `{`)
+	request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(form.Encode()))
+	request.Header.Set(HTTPHeaderContentType, HTTPContentTypeForm)
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, 2, service.eventBuffer.Len())
+}
+
+type testShortResponseWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+	err   error
+}
+
+func (writer *testShortResponseWriter) Write(data []byte) (int, error) {
+	if len(data) > writer.limit {
+		data = data[:writer.limit]
+	}
+	n, _ := writer.ResponseRecorder.Write(data)
+	return n, writer.err
+}
+
+func TestWriteResponsePartially(t *testing.T) {
+	writer := &testShortResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 100}
+	assert.Nil(t, writeSuccessResponse(writer, CollectEventsResponse{Count: 1}))
+
+	writer = &testShortResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 2}
+	err := writeSuccessResponse(writer, CollectEventsResponse{Count: 1})
+	var partialErr *partialWriteError
+	assert.True(t, errors.As(err, &partialErr))
+	assert.Equal(t, 2, partialErr.written)
+	assert.True(t, errors.Is(err, io.ErrShortWrite))
+	assert.False(t, isBrokenPipeError(err))
+
+	writer = &testShortResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 2, err: syscall.EPIPE}
+	err = writeErrorResponse(writer, http.StatusBadRequest, errors.New("error"))
+	assert.True(t, errors.As(err, &partialErr))
+	assert.True(t, isBrokenPipeError(err))
+
+	writer = &testShortResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 0, err: &net.OpError{Op: "write", Err: syscall.ECONNRESET}}
+	err = writeSuccessResponse(writer, CollectEventsResponse{Count: 1})
+	assert.False(t, errors.As(err, &partialErr))
+	assert.True(t, isBrokenPipeError(err))
+}
+
+func TestPauseShard(t *testing.T) {
+	service := testNewCollectEventService()
+	service.pausedShards = make(map[int]bool)
+	hashTag := "abc"
+	shard := service.db.GetShardingIndex(hashTag)
+	event := base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet("{abc}1"), AccessTime: time.Now()}
+	service.events = map[string]base.HashTagEvent{hashTag: event}
+
+	assert.Nil(t, service.PauseShard(shard))
+	assert.Equal(t, []int{shard}, service.GetPausedShards())
+	assert.Equal(t, 0, len(service.collectEvents()))
+	assert.Equal(t, int64(1), service.GetAggregatedEventCount())
+
+	assert.Nil(t, service.ResumeShard(shard))
+	assert.Equal(t, 0, len(service.GetPausedShards()))
+	assert.Equal(t, []base.HashTagEvent{event}, service.collectEvents())
+
+	assert.True(t, errors.Is(service.PauseShard(-1), errShardOutOfRange))
+	assert.True(t, errors.Is(service.ResumeShard(service.db.GetShardingCount()), errShardOutOfRange))
+}
+
+func TestSaveEventRetryTimeout(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.SaveDB.TimeoutMS = 1000
+	service.config.SaveDB.AttemptTimeoutMS = 50
+	attemptCount := 0
+	upsertHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, t time.Time) (*roomHashTagKeys, error) {
+		attemptCount++
+		if attemptCount == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &roomHashTagKeys{HashTag: event.HashTag, Keys: event.Keys.ToSlice()}, nil
+	}
+	defer func() { upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent }()
+
+	event, _ := base.NewHashTagEvent("abc", []string{"{abc}a"}, base.HashTagAccessModeWrite, time.Now())
+	assert.Nil(t, service.saveEvent(event))
+	assert.Equal(t, 2, attemptCount)
+
+	// attempts share timeout_ms
+	service.config.SaveDB.TimeoutMS = 50
+	service.config.SaveDB.AttemptTimeoutMS = 0
+	attemptCount = 0
+	err := service.saveEvent(event)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, attemptCount)
+}
+
+func TestSaveEventsInBatch(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.SaveDB.RetryTimes = 2
+	batches := make([][]string, 0)
+	upsertHashTagKeysRecords = func(ctx context.Context, db *base.DBCluster, events []base.HashTagEvent, t time.Time) ([]*roomHashTagKeys, []error) {
+		hashTags := make([]string, 0, len(events))
+		models := make([]*roomHashTagKeys, len(events))
+		errs := make([]error, len(events))
+		for index, event := range events {
+			hashTags = append(hashTags, event.HashTag)
+			switch event.HashTag {
+			case "retry":
+				// succeeds in retry
+				if len(batches) == 0 {
+					errs[index] = errNoRowsUpdated
+					continue
+				}
+			case "retry_fail":
+				errs[index] = errNoRowsUpdated
+				continue
+			case "fail":
+				errs[index] = errors.New("fail")
+				continue
+			}
+			models[index] = &roomHashTagKeys{HashTag: event.HashTag, Keys: event.Keys.ToSlice()}
+		}
+		batches = append(batches, hashTags)
+		return models, errs
+	}
+	deletedHashTags := make([]string, 0)
+	deleteHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent) error {
+		deletedHashTags = append(deletedHashTags, event.HashTag)
+		assert.Equal(t, 2, len(batches))
+		return nil
+	}
+	defer func() {
+		upsertHashTagKeysRecords = upsertHashTagKeysRecordsByEvents
+		deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent
+	}()
+
+	events := make([]base.HashTagEvent, 0)
+	for _, hashTag := range []string{"a", "retry", "fail", "retry_fail"} {
+		event, _ := base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeRead, time.Now())
+		events = append(events, event)
+	}
+	// delete event is saved alone after events before it
+	event, _ := base.NewHashTagEvent("b", []string{}, base.HashTagAccessModeDelete, time.Now())
+	events = append(events, event)
+	event, _ = base.NewHashTagEvent("c", []string{}, base.HashTagAccessModeRead, time.Now())
+	events = append(events, event)
+
+	errs := service.saveEvents(events)
+	assert.Equal(t, 6, len(errs))
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, "fail", errs[2].Error())
+	assert.True(t, errors.Is(errs[3], errNoRowsUpdated))
+	assert.Nil(t, errs[4])
+	assert.Nil(t, errs[5])
+	assert.Equal(t, [][]string{{"a", "retry", "fail", "retry_fail"}, {"retry", "retry_fail"}, {"c"}}, batches)
+	assert.Equal(t, []string{"b"}, deletedHashTags)
+}
+
+// saves of a hash tag by SaveEventSync and by saving buffered events do not run at the same time.
+func TestSaveEventSyncWithBufferedEvents(t *testing.T) {
+	service := testNewCollectEventService()
+	service.deletedTags = newDeletedTagSet(10, time.Minute)
+	var inFlight, maxInFlight int32
+	saved := make(chan string, 100)
+	save := func(event base.HashTagEvent, operation string) {
+		count := atomic.AddInt32(&inFlight, 1)
+		if count > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, count)
+		}
+		time.Sleep(time.Millisecond)
+		saved <- fmt.Sprintf("%s %d", operation, event.AccessTime.Unix())
+		atomic.AddInt32(&inFlight, -1)
+	}
+	upsertHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, t time.Time) (*roomHashTagKeys, error) {
+		save(event, "upsert")
+		return &roomHashTagKeys{HashTag: event.HashTag}, nil
+	}
+	upsertHashTagKeysRecords = func(ctx context.Context, db *base.DBCluster, events []base.HashTagEvent, t time.Time) ([]*roomHashTagKeys, []error) {
+		models := make([]*roomHashTagKeys, len(events))
+		for index, event := range events {
+			save(event, "upsert")
+			models[index] = &roomHashTagKeys{HashTag: event.HashTag}
+		}
+		return models, make([]error, len(events))
+	}
+	deleteHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent) error {
+		save(event, "delete")
+		return nil
+	}
+	defer func() {
+		upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent
+		upsertHashTagKeysRecords = upsertHashTagKeysRecordsByEvents
+		deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent
+	}()
+
+	accessTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		event, _ := base.NewHashTagEvent("abc", []string{"{abc}a"}, base.HashTagAccessModeRead, accessTime.Add(time.Duration(i)*time.Second))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, []error{nil}, service.saveEvents([]base.HashTagEvent{event}))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, service.SaveEventSync(event))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), maxInFlight)
+	assert.Equal(t, 20, len(saved))
+
+	// buffered event accessed before a delete saved by SaveEventSync is dropped
+	deleteEvent, _ := base.NewHashTagEvent("abc", []string{}, base.HashTagAccessModeDelete, accessTime.Add(time.Minute))
+	assert.Nil(t, service.SaveEventSync(deleteEvent))
+	event, _ := base.NewHashTagEvent("abc", []string{"{abc}a"}, base.HashTagAccessModeRead, accessTime)
+	assert.Equal(t, []error{nil}, service.saveEvents([]base.HashTagEvent{event}))
+	assert.Equal(t, 21, len(saved))
+}
+
+// testListenMetrics returns a metric client sending to a local udp listener,
+// and a function returning counts received by name.
+func testListenMetrics(t *testing.T) (*base.MetricClient, func() map[string]int) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	metric, err := base.InitMetric(base.MetricConfig{Host: conn.LocalAddr().String(), Network: "udp"})
+	assert.Nil(t, err)
+	return metric, func() map[string]int {
+		defer conn.Close()
+		metric.Close()
+		counts := make(map[string]int)
+		buffer := make([]byte, 65536)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return counts
+			}
+			for _, line := range strings.Split(string(buffer[:n]), "\n") {
+				var count int
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 && strings.HasSuffix(parts[1], "|c") {
+					_, _ = fmt.Sscanf(parts[1], "%d|c", &count)
+					counts[parts[0]] += count
+				}
+			}
+		}
+	}
+}
+
+func TestRecordSaveSuccessByPath(t *testing.T) {
+	service := testNewCollectEventService()
+	metric, receivedCounts := testListenMetrics(t)
+	service.metric = metric
+	attemptCount := 0
+	upsertHashTagKeysRecords = func(ctx context.Context, db *base.DBCluster, events []base.HashTagEvent, t time.Time) ([]*roomHashTagKeys, []error) {
+		attemptCount++
+		models := make([]*roomHashTagKeys, len(events))
+		errs := make([]error, len(events))
+		for index, event := range events {
+			// the first attempt fails and is retried
+			if attemptCount == 1 {
+				errs[index] = errNoRowsUpdated
+				continue
+			}
+			models[index] = &roomHashTagKeys{HashTag: event.HashTag}
+		}
+		return models, errs
+	}
+	upsertHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, t time.Time) (*roomHashTagKeys, error) {
+		return &roomHashTagKeys{HashTag: event.HashTag}, nil
+	}
+	deleteHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent) error {
+		return nil
+	}
+	defer func() {
+		upsertHashTagKeysRecords = upsertHashTagKeysRecordsByEvents
+		upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent
+		deleteHashTagKeysRecord = deleteHashTagKeysRecordByEvent
+	}()
+
+	events := make([]base.HashTagEvent, 0)
+	for _, hashTag := range []string{"a", "b"} {
+		event, _ := base.NewHashTagEvent(hashTag, []string{}, base.HashTagAccessModeRead, time.Now())
+		events = append(events, event)
+	}
+	event, _ := base.NewHashTagEvent("c", []string{}, base.HashTagAccessModeDelete, time.Now())
+	events = append(events, event)
+	assert.Equal(t, []error{nil, nil, nil}, service.saveEvents(events))
+	event, _ = base.NewHashTagEvent("d", []string{}, base.HashTagAccessModeRead, time.Now())
+	assert.Nil(t, service.SaveEventSync(event))
+	assert.Equal(t, 2, attemptCount)
+
+	counts := receivedCounts()
+	assert.Equal(t, 2, counts["counter.success.save_event_to_db.async.batch"])
+	assert.Equal(t, 1, counts["counter.success.save_event_to_db.async.single"])
+	assert.Equal(t, 1, counts["counter.success.save_event_to_db.sync.single"])
+	// retries and other successes are not counted as saved events
+	assert.Equal(t, 0, counts["counter.success"])
+	assert.Equal(t, 2, counts["counter.save_event_to_db_retry"])
+}
+
+func TestPostEventsHandlerAssignDC(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z", "dc": "client"}]}`
+	request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	service.postEventsHandler(httptest.NewRecorder(), request)
+	event := <-service.eventBuffer.Dequeue()
+	assert.Equal(t, "", event.DC)
+
+	service.config.DC = "dc1"
+	request = httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	service.postEventsHandler(httptest.NewRecorder(), request)
+	event = <-service.eventBuffer.Dequeue()
+	assert.Equal(t, "dc1", event.DC)
+}
+
+func TestPostEventsHandlerStrictKeyCheck(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	body := `{"events": [{"hash_tag": "abc", "keys": ["{abc}1", "{xyz}2"], "access_time": "2021-06-25T11:30:25Z", "write_time": "2021-06-25T11:30:25Z"}]}`
+	recorder := httptest.NewRecorder()
+	service.postEventsHandler(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	<-service.eventBuffer.Dequeue()
+
+	service.config.StrictKeyCheck = true
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, 0, service.eventBuffer.Len())
+
+	body = `{"events": [{"hash_tag": "abc", "keys": ["{abc}1", "2{abc}"], "access_time": "2021-06-25T11:30:25Z", "write_time": "2021-06-25T11:30:25Z"}]}`
+	recorder = httptest.NewRecorder()
+	service.postEventsHandler(recorder, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, service.eventBuffer.Len())
+}
+
+func TestPostEventsHandlerMalformedBody(t *testing.T) {
+	seeds := []string{
+		`{"events": [{"hash_tag": "abc", "keys": ["{abc}1"], "access_time": "2021-06-25T11:30:25Z", "write_time": "2021-06-25T11:30:25Z"}]}`,
+		`{"events": [{"hash_tag": "abc", "access_time": "2021-06-25T11:30:25Z", "write_time": "2021-06-25T11:30:25Z"}]}`,
+		`{"events": [{"hash_tag": "abc", "keys": null, "access_time": "2021-06-25T11:30:25Z"}]}`,
+		`{"events": [{"hash_tag": "abc", "access_time": "2021-06-25T11:30:25Z", "delete_time": "2021-06-25T11:30:25Z"}]}`,
+		`{"events": null}`,
+		`{"events": [null]}`,
+		`{"events": {"hash_tag": "abc"}}`,
+		`{"events": [{"hash_tag": 1, "keys": [1, null], "access_time": 1e1000}]}`,
+		strings.Repeat("[", 10000) + strings.Repeat("]", 10000),
+		`{"events": ` + strings.Repeat(`{"a":`, 10000) + "1" + strings.Repeat("}", 10000) + "}",
+		"\xff\xfe\x00",
+		"",
+	}
+	random := rand.New(rand.NewSource(1))
+	bodies := make([]string, 0)
+	for _, seed := range seeds {
+		bodies = append(bodies, seed)
+		for i := 0; i < 100 && len(seed) > 0; i++ {
+			mutated := []byte(seed)
+			for j := random.Intn(4); j >= 0; j-- {
+				mutated[random.Intn(len(mutated))] = byte(random.Intn(256))
+			}
+			bodies = append(bodies, string(mutated[:random.Intn(len(mutated)+1)]))
+		}
+	}
+
+	for _, contentType := range []string{HTTPContentTypeJSON, HTTPContentTypeForm} {
+		for _, body := range bodies {
+			service := testNewCollectEventService()
+			service.config.BufferLimit = 10
+			service.eventBuffer = newDroppingEventBuffer(10)
+			service.events = make(map[string]base.HashTagEvent)
+			if contentType == HTTPContentTypeForm {
+				body = url.Values{formEventKey: []string{body}}.Encode()
+			}
+			request := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+			request.Header.Set(HTTPHeaderContentType, contentType)
+			recorder := httptest.NewRecorder()
+			assert.NotPanics(t, func() { service.postEventsHandler(recorder, request) }, body)
+			assert.True(t, recorder.Code == http.StatusOK || recorder.Code >= http.StatusBadRequest, body)
+
+			service.eventBuffer.Close()
+			for event := range service.eventBuffer.Dequeue() {
+				assert.NotPanics(t, func() { _ = service.aggregateEvent(event) }, body)
+			}
+		}
+	}
+}
+
+func TestSaveEventsFromFileWithPooledEvents(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.SaveDB.RateLimitPerSecond = 1000
+	service.config.SaveDB.BatchSize = 2
+	service.config.SaveDB.BatchIntervalMS = 1000
+	service.eventPool = &sync.Pool{New: func() interface{} { return &base.HashTagEvent{} }}
+	var savedHashTags []string
+	upsertHashTagKeysRecords = func(ctx context.Context, db *base.DBCluster, events []base.HashTagEvent, t time.Time) ([]*roomHashTagKeys, []error) {
+		models := make([]*roomHashTagKeys, len(events))
+		for index, event := range events {
+			savedHashTags = append(savedHashTags, event.HashTag)
+			models[index] = &roomHashTagKeys{HashTag: event.HashTag}
+		}
+		return models, make([]error, len(events))
+	}
+	defer func() { upsertHashTagKeysRecords = upsertHashTagKeysRecordsByEvents }()
+
+	lines := []string{
+		`{"hash_tag": "a", "keys": ["{a}1"], "access_time": "2021-06-25T11:30:25Z"}`,
+		`{"hash_tag": "b", "keys": ["{b}1"], "access_time": "2021-06-25T11:30:25Z"}`,
+		`not json`,
+		`{"hash_tag": "c", "keys": ["{c}1"], "access_time": "2021-06-25T11:30:25Z"}`,
+	}
+	name := filepath.Join(t.TempDir(), "events")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(strings.Join(lines, "\n")), 0644))
+
+	count, quit, errs := service._saveEventsFromFileToDB(name, "test")
+	assert.Equal(t, 3, count)
+	assert.False(t, quit)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, []string{"a", "b", "c"}, savedHashTags)
+}
+
+func benchmarkSaveEventsFromFile(b *testing.B, poolEvents bool) {
+	service := testNewCollectEventService()
+	service.config.SaveDB.RateLimitPerSecond = 1000000000
+	if poolEvents {
+		service.config.SaveDB.PoolEvents = true
+		service.eventPool = &sync.Pool{New: func() interface{} { return &base.HashTagEvent{} }}
+	}
+	upsertHashTagKeysRecord = func(ctx context.Context, db *base.DBCluster, event base.HashTagEvent, t time.Time) (*roomHashTagKeys, error) {
+		return &roomHashTagKeys{HashTag: event.HashTag}, nil
+	}
+	defer func() { upsertHashTagKeysRecord = _upsertHashTagKeysRecordByEvent }()
+
+	lines := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf(`{"hash_tag": "abc%d", "keys": ["{abc%d}1"], "access_time": "2021-06-25T11:30:25Z"}`, i, i))
+	}
+	name := filepath.Join(b.TempDir(), "events")
+	if err := ioutil.WriteFile(name, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, errs := service._saveEventsFromFileToDB(name, "benchmark"); len(errs) != 0 {
+			b.Fatal(errs)
+		}
+	}
+}
+
+func BenchmarkSaveEventsFromFile(b *testing.B) {
+	benchmarkSaveEventsFromFile(b, false)
+}
+
+func BenchmarkSaveEventsFromFileWithPooledEvents(b *testing.B) {
+	benchmarkSaveEventsFromFile(b, true)
+}
+
+func TestCheckEventBufferDepth(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.BufferWarningRatio = 0.5
+	service.config.BufferWarningTicks = 2
+
+	service.checkEventBufferDepth(5)
+	assert.Equal(t, 0, service.bufferWarningTicks)
+	for i := 1; i <= 3; i++ {
+		service.checkEventBufferDepth(6)
+		assert.Equal(t, i, service.bufferWarningTicks)
+	}
+	service.checkEventBufferDepth(1)
+	assert.Equal(t, 0, service.bufferWarningTicks)
+
+	service.config.BufferWarningRatio = 0
+	service.checkEventBufferDepth(10)
+	assert.Equal(t, 0, service.bufferWarningTicks)
+}
+
+func TestDrainTo(t *testing.T) {
+	service := testNewCollectEventService()
+	file, err := NewEventFile(service.logger, service.metric, t.TempDir(), 10, time.Minute)
+	assert.Nil(t, err)
+	service.file = file
+	service.server = &http.Server{}
+	service.serverRequestCtxCancel = func() {}
+	service.stopCh = make(chan bool)
+	service.events = make(map[string]base.HashTagEvent)
+	service.eventBuffer = newDroppingEventBuffer(10)
+	service.collectedEventBuffer = make(chan base.HashTagEvent, 10)
+
+	currentTime := time.Now()
+	service.events["a"] = base.HashTagEvent{HashTag: "a", Keys: utility.NewStringSet(), AccessTime: currentTime}
+	assert.Nil(t, service.addEvent(base.HashTagEvent{HashTag: "a", Keys: utility.NewStringSet(), AccessTime: currentTime.Add(time.Second)}))
+	assert.Nil(t, service.addEvent(base.HashTagEvent{HashTag: "b", Keys: utility.NewStringSet(), AccessTime: currentTime}))
+	service.collectedEventBuffer <- base.HashTagEvent{HashTag: "c", Keys: utility.NewStringSet(), AccessTime: currentTime}
+	service.eventCountInCollectedEventBuffer++
+
+	events := service.DrainTo()
+	hashTags := make([]string, 0)
+	for _, event := range events {
+		hashTags = append(hashTags, event.HashTag)
+		if event.HashTag == "a" {
+			assert.Equal(t, currentTime.Add(time.Second), event.AccessTime)
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, hashTags)
+	assert.Equal(t, 0, len(service.events))
+	assert.Equal(t, int64(0), service.eventCountInEventBuffer)
+	assert.Equal(t, int64(0), service.eventCountInCollectedEventBuffer)
+
+	// service is stopped
+	assert.Nil(t, service.DrainTo())
+	service.Stop()
+}
+
+func TestAddEventDuringStop(t *testing.T) {
+	service := testNewCollectEventService()
+	file, err := NewEventFile(service.logger, service.metric, t.TempDir(), 10, time.Minute)
+	assert.Nil(t, err)
+	service.file = file
+	service.server = &http.Server{}
+	service.serverRequestCtxCancel = func() {}
+	service.stopCh = make(chan bool)
+	service.events = make(map[string]base.HashTagEvent)
+	service.eventBuffer = newDroppingEventBuffer(1000)
+	service.collectedEventBuffer = make(chan base.HashTagEvent, 10)
+
+	var addedCount int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				event := base.HashTagEvent{HashTag: fmt.Sprintf("%d_%d", i, j), Keys: utility.NewStringSet(), AccessTime: time.Now()}
+				err := service.addEvent(event)
+				if err == errEventBufferClosed {
+					return
+				}
+				// events are dropped when buffer is full
+				if err == nil {
+					atomic.AddInt64(&addedCount, 1)
+				}
+			}
+		}(i)
+	}
+	service.Stop()
+	wg.Wait()
+	// every event added before buffer is closed is drained
+	assert.Equal(t, int(addedCount), len(service.events))
+}
+
+func TestResetStats(t *testing.T) {
+	service := testNewCollectEventService()
+	service.currentStatsCounter = &collectEventStatsCounter{}
+	service.config.Server.AdminToken = "secret"
+	service.eventBuffer = newDroppingEventBuffer(2)
+
+	currentTime := time.Now()
+	for _, hashTag := range []string{"a", "b", "c"} {
+		_ = service.addEvent(base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: currentTime})
+	}
+	service.recordError("test", nil, nil)
+	stats := service.GetStats()
+	assert.Equal(t, int64(1), stats.DroppedEventCount)
+	assert.Equal(t, int64(1), stats.ErrorCount)
+	assert.Equal(t, int64(2), stats.EventBufferHighWaterMark)
+
+	recorder := httptest.NewRecorder()
+	service.resetStatsHandler(recorder, httptest.NewRequest(http.MethodGet, "/stats/reset", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, int64(2), service.GetStats().ErrorCount)
+
+	// admin token is required
+	recorder = httptest.NewRecorder()
+	service.resetStatsHandler(recorder, httptest.NewRequest(http.MethodPost, "/stats/reset", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, int64(3), service.GetStats().ErrorCount)
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/stats/reset", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	service.resetStatsHandler(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var statsBeforeReset CollectEventStats
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &statsBeforeReset))
+	assert.Equal(t, int64(1), statsBeforeReset.DroppedEventCount)
+	assert.Equal(t, int64(3), statsBeforeReset.ErrorCount)
+	assert.Equal(t, CollectEventStats{}, service.GetStats())
+
+	<-service.eventBuffer.Dequeue()
+	service.eventCountInEventBuffer--
+	assert.Nil(t, service.addEvent(base.HashTagEvent{HashTag: "d", Keys: utility.NewStringSet(), AccessTime: currentTime}))
+	assert.Equal(t, int64(2), service.GetStats().EventBufferHighWaterMark)
+}
+
+func TestGetEventStatus(t *testing.T) {
+	service := testNewCollectEventService()
+	service.bufferedTags = newBufferedTagIndex()
+	service.events = make(map[string]base.HashTagEvent)
+	service.eventBuffer = newDroppingEventBuffer(10)
+	hashTag := "abc"
+	defer testEmptyHashTagKeysRecordInDB(hashTag)
+
+	getStatus := func(query string) (int, EventStatus) {
+		recorder := httptest.NewRecorder()
+		service.getEventStatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/events/status"+query, nil))
+		var status EventStatus
+		if recorder.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+		}
+		return recorder.Code, status
+	}
+	code, _ := getStatus("")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, status := getStatus("?tag=" + hashTag)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, EventStatus{HashTag: hashTag}, status)
+
+	accessTime := time.Now()
+	event, _ := base.NewHashTagEvent(hashTag, []string{"{abc}a"}, base.HashTagAccessModeWrite, accessTime)
+	assert.Nil(t, service.addEvent(event))
+	assert.Nil(t, service.addEvent(event))
+	_, status = getStatus("?tag=" + hashTag)
+	assert.True(t, status.Buffered)
+	assert.False(t, status.Persisted)
+
+	// two events are merged into one
+	assert.Nil(t, service.aggregateEvent(<-service.eventBuffer.Dequeue()))
+	assert.Nil(t, service.aggregateEvent(<-service.eventBuffer.Dequeue()))
+	event = service.collectEvents()[0]
+	_, status = getStatus("?tag=" + hashTag)
+	assert.True(t, status.Buffered)
+
+	assert.Nil(t, service.saveEvent(event))
+	service.bufferedTags.remove(event.HashTag)
+	_, status = getStatus("?tag=" + hashTag)
+	assert.False(t, status.Buffered)
+	assert.True(t, status.Persisted)
+	assert.Equal(t, []string{"{abc}a"}, status.Record.Keys)
+}
+
+func TestCollectHotTagEvents(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.HotTag = base.CollectEventServiceHotTagConfig{MergeThreshold: 2, Interval: time.Hour}
+	service.events = make(map[string]base.HashTagEvent)
+	service.mergeCounts = make(map[string]int)
+	service.hotTagCollectedAt = make(map[string]time.Time)
+
+	currentTime := time.Now()
+	addEvents := func(hashTag string, count int) {
+		for i := 0; i < count; i++ {
+			event := base.HashTagEvent{HashTag: hashTag, Keys: utility.NewStringSet(), AccessTime: currentTime.Add(time.Duration(i) * time.Second)}
+			assert.Nil(t, service.aggregateEvent(event))
+		}
+	}
+	collectHashTags := func() []string {
+		hashTags := make([]string, 0)
+		for _, event := range service.collectEvents() {
+			hashTags = append(hashTags, event.HashTag)
+		}
+		return hashTags
+	}
+
+	// hot tag is collected the first time
+	addEvents("hot", 3)
+	addEvents("cold", 2)
+	assert.ElementsMatch(t, []string{"hot", "cold"}, collectHashTags())
+
+	// hot tag is coalesced within interval
+	addEvents("hot", 3)
+	addEvents("cold", 2)
+	assert.ElementsMatch(t, []string{"cold"}, collectHashTags())
+	addEvents("hot", 3)
+	assert.ElementsMatch(t, []string{}, collectHashTags())
+	assert.Equal(t, int64(1), service.GetAggregatedEventCount())
+
+	// hot tag is collected after interval
+	service.hotTagCollectedAt["hot"] = currentTime.Add(-time.Hour)
+	assert.ElementsMatch(t, []string{"hot"}, collectHashTags())
+	assert.Equal(t, 0, len(service.mergeCounts))
+}
@@ -62,6 +62,14 @@ func NewRoomService(config *base.RoomServerConfig, dep base.Dependency, host str
 	if port <= 0 {
 		return nil, errors.New("port should be greater than 0")
 	}
+	if err := commands.SetAllowedCommands(config.AllowedCommands); err != nil {
+		return nil, fmt.Errorf("allowed_commands.%w", err)
+	}
+	commands.SetExecDiagnostics(config.IsDebug)
+	commands.SetKeyNamespace(config.KeyNamespace)
+	commands.SetExecTimeout(time.Duration(config.ExecTimeoutMS) * time.Millisecond)
+	commands.SetTransactionMemoryLimit(config.TransactionMemoryLimitBytes)
+	commands.SetClusterEndpoint(host, port)
 
 	roomService := &RoomService{
 		config:       config,
@@ -118,6 +126,9 @@ func (service *RoomService) Stop() {
 }
 
 func (service *RoomService) connAcceptHandler(conn redcon.Conn) bool {
+	session := commands.NewSession()
+	conn.SetContext(session)
+	sessionManager.addSession(conn, session)
 	service.dep.Metric.MetricIncrease("connection.accept")
 	connectionCount := atomic.AddInt64(&connectionTotal, 1)
 	service.dep.Metric.MetricGauge("connection.total", connectionCount)
@@ -139,6 +150,7 @@ func (service *RoomService) connAcceptErrorHandler(err error) {
 
 func (service *RoomService) connServeHandler(conn redcon.Conn, cmds []redcon.Command) {
 	serveStartTime := time.Now()
+	ctx := context.Background()
 
 	redisCluster := service.dep.Redis
 	metric := service.dep.Metric
@@ -148,9 +160,14 @@ func (service *RoomService) connServeHandler(conn redcon.Conn, cmds []redcon.Com
 	allCommands := make([]commands.Commander, 0, cmdCount)
 	results := make([]commands.RESPData, cmdCount)
 
+	session := getSession(conn)
+
 	metric.MetricCount("receive.command", cmdCount)
 	metric.MetricGauge("command.batch.total", cmdCount)
+	metric.MetricGauge("transaction.memory_bytes", commands.GetTransactionMemoryUsage())
 
+	// commands from subscribe command on are processed in subscribe mode
+	subscribeIndex := -1
 	for index, cmd := range cmds {
 		command, err := service.preProcessCommand(cmd, serveStartTime)
 		if err != nil {
@@ -164,7 +181,12 @@ func (service *RoomService) connServeHandler(conn redcon.Conn, cmds []redcon.Com
 			transaction := transactionManager.getTransaction(conn)
 			if transaction != nil {
 				metric.MetricIncrease("error.in_transaction")
-				transactionManager.removeTransaction(conn, commands.TransactionCloseReasonInvalidCommand)
+				// exec of started transaction replies EXECABORT like redis does
+				if transaction.IsStarted() {
+					transaction.MarkDirty()
+				} else {
+					transactionManager.removeTransaction(conn, commands.TransactionCloseReasonInvalidCommand)
+				}
 			}
 			continue
 		}
@@ -174,16 +196,42 @@ func (service *RoomService) connServeHandler(conn redcon.Conn, cmds []redcon.Com
 			log.String("command", command.String()),
 		)
 
+		if commands.IsSubscribeCommand(command) {
+			if transaction := transactionManager.getTransaction(conn); transaction != nil && transaction.IsStarted() {
+				results[index] = commands.ConvertErrorToRESPData(errSubscribeInMulti)
+				transaction.MarkDirty()
+				continue
+			}
+			subscribeIndex = index
+			break
+		}
 		allCommands = append(allCommands, command)
+		if commands.IsSessionCommand(command) {
+			resultMap := toBeExecutedCommandBatch.Execute(ctx, redisCluster)
+			for index, result := range resultMap {
+				results[index] = result
+			}
+			toBeExecutedCommandBatch = commands.NewCommandBatch()
+			results[index] = session.Process(command)
+			continue
+		}
 		transaction := getTransactionIfNeeded(service.dep, conn, command)
 		if transaction != nil && (transaction.IsStarted() || isTransactionCommand(command)) {
-			resultMap := toBeExecutedCommandBatch.Execute(context.TODO(), redisCluster)
+			resultMap := toBeExecutedCommandBatch.Execute(ctx, redisCluster)
 			for index, result := range resultMap {
 				results[index] = result
 			}
 			toBeExecutedCommandBatch = commands.NewCommandBatch()
+			if transaction.IsStarted() {
+				if err := session.AcceptExecSequence(command); err != nil {
+					metric.MetricIncrease("transaction.stale_sequence")
+					results[index] = commands.ConvertErrorToRESPData(err)
+					transactionManager.removeTransaction(conn, commands.TransactionCloseReasonStaleSequence)
+					continue
+				}
+			}
 			startTime := time.Now()
-			results[index] = transaction.Process(command)
+			results[index] = transaction.Process(ctx, command)
 			if transaction.IsClosed() {
 				transactionManager.removeTransaction(conn, commands.TransactionCloseReasonTxClosed)
 				metric.MetricIncrease(fmt.Sprintf("process.transaction.by_%s", command.Name()))
@@ -193,15 +241,21 @@ func (service *RoomService) connServeHandler(conn redcon.Conn, cmds []redcon.Com
 			toBeExecutedCommandBatch.AddCommand(index, command)
 		}
 	}
-	resultMap := toBeExecutedCommandBatch.Execute(context.TODO(), redisCluster)
+	resultMap := toBeExecutedCommandBatch.Execute(ctx, redisCluster)
 	for index, result := range resultMap {
 		results[index] = result
 	}
+	if subscribeIndex >= 0 {
+		results = results[:subscribeIndex]
+	}
 	for _, result := range results {
-		writeDataToConnection(conn, result)
+		writeDataToConnection(conn, result, session.ProtocolVersion())
 	}
 	service.sendEvents(allCommands, serveStartTime)
 	service.recordCommands(allCommands, results, serveStartTime)
+	if subscribeIndex >= 0 {
+		service.enterSubscribeMode(conn, session, cmds[subscribeIndex:])
+	}
 }
 
 func (service *RoomService) preProcessCommand(cmd redcon.Command, serveStartTime time.Time) (commands.Commander, error) {
@@ -210,8 +264,8 @@ func (service *RoomService) preProcessCommand(cmd redcon.Command, serveStartTime
 		args = append(args, string(arg))
 	}
 
-	// Parse command
-	command, err := commands.ParseCommand(args)
+	// Parse command with rewritten keys, so slot and hash tag checks use rewritten keys
+	command, err := commands.ParseCommand(commands.RewriteCommandKeys(args))
 	if err != nil {
 		return nil, err
 	}
@@ -260,13 +314,14 @@ func (service *RoomService) recordCommands(cmds []commands.Commander, results []
 	service.dep.Metric.MetricTimeDuration("process.commands.duration", duration)
 }
 
+// transaction is created for reset without transaction, so reset always replies RESET.
 func isTransactionNeeded(command commands.Commander) bool {
-	transactionCommands := []string{"watch", "multi"}
+	transactionCommands := []string{"watch", "multi", "reset"}
 	return utility.StringSliceContains(transactionCommands, command.Name())
 }
 
 func isTransactionCommand(command commands.Commander) bool {
-	transactionCommands := []string{"watch", "unwatch", "multi", "exec", "discard"}
+	transactionCommands := []string{"watch", "unwatch", "multi", "exec", "discard", "reset"}
 	return utility.StringSliceContains(transactionCommands, command.Name())
 }
 
@@ -309,7 +364,17 @@ func getTransactionIfNeeded(dep base.Dependency, conn redcon.Conn, command comma
 	return transaction
 }
 
-func writeDataToConnection(conn redcon.Conn, data commands.RESPData) {
+func getSession(conn redcon.Conn) *commands.Session {
+	session, ok := conn.Context().(*commands.Session)
+	if !ok {
+		session = commands.NewSession()
+		conn.SetContext(session)
+		sessionManager.addSession(conn, session)
+	}
+	return session
+}
+
+func writeDataToConnection(conn redcon.Conn, data commands.RESPData, protocolVersion int) {
 	switch data.DataType {
 	case commands.SimpleStringRespType:
 		conn.WriteString(utility.AnyToString(data.Value))
@@ -330,7 +395,11 @@ func writeDataToConnection(conn redcon.Conn, data commands.RESPData) {
 			conn.WriteInt64(num)
 		}
 	case commands.NilRespType:
-		conn.WriteNull()
+		if protocolVersion == commands.ProtocolVersionRESP3 {
+			conn.WriteRaw([]byte("_\r\n"))
+		} else {
+			conn.WriteNull()
+		}
 	case commands.ArrayRespType:
 		array, ok := data.Value.([]commands.RESPData)
 		if !ok {
@@ -338,11 +407,57 @@ func writeDataToConnection(conn redcon.Conn, data commands.RESPData) {
 		} else {
 			conn.WriteArray(len(array))
 			for _, item := range array {
-				writeDataToConnection(conn, item)
+				writeDataToConnection(conn, item, protocolVersion)
+			}
+		}
+	case commands.MapRespType:
+		array, ok := data.Value.([]commands.RESPData)
+		if !ok || len(array)%2 != 0 {
+			conn.WriteError(errInvalidResponse.Error())
+		} else {
+			if protocolVersion == commands.ProtocolVersionRESP3 {
+				conn.WriteRaw([]byte(fmt.Sprintf("%%%d\r\n", len(array)/2)))
+			} else {
+				conn.WriteArray(len(array))
+			}
+			for _, item := range array {
+				writeDataToConnection(conn, item, protocolVersion)
+			}
+		}
+	case commands.PushRespType:
+		array, ok := data.Value.([]commands.RESPData)
+		if !ok {
+			conn.WriteError(errInvalidResponse.Error())
+		} else {
+			if protocolVersion == commands.ProtocolVersionRESP3 {
+				conn.WriteRaw([]byte(fmt.Sprintf(">%d\r\n", len(array))))
+			} else {
+				conn.WriteArray(len(array))
+			}
+			for _, item := range array {
+				writeDataToConnection(conn, item, protocolVersion)
 			}
 		}
 	case commands.NilArrayRespType:
-		conn.WriteRaw([]byte("*-1\r\n"))
+		if protocolVersion == commands.ProtocolVersionRESP3 {
+			conn.WriteRaw([]byte("_\r\n"))
+		} else {
+			conn.WriteRaw([]byte("*-1\r\n"))
+		}
+	case commands.DoubleRespType, commands.BigNumberRespType, commands.VerbatimStringRespType:
+		value := utility.AnyToString(data.Value)
+		if protocolVersion != commands.ProtocolVersionRESP3 {
+			conn.WriteBulkString(value)
+			return
+		}
+		switch data.DataType {
+		case commands.DoubleRespType:
+			conn.WriteRaw([]byte(fmt.Sprintf(",%s\r\n", value)))
+		case commands.BigNumberRespType:
+			conn.WriteRaw([]byte(fmt.Sprintf("(%s\r\n", value)))
+		case commands.VerbatimStringRespType:
+			conn.WriteRaw([]byte(fmt.Sprintf("=%d\r\ntxt:%s\r\n", len(value)+4, value)))
+		}
 	}
 }
 
@@ -362,10 +477,19 @@ func sendCommandEvents(command commands.Commander, accessTime time.Time) error {
 	return nil
 }
 
+// connCloseHandler is called by redcon when connection is detached for subscribe mode,
+// the detached connection is served from then on, it is called again when the detached connection is closed.
 func (service *RoomService) connCloseHandler(conn redcon.Conn, err error) {
+	if subscribing := subscriptionManager.getSubscription(conn); subscribing != nil {
+		go service.serveSubscription(conn, subscribing)
+		return
+	}
 	metric := service.dep.Metric
 	metric.MetricIncrease("connection.close")
 	transactionManager.removeTransaction(conn, commands.TransactionCloseReasonConnClosed)
+	if session, ok := conn.Context().(*commands.Session); ok {
+		sessionManager.removeSession(session)
+	}
 	transactionCount := transactionManager.transactionCount()
 	connectionCount := atomic.AddInt64(&connectionTotal, -1)
 	if err == nil {
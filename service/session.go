@@ -0,0 +1,54 @@
+package service
+
+import (
+	"bytepower_room/commands"
+	"errors"
+	"sync"
+
+	"github.com/tidwall/redcon"
+)
+
+var errSessionNotFound = errors.New("session is not found")
+
+var sessionManager = SessionManager{
+	idConnMap: make(map[int64]redcon.Conn),
+	mutex:     &sync.Mutex{},
+}
+
+// SessionManager registers connections by session id, so sessions can be inspected by id.
+type SessionManager struct {
+	idConnMap map[int64]redcon.Conn
+	mutex     *sync.Mutex
+}
+
+func (manager *SessionManager) addSession(conn redcon.Conn, session *commands.Session) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.idConnMap[session.ID()] = conn
+}
+
+func (manager *SessionManager) getConn(id int64) redcon.Conn {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.idConnMap[id]
+}
+
+func (manager *SessionManager) removeSession(session *commands.Session) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	delete(manager.idConnMap, session.ID())
+}
+
+// GetTransactionState returns transaction state of session with id,
+// session without transaction has an empty state.
+func (service *RoomService) GetTransactionState(sessionID int64) (commands.TransactionState, error) {
+	conn := sessionManager.getConn(sessionID)
+	if conn == nil {
+		return commands.TransactionState{}, errSessionNotFound
+	}
+	transaction := transactionManager.getTransaction(conn)
+	if transaction == nil {
+		return commands.TransactionState{WatchedKeys: []string{}}, nil
+	}
+	return transaction.State(), nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+type connContextKeyType struct{}
+
+// connContextKey is the key of net.Conn of request in request context.
+var connContextKey = connContextKeyType{}
+
+func saveConnInContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey, conn)
+}
+
+func getConnFromContext(ctx context.Context) net.Conn {
+	conn, _ := ctx.Value(connContextKey).(net.Conn)
+	return conn
+}
+
+var errBodyReadTooSlow = errors.New("request body is read too slowly")
+
+// minRateBodyReader fails reading body when less than minBytesPerSecond * (elapsed - grace) bytes are read,
+// read deadline of conn is set to the time next byte is required, so a client dripping bytes is aborted
+// without waiting for read timeout of server.
+type minRateBodyReader struct {
+	reader            io.Reader
+	minBytesPerSecond int64
+	grace             time.Duration
+	startTime         time.Time
+	// nil if conn is unknown, then rate is checked after reads return.
+	conn net.Conn
+	// read deadline of server, restored when body is read.
+	readDeadline time.Time
+
+	count int64
+}
+
+func newMinRateBodyReader(
+	reader io.Reader, conn net.Conn,
+	minBytesPerSecond int64, grace time.Duration,
+	startTime, readDeadline time.Time) *minRateBodyReader {
+	return &minRateBodyReader{
+		reader:            reader,
+		minBytesPerSecond: minBytesPerSecond,
+		grace:             grace,
+		startTime:         startTime,
+		conn:              conn,
+		readDeadline:      readDeadline,
+	}
+}
+
+// requiredBy returns the time when more than count bytes are required.
+func (reader *minRateBodyReader) requiredBy() time.Time {
+	return reader.startTime.Add(reader.grace + time.Duration(reader.count*int64(time.Second)/reader.minBytesPerSecond))
+}
+
+func (reader *minRateBodyReader) Read(p []byte) (int, error) {
+	requiredBy := reader.requiredBy()
+	limitedByRate := reader.readDeadline.IsZero() || requiredBy.Before(reader.readDeadline)
+	if reader.conn != nil && limitedByRate {
+		if err := reader.conn.SetReadDeadline(requiredBy); err != nil {
+			return 0, err
+		}
+	}
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	if err != nil {
+		if reader.conn != nil && limitedByRate {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return n, reader.tooSlowError(time.Now())
+			}
+			if restoreErr := reader.conn.SetReadDeadline(reader.readDeadline); restoreErr != nil {
+				return n, restoreErr
+			}
+		}
+		return n, err
+	}
+	if currentTime := time.Now(); limitedByRate && currentTime.After(reader.requiredBy()) {
+		return n, reader.tooSlowError(currentTime)
+	}
+	return n, nil
+}
+
+func (reader *minRateBodyReader) tooSlowError(t time.Time) error {
+	return fmt.Errorf(
+		"%w, %d bytes in %s, min rate is %d bytes per second",
+		errBodyReadTooSlow, reader.count, t.Sub(reader.startTime).String(), reader.minBytesPerSecond)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSlowReader struct {
+	chunks   []string
+	interval time.Duration
+}
+
+func (reader *testSlowReader) Read(p []byte) (int, error) {
+	if len(reader.chunks) == 0 {
+		return 0, nil
+	}
+	time.Sleep(reader.interval)
+	n := copy(p, reader.chunks[0])
+	reader.chunks = reader.chunks[1:]
+	return n, nil
+}
+
+func TestMinRateBodyReaderWithoutConn(t *testing.T) {
+	startTime := time.Now()
+	reader := newMinRateBodyReader(
+		&testSlowReader{chunks: []string{"a", "b", "c"}, interval: 20 * time.Millisecond},
+		nil, 1000, 30*time.Millisecond, startTime, time.Time{})
+	p := make([]byte, 10)
+	_, err := reader.Read(p)
+	assert.Nil(t, err)
+	_, err = reader.Read(p)
+	assert.True(t, errors.Is(err, errBodyReadTooSlow))
+
+	// read deadline of server is earlier than required time
+	reader = newMinRateBodyReader(
+		&testSlowReader{chunks: []string{"a", "b"}, interval: 20 * time.Millisecond},
+		nil, 1000, 30*time.Millisecond, startTime, startTime.Add(time.Millisecond))
+	_, err = reader.Read(p)
+	assert.Nil(t, err)
+	_, err = reader.Read(p)
+	assert.Nil(t, err)
+}
+
+func TestPostEventsHandlerSlowBody(t *testing.T) {
+	service := testNewCollectEventService()
+	service.config.BufferLimit = 10
+	service.config.Server.ReadTimeoutMS = 10000
+	service.config.Server.MinBodyBytesPerSecond = 100
+	service.config.Server.MinBodyRateGraceMS = 50
+	service.eventBuffer = newDroppingEventBuffer(10)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(service.postEventsHandler))
+	server.Config.ConnContext = saveConnInContext
+	server.Start()
+	defer server.Close()
+
+	body := `{"events": [{"hash_tag": "abc", "keys": [], "access_time": "2021-06-25T11:30:25Z"}]}`
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "POST /events HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:10])
+	assert.Nil(t, err)
+
+	startTime := time.Now()
+	assert.Nil(t, conn.SetReadDeadline(startTime.Add(5*time.Second)))
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, response.StatusCode)
+	assert.True(t, time.Since(startTime) < time.Second)
+	assert.Equal(t, 0, service.eventBuffer.Len())
+
+	// body sent in time
+	response, err = http.Post(server.URL, HTTPContentTypeJSON, strings.NewReader(body))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, 1, service.eventBuffer.Len())
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+const tagSaveLockCount = 256
+
+// tagSaveLocks serializes saves of the same hash tag between saving buffered events and SaveEventSync.
+// Hash tags are spread over a fixed number of locks, so memory is bounded and hash tags sharing a lock wait for each other.
+type tagSaveLocks struct {
+	mutexes [tagSaveLockCount]sync.Mutex
+}
+
+// lock locks hash tags in order of lock index, so callers locking many hash tags do not deadlock,
+// hash tags are unlocked by the returned function.
+func (locks *tagSaveLocks) lock(hashTags ...string) func() {
+	indexes := make([]int, 0, len(hashTags))
+	locked := make(map[int]bool, len(hashTags))
+	for _, hashTag := range hashTags {
+		index := tagSaveLockIndex(hashTag)
+		if !locked[index] {
+			locked[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		locks.mutexes[index].Lock()
+	}
+	return func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			locks.mutexes[indexes[i]].Unlock()
+		}
+	}
+}
+
+func tagSaveLockIndex(hashTag string) int {
+	return int(crc32.ChecksumIEEE([]byte(hashTag)) % tagSaveLockCount)
+}
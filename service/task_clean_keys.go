@@ -41,6 +41,7 @@ func CleanKeysTask(dep base.Dependency, inactiveDuration time.Duration, rateLimi
 					"stack": string(debug.Stack()),
 				},
 			)
+			dep.Metric.Flush()
 		} else if err == nil {
 			recordTaskSuccess(dep.Logger, dep.Metric, CleanKeysTaskName, time.Since(startTime))
 		}
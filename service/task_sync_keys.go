@@ -43,6 +43,7 @@ func SyncKeysTask(dep base.Dependency, config base.SyncKeyTaskConfig) {
 				dep.Logger, dep.Metric, SyncKeysTaskName,
 				errTaskPanic, "panic", info,
 			)
+			dep.Metric.Flush()
 		} else if err == nil {
 			recordTaskSuccess(dep.Logger, dep.Metric, SyncKeysTaskName, time.Since(startTime))
 		}
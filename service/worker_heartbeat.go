@@ -0,0 +1,54 @@
+package service
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	workerSaveEventsToFile = "save_events_to_file"
+	workerSaveEventsToDB   = "save_events_to_db"
+)
+
+// idle workers beat in workerHeartbeatInterval, so they are not taken as stuck.
+const workerHeartbeatInterval = time.Second
+
+// workerHeartbeats keeps the last heartbeat time of workers in unix nanoseconds,
+// workers are registered when created and beat without locks. Nil heartbeats keep nothing.
+type workerHeartbeats struct {
+	beats map[string]*int64
+}
+
+func newWorkerHeartbeats(names []string, t time.Time) *workerHeartbeats {
+	heartbeats := &workerHeartbeats{beats: make(map[string]*int64, len(names))}
+	for _, name := range names {
+		beat := t.UnixNano()
+		heartbeats.beats[name] = &beat
+	}
+	return heartbeats
+}
+
+func (heartbeats *workerHeartbeats) beat(name string, t time.Time) {
+	if heartbeats == nil {
+		return
+	}
+	if beat, ok := heartbeats.beats[name]; ok {
+		atomic.StoreInt64(beat, t.UnixNano())
+	}
+}
+
+// staleWorkers returns names of workers not beating within threshold before t.
+func (heartbeats *workerHeartbeats) staleWorkers(threshold time.Duration, t time.Time) []string {
+	if heartbeats == nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for name, beat := range heartbeats.beats {
+		if t.Sub(time.Unix(0, atomic.LoadInt64(beat))) > threshold {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerHeartbeats(t *testing.T) {
+	var heartbeats *workerHeartbeats
+	heartbeats.beat(workerSaveEventsToDB, time.Now())
+	assert.Nil(t, heartbeats.staleWorkers(time.Second, time.Now()))
+
+	now := time.Now()
+	heartbeats = newWorkerHeartbeats([]string{workerSaveEventsToFile, workerSaveEventsToDB}, now)
+	assert.Equal(t, []string{}, heartbeats.staleWorkers(time.Minute, now.Add(time.Minute)))
+	assert.Equal(
+		t, []string{workerSaveEventsToDB, workerSaveEventsToFile},
+		heartbeats.staleWorkers(time.Minute, now.Add(2*time.Minute)))
+
+	heartbeats.beat(workerSaveEventsToFile, now.Add(time.Minute))
+	// unknown worker is ignored
+	heartbeats.beat("unknown", now.Add(time.Minute))
+	assert.Equal(t, []string{workerSaveEventsToDB}, heartbeats.staleWorkers(time.Minute, now.Add(2*time.Minute)))
+}
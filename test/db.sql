@@ -132,9 +132,12 @@ CREATE TABLE public.room_hash_tag_keys_0 (
     accessed_at timestamp with time zone NOT NULL,
     written_at timestamp with time zone DEFAULT NULL,
     synced_at timestamp with time zone DEFAULT NULL,
+    expires_at timestamp with time zone DEFAULT NULL,
     created_at timestamp with time zone NOT NULL DEFAULT now(),
     updated_at timestamp with time zone NOT NULL DEFAULT now(),
     status character varying NOT NULL,
+    dc character varying DEFAULT NULL,
+    event_id character varying DEFAULT NULL,
     version bigint NOT NULL DEFAULT 0
 );
 
@@ -152,9 +155,12 @@ CREATE TABLE public.room_hash_tag_keys_1 (
     accessed_at timestamp with time zone NOT NULL,
     written_at timestamp with time zone DEFAULT NULL,
     synced_at timestamp with time zone DEFAULT NULL,
+    expires_at timestamp with time zone DEFAULT NULL,
     created_at timestamp with time zone NOT NULL DEFAULT now(),
     updated_at timestamp with time zone NOT NULL DEFAULT now(),
     status character varying NOT NULL,
+    dc character varying DEFAULT NULL,
+    event_id character varying DEFAULT NULL,
     version bigint NOT NULL DEFAULT 0
 );
 
@@ -172,9 +178,12 @@ CREATE TABLE public.room_hash_tag_keys_2 (
     accessed_at timestamp with time zone NOT NULL,
     written_at timestamp with time zone DEFAULT NULL,
     synced_at timestamp with time zone DEFAULT NULL,
+    expires_at timestamp with time zone DEFAULT NULL,
     created_at timestamp with time zone NOT NULL DEFAULT now(),
     updated_at timestamp with time zone NOT NULL DEFAULT now(),
     status character varying NOT NULL,
+    dc character varying DEFAULT NULL,
+    event_id character varying DEFAULT NULL,
     version bigint NOT NULL DEFAULT 0
 );
 
@@ -192,9 +201,12 @@ CREATE TABLE public.room_hash_tag_keys_3 (
     accessed_at timestamp with time zone NOT NULL,
     written_at timestamp with time zone DEFAULT NULL,
     synced_at timestamp with time zone DEFAULT NULL,
+    expires_at timestamp with time zone DEFAULT NULL,
     created_at timestamp with time zone NOT NULL DEFAULT now(),
     updated_at timestamp with time zone NOT NULL DEFAULT now(),
     status character varying NOT NULL,
+    dc character varying DEFAULT NULL,
+    event_id character varying DEFAULT NULL,
     version bigint NOT NULL DEFAULT 0
 );
 
@@ -212,9 +224,12 @@ CREATE TABLE public.room_hash_tag_keys_4 (
     accessed_at timestamp with time zone NOT NULL,
     written_at timestamp with time zone DEFAULT NULL,
     synced_at timestamp with time zone DEFAULT NULL,
+    expires_at timestamp with time zone DEFAULT NULL,
     created_at timestamp with time zone NOT NULL DEFAULT now(),
     updated_at timestamp with time zone NOT NULL DEFAULT now(),
     status character varying NOT NULL,
+    dc character varying DEFAULT NULL,
+    event_id character varying DEFAULT NULL,
     version bigint NOT NULL DEFAULT 0
 );
 
@@ -224,3 +239,67 @@ ALTER TABLE ONLY public.room_hash_tag_keys_4
 CREATE INDEX room_hash_tag_keys_status_accessed_at_4_idx ON public.room_hash_tag_keys_4 USING btree (status, accessed_at);
 
 CREATE INDEX room_hash_tag_keys_status_written_at_4_idx ON public.room_hash_tag_keys_4 USING btree (status, written_at);
+
+CREATE TABLE public.room_lease_0 (
+    name character varying NOT NULL,
+    holder character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    version bigint NOT NULL DEFAULT 0
+);
+
+ALTER TABLE ONLY public.room_lease_0
+    ADD CONSTRAINT room_lease_0_pkey PRIMARY KEY (name);
+
+
+CREATE TABLE public.room_lease_1 (
+    name character varying NOT NULL,
+    holder character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    version bigint NOT NULL DEFAULT 0
+);
+
+ALTER TABLE ONLY public.room_lease_1
+    ADD CONSTRAINT room_lease_1_pkey PRIMARY KEY (name);
+
+
+CREATE TABLE public.room_lease_2 (
+    name character varying NOT NULL,
+    holder character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    version bigint NOT NULL DEFAULT 0
+);
+
+ALTER TABLE ONLY public.room_lease_2
+    ADD CONSTRAINT room_lease_2_pkey PRIMARY KEY (name);
+
+
+CREATE TABLE public.room_lease_3 (
+    name character varying NOT NULL,
+    holder character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    version bigint NOT NULL DEFAULT 0
+);
+
+ALTER TABLE ONLY public.room_lease_3
+    ADD CONSTRAINT room_lease_3_pkey PRIMARY KEY (name);
+
+
+CREATE TABLE public.room_lease_4 (
+    name character varying NOT NULL,
+    holder character varying NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now(),
+    version bigint NOT NULL DEFAULT 0
+);
+
+ALTER TABLE ONLY public.room_lease_4
+    ADD CONSTRAINT room_lease_4_pkey PRIMARY KEY (name);
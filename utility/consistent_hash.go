@@ -0,0 +1,44 @@
+package utility
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// ConsistentHash maps keys to nodes, only keys of a node move when the node is added or removed.
+// Each node has virtualNodeCount points on the ring to balance keys.
+type ConsistentHash struct {
+	virtualNodeCount int
+	hashes           []uint32
+	hashNodes        map[uint32]string
+}
+
+func NewConsistentHash(virtualNodeCount int, nodes ...string) *ConsistentHash {
+	ring := &ConsistentHash{
+		virtualNodeCount: virtualNodeCount,
+		hashNodes:        make(map[uint32]string),
+	}
+	for _, node := range nodes {
+		for i := 0; i < virtualNodeCount; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			ring.hashes = append(ring.hashes, hash)
+			ring.hashNodes[hash] = node
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// Get returns empty string if there is no node.
+func (ring *ConsistentHash) Get(key string) string {
+	if len(ring.hashes) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	index := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= hash })
+	if index == len(ring.hashes) {
+		index = 0
+	}
+	return ring.hashNodes[ring.hashes[index]]
+}
@@ -0,0 +1,35 @@
+package utility
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistentHash(t *testing.T) {
+	assert.Equal(t, "", NewConsistentHash(10).Get("a"))
+
+	nodes := []string{"node1", "node2", "node3"}
+	ring := NewConsistentHash(100, nodes...)
+	counts := make(map[string]int)
+	keyNodes := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		node := ring.Get(key)
+		assert.Equal(t, node, ring.Get(key))
+		counts[node]++
+		keyNodes[key] = node
+	}
+	for _, node := range nodes {
+		assert.Greater(t, counts[node], 500)
+	}
+
+	// only keys of removed node move
+	ring = NewConsistentHash(100, "node1", "node2")
+	for key, node := range keyNodes {
+		if node != "node3" {
+			assert.Equal(t, node, ring.Get(key))
+		}
+	}
+}
@@ -705,6 +705,17 @@ func GetLatestTime(times ...time.Time) time.Time {
 	return latestTime
 }
 
+// GetEarliestTime returns the earliest time of times not zero, zero if all times are zero.
+func GetEarliestTime(times ...time.Time) time.Time {
+	earliestTime := time.Time{}
+	for _, t := range times {
+		if !t.IsZero() && (earliestTime.IsZero() || t.Before(earliestTime)) {
+			earliestTime = t
+		}
+	}
+	return earliestTime
+}
+
 func MergeStringSliceAndRemoveDuplicateItems(slices ...[]string) []string {
 	return MergeStringSlicesToStringSet(slices...).ToSlice()
 }
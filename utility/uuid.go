@@ -1,7 +1,11 @@
 package utility
 
 import (
+	"bufio"
+	crand "crypto/rand"
+	"io"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -51,3 +55,47 @@ func GenerateFixedLengthRandomString(length uint8) string {
 	}
 	return BytesToString(b)
 }
+
+const (
+	ulidAlphabet      = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	ulidLength        = 26
+	ulidTimeBytes     = 6
+	ulidRandomBytes   = 10
+	ulidLeadingBits   = ulidLength*b32WordLength - (ulidTimeBytes+ulidRandomBytes)*8
+	ulidEntropyBuffer = 4096
+)
+
+// ulidEntropy reads crypto random bytes in batch, so generating ULID does not make a syscall every time.
+var ulidEntropy = struct {
+	sync.Mutex
+	reader *bufio.Reader
+}{reader: bufio.NewReaderSize(crand.Reader, ulidEntropyBuffer)}
+
+// GenerateULID generates ULID with 48 bits milliseconds of t and 80 bits crypto randomness,
+// encoded in 26 characters of Crockford's base32, ULIDs of later milliseconds are greater in lexicographical order.
+func GenerateULID(t time.Time) (string, error) {
+	var data [ulidTimeBytes + ulidRandomBytes]byte
+	milliseconds := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := ulidTimeBytes - 1; i >= 0; i-- {
+		data[i] = byte(milliseconds)
+		milliseconds >>= 8
+	}
+	ulidEntropy.Lock()
+	_, err := io.ReadFull(ulidEntropy.reader, data[ulidTimeBytes:])
+	ulidEntropy.Unlock()
+	if err != nil {
+		return "", err
+	}
+	result := make([]byte, ulidLength)
+	for i := range result {
+		index := 0
+		for j := i * b32WordLength; j < (i+1)*b32WordLength; j++ {
+			index <<= 1
+			if bit := j - ulidLeadingBits; bit >= 0 {
+				index |= int(data[bit/8]>>(7-bit%8)) & 1
+			}
+		}
+		result[i] = ulidAlphabet[index]
+	}
+	return BytesToString(result), nil
+}
@@ -0,0 +1,46 @@
+package utility
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateULID(t *testing.T) {
+	// example of ULID spec
+	id, err := GenerateULID(time.Unix(0, 1469918176385*int64(time.Millisecond)))
+	assert.Nil(t, err)
+	assert.Equal(t, 26, len(id))
+	assert.Equal(t, "01ARYZ6S41", id[:10])
+
+	currentTime := time.Now()
+	ids := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		id, err := GenerateULID(currentTime.Add(time.Duration(i) * time.Millisecond))
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	assert.True(t, sort.StringsAreSorted(ids))
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	idSet := NewStringSet()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				id, err := GenerateULID(currentTime)
+				assert.Nil(t, err)
+				mutex.Lock()
+				idSet.Add(id)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10000, idSet.Len())
+}